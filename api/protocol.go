@@ -40,6 +40,11 @@ const (
 	MethodGetRealtimeStats     = "get_realtime_stats"
 	MethodGetHistoricalStats   = "get_historical_stats"
 	MethodGetActiveConnections = "get_active_connections"
+	MethodGetConnectionHistory = "get_connection_history"
+	MethodGetPortBreakdown     = "get_port_breakdown"
+	MethodGetProcessStats      = "get_process_stats"
+	MethodGetSizeHistogram     = "get_size_histogram"
+	MethodGetRateHistory       = "get_rate_history"
 	MethodGetStatus            = "get_status"
 	MethodAddPort              = "add_port"
 	MethodRemovePort           = "remove_port"
@@ -49,35 +54,188 @@ const (
 
 // ========== Request Parameters ==========
 
-// PortParams is used for single-port operations.
+// PortParams is used for single-port operations. A non-zero PortEnd
+// selects the port range Port-PortEnd; a non-zero Netns selects the port
+// as monitored in that network namespace (by inode). For stats queries, a
+// non-empty LocalAddr limits the totals to the traffic on that local
+// address, leaving out the breakdowns that aren't kept per address.
 type PortParams struct {
-	Port uint16 `json:"port"`
+	Port      uint16 `json:"port"`
+	PortEnd   uint16 `json:"port_end,omitempty"`
+	Protocol  string `json:"protocol,omitempty"` // "tcp" (default) or "udp"
+	Netns     uint32 `json:"netns,omitempty"`
+	Role      string `json:"role,omitempty"` // add_port: "server", "client" or "both" (default)
+	LocalAddr string `json:"local_addr,omitempty"`
 }
 
 // HistoricalParams is used for historical data queries.
 type HistoricalParams struct {
 	Port      uint16 `json:"port"`
+	PortEnd   uint16 `json:"port_end,omitempty"`
+	Protocol  string `json:"protocol,omitempty"`
+	Netns     uint32 `json:"netns,omitempty"`
+	LocalAddr string `json:"local_addr,omitempty"`
 	StartDate string `json:"start_date"` // YYYY-MM-DD
 	EndDate   string `json:"end_date"`   // YYYY-MM-DD
 }
 
+// ProcessStatsParams is used for per-process queries.
+type ProcessStatsParams struct {
+	Port     uint16 `json:"port"`
+	PortEnd  uint16 `json:"port_end,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Netns    uint32 `json:"netns,omitempty"`
+	Limit    int    `json:"limit,omitempty"` // 0 = all
+}
+
+// SizeHistogramParams is used for payload size histogram queries.
+type SizeHistogramParams struct {
+	Port     uint16 `json:"port"`
+	PortEnd  uint16 `json:"port_end,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Netns    uint32 `json:"netns,omitempty"`
+	Hours    int    `json:"hours,omitempty"` // 0 = last 24 hours
+}
+
+// RateHistoryParams is used for recent per-second rate queries.
+type RateHistoryParams struct {
+	Port     uint16 `json:"port"`
+	PortEnd  uint16 `json:"port_end,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Netns    uint32 `json:"netns,omitempty"`
+	Seconds  int    `json:"seconds,omitempty"` // 0 = all kept, up to 15 minutes
+}
+
+// ConnectionHistoryParams is used for finished-connection queries.
+type ConnectionHistoryParams struct {
+	Port      uint16 `json:"port"`
+	StartDate string `json:"start_date"` // YYYY-MM-DD
+	EndDate   string `json:"end_date"`   // YYYY-MM-DD
+	Limit     int    `json:"limit,omitempty"`
+}
+
 // ========== Response Types ==========
 
 // RealtimeStatsResult contains current stats and rates.
 type RealtimeStatsResult struct {
 	Port        uint16  `json:"port"`
+	PortEnd     uint16  `json:"port_end,omitempty"`
+	Protocol    string  `json:"protocol"`
+	Netns       uint32  `json:"netns,omitempty"`
 	RxBytes     uint64  `json:"rx_bytes"`
 	TxBytes     uint64  `json:"tx_bytes"`
-	RxPackets   uint64  `json:"rx_packets"`
+	RxPackets   uint64  `json:"rx_packets"` // segments and datagrams
 	TxPackets   uint64  `json:"tx_packets"`
+	RxOps       uint64  `json:"rx_ops"` // send/receive calls
+	TxOps       uint64  `json:"tx_ops"`
 	Connections uint64  `json:"connections"`
-	RxRate      float64 `json:"rx_rate"` // bytes/sec
+	RxRate      float64 `json:"rx_rate"` // bytes/sec over the last poll
 	TxRate      float64 `json:"tx_rate"`
+
+	// Rates averaged over the last "1s", "10s", "60s" and "300s", like
+	// load averages; clients pick the window they display
+	Rates map[string]WindowRate `json:"rates"`
+
+	// Split of the totals by the role the port played
+	Server TrafficStats `json:"server"`
+	Client TrafficStats `json:"client"`
+
+	// Traffic excluded by the port's CIDR filters, not part of the totals
+	Filtered TrafficStats `json:"filtered"`
+
+	// TCP health over the same period as the totals; nil for UDP ports
+	Health *TCPHealth `json:"health,omitempty"`
+
+	// TCP connections established over the same period; nil for UDP ports
+	Handshakes *HandshakeStats `json:"handshakes,omitempty"`
+
+	// IP-level traffic including headers and retransmissions, for
+	// reconciling the payload totals with provider bills; wire mode only
+	Wire *TrafficStats `json:"wire,omitempty"`
+
+	// Per-cgroup traffic since the daemon started, busiest first
+	Cgroups []CgroupStats `json:"cgroups,omitempty"`
+
+	// Per-local-address traffic since the daemon started, busiest first
+	LocalAddrs []LocalAddrStats `json:"local_addrs,omitempty"`
+}
+
+// WindowRate holds a port's rates averaged over one window.
+type WindowRate struct {
+	RxRate float64 `json:"rx_rate"` // bytes/sec
+	TxRate float64 `json:"tx_rate"`
+}
+
+// TrafficStats holds a share of a port's traffic. For roles, server-side
+// traffic is on connections to the local port, client-side traffic on
+// connections this host made to the port on a remote host. Filtered
+// traffic is traffic excluded by the port's CIDR filters.
+type TrafficStats struct {
+	RxBytes   uint64  `json:"rx_bytes"`
+	TxBytes   uint64  `json:"tx_bytes"`
+	RxPackets uint64  `json:"rx_packets,omitempty"`
+	TxPackets uint64  `json:"tx_packets,omitempty"`
+	RxRate    float64 `json:"rx_rate,omitempty"` // realtime only
+	TxRate    float64 `json:"tx_rate,omitempty"`
+}
+
+// TCPHealth summarizes a TCP port's health: retransmissions, resets sent
+// and received, and the distribution of smoothed RTT. RTTHistogram bucket i
+// counts samples in [2^i, 2^(i+1)) microseconds; percentiles are the upper
+// bound of the bucket they fall in.
+type TCPHealth struct {
+	Retransmits  uint64   `json:"retransmits"`
+	Resets       uint64   `json:"resets"`
+	RTTSamples   uint64   `json:"rtt_samples"`
+	RTTP50       uint64   `json:"rtt_p50_us"`
+	RTTP90       uint64   `json:"rtt_p90_us"`
+	RTTP99       uint64   `json:"rtt_p99_us"`
+	RTTHistogram []uint64 `json:"rtt_histogram,omitempty"`
+}
+
+// HandshakeStats counts a TCP port's connections at the handshake:
+// accepted as the server, initiated as the client, and connects or
+// handshakes that failed. Resets are part of TCPHealth.
+type HandshakeStats struct {
+	Accepted  uint64 `json:"accepted"`
+	Initiated uint64 `json:"initiated"`
+	Failed    uint64 `json:"failed"`
+}
+
+// CgroupStats holds a cgroup's traffic on a port. Unit and Container are
+// set when the cgroup path names a systemd unit or container scope.
+type CgroupStats struct {
+	Path        string  `json:"path"`
+	Unit        string  `json:"unit,omitempty"`
+	Container   string  `json:"container,omitempty"`
+	RxBytes     uint64  `json:"rx_bytes"`
+	TxBytes     uint64  `json:"tx_bytes"`
+	RxOps       uint64  `json:"rx_ops"`
+	TxOps       uint64  `json:"tx_ops"`
+	Connections uint64  `json:"connections"`
+	RxRate      float64 `json:"rx_rate,omitempty"` // realtime only
+	TxRate      float64 `json:"tx_rate,omitempty"`
+}
+
+// LocalAddrStats holds the traffic on one local address of a port, for
+// hosts serving the same port on several addresses.
+type LocalAddrStats struct {
+	Addr        string  `json:"addr"`
+	RxBytes     uint64  `json:"rx_bytes"`
+	TxBytes     uint64  `json:"tx_bytes"`
+	RxPackets   uint64  `json:"rx_packets"`
+	TxPackets   uint64  `json:"tx_packets"`
+	Connections uint64  `json:"connections"`
+	RxRate      float64 `json:"rx_rate,omitempty"` // realtime only
+	TxRate      float64 `json:"tx_rate,omitempty"`
 }
 
 // HistoricalStatsResult contains aggregated historical data.
 type HistoricalStatsResult struct {
 	Port       uint16     `json:"port"`
+	PortEnd    uint16     `json:"port_end,omitempty"`
+	Protocol   string     `json:"protocol"`
+	Netns      uint32     `json:"netns,omitempty"`
 	StartDate  string     `json:"start_date"`
 	EndDate    string     `json:"end_date"`
 	TotalRx    uint64     `json:"total_rx"`
@@ -86,6 +244,29 @@ type HistoricalStatsResult struct {
 	PeakRxRate uint64     `json:"peak_rx_rate"`
 	PeakTxRate uint64     `json:"peak_tx_rate"`
 	DailyStats []DayStats `json:"daily_stats,omitempty"`
+
+	// Split of the totals by the role the port played
+	Server TrafficStats `json:"server"`
+	Client TrafficStats `json:"client"`
+
+	// Traffic excluded by the port's CIDR filters, not part of the totals
+	Filtered TrafficStats `json:"filtered"`
+
+	// TCP health over the same period as the totals; nil for UDP ports
+	Health *TCPHealth `json:"health,omitempty"`
+
+	// TCP connections established over the same period; nil for UDP ports
+	Handshakes *HandshakeStats `json:"handshakes,omitempty"`
+
+	// IP-level traffic including headers and retransmissions, for
+	// reconciling the payload totals with provider bills; wire mode only
+	Wire *TrafficStats `json:"wire,omitempty"`
+
+	// Per-cgroup totals over the period, largest first
+	Cgroups []CgroupStats `json:"cgroups,omitempty"`
+
+	// Per-local-address totals over the period, largest first
+	LocalAddrs []LocalAddrStats `json:"local_addrs,omitempty"`
 }
 
 // DayStats represents a single day's statistics.
@@ -95,6 +276,8 @@ type DayStats struct {
 	TxBytes     uint64 `json:"tx_bytes"`
 	RxPackets   uint64 `json:"rx_packets"`
 	TxPackets   uint64 `json:"tx_packets"`
+	RxOps       uint64 `json:"rx_ops"`
+	TxOps       uint64 `json:"tx_ops"`
 	Connections uint64 `json:"connections"`
 	PeakRxRate  uint64 `json:"peak_rx_rate"`
 	PeakTxRate  uint64 `json:"peak_tx_rate"`
@@ -102,6 +285,9 @@ type DayStats struct {
 
 // ConnectionInfo represents an active connection.
 type ConnectionInfo struct {
+	Family     string    `json:"family"` // "ipv4" or "ipv6"
+	LocalAddr  string    `json:"local_addr"`
+	LocalPort  uint16    `json:"local_port"`
 	RemoteAddr string    `json:"remote_addr"`
 	RemotePort uint16    `json:"remote_port"`
 	RxBytes    uint64    `json:"rx_bytes"`
@@ -117,10 +303,130 @@ type ActiveConnectionsResult struct {
 	Count       int              `json:"count"`
 }
 
-// PortInfo contains port number and description.
-type PortInfo struct {
+// ConnectionHistoryEntry represents a finished connection.
+type ConnectionHistoryEntry struct {
+	Family     string    `json:"family"`
+	LocalAddr  string    `json:"local_addr"`
+	LocalPort  uint16    `json:"local_port"`
+	RemoteAddr string    `json:"remote_addr"`
+	RemotePort uint16    `json:"remote_port"`
+	RxBytes    uint64    `json:"rx_bytes"`
+	TxBytes    uint64    `json:"tx_bytes"`
+	StartedAt  time.Time `json:"started_at"`
+	EndedAt    time.Time `json:"ended_at"`
+}
+
+// ConnectionHistoryResult contains finished connections, largest first.
+type ConnectionHistoryResult struct {
+	Port        uint16                   `json:"port"`
+	StartDate   string                   `json:"start_date"`
+	EndDate     string                   `json:"end_date"`
+	Connections []ConnectionHistoryEntry `json:"connections"`
+}
+
+// PortBreakdownEntry holds the traffic of one member port of a range.
+type PortBreakdownEntry struct {
 	Port        uint16 `json:"port"`
-	Description string `json:"description"`
+	RxBytes     uint64 `json:"rx_bytes"`
+	TxBytes     uint64 `json:"tx_bytes"`
+	RxPackets   uint64 `json:"rx_packets"`
+	TxPackets   uint64 `json:"tx_packets"`
+	Connections uint64 `json:"connections"`
+}
+
+// PortBreakdownResult contains per-port traffic within a port range since
+// the daemon started, busiest ports first.
+type PortBreakdownResult struct {
+	Port     uint16               `json:"port"`
+	PortEnd  uint16               `json:"port_end,omitempty"`
+	Protocol string               `json:"protocol"`
+	Netns    uint32               `json:"netns,omitempty"`
+	Ports    []PortBreakdownEntry `json:"ports"`
+}
+
+// ProcessInfo holds one process's traffic on a port since the daemon started.
+type ProcessInfo struct {
+	Pid     uint32  `json:"pid"`
+	Tgid    uint32  `json:"tgid"`
+	Comm    string  `json:"comm"`
+	RxBytes uint64  `json:"rx_bytes"`
+	TxBytes uint64  `json:"tx_bytes"`
+	RxOps   uint64  `json:"rx_ops"`
+	TxOps   uint64  `json:"tx_ops"`
+	RxRate  float64 `json:"rx_rate"` // bytes/sec
+	TxRate  float64 `json:"tx_rate"`
+}
+
+// ProcessStatsResult lists processes by current rate, busiest first.
+type ProcessStatsResult struct {
+	Port      uint16        `json:"port"`
+	PortEnd   uint16        `json:"port_end,omitempty"`
+	Protocol  string        `json:"protocol"`
+	Netns     uint32        `json:"netns,omitempty"`
+	Processes []ProcessInfo `json:"processes"`
+}
+
+// SizeHistogramResult holds the distribution of a TCP port's send and
+// receive sizes over the last Hours hours, including the current hour.
+type SizeHistogramResult struct {
+	Port     uint16        `json:"port"`
+	PortEnd  uint16        `json:"port_end,omitempty"`
+	Protocol string        `json:"protocol"`
+	Netns    uint32        `json:"netns,omitempty"`
+	Hours    int           `json:"hours"`
+	Send     SizeHistogram `json:"send"`
+	Recv     SizeHistogram `json:"recv"`
+}
+
+// RateHistoryResult holds a port's per-second rates over the last
+// minutes, oldest first. It is kept in memory by the daemon, so it starts
+// empty after a restart and has no samples before the port's first
+// traffic.
+type RateHistoryResult struct {
+	Port     uint16       `json:"port"`
+	PortEnd  uint16       `json:"port_end,omitempty"`
+	Protocol string       `json:"protocol"`
+	Netns    uint32       `json:"netns,omitempty"`
+	Samples  []RateSample `json:"samples"`
+}
+
+// RateSample is a port's average rate over one second.
+type RateSample struct {
+	Time   int64   `json:"time"`    // Unix time of the start of the second
+	RxRate float64 `json:"rx_rate"` // bytes/sec
+	TxRate float64 `json:"tx_rate"` // bytes/sec
+}
+
+// SizeHistogram counts sends or receives by payload size. Bucket i counts
+// calls of [2^i, 2^(i+1)) bytes; percentiles are the upper bound of the
+// bucket they fall in.
+type SizeHistogram struct {
+	Samples uint64   `json:"samples"`
+	P50     uint64   `json:"p50_bytes"`
+	P90     uint64   `json:"p90_bytes"`
+	P99     uint64   `json:"p99_bytes"`
+	Buckets []uint64 `json:"buckets,omitempty"`
+}
+
+// PortInfo contains port number (or range), protocol and description.
+// Netns is set for ports monitored in a single network namespace, with
+// NetnsName the namespace as configured (e.g. "/run/netns/blue").
+type PortInfo struct {
+	Port        uint16       `json:"port"`
+	PortEnd     uint16       `json:"port_end,omitempty"`
+	Protocol    string       `json:"protocol"`
+	Netns       uint32       `json:"netns,omitempty"`
+	NetnsName   string       `json:"netns_name,omitempty"`
+	Role        string       `json:"role,omitempty"` // roles counted: "server", "client" or "both"
+	Filters     *PortFilters `json:"filters,omitempty"`
+	LocalAddrs  []string     `json:"local_addrs,omitempty"` // local addresses the port is pinned to
+	Description string       `json:"description"`
+}
+
+// PortFilters lists the remote address filters of a port as CIDR prefixes.
+type PortFilters struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
 }
 
 // StatusResult contains daemon status information.
@@ -128,17 +434,31 @@ type StatusResult struct {
 	Running        bool       `json:"running"`
 	Uptime         string     `json:"uptime"`
 	StartTime      string     `json:"start_time"`
-	MonitoredPorts []uint16   `json:"monitored_ports"`
+	MonitoredPorts []string   `json:"monitored_ports"` // "port/proto" or "first-last/proto"
 	PortInfos      []PortInfo `json:"port_infos"`
 	DataDir        string     `json:"data_dir"`
 	RetentionDays  int        `json:"retention_days"`
 	SocketPath     string     `json:"socket_path"`
+	Accounting     string     `json:"accounting"`  // "payload" or "wire"
+	AttachMode     string     `json:"attach_mode"` // "fentry", "kprobe", "tracepoint", "mixed" or "netlink"
+	DegradedProbes []string   `json:"degraded_probes,omitempty"`
+	Maps           []MapUsage `json:"maps,omitempty"`
 	Version        string     `json:"version"`
 }
 
+// MapUsage is the fill level of an in-kernel map and the number of inserts
+// dropped because it was full. MaxEntries is zero for ring buffers, whose
+// fill level isn't tracked.
+type MapUsage struct {
+	Name       string `json:"name"`
+	Entries    int    `json:"entries"`
+	MaxEntries int    `json:"max_entries"`
+	Drops      uint64 `json:"drops"`
+}
+
 // ListPortsResult contains the list of monitored ports.
 type ListPortsResult struct {
-	Ports []uint16 `json:"ports"`
+	Ports []string `json:"ports"` // "port/proto"
 }
 
 // SuccessResult indicates a successful operation.
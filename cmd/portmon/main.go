@@ -4,19 +4,34 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
+	"github.com/wellsgz/portmon/api"
 	"github.com/wellsgz/portmon/internal/client"
+	"github.com/wellsgz/portmon/internal/netns"
 	"github.com/wellsgz/portmon/internal/storage"
 	"github.com/wellsgz/portmon/internal/tui"
+	"github.com/wellsgz/portmon/internal/types"
 )
 
 var (
 	socketPath string
 	port       uint16
+	portSpec   string
+	protocol   string
+	netnsSpec  string
+	role       string
+	breakdown  bool
+	byProcess  bool
+	byCgroup   bool
+	byAddr     bool
+	localAddr  string
 	outputJSON bool
 	fromDate   string
 	toDate     string
@@ -25,6 +40,11 @@ var (
 	thisMonth  bool
 	last7Days  bool
 	last30Days bool
+
+	historyLimit   int
+	histogramHours int
+	rateSeconds    int
+	rateWindow     string
 )
 
 func main() {
@@ -42,7 +62,9 @@ func main() {
 		Short: "Launch interactive terminal UI",
 		RunE:  runTUI,
 	}
-	tuiCmd.Flags().Uint16VarP(&port, "port", "p", 0, "Initial port to display (optional)")
+	tuiCmd.Flags().StringVarP(&portSpec, "port", "p", "", "Initial port or range to display (optional)")
+	tuiCmd.Flags().StringVar(&protocol, "protocol", "tcp", "Protocol of the initial port (tcp or udp)")
+	tuiCmd.Flags().StringVar(&netnsSpec, "netns", "", "Network namespace of the initial port (path, name, pid:PID or inode)")
 
 	// Stats command
 	statsCmd := &cobra.Command{
@@ -50,7 +72,15 @@ func main() {
 		Short: "Show traffic statistics",
 		RunE:  runStats,
 	}
-	statsCmd.Flags().Uint16VarP(&port, "port", "p", 0, "Port to query (required)")
+	statsCmd.Flags().StringVarP(&portSpec, "port", "p", "", "Port or range to query, e.g. 5000 or 30000-30999 (required)")
+	statsCmd.Flags().StringVar(&protocol, "protocol", "tcp", "Protocol (tcp or udp)")
+	statsCmd.Flags().StringVar(&netnsSpec, "netns", "", "Network namespace the port is monitored in (path, name, pid:PID or inode)")
+	statsCmd.Flags().BoolVar(&breakdown, "breakdown", false, "Show per-port traffic within a range")
+	statsCmd.Flags().BoolVar(&byProcess, "by-process", false, "Show traffic per process")
+	statsCmd.Flags().BoolVar(&byCgroup, "by-cgroup", false, "Show traffic per cgroup (systemd unit or container)")
+	statsCmd.Flags().BoolVar(&byAddr, "by-local-addr", false, "Show traffic per local address")
+	statsCmd.Flags().StringVar(&localAddr, "local-addr", "", "Show traffic on this local address only")
+	statsCmd.Flags().StringVar(&rateWindow, "rate-window", "10s", "Window to average current rates over: 1s, 10s, 60s or 300s")
 	statsCmd.Flags().BoolVar(&outputJSON, "json", false, "Output in JSON format")
 	statsCmd.Flags().StringVar(&fromDate, "from", "", "Start date (YYYY-MM-DD)")
 	statsCmd.Flags().StringVar(&toDate, "to", "", "End date (YYYY-MM-DD)")
@@ -61,6 +91,55 @@ func main() {
 	statsCmd.Flags().BoolVar(&last30Days, "last-30-days", false, "Show last 30 days")
 	statsCmd.MarkFlagRequired("port")
 
+	// Connections command
+	connectionsCmd := &cobra.Command{
+		Use:   "connections",
+		Short: "Show active connections for a port",
+		RunE:  runConnections,
+	}
+	connectionsCmd.Flags().Uint16VarP(&port, "port", "p", 0, "Port to query (required)")
+	connectionsCmd.Flags().BoolVar(&outputJSON, "json", false, "Output in JSON format")
+	connectionsCmd.MarkFlagRequired("port")
+
+	// History command
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show finished connections, largest transfers first",
+		RunE:  runHistory,
+	}
+	historyCmd.Flags().Uint16VarP(&port, "port", "p", 0, "Port to query (required)")
+	historyCmd.Flags().BoolVar(&outputJSON, "json", false, "Output in JSON format")
+	historyCmd.Flags().StringVar(&fromDate, "from", "", "Start date (YYYY-MM-DD)")
+	historyCmd.Flags().StringVar(&toDate, "to", "", "End date (YYYY-MM-DD)")
+	historyCmd.Flags().BoolVar(&today, "today", false, "Show today's connections (default)")
+	historyCmd.Flags().BoolVar(&last7Days, "last-7-days", false, "Show last 7 days")
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 20, "Maximum number of connections to show (0 = all)")
+	historyCmd.MarkFlagRequired("port")
+
+	// Histogram command
+	histogramCmd := &cobra.Command{
+		Use:   "histogram",
+		Short: "Show the distribution of send and receive sizes on a TCP port",
+		RunE:  runHistogram,
+	}
+	histogramCmd.Flags().StringVarP(&portSpec, "port", "p", "", "Port or range to query (required)")
+	histogramCmd.Flags().StringVar(&netnsSpec, "netns", "", "Network namespace the port is monitored in (path, name, pid:PID or inode)")
+	histogramCmd.Flags().IntVar(&histogramHours, "hours", 24, "Number of hours to include, the current one included")
+	histogramCmd.Flags().BoolVar(&outputJSON, "json", false, "Output in JSON format")
+	histogramCmd.MarkFlagRequired("port")
+
+	// Rates command
+	ratesCmd := &cobra.Command{
+		Use:   "rates",
+		Short: "Graph the per-second rates of the last minutes",
+		RunE:  runRates,
+	}
+	ratesCmd.Flags().StringVarP(&portSpec, "port", "p", "", "Port or range to query (required)")
+	ratesCmd.Flags().StringVar(&netnsSpec, "netns", "", "Network namespace the port is monitored in (path, name, pid:PID or inode)")
+	ratesCmd.Flags().IntVar(&rateSeconds, "seconds", 0, "Number of seconds to show (0 = all kept, up to 15 minutes)")
+	ratesCmd.Flags().BoolVar(&outputJSON, "json", false, "Output in JSON format")
+	ratesCmd.MarkFlagRequired("port")
+
 	// Status command
 	statusCmd := &cobra.Command{
 		Use:   "status",
@@ -77,21 +156,23 @@ func main() {
 
 	// Add port command
 	addPortCmd := &cobra.Command{
-		Use:   "add-port PORT",
-		Short: "Add a port to monitor",
+		Use:   "add-port PORT[-LAST][/PROTO][@NETNS]",
+		Short: "Add a port or range to monitor (e.g. 5000, 5353/udp, 30000-30999 or 8080@blue)",
 		Args:  cobra.ExactArgs(1),
 		RunE:  runAddPort,
 	}
 
+	addPortCmd.Flags().StringVar(&role, "role", "both", "Which side of connections to count: server, client or both")
+
 	// Remove port command
 	removePortCmd := &cobra.Command{
-		Use:   "remove-port PORT",
-		Short: "Remove a port from monitoring",
+		Use:   "remove-port PORT[-LAST][/PROTO][@NETNS]",
+		Short: "Remove a port or range from monitoring",
 		Args:  cobra.ExactArgs(1),
 		RunE:  runRemovePort,
 	}
 
-	rootCmd.AddCommand(tuiCmd, statsCmd, statusCmd, listPortsCmd, addPortCmd, removePortCmd)
+	rootCmd.AddCommand(tuiCmd, statsCmd, connectionsCmd, historyCmd, histogramCmd, ratesCmd, statusCmd, listPortsCmd, addPortCmd, removePortCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -100,7 +181,15 @@ func main() {
 }
 
 func runTUI(cmd *cobra.Command, args []string) error {
-	model := tui.New(socketPath, port)
+	var key types.PortKey
+	if portSpec != "" {
+		var err error
+		if key, err = parsePortFlag(); err != nil {
+			return err
+		}
+	}
+
+	model := tui.New(socketPath, key)
 	p := tea.NewProgram(model, tea.WithAltScreen())
 	_, err := p.Run()
 	return err
@@ -114,39 +203,64 @@ func getClient() (*client.Client, error) {
 	return c, nil
 }
 
+// parsePortFlag parses the --port value, using --protocol unless the value
+// carries its own protocol suffix and --netns unless it names a namespace.
+func parsePortFlag() (types.PortKey, error) {
+	spec, ns, _ := strings.Cut(portSpec, "@")
+	if !strings.Contains(spec, "/") {
+		spec += "/" + protocol
+	}
+	if ns == "" {
+		ns = netnsSpec
+	}
+	return parsePortArg(spec + "@" + ns)
+}
+
+// parsePortArg parses "PORT[-LAST][/PROTO][@NETNS]", resolving the network
+// namespace to its inode.
+func parsePortArg(s string) (types.PortKey, error) {
+	spec, ns, _ := strings.Cut(s, "@")
+	key, err := types.ParsePortKey(spec)
+	if err != nil {
+		return types.PortKey{}, err
+	}
+	if ns != "" {
+		if key.Netns, err = netns.Resolve(ns); err != nil {
+			return types.PortKey{}, err
+		}
+	}
+	return key, nil
+}
+
 func runStats(cmd *cobra.Command, args []string) error {
+	key, err := parsePortFlag()
+	if err != nil {
+		return err
+	}
+
 	c, err := getClient()
 	if err != nil {
 		return err
 	}
 	defer c.Close()
 
-	now := time.Now()
+	if breakdown {
+		return printBreakdown(c, key)
+	}
+	if byProcess {
+		return printProcesses(c, key)
+	}
 
 	// Determine date range
-	var startDate, endDate string
-
-	switch {
-	case today:
-		start, end := storage.GetTodayDates(now)
-		startDate, endDate = storage.FormatDateRange(start, end)
-	case thisMonth:
-		start, end := storage.GetCurrentMonthDates(now)
-		startDate, endDate = storage.FormatDateRange(start, end)
-	case last7Days:
-		start, end := storage.GetLastNDays(7, now)
-		startDate, endDate = storage.FormatDateRange(start, end)
-	case last30Days:
-		start, end := storage.GetLastNDays(30, now)
-		startDate, endDate = storage.FormatDateRange(start, end)
-	case cycleDay > 0:
-		start, end := storage.GetBillingCycleDates(cycleDay, now)
-		startDate, endDate = storage.FormatDateRange(start, end)
-	case fromDate != "" && toDate != "":
-		startDate, endDate = fromDate, toDate
-	default:
+	startDate, endDate, ok := resolveDateRange(time.Now())
+	if !ok {
 		// Default: show realtime stats
-		stats, err := c.GetRealtimeStats(port)
+		window, err := types.ParseRateWindow(rateWindow)
+		if err != nil {
+			return err
+		}
+
+		stats, err := c.GetRealtimeStats(key, localAddr)
 		if err != nil {
 			return err
 		}
@@ -155,19 +269,35 @@ func runStats(cmd *cobra.Command, args []string) error {
 			return json.NewEncoder(os.Stdout).Encode(stats)
 		}
 
-		fmt.Printf("Port %d - Realtime Statistics\n", port)
+		rate := windowRate(stats, window)
+		fmt.Printf("Port %s%s - Realtime Statistics (rates over %s)\n", key, onLocalAddr(localAddr), types.RateWindowName(window))
 		fmt.Printf("════════════════════════════════════════\n")
-		fmt.Printf("  RX Bytes:    %s (%s/s)\n", formatBytes(stats.RxBytes), formatBytes(uint64(stats.RxRate)))
-		fmt.Printf("  TX Bytes:    %s (%s/s)\n", formatBytes(stats.TxBytes), formatBytes(uint64(stats.TxRate)))
+		fmt.Printf("  RX Bytes:    %s (%s/s)\n", formatBytes(stats.RxBytes), formatBytes(uint64(rate.RxRate)))
+		fmt.Printf("  TX Bytes:    %s (%s/s)\n", formatBytes(stats.TxBytes), formatBytes(uint64(rate.TxRate)))
 		fmt.Printf("  Total:       %s\n", formatBytes(stats.RxBytes+stats.TxBytes))
 		fmt.Printf("  RX Packets:  %d\n", stats.RxPackets)
 		fmt.Printf("  TX Packets:  %d\n", stats.TxPackets)
+		fmt.Printf("  RX Ops:      %d\n", stats.RxOps)
+		fmt.Printf("  TX Ops:      %d\n", stats.TxOps)
 		fmt.Printf("  Connections: %d\n", stats.Connections)
+		if localAddr == "" { // Not kept per local address
+			printFiltered(stats.Filtered)
+			printWire(stats.Wire, stats.RxBytes+stats.TxBytes)
+			printHealth(stats.Health)
+			printHandshakes(stats.Handshakes)
+			printRoles(stats.Server, stats.Client, true)
+		}
+		if byCgroup {
+			printCgroups(stats.Cgroups, true)
+		}
+		if byAddr || localAddr != "" {
+			printLocalAddrs(stats.LocalAddrs, true)
+		}
 		return nil
 	}
 
 	// Query historical stats
-	stats, err := c.GetHistoricalStats(port, startDate, endDate)
+	stats, err := c.GetHistoricalStats(key, localAddr, startDate, endDate)
 	if err != nil {
 		return err
 	}
@@ -176,14 +306,21 @@ func runStats(cmd *cobra.Command, args []string) error {
 		return json.NewEncoder(os.Stdout).Encode(stats)
 	}
 
-	fmt.Printf("Port %d - Historical Statistics\n", port)
+	fmt.Printf("Port %s%s - Historical Statistics\n", key, onLocalAddr(localAddr))
 	fmt.Printf("Period: %s to %s\n", startDate, endDate)
 	fmt.Printf("════════════════════════════════════════\n")
 	fmt.Printf("  Total RX:    %s\n", formatBytes(stats.TotalRx))
 	fmt.Printf("  Total TX:    %s\n", formatBytes(stats.TotalTx))
 	fmt.Printf("  Total:       %s\n", formatBytes(stats.TotalBytes))
-	fmt.Printf("  Peak RX:     %s/s\n", formatBytes(stats.PeakRxRate))
-	fmt.Printf("  Peak TX:     %s/s\n", formatBytes(stats.PeakTxRate))
+	if localAddr == "" { // Not kept per local address
+		fmt.Printf("  Peak RX:     %s/s\n", formatBytes(stats.PeakRxRate))
+		fmt.Printf("  Peak TX:     %s/s\n", formatBytes(stats.PeakTxRate))
+		printFiltered(stats.Filtered)
+		printWire(stats.Wire, stats.TotalBytes)
+		printHealth(stats.Health)
+		printHandshakes(stats.Handshakes)
+		printRoles(stats.Server, stats.Client, false)
+	}
 
 	if len(stats.DailyStats) > 0 {
 		fmt.Printf("\nDaily Breakdown:\n")
@@ -198,9 +335,470 @@ func runStats(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if byCgroup {
+		printCgroups(stats.Cgroups, false)
+	}
+	if byAddr || localAddr != "" {
+		printLocalAddrs(stats.LocalAddrs, false)
+	}
+
 	return nil
 }
 
+// onLocalAddr returns the suffix naming the local address stats are
+// limited to, if any.
+func onLocalAddr(addr string) string {
+	if addr == "" {
+		return ""
+	}
+	return " on " + addr
+}
+
+// printRoles prints the server/client split of a port's traffic.
+func printRoles(server, client api.TrafficStats, withRates bool) {
+	fmt.Printf("\nBy Role:\n")
+	fmt.Printf("  %-8s  %12s  %12s", "Role", "RX", "TX")
+	if withRates {
+		fmt.Printf("  %12s  %12s", "RX/s", "TX/s")
+	}
+	fmt.Println()
+
+	for _, r := range []struct {
+		name  string
+		stats api.TrafficStats
+	}{{"server", server}, {"client", client}} {
+		fmt.Printf("  %-8s  %12s  %12s", r.name, formatBytes(r.stats.RxBytes), formatBytes(r.stats.TxBytes))
+		if withRates {
+			fmt.Printf("  %12s  %12s", formatBytes(uint64(r.stats.RxRate)), formatBytes(uint64(r.stats.TxRate)))
+		}
+		fmt.Println()
+	}
+}
+
+// printFiltered prints the traffic excluded by a port's filters, which is
+// not part of the totals above it. Nothing is printed if there is none.
+func printFiltered(filtered api.TrafficStats) {
+	if filtered.RxBytes == 0 && filtered.TxBytes == 0 {
+		return
+	}
+	fmt.Printf("  Filtered:    %s RX / %s TX (not counted)\n", formatBytes(filtered.RxBytes), formatBytes(filtered.TxBytes))
+}
+
+// printWire prints IP-level traffic in wire accounting mode, with its
+// overhead over the payload total for reconciling against provider bills.
+func printWire(wire *api.TrafficStats, payload uint64) {
+	if wire == nil {
+		return
+	}
+	fmt.Printf("  Wire RX:     %s (%d packets)\n", formatBytes(wire.RxBytes), wire.RxPackets)
+	fmt.Printf("  Wire TX:     %s (%d packets)\n", formatBytes(wire.TxBytes), wire.TxPackets)
+	if total := wire.RxBytes + wire.TxBytes; payload > 0 && total >= payload {
+		fmt.Printf("  Wire Total:  %s (+%.1f%% over payload)\n", formatBytes(total), float64(total-payload)*100/float64(payload))
+	} else {
+		fmt.Printf("  Wire Total:  %s\n", formatBytes(total))
+	}
+}
+
+// printHealth prints the TCP health counters of a port. UDP ports have
+// none.
+func printHealth(h *api.TCPHealth) {
+	if h == nil {
+		return
+	}
+	fmt.Printf("\nTCP Health:\n")
+	fmt.Printf("  Retransmits: %d\n", h.Retransmits)
+	fmt.Printf("  Resets:      %d\n", h.Resets)
+	if h.RTTSamples == 0 {
+		fmt.Printf("  RTT:         no samples\n")
+		return
+	}
+	fmt.Printf("  RTT:         p50 <%s  p90 <%s  p99 <%s  (%d samples)\n",
+		formatMicros(h.RTTP50), formatMicros(h.RTTP90), formatMicros(h.RTTP99), h.RTTSamples)
+}
+
+// printHandshakes prints the TCP connections established on a port. UDP
+// ports have none.
+func printHandshakes(h *api.HandshakeStats) {
+	if h == nil {
+		return
+	}
+	fmt.Printf("\nTCP Connections:\n")
+	fmt.Printf("  Accepted:    %d\n", h.Accepted)
+	fmt.Printf("  Initiated:   %d\n", h.Initiated)
+	fmt.Printf("  Failed:      %d\n", h.Failed)
+}
+
+// formatMicros formats a duration given in microseconds.
+func formatMicros(us uint64) string {
+	return (time.Duration(us) * time.Microsecond).String()
+}
+
+// printCgroups prints per-cgroup traffic, labelled by container or systemd
+// unit when known.
+func printCgroups(cgroups []api.CgroupStats, withRates bool) {
+	fmt.Printf("\nBy Cgroup:\n")
+	if len(cgroups) == 0 {
+		fmt.Println("  No cgroup traffic")
+		return
+	}
+
+	fmt.Printf("  %-40s  %12s  %12s", "Cgroup", "RX", "TX")
+	if withRates {
+		fmt.Printf("  %12s  %12s", "RX/s", "TX/s")
+	}
+	fmt.Println()
+
+	for _, cg := range cgroups {
+		label := cg.Path
+		switch {
+		case cg.Container != "":
+			label = cg.Container
+		case cg.Unit != "":
+			label = cg.Unit
+		}
+		if len(label) > 40 {
+			label = "..." + label[len(label)-37:]
+		}
+
+		fmt.Printf("  %-40s  %12s  %12s", label, formatBytes(cg.RxBytes), formatBytes(cg.TxBytes))
+		if withRates {
+			fmt.Printf("  %12s  %12s", formatBytes(uint64(cg.RxRate)), formatBytes(uint64(cg.TxRate)))
+		}
+		fmt.Println()
+	}
+}
+
+// printLocalAddrs prints per-local-address traffic, for hosts serving a
+// port on several addresses.
+func printLocalAddrs(addrs []api.LocalAddrStats, withRates bool) {
+	fmt.Printf("\nBy Local Address:\n")
+	if len(addrs) == 0 {
+		fmt.Println("  No local address traffic")
+		return
+	}
+
+	fmt.Printf("  %-39s  %12s  %12s  %11s", "Address", "RX", "TX", "Connections")
+	if withRates {
+		fmt.Printf("  %12s  %12s", "RX/s", "TX/s")
+	}
+	fmt.Println()
+
+	for _, a := range addrs {
+		fmt.Printf("  %-39s  %12s  %12s  %11d", a.Addr, formatBytes(a.RxBytes), formatBytes(a.TxBytes), a.Connections)
+		if withRates {
+			fmt.Printf("  %12s  %12s", formatBytes(uint64(a.RxRate)), formatBytes(uint64(a.TxRate)))
+		}
+		fmt.Println()
+	}
+}
+
+// printBreakdown prints per-port traffic for the members of a port range.
+func printBreakdown(c *client.Client, key types.PortKey) error {
+	result, err := c.GetPortBreakdown(key)
+	if err != nil {
+		return err
+	}
+
+	if outputJSON {
+		return json.NewEncoder(os.Stdout).Encode(result)
+	}
+
+	fmt.Printf("Port %s - Per-Port Breakdown (since daemon start)\n", key)
+	fmt.Printf("════════════════════════════════════════\n")
+	if len(result.Ports) == 0 {
+		fmt.Println("  No traffic")
+		return nil
+	}
+
+	fmt.Printf("  %-6s  %12s  %12s  %12s  %11s\n", "Port", "RX", "TX", "Total", "Connections")
+	for _, p := range result.Ports {
+		fmt.Printf("  %-6d  %12s  %12s  %12s  %11d\n",
+			p.Port,
+			formatBytes(p.RxBytes),
+			formatBytes(p.TxBytes),
+			formatBytes(p.RxBytes+p.TxBytes),
+			p.Connections)
+	}
+
+	return nil
+}
+
+// printProcesses prints per-process traffic for a port, busiest first.
+func printProcesses(c *client.Client, key types.PortKey) error {
+	result, err := c.GetProcessStats(key, 0)
+	if err != nil {
+		return err
+	}
+
+	if outputJSON {
+		return json.NewEncoder(os.Stdout).Encode(result)
+	}
+
+	fmt.Printf("Port %s - Processes (since daemon start)\n", key)
+	fmt.Printf("════════════════════════════════════════\n")
+	if len(result.Processes) == 0 {
+		fmt.Println("  No traffic")
+		return nil
+	}
+
+	fmt.Printf("  %-8s  %-16s  %12s  %12s  %12s  %12s\n", "PID", "Command", "RX", "TX", "RX/s", "TX/s")
+	for _, p := range result.Processes {
+		fmt.Printf("  %-8d  %-16s  %12s  %12s  %12s  %12s\n",
+			p.Tgid,
+			p.Comm,
+			formatBytes(p.RxBytes),
+			formatBytes(p.TxBytes),
+			formatBytes(uint64(p.RxRate)),
+			formatBytes(uint64(p.TxRate)))
+	}
+
+	return nil
+}
+
+func runConnections(cmd *cobra.Command, args []string) error {
+	c, err := getClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	result, err := c.GetActiveConnections(port)
+	if err != nil {
+		return err
+	}
+
+	if outputJSON {
+		return json.NewEncoder(os.Stdout).Encode(result)
+	}
+
+	fmt.Printf("Port %d - Active Connections (%d)\n", port, result.Count)
+	fmt.Printf("════════════════════════════════════════\n")
+	if result.Count == 0 {
+		fmt.Println("  No active connections")
+		return nil
+	}
+
+	fmt.Printf("  %-6s  %-46s  %12s  %12s  %10s\n", "Family", "Remote", "RX", "TX", "Duration")
+	for _, conn := range result.Connections {
+		remote := net.JoinHostPort(conn.RemoteAddr, strconv.Itoa(int(conn.RemotePort)))
+		fmt.Printf("  %-6s  %-46s  %12s  %12s  %10s\n",
+			conn.Family,
+			remote,
+			formatBytes(conn.RxBytes),
+			formatBytes(conn.TxBytes),
+			conn.Duration)
+	}
+
+	return nil
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	c, err := getClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// Default to today when no range is given
+	startDate, endDate, ok := resolveDateRange(time.Now())
+	if !ok {
+		startDate, endDate = storage.FormatDateRange(storage.GetTodayDates(time.Now()))
+	}
+
+	result, err := c.GetConnectionHistory(port, startDate, endDate, historyLimit)
+	if err != nil {
+		return err
+	}
+
+	if outputJSON {
+		return json.NewEncoder(os.Stdout).Encode(result)
+	}
+
+	fmt.Printf("Port %d - Connection History\n", port)
+	fmt.Printf("Period: %s to %s\n", startDate, endDate)
+	fmt.Printf("════════════════════════════════════════\n")
+	if len(result.Connections) == 0 {
+		fmt.Println("  No finished connections")
+		return nil
+	}
+
+	fmt.Printf("  %-46s  %12s  %12s  %-19s  %-19s\n", "Remote", "RX", "TX", "Started", "Ended")
+	for _, conn := range result.Connections {
+		remote := net.JoinHostPort(conn.RemoteAddr, strconv.Itoa(int(conn.RemotePort)))
+		fmt.Printf("  %-46s  %12s  %12s  %-19s  %-19s\n",
+			remote,
+			formatBytes(conn.RxBytes),
+			formatBytes(conn.TxBytes),
+			conn.StartedAt.Local().Format("2006-01-02 15:04:05"),
+			conn.EndedAt.Local().Format("2006-01-02 15:04:05"))
+	}
+
+	return nil
+}
+
+// windowRate returns a port's rates averaged over window, or its rates
+// over the last poll from a daemon that doesn't average them.
+func windowRate(stats *api.RealtimeStatsResult, window time.Duration) api.WindowRate {
+	if r, ok := stats.Rates[types.RateWindowName(window)]; ok {
+		return r
+	}
+	return api.WindowRate{RxRate: stats.RxRate, TxRate: stats.TxRate}
+}
+
+// resolveDateRange returns the date range selected by the date flags.
+// ok is false when no range flag was given.
+func resolveDateRange(now time.Time) (startDate, endDate string, ok bool) {
+	switch {
+	case today:
+		startDate, endDate = storage.FormatDateRange(storage.GetTodayDates(now))
+	case thisMonth:
+		startDate, endDate = storage.FormatDateRange(storage.GetCurrentMonthDates(now))
+	case last7Days:
+		startDate, endDate = storage.FormatDateRange(storage.GetLastNDays(7, now))
+	case last30Days:
+		startDate, endDate = storage.FormatDateRange(storage.GetLastNDays(30, now))
+	case cycleDay > 0:
+		startDate, endDate = storage.FormatDateRange(storage.GetBillingCycleDates(cycleDay, now))
+	case fromDate != "" && toDate != "":
+		startDate, endDate = fromDate, toDate
+	default:
+		return "", "", false
+	}
+	return startDate, endDate, true
+}
+
+func runHistogram(cmd *cobra.Command, args []string) error {
+	protocol = "tcp" // Only TCP sizes are recorded
+	key, err := parsePortFlag()
+	if err != nil {
+		return err
+	}
+
+	c, err := getClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	result, err := c.GetSizeHistogram(key, histogramHours)
+	if err != nil {
+		return err
+	}
+
+	if outputJSON {
+		return json.NewEncoder(os.Stdout).Encode(result)
+	}
+
+	fmt.Printf("Port %s - Payload Sizes (last %d hours)\n", key, result.Hours)
+	fmt.Printf("════════════════════════════════════════\n")
+	printSizeHistogram("Sends", result.Send)
+	printSizeHistogram("Receives", result.Recv)
+	return nil
+}
+
+// printSizeHistogram prints the non-empty range of a size histogram as a
+// bar chart scaled to its largest bucket.
+func printSizeHistogram(title string, h api.SizeHistogram) {
+	fmt.Printf("\n%s (%d):\n", title, h.Samples)
+	if h.Samples == 0 {
+		fmt.Println("  No data")
+		return
+	}
+	fmt.Printf("  p50 <%s  p90 <%s  p99 <%s\n\n",
+		formatBucket(h.P50), formatBucket(h.P90), formatBucket(h.P99))
+
+	first, last := -1, 0
+	var peak uint64
+	for i, n := range h.Buckets {
+		if n == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		peak = max(peak, n)
+	}
+
+	const width = 40
+	for i := first; i <= last; i++ {
+		n := h.Buckets[i]
+		bar := int(n * width / peak)
+		if n > 0 && bar == 0 {
+			bar = 1
+		}
+		label := formatBucket(1<<i) + " - " + formatBucket(1<<(i+1))
+		if i == len(h.Buckets)-1 {
+			label = ">= " + formatBucket(1<<i)
+		}
+		fmt.Printf("  %17s  %10d  %s\n", label, n, strings.Repeat("█", bar))
+	}
+}
+
+// formatBucket formats a power-of-two bucket bound in the largest unit it
+// is a whole multiple of.
+func formatBucket(b uint64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for i < len(units)-1 && b >= 1024 && b%1024 == 0 {
+		b /= 1024
+		i++
+	}
+	return fmt.Sprintf("%d %s", b, units[i])
+}
+
+func runRates(cmd *cobra.Command, args []string) error {
+	key, err := parsePortFlag()
+	if err != nil {
+		return err
+	}
+
+	c, err := getClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	result, err := c.GetRateHistory(key, rateSeconds)
+	if err != nil {
+		return err
+	}
+
+	if outputJSON {
+		return json.NewEncoder(os.Stdout).Encode(result)
+	}
+
+	span := time.Duration(len(result.Samples)) * time.Second
+	fmt.Printf("Port %s - Rates (last %s)\n", key, span)
+	fmt.Printf("════════════════════════════════════════\n")
+	if len(result.Samples) == 0 {
+		fmt.Println("\nNo traffic seen since the daemon started")
+		return nil
+	}
+
+	rx := make([]float64, len(result.Samples))
+	tx := make([]float64, len(result.Samples))
+	for i, s := range result.Samples {
+		rx[i], tx[i] = s.RxRate, s.TxRate
+	}
+	printRateGraph("RX", rx)
+	printRateGraph("TX", tx)
+	return nil
+}
+
+// printRateGraph prints per-second rates as a sparkline with their peak
+// and average.
+func printRateGraph(title string, rates []float64) {
+	var sum, peak float64
+	for _, r := range rates {
+		sum += r
+		peak = max(peak, r)
+	}
+	fmt.Printf("\n%s:  peak %s/s  avg %s/s\n", title,
+		formatBytes(uint64(peak)), formatBytes(uint64(sum/float64(len(rates)))))
+	fmt.Printf("  %s\n", tui.Sparkline(rates, 60))
+}
+
 func runStatus(cmd *cobra.Command, args []string) error {
 	c, err := getClient()
 	if err != nil {
@@ -226,6 +824,21 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	fmt.Printf("  Data Dir:   %s\n", status.DataDir)
 	fmt.Printf("  Retention:  %d days\n", status.RetentionDays)
 	fmt.Printf("  Socket:     %s\n", status.SocketPath)
+	fmt.Printf("  Accounting: %s\n", status.Accounting)
+	fmt.Printf("  Probes:     %s\n", status.AttachMode)
+	for _, d := range status.DegradedProbes {
+		fmt.Printf("    degraded: %s\n", d)
+	}
+	if len(status.Maps) > 0 {
+		fmt.Printf("  Maps:\n")
+		for _, m := range status.Maps {
+			fill := "-"
+			if m.MaxEntries > 0 {
+				fill = fmt.Sprintf("%d/%d (%.0f%%)", m.Entries, m.MaxEntries, 100*float64(m.Entries)/float64(m.MaxEntries))
+			}
+			fmt.Printf("    %-20s %-22s drops: %d\n", m.Name, fill, m.Drops)
+		}
+	}
 	fmt.Printf("  Ports:      %v\n", status.MonitoredPorts)
 
 	return nil
@@ -244,7 +857,7 @@ func runListPorts(cmd *cobra.Command, args []string) error {
 	}
 
 	if outputJSON {
-		return json.NewEncoder(os.Stdout).Encode(map[string][]uint16{"ports": ports})
+		return json.NewEncoder(os.Stdout).Encode(map[string][]string{"ports": ports})
 	}
 
 	if len(ports) == 0 {
@@ -254,16 +867,16 @@ func runListPorts(cmd *cobra.Command, args []string) error {
 
 	fmt.Println("Monitored ports:")
 	for _, p := range ports {
-		fmt.Printf("  - %d\n", p)
+		fmt.Printf("  - %s\n", p)
 	}
 
 	return nil
 }
 
 func runAddPort(cmd *cobra.Command, args []string) error {
-	var portNum uint16
-	if _, err := fmt.Sscanf(args[0], "%d", &portNum); err != nil {
-		return fmt.Errorf("invalid port: %s", args[0])
+	key, err := parsePortArg(args[0])
+	if err != nil {
+		return err
 	}
 
 	c, err := getClient()
@@ -272,18 +885,18 @@ func runAddPort(cmd *cobra.Command, args []string) error {
 	}
 	defer c.Close()
 
-	if err := c.AddPort(portNum); err != nil {
+	if err := c.AddPort(key, role); err != nil {
 		return err
 	}
 
-	fmt.Printf("Port %d added to monitoring\n", portNum)
+	fmt.Printf("Port %s added to monitoring\n", key)
 	return nil
 }
 
 func runRemovePort(cmd *cobra.Command, args []string) error {
-	var portNum uint16
-	if _, err := fmt.Sscanf(args[0], "%d", &portNum); err != nil {
-		return fmt.Errorf("invalid port: %s", args[0])
+	key, err := parsePortArg(args[0])
+	if err != nil {
+		return err
 	}
 
 	c, err := getClient()
@@ -292,11 +905,11 @@ func runRemovePort(cmd *cobra.Command, args []string) error {
 	}
 	defer c.Close()
 
-	if err := c.RemovePort(portNum); err != nil {
+	if err := c.RemovePort(key); err != nil {
 		return err
 	}
 
-	fmt.Printf("Port %d removed from monitoring\n", portNum)
+	fmt.Printf("Port %s removed from monitoring\n", key)
 	return nil
 }
 
@@ -2,40 +2,51 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/wellsgz/portmon/internal/config"
 	"github.com/wellsgz/portmon/internal/daemon"
+	"github.com/wellsgz/portmon/internal/ebpf"
+	"github.com/wellsgz/portmon/internal/netns"
+	"github.com/wellsgz/portmon/internal/types"
 )
 
 var (
 	configPath    string
-	ports         []int
+	ports         []string
 	dataDir       string
 	retentionDays int
 	socketPath    string
 	logLevel      string
+	accounting    string
+	resetMaps     bool
 )
 
 func main() {
 	rootCmd := &cobra.Command{
 		Use:   "portmond",
 		Short: "eBPF port traffic monitor daemon",
-		Long: `portmond is a daemon that uses eBPF kprobes to monitor TCP traffic
-on specified ports. It collects statistics, persists them to SQLite,
+		Long: `portmond is a daemon that uses eBPF kprobes to monitor TCP and UDP
+traffic on specified ports. It collects statistics, persists them to SQLite,
 and exposes an IPC interface for clients.`,
 		RunE: runDaemon,
 	}
 
 	rootCmd.Flags().StringVarP(&configPath, "config", "c", "", "Config file path (default: /etc/portmon/portmon.yaml)")
-	rootCmd.Flags().IntSliceVarP(&ports, "port", "p", nil, "Ports to monitor (can be specified multiple times)")
+	rootCmd.Flags().StringSliceVarP(&ports, "port", "p", nil, "Ports to monitor, e.g. 5000, 5353/udp, 30000-30999 or 8080@blue to limit to a network namespace (can be specified multiple times)")
 	rootCmd.Flags().StringVar(&dataDir, "data-dir", "", "Data directory (default: /var/lib/portmon)")
 	rootCmd.Flags().IntVar(&retentionDays, "retention-days", 0, "Data retention in days (1-365)")
 	rootCmd.Flags().StringVar(&socketPath, "socket", "", "Unix socket path (default: /run/portmon/portmon.sock)")
 	rootCmd.Flags().StringVar(&logLevel, "log-level", "", "Log level (debug, info, warn, error)")
+	rootCmd.Flags().StringVar(&accounting, "accounting", "", "Accounting mode: payload, or wire to also count IP-level bytes")
+	rootCmd.Flags().BoolVar(&resetMaps, "reset-maps", false, "Discard the in-kernel counters kept from the previous run")
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -56,17 +67,27 @@ func runDaemon(cmd *cobra.Command, args []string) error {
 	if fileCfg, err := config.Load(cfgPath); err == nil {
 		slog.Info("loaded config file", "path", cfgPath)
 		cfg = fileCfg
-	} else if configPath != "" {
-		// Only error if user explicitly specified a config file
-		return fmt.Errorf("loading config file %s: %w", configPath, err)
+	} else if configPath != "" || !errors.Is(err, fs.ErrNotExist) {
+		// A missing default config file is fine, a broken one is not
+		return fmt.Errorf("loading config file %s: %w", cfgPath, err)
 	}
 
 	// CLI flags override config file
 	if len(ports) > 0 {
-		// Convert CLI int ports to PortConfig
+		// Convert CLI "port[/proto][@netns]" values to PortConfig
 		cfg.Ports = make([]config.PortConfig, len(ports))
 		for i, p := range ports {
-			cfg.Ports[i] = config.PortConfig{Port: p}
+			spec, ns, _ := strings.Cut(p, "@")
+			key, err := types.ParsePortKey(spec)
+			if err != nil {
+				return err
+			}
+			cfg.Ports[i] = config.PortConfig{
+				Port:     int(key.Port),
+				PortEnd:  int(key.PortEnd),
+				Protocol: types.ProtocolName(key.Protocol),
+				Netns:    ns,
+			}
 		}
 	}
 	if dataDir != "" {
@@ -81,6 +102,9 @@ func runDaemon(cmd *cobra.Command, args []string) error {
 	if logLevel != "" {
 		cfg.LogLevel = logLevel
 	}
+	if accounting != "" {
+		cfg.Accounting = accounting
+	}
 
 	// Set default for log level if still empty
 	if cfg.LogLevel == "" {
@@ -113,22 +137,64 @@ func runDaemon(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("at least one port must be specified (via --port or config file)")
 	}
 
-	// Convert and validate ports (detect duplicates)
-	portList := make([]uint16, 0, len(cfg.Ports))
+	// Convert and validate ports (detect duplicates and overlapping ranges)
+	portList := make([]types.PortKey, 0, len(cfg.Ports))
 	portInfos := make([]daemon.PortInfo, 0, len(cfg.Ports))
-	seen := make(map[int]bool)
 	for _, p := range cfg.Ports {
-		if p.Port < 1 || p.Port > 65535 {
-			return fmt.Errorf("invalid port %d: must be between 1 and 65535", p.Port)
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		proto, err := types.ParseProtocol(p.Protocol)
+		if err != nil {
+			return fmt.Errorf("invalid port %d: %w", p.Port, err)
+		}
+		roles, err := types.ParseRoles(p.Role)
+		if err != nil {
+			return fmt.Errorf("invalid port %d: %w", p.Port, err)
+		}
+		key := types.NewPortKey(uint16(p.Port), uint16(p.PortEnd), proto)
+		if p.Netns != "" {
+			if key.Netns, err = netns.Resolve(p.Netns); err != nil {
+				return fmt.Errorf("invalid port %s: %w", key, err)
+			}
+		}
+		var filters types.PortFilters
+		if filters.Include, err = types.ParsePrefixes(p.Filters.Include); err != nil {
+			return fmt.Errorf("invalid include filter for port %s: %w", key, err)
 		}
-		if seen[p.Port] {
-			slog.Warn("duplicate port in configuration, skipping", "port", p.Port)
+		if filters.Exclude, err = types.ParsePrefixes(p.Filters.Exclude); err != nil {
+			return fmt.Errorf("invalid exclude filter for port %s: %w", key, err)
+		}
+		localAddrs, err := types.ParseLocalAddrs(p.LocalAddr)
+		if err != nil {
+			return fmt.Errorf("invalid port %s: %w", key, err)
+		}
+
+		duplicate := false
+		for _, existing := range portList {
+			if existing == key {
+				duplicate = true
+				break
+			}
+			if existing.Overlaps(key) {
+				return fmt.Errorf("port %s overlaps %s", key, existing)
+			}
+		}
+		if duplicate {
+			slog.Warn("duplicate port in configuration, skipping", "port", key)
 			continue
 		}
-		seen[p.Port] = true
-		portList = append(portList, uint16(p.Port))
+
+		portList = append(portList, key)
 		portInfos = append(portInfos, daemon.PortInfo{
-			Port:        uint16(p.Port),
+			Port:        key.Port,
+			PortEnd:     key.PortEnd,
+			Protocol:    key.Protocol,
+			Netns:       key.Netns,
+			NetnsName:   p.Netns,
+			Roles:       roles,
+			Filters:     filters,
+			LocalAddrs:  localAddrs,
 			Description: p.Description,
 		})
 	}
@@ -137,6 +203,29 @@ func runDaemon(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("retention_days must be between 1 and 365")
 	}
 
+	if cfg.Accounting == "" {
+		cfg.Accounting = daemon.AccountingPayload
+	}
+	if cfg.Accounting != daemon.AccountingPayload && cfg.Accounting != daemon.AccountingWire {
+		return fmt.Errorf("accounting must be %q or %q", daemon.AccountingPayload, daemon.AccountingWire)
+	}
+
+	var peakWindow time.Duration
+	if cfg.PeakRateWindow != "" {
+		w, err := types.ParseRateWindow(cfg.PeakRateWindow)
+		if err != nil {
+			return fmt.Errorf("peak_rate_window: %w", err)
+		}
+		peakWindow = w
+	}
+
+	if cfg.MaxPorts < 0 || cfg.MaxConnections < 0 {
+		return fmt.Errorf("max_ports and max_connections must not be negative")
+	}
+	if cfg.MaxPorts > 0 && len(portList) > cfg.MaxPorts {
+		return fmt.Errorf("%d ports configured, more than max_ports (%d)", len(portList), cfg.MaxPorts)
+	}
+
 	// Check for root privileges (required for eBPF)
 	if os.Geteuid() != 0 {
 		slog.Warn("running without root privileges, eBPF loading may fail")
@@ -149,6 +238,13 @@ func runDaemon(cmd *cobra.Command, args []string) error {
 		RetentionDays: cfg.RetentionDays,
 		SocketPath:    cfg.Socket,
 		LogLevel:      cfg.LogLevel,
+		Accounting:    cfg.Accounting,
+		ResetMaps:     resetMaps,
+		MapLimits: ebpf.MapLimits{
+			MaxPorts:       cfg.MaxPorts,
+			MaxConnections: cfg.MaxConnections,
+		},
+		PeakRateWindow: peakWindow,
 	}
 
 	d := daemon.New(daemonCfg)
@@ -11,6 +11,7 @@ import (
 	"sync/atomic"
 
 	"github.com/wellsgz/portmon/api"
+	"github.com/wellsgz/portmon/internal/types"
 )
 
 // Client connects to the portmon daemon via Unix socket.
@@ -129,9 +130,23 @@ func (c *Client) call(method string, params interface{}) (*api.Response, error)
 	return &resp, nil
 }
 
-// GetRealtimeStats retrieves current stats for a port.
-func (c *Client) GetRealtimeStats(port uint16) (*api.RealtimeStatsResult, error) {
-	resp, err := c.call(api.MethodGetRealtimeStats, api.PortParams{Port: port})
+// portParams converts a PortKey into request parameters.
+func portParams(key types.PortKey) api.PortParams {
+	return api.PortParams{
+		Port:     key.Port,
+		PortEnd:  key.PortEnd,
+		Protocol: types.ProtocolName(key.Protocol),
+		Netns:    key.Netns,
+	}
+}
+
+// GetRealtimeStats retrieves current stats for a port or port range. A
+// non-empty localAddr limits the per-local-address breakdown to that
+// address.
+func (c *Client) GetRealtimeStats(key types.PortKey, localAddr string) (*api.RealtimeStatsResult, error) {
+	params := portParams(key)
+	params.LocalAddr = localAddr
+	resp, err := c.call(api.MethodGetRealtimeStats, params)
 	if err != nil {
 		return nil, err
 	}
@@ -144,9 +159,15 @@ func (c *Client) GetRealtimeStats(port uint16) (*api.RealtimeStatsResult, error)
 }
 
 // GetHistoricalStats retrieves historical stats for a port and date range.
-func (c *Client) GetHistoricalStats(port uint16, startDate, endDate string) (*api.HistoricalStatsResult, error) {
+// A non-empty localAddr limits the per-local-address breakdown to that
+// address.
+func (c *Client) GetHistoricalStats(key types.PortKey, localAddr, startDate, endDate string) (*api.HistoricalStatsResult, error) {
 	resp, err := c.call(api.MethodGetHistoricalStats, api.HistoricalParams{
-		Port:      port,
+		Port:      key.Port,
+		PortEnd:   key.PortEnd,
+		Protocol:  types.ProtocolName(key.Protocol),
+		Netns:     key.Netns,
+		LocalAddr: localAddr,
 		StartDate: startDate,
 		EndDate:   endDate,
 	})
@@ -161,6 +182,116 @@ func (c *Client) GetHistoricalStats(port uint16, startDate, endDate string) (*ap
 	return &result, nil
 }
 
+// GetActiveConnections retrieves the tracked connections for a port.
+func (c *Client) GetActiveConnections(port uint16) (*api.ActiveConnectionsResult, error) {
+	resp, err := c.call(api.MethodGetActiveConnections, api.PortParams{Port: port})
+	if err != nil {
+		return nil, err
+	}
+
+	var result api.ActiveConnectionsResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetConnectionHistory retrieves finished connections for a port and date range.
+func (c *Client) GetConnectionHistory(port uint16, startDate, endDate string, limit int) (*api.ConnectionHistoryResult, error) {
+	resp, err := c.call(api.MethodGetConnectionHistory, api.ConnectionHistoryParams{
+		Port:      port,
+		StartDate: startDate,
+		EndDate:   endDate,
+		Limit:     limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result api.ConnectionHistoryResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetPortBreakdown retrieves per-port traffic for the members of a port range.
+func (c *Client) GetPortBreakdown(key types.PortKey) (*api.PortBreakdownResult, error) {
+	resp, err := c.call(api.MethodGetPortBreakdown, portParams(key))
+	if err != nil {
+		return nil, err
+	}
+
+	var result api.PortBreakdownResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetProcessStats retrieves the busiest processes on a port. A limit of 0
+// returns all processes.
+func (c *Client) GetProcessStats(key types.PortKey, limit int) (*api.ProcessStatsResult, error) {
+	resp, err := c.call(api.MethodGetProcessStats, api.ProcessStatsParams{
+		Port:     key.Port,
+		PortEnd:  key.PortEnd,
+		Protocol: types.ProtocolName(key.Protocol),
+		Netns:    key.Netns,
+		Limit:    limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result api.ProcessStatsResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetSizeHistogram retrieves the send and receive size distributions of a
+// TCP port over the last hours hours. Zero hours selects the default.
+func (c *Client) GetSizeHistogram(key types.PortKey, hours int) (*api.SizeHistogramResult, error) {
+	resp, err := c.call(api.MethodGetSizeHistogram, api.SizeHistogramParams{
+		Port:     key.Port,
+		PortEnd:  key.PortEnd,
+		Protocol: types.ProtocolName(key.Protocol),
+		Netns:    key.Netns,
+		Hours:    hours,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result api.SizeHistogramResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetRateHistory retrieves a port's per-second rates over the last
+// seconds, or all the daemon keeps if seconds is zero.
+func (c *Client) GetRateHistory(key types.PortKey, seconds int) (*api.RateHistoryResult, error) {
+	resp, err := c.call(api.MethodGetRateHistory, api.RateHistoryParams{
+		Port:     key.Port,
+		PortEnd:  key.PortEnd,
+		Protocol: types.ProtocolName(key.Protocol),
+		Netns:    key.Netns,
+		Seconds:  seconds,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result api.RateHistoryResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 // GetStatus retrieves daemon status.
 func (c *Client) GetStatus() (*api.StatusResult, error) {
 	resp, err := c.call(api.MethodGetStatus, nil)
@@ -175,20 +306,24 @@ func (c *Client) GetStatus() (*api.StatusResult, error) {
 	return &result, nil
 }
 
-// AddPort adds a port to monitoring.
-func (c *Client) AddPort(port uint16) error {
-	_, err := c.call(api.MethodAddPort, api.PortParams{Port: port})
+// AddPort adds a port or port range to monitoring. role is "server",
+// "client" or empty for both.
+func (c *Client) AddPort(key types.PortKey, role string) error {
+	params := portParams(key)
+	params.Role = role
+	_, err := c.call(api.MethodAddPort, params)
 	return err
 }
 
-// RemovePort removes a port from monitoring.
-func (c *Client) RemovePort(port uint16) error {
-	_, err := c.call(api.MethodRemovePort, api.PortParams{Port: port})
+// RemovePort removes a port or port range from monitoring.
+func (c *Client) RemovePort(key types.PortKey) error {
+	_, err := c.call(api.MethodRemovePort, portParams(key))
 	return err
 }
 
-// ListPorts returns all monitored ports.
-func (c *Client) ListPorts() ([]uint16, error) {
+// ListPorts returns all monitored ports as "port/proto" or
+// "first-last/proto" strings.
+func (c *Client) ListPorts() ([]string, error) {
 	resp, err := c.call(api.MethodListPorts, nil)
 	if err != nil {
 		return nil, err
@@ -2,16 +2,42 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 // PortConfig holds port configuration with optional description.
+// A non-zero PortEnd makes the entry an inclusive port range that is
+// monitored as one service. A non-empty Netns restricts the entry to one
+// network namespace; otherwise the port is matched in every namespace.
+// Role limits counting to connections to the local port ("server") or to
+// a remote port ("client"); both are counted by default. Filters restrict
+// counting by remote address. A non-empty LocalAddr pins the entry to
+// traffic on those local addresses, for hosts with several addresses
+// serving the same port.
 type PortConfig struct {
-	Port        int    `yaml:"port"`
-	Description string `yaml:"description"`
+	Port        int          `yaml:"port"`
+	PortEnd     int          `yaml:"port_end"`
+	Protocol    string       `yaml:"protocol"` // "tcp" (default) or "udp"
+	Netns       string       `yaml:"netns"`    // path, "ip netns" name or "pid:<pid>"
+	Role        string       `yaml:"role"`     // "server", "client" or "both" (default)
+	Filters     FilterConfig `yaml:"filters"`
+	LocalAddr   []string     `yaml:"local_addr"` // one address or a list
+	Description string       `yaml:"description"`
+}
+
+// FilterConfig lists remote address filters as CIDRs, addresses or named
+// sets such as "loopback" and "rfc1918". With include rules only matching
+// peers are counted; exclude rules drop matching peers. The most specific
+// rule wins. Excluded traffic is reported separately.
+type FilterConfig struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
 }
 
 // Config holds daemon configuration.
@@ -22,6 +48,16 @@ type Config struct {
 	RetentionDays int          `yaml:"retention_days"`
 	Socket        string       `yaml:"socket"`
 	LogLevel      string       `yaml:"log_level"`
+	Accounting    string       `yaml:"accounting"` // "payload" (default) or "wire"
+
+	// Rate window daily peaks are tracked over: "1s" (default), "10s",
+	// "60s" or "300s"
+	PeakRateWindow string `yaml:"peak_rate_window"`
+
+	// Capacity of the in-kernel maps; changing them resizes the pinned
+	// maps on the next start
+	MaxPorts       int `yaml:"max_ports"`
+	MaxConnections int `yaml:"max_connections"`
 }
 
 // DefaultConfigPath is the default location for the config file.
@@ -46,50 +82,166 @@ func Load(path string) (*Config, error) {
 	}
 
 	// Parse ports - support both old (int list) and new (object list) formats
-	cfg.Ports = parsePorts(cfg.RawPorts)
+	if cfg.Ports, err = parsePorts(cfg.RawPorts); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
 
 // parsePorts handles both formats:
-// ports: [5000, 8080]  OR  ports: [{port: 5000, description: "API"}]
-func parsePorts(raw interface{}) []PortConfig {
+// ports: [5000, "5353/udp", "30000-30999"]  OR
+// ports: [{port: 5000, protocol: udp, description: "API"}, {port: "30000-30999"}]
+//
+// Object entries may also set netns (a namespace path or name) or
+// netns_pid (the PID of a process whose namespace to use), role, and
+// filters with include and exclude lists. A malformed entry is an error.
+func parsePorts(raw interface{}) ([]PortConfig, error) {
 	if raw == nil {
-		return nil
+		return nil, nil
+	}
+
+	v, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("ports: want a list, got %v", raw)
+	}
+
+	ports := make([]PortConfig, 0, len(v))
+	for i, item := range v {
+		pc, err := parsePortEntry(item)
+		if err == nil {
+			err = pc.Validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ports[%d]: %w", i, err)
+		}
+		ports = append(ports, pc)
 	}
+	return ports, nil
+}
 
+// parsePortEntry parses one entry of the ports list.
+func parsePortEntry(item interface{}) (PortConfig, error) {
+	switch p := item.(type) {
+	case int:
+		return PortConfig{Port: p}, nil
+	case float64:
+		return PortConfig{Port: int(p)}, nil
+	case string:
+		return parsePortString(p)
+	case map[string]interface{}:
+		pc := PortConfig{}
+		switch pv := p["port"].(type) {
+		case int:
+			pc.Port = pv
+		case float64:
+			pc.Port = int(pv)
+		case string:
+			parsed, err := parsePortString(pv)
+			if err != nil {
+				return PortConfig{}, err
+			}
+			pc.Port = parsed.Port
+			pc.PortEnd = parsed.PortEnd
+			pc.Protocol = parsed.Protocol
+		case nil:
+			return PortConfig{}, fmt.Errorf("missing port")
+		default:
+			return PortConfig{}, fmt.Errorf("invalid port %v", pv)
+		}
+		switch pv := p["port_end"].(type) {
+		case int:
+			pc.PortEnd = pv
+		case float64:
+			pc.PortEnd = int(pv)
+		case nil:
+		default:
+			return PortConfig{}, fmt.Errorf("invalid port_end %v", pv)
+		}
+		if proto, ok := p["protocol"].(string); ok {
+			pc.Protocol = proto
+		}
+		if ns, ok := p["netns"].(string); ok {
+			pc.Netns = ns
+		}
+		if pid, ok := p["netns_pid"]; ok {
+			if pc.Netns != "" {
+				return PortConfig{}, fmt.Errorf("netns and netns_pid are mutually exclusive")
+			}
+			switch pv := pid.(type) {
+			case int:
+				pc.Netns = fmt.Sprintf("pid:%d", pv)
+			case float64:
+				pc.Netns = fmt.Sprintf("pid:%d", int(pv))
+			default:
+				return PortConfig{}, fmt.Errorf("invalid netns_pid %v", pv)
+			}
+		}
+		if role, ok := p["role"].(string); ok {
+			pc.Role = role
+		}
+		if filters, ok := p["filters"].(map[string]interface{}); ok {
+			pc.Filters.Include = stringList(filters["include"])
+			pc.Filters.Exclude = stringList(filters["exclude"])
+		}
+		pc.LocalAddr = stringList(p["local_addr"])
+		if desc, ok := p["description"].(string); ok {
+			pc.Description = desc
+		}
+		return pc, nil
+	}
+	return PortConfig{}, fmt.Errorf("invalid port %v", item)
+}
+
+// Validate checks that the entry's port, and the end of its range if any,
+// are valid port numbers in order.
+func (pc PortConfig) Validate() error {
+	if pc.Port < 1 || pc.Port > 65535 {
+		return fmt.Errorf("invalid port %d: must be between 1 and 65535", pc.Port)
+	}
+	if pc.PortEnd != 0 && (pc.PortEnd < pc.Port || pc.PortEnd > 65535) {
+		return fmt.Errorf("invalid port range %d-%d", pc.Port, pc.PortEnd)
+	}
+	return nil
+}
+
+// stringList converts a YAML list of strings, or a single string, into a
+// slice.
+func stringList(raw interface{}) []string {
 	switch v := raw.(type) {
+	case string:
+		return []string{v}
 	case []interface{}:
-		ports := make([]PortConfig, 0, len(v))
+		list := make([]string, 0, len(v))
 		for _, item := range v {
-			switch p := item.(type) {
-			case int:
-				ports = append(ports, PortConfig{Port: p})
-			case float64:
-				ports = append(ports, PortConfig{Port: int(p)})
-			case map[string]interface{}:
-				pc := PortConfig{}
-				if port, ok := p["port"]; ok {
-					switch pv := port.(type) {
-					case int:
-						pc.Port = pv
-					case float64:
-						pc.Port = int(pv)
-					}
-				}
-				if desc, ok := p["description"].(string); ok {
-					pc.Description = desc
-				}
-				if pc.Port > 0 {
-					ports = append(ports, pc)
-				}
+			if s, ok := item.(string); ok {
+				list = append(list, s)
 			}
 		}
-		return ports
+		return list
 	}
 	return nil
 }
 
+// parsePortString parses "5000", "5000/udp" or "30000-30999[/udp]".
+func parsePortString(s string) (PortConfig, error) {
+	portStr, proto, _ := strings.Cut(strings.TrimSpace(s), "/")
+	firstStr, lastStr, isRange := strings.Cut(portStr, "-")
+	port, err := strconv.Atoi(strings.TrimSpace(firstStr))
+	if err != nil {
+		return PortConfig{}, fmt.Errorf("invalid port %q", s)
+	}
+	pc := PortConfig{Port: port, Protocol: proto}
+	if isRange {
+		end, err := strconv.Atoi(strings.TrimSpace(lastStr))
+		if err != nil {
+			return PortConfig{}, fmt.Errorf("invalid port range %q", s)
+		}
+		pc.PortEnd = end
+	}
+	return pc, nil
+}
+
 // GetPortNumbers returns just the port numbers for backward compatibility.
 func (c *Config) GetPortNumbers() []int {
 	ports := make([]int, len(c.Ports))
@@ -107,5 +259,9 @@ func Defaults() *Config {
 		RetentionDays: 180,
 		Socket:        "/run/portmon/portmon.sock",
 		LogLevel:      "info",
+		Accounting:    "payload",
+
+		MaxPorts:       64,
+		MaxConnections: 10240,
 	}
 }
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadString loads a config file with the given contents.
+func loadString(t *testing.T, yaml string) (*Config, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "portmon.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestLoadPorts(t *testing.T) {
+	tests := []struct {
+		name  string
+		yaml  string
+		want  []PortConfig
+		error string // Substring of the expected error, if any
+	}{
+		{
+			name: "numbers and strings",
+			yaml: `ports: [5000, "5353/udp", "30000-30999", "40000-40099/udp"]`,
+			want: []PortConfig{
+				{Port: 5000},
+				{Port: 5353, Protocol: "udp"},
+				{Port: 30000, PortEnd: 30999},
+				{Port: 40000, PortEnd: 40099, Protocol: "udp"},
+			},
+		},
+		{
+			name: "objects",
+			yaml: `
+ports:
+  - port: 5000
+    protocol: udp
+    description: API
+  - port: "30000-30999"
+  - port: 8000
+    port_end: 8099
+    role: server
+`,
+			want: []PortConfig{
+				{Port: 5000, Protocol: "udp", Description: "API"},
+				{Port: 30000, PortEnd: 30999},
+				{Port: 8000, PortEnd: 8099, Role: "server"},
+			},
+		},
+		{
+			name: "single port range",
+			yaml: `ports: ["8080-8080"]`,
+			want: []PortConfig{{Port: 8080, PortEnd: 8080}},
+		},
+		{
+			name: "network namespaces",
+			yaml: `
+ports:
+  - port: 5000
+    netns: blue
+  - port: 5001
+    netns: /proc/1/ns/net
+  - port: 5002
+    netns_pid: 1234
+  - port: 5003
+    netns: "pid:42"
+  - port: 5004
+    netns: "4026531840"
+`,
+			want: []PortConfig{
+				{Port: 5000, Netns: "blue"},
+				{Port: 5001, Netns: "/proc/1/ns/net"},
+				{Port: 5002, Netns: "pid:1234"},
+				{Port: 5003, Netns: "pid:42"},
+				{Port: 5004, Netns: "4026531840"},
+			},
+		},
+		{name: "no ports", yaml: `data_dir: /tmp`},
+		{name: "malformed range end", yaml: `ports: ["30000-abc"]`, error: `ports[0]: invalid port range "30000-abc"`},
+		{name: "malformed port", yaml: `ports: [5000, "abc/udp"]`, error: `ports[1]: invalid port "abc/udp"`},
+		{name: "zero", yaml: `ports: ["0"]`, error: "ports[0]: invalid port 0"},
+		{name: "zero number", yaml: `ports: [0]`, error: "ports[0]: invalid port 0"},
+		{name: "too large", yaml: `ports: [70000]`, error: "ports[0]: invalid port 70000"},
+		{name: "reversed range", yaml: `ports: ["30999-30000"]`, error: "ports[0]: invalid port range 30999-30000"},
+		{name: "range end too large", yaml: `ports: ["30000-70000"]`, error: "ports[0]: invalid port range 30000-70000"},
+		{name: "malformed object port", yaml: "ports:\n  - port: \"30000-abc\"", error: `ports[0]: invalid port range "30000-abc"`},
+		{name: "object without port", yaml: "ports:\n  - description: API", error: "ports[0]: missing port"},
+		{name: "object port_end before port", yaml: "ports:\n  - port: 8000\n    port_end: 7999", error: "ports[0]: invalid port range 8000-7999"},
+		{name: "object malformed port_end", yaml: "ports:\n  - port: 8000\n    port_end: abc", error: "ports[0]: invalid port_end abc"},
+		{name: "malformed netns_pid", yaml: "ports:\n  - port: 5000\n    netns_pid: abc", error: "ports[0]: invalid netns_pid abc"},
+		{name: "netns and netns_pid", yaml: "ports:\n  - port: 5000\n    netns: blue\n    netns_pid: 1234", error: "ports[0]: netns and netns_pid are mutually exclusive"},
+		{name: "not a list", yaml: `ports: 5000`, error: "ports: want a list"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadString(t, tt.yaml)
+			if tt.error != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.error) {
+					t.Fatalf("Load error = %v, want %q", err, tt.error)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if !reflect.DeepEqual(cfg.Ports, tt.want) && (len(cfg.Ports) != 0 || len(tt.want) != 0) {
+				t.Errorf("ports = %+v, want %+v", cfg.Ports, tt.want)
+			}
+		})
+	}
+}
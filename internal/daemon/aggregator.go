@@ -4,11 +4,13 @@ package daemon
 import (
 	"context"
 	"log/slog"
+	"net/netip"
 	"sync"
 	"time"
 
 	"github.com/wellsgz/portmon/internal/ebpf"
 	"github.com/wellsgz/portmon/internal/storage"
+	"github.com/wellsgz/portmon/internal/types"
 )
 
 // Aggregator collects stats from eBPF and persists to database.
@@ -18,8 +20,30 @@ type Aggregator struct {
 	persistInterval time.Duration
 
 	mu          sync.RWMutex
-	lastPersist map[uint16]*persistedStats
-	peakRates   map[uint16]*peakRateTracker
+	lastPersist map[types.PortKey]*persistedStats
+	peakRates   map[types.PortKey]*peakRateTracker
+
+	lastCgroupPersist    map[cgroupPersistKey]*persistedStats
+	lastAddrPersist      map[addrPersistKey]*persistedStats
+	lastFilteredPersist  map[types.PortKey]storage.FilteredBytes
+	lastHealthPersist    map[types.PortKey]types.TCPHealth
+	lastHandshakePersist map[types.PortKey]types.HandshakeStats
+	lastSizePersist      map[types.PortKey]types.SizeStats
+	lastWirePersist      map[types.PortKey]storage.WireStats
+
+	noBaseline bool // counters don't come from the pinned maps
+}
+
+// cgroupPersistKey identifies a cgroup's counters on a port.
+type cgroupPersistKey struct {
+	port     types.PortKey
+	cgroupID uint64
+}
+
+// addrPersistKey identifies a local address's counters on a port.
+type addrPersistKey struct {
+	port types.PortKey
+	addr netip.Addr
 }
 
 type persistedStats struct {
@@ -28,6 +52,8 @@ type persistedStats struct {
 	rxPackets   uint64
 	txPackets   uint64
 	connections uint64
+	ops         storage.OpCounts
+	roles       storage.RoleBytes // Port totals only
 }
 
 type peakRateTracker struct {
@@ -42,8 +68,16 @@ func NewAggregator(collector *ebpf.Collector, db *storage.DB, persistInterval ti
 		collector:       collector,
 		db:              db,
 		persistInterval: persistInterval,
-		lastPersist:     make(map[uint16]*persistedStats),
-		peakRates:       make(map[uint16]*peakRateTracker),
+		lastPersist:     make(map[types.PortKey]*persistedStats),
+		peakRates:       make(map[types.PortKey]*peakRateTracker),
+
+		lastCgroupPersist:    make(map[cgroupPersistKey]*persistedStats),
+		lastAddrPersist:      make(map[addrPersistKey]*persistedStats),
+		lastFilteredPersist:  make(map[types.PortKey]storage.FilteredBytes),
+		lastHealthPersist:    make(map[types.PortKey]types.TCPHealth),
+		lastHandshakePersist: make(map[types.PortKey]types.HandshakeStats),
+		lastSizePersist:      make(map[types.PortKey]types.SizeStats),
+		lastWirePersist:      make(map[types.PortKey]storage.WireStats),
 	}
 }
 
@@ -76,17 +110,18 @@ func (a *Aggregator) Flush() {
 // persist writes accumulated stats to the database.
 func (a *Aggregator) persist() {
 	allStats := a.collector.GetAllStats()
+	peaks := a.collector.TakePeaks()
 	now := time.Now()
 	today := now.Format("2006-01-02")
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	for port, stats := range allStats {
+	for key, stats := range allStats {
 		// Calculate deltas since last persist
 		var deltaRx, deltaTx, deltaRxPkt, deltaTxPkt, deltaConn uint64
 
-		if last, ok := a.lastPersist[port]; ok {
+		if last, ok := a.lastPersist[key]; ok {
 			if stats.RxBytes >= last.rxBytes {
 				deltaRx = stats.RxBytes - last.rxBytes
 			}
@@ -111,26 +146,49 @@ func (a *Aggregator) persist() {
 			deltaConn = stats.Connections
 		}
 
-		// Skip if no change
-		if deltaRx == 0 && deltaTx == 0 {
+		// Server/client split and send/receive calls behind the same delta
+		roles := roleBytes(stats)
+		ops := storage.OpCounts{RxOps: stats.RxOps, TxOps: stats.TxOps}
+		var lastRoles storage.RoleBytes
+		var lastOps storage.OpCounts
+		if last, ok := a.lastPersist[key]; ok {
+			lastRoles = last.roles
+			lastOps = last.ops
+		}
+		deltaRoles := roleDelta(roles, lastRoles)
+		deltaOps := opsDelta(ops, lastOps)
+
+		// Skip only if nothing changed. Connections are counted at the
+		// handshake and segments apart from payload, so an interval can
+		// carry either without any new bytes.
+		lastHandshakes := a.lastHandshakePersist[key]
+		deltaHandshakes := handshakeDelta(&stats.Handshakes, &lastHandshakes)
+		if deltaRx == 0 && deltaTx == 0 && deltaRxPkt == 0 && deltaTxPkt == 0 && deltaConn == 0 &&
+			deltaRoles == (storage.RoleBytes{}) && deltaOps == (storage.OpCounts{}) && deltaHandshakes.IsZero() {
 			continue
 		}
 
 		// Update hourly stats
-		if err := a.db.UpsertHourlyStats(port, now, deltaRx, deltaTx, deltaRxPkt, deltaTxPkt, deltaConn); err != nil {
-			slog.Error("failed to upsert hourly stats", "port", port, "error", err)
+		if err := a.db.UpsertHourlyStats(key, now, deltaRx, deltaTx, deltaRxPkt, deltaTxPkt, deltaConn); err != nil {
+			slog.Error("failed to upsert hourly stats", "port", key, "error", err)
+		}
+		if err := a.db.UpsertRoleStats(key, now, deltaRoles); err != nil {
+			slog.Error("failed to upsert role stats", "port", key, "error", err)
+		}
+		if err := a.db.UpsertOpStats(key, now, deltaOps); err != nil {
+			slog.Error("failed to upsert op stats", "port", key, "error", err)
 		}
 
 		// Track peak rates
-		peak := a.peakRates[port]
+		peak := a.peakRates[key]
 		if peak == nil || peak.date != today {
 			peak = &peakRateTracker{date: today}
-			a.peakRates[port] = peak
+			a.peakRates[key] = peak
 		}
 
-		// Update peak rates
-		rxRate := uint64(stats.RxRate)
-		txRate := uint64(stats.TxRate)
+		// Update peak rates with the highest since the last persist
+		rxRate := uint64(peaks[key].RxRate)
+		txRate := uint64(peaks[key].TxRate)
 		if rxRate > peak.peakRxRate {
 			peak.peakRxRate = rxRate
 		}
@@ -139,25 +197,394 @@ func (a *Aggregator) persist() {
 		}
 
 		// Update daily stats
-		if err := a.db.UpsertDailyStats(port, today, deltaRx, deltaTx, deltaRxPkt, deltaTxPkt, deltaConn, peak.peakRxRate, peak.peakTxRate); err != nil {
-			slog.Error("failed to upsert daily stats", "port", port, "error", err)
+		if err := a.db.UpsertDailyStats(key, today, deltaRx, deltaTx, deltaRxPkt, deltaTxPkt, deltaConn, peak.peakRxRate, peak.peakTxRate); err != nil {
+			slog.Error("failed to upsert daily stats", "port", key, "error", err)
 		}
 
 		// Update last persisted values
-		a.lastPersist[port] = &persistedStats{
+		a.lastPersist[key] = &persistedStats{
 			rxBytes:     stats.RxBytes,
 			txBytes:     stats.TxBytes,
 			rxPackets:   stats.RxPackets,
 			txPackets:   stats.TxPackets,
 			connections: stats.Connections,
+			ops:         ops,
+			roles:       roles,
 		}
 
-		slog.Debug("persisted stats", "port", port,
+		slog.Debug("persisted stats", "port", key,
 			"delta_rx", deltaRx, "delta_tx", deltaTx)
 	}
+
+	a.persistFiltered(allStats, now)
+	a.persistHealth(allStats, now)
+	a.persistHandshakes(allStats, now)
+	a.persistSizes(allStats, now)
+	a.persistWire(allStats, now)
+	a.persistCgroups(now)
+	a.persistLocalAddrs(now)
+	if !a.noBaseline {
+		a.saveBaseline()
+	}
+}
+
+// Unpersisted reduces stats, as returned by the collector, to the traffic
+// not yet written to the database, so it can be added to persisted totals
+// without counting anything twice.
+func (a *Aggregator) Unpersisted(key types.PortKey, stats *types.PortStats) {
+	sub := func(c, l uint64) uint64 {
+		if c >= l {
+			return c - l
+		}
+		return 0
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if last, ok := a.lastPersist[key]; ok {
+		stats.RxBytes = sub(stats.RxBytes, last.rxBytes)
+		stats.TxBytes = sub(stats.TxBytes, last.txBytes)
+		stats.RxPackets = sub(stats.RxPackets, last.rxPackets)
+		stats.TxPackets = sub(stats.TxPackets, last.txPackets)
+		stats.RxOps = sub(stats.RxOps, last.ops.RxOps)
+		stats.TxOps = sub(stats.TxOps, last.ops.TxOps)
+
+		roles := roleDelta(roleBytes(stats), last.roles)
+		stats.Server.RxBytes, stats.Server.TxBytes = roles.ServerRxBytes, roles.ServerTxBytes
+		stats.Client.RxBytes, stats.Client.TxBytes = roles.ClientRxBytes, roles.ClientTxBytes
+	}
+	if last, ok := a.lastHealthPersist[key]; ok {
+		stats.Health = healthDelta(&stats.Health, &last)
+	}
+	if last, ok := a.lastHandshakePersist[key]; ok {
+		stats.Handshakes = handshakeDelta(&stats.Handshakes, &last)
+	}
+	if last, ok := a.lastSizePersist[key]; ok {
+		stats.Sizes = sizeDelta(&stats.Sizes, &last)
+	}
+	if last, ok := a.lastFilteredPersist[key]; ok {
+		stats.Filtered.RxBytes = sub(stats.Filtered.RxBytes, last.FilteredRxBytes)
+		stats.Filtered.TxBytes = sub(stats.Filtered.TxBytes, last.FilteredTxBytes)
+	}
+	if last, ok := a.lastWirePersist[key]; ok {
+		stats.Wire.RxBytes = sub(stats.Wire.RxBytes, last.WireRxBytes)
+		stats.Wire.TxBytes = sub(stats.Wire.TxBytes, last.WireTxBytes)
+		stats.Wire.RxPackets = sub(stats.Wire.RxPackets, last.WireRxPackets)
+		stats.Wire.TxPackets = sub(stats.Wire.TxPackets, last.WireTxPackets)
+	}
+}
+
+// UnpersistedLocalAddr reduces a local address's stats, as returned by the
+// collector, to the traffic not yet written to the database, like
+// Unpersisted.
+func (a *Aggregator) UnpersistedLocalAddr(key types.PortKey, stats *types.LocalAddrStats) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	// Counters that went backwards are persisted whole; see persistLocalAddrs
+	last, ok := a.lastAddrPersist[addrPersistKey{port: key, addr: stats.Addr}]
+	if !ok || stats.RxBytes < last.rxBytes || stats.TxBytes < last.txBytes || stats.Connections < last.connections {
+		return
+	}
+	stats.RxBytes -= last.rxBytes
+	stats.TxBytes -= last.txBytes
+	stats.RxPackets -= last.rxPackets
+	stats.TxPackets -= last.txPackets
+	stats.Connections -= last.connections
+}
+
+// persistFiltered writes the bytes excluded by each port's filters since
+// the last persist. Ports with only filtered traffic have no billed delta,
+// so this is kept separate from the totals. Callers must hold a.mu.
+func (a *Aggregator) persistFiltered(allStats map[types.PortKey]*types.PortStats, now time.Time) {
+	for key, stats := range allStats {
+		current := storage.FilteredBytes{
+			FilteredRxBytes: stats.Filtered.RxBytes,
+			FilteredTxBytes: stats.Filtered.TxBytes,
+		}
+		last := a.lastFilteredPersist[key]
+		a.lastFilteredPersist[key] = current
+
+		var delta storage.FilteredBytes
+		if current.FilteredRxBytes >= last.FilteredRxBytes {
+			delta.FilteredRxBytes = current.FilteredRxBytes - last.FilteredRxBytes
+		}
+		if current.FilteredTxBytes >= last.FilteredTxBytes {
+			delta.FilteredTxBytes = current.FilteredTxBytes - last.FilteredTxBytes
+		}
+		if delta.FilteredRxBytes == 0 && delta.FilteredTxBytes == 0 {
+			continue
+		}
+
+		if err := a.db.UpsertFilteredStats(key, now, delta); err != nil {
+			slog.Error("failed to upsert filtered stats", "port", key, "error", err)
+		}
+	}
+}
+
+// roleBytes returns the server/client byte split of a port's stats.
+func roleBytes(stats *types.PortStats) storage.RoleBytes {
+	return storage.RoleBytes{
+		ServerRxBytes: stats.Server.RxBytes,
+		ServerTxBytes: stats.Server.TxBytes,
+		ClientRxBytes: stats.Client.RxBytes,
+		ClientTxBytes: stats.Client.TxBytes,
+	}
+}
+
+// roleDelta returns current minus last, like the port totals skipping
+// counters that went backwards.
+func roleDelta(current, last storage.RoleBytes) storage.RoleBytes {
+	sub := func(c, l uint64) uint64 {
+		if c >= l {
+			return c - l
+		}
+		return 0
+	}
+	return storage.RoleBytes{
+		ServerRxBytes: sub(current.ServerRxBytes, last.ServerRxBytes),
+		ServerTxBytes: sub(current.ServerTxBytes, last.ServerTxBytes),
+		ClientRxBytes: sub(current.ClientRxBytes, last.ClientRxBytes),
+		ClientTxBytes: sub(current.ClientTxBytes, last.ClientTxBytes),
+	}
+}
+
+// opsDelta returns current minus last, skipping counters that went
+// backwards like roleDelta.
+func opsDelta(current, last storage.OpCounts) storage.OpCounts {
+	sub := func(c, l uint64) uint64 {
+		if c >= l {
+			return c - l
+		}
+		return 0
+	}
+	return storage.OpCounts{
+		RxOps: sub(current.RxOps, last.RxOps),
+		TxOps: sub(current.TxOps, last.TxOps),
+	}
+}
+
+// persistHealth writes TCP health deltas since the last persist. A
+// degraded port may retransmit without delivering new bytes, so this is
+// independent of the byte totals. Callers must hold a.mu.
+func (a *Aggregator) persistHealth(allStats map[types.PortKey]*types.PortStats, now time.Time) {
+	for key, stats := range allStats {
+		last := a.lastHealthPersist[key]
+		a.lastHealthPersist[key] = stats.Health
+
+		delta := healthDelta(&stats.Health, &last)
+		if delta.IsZero() {
+			continue
+		}
+
+		if err := a.db.UpsertHealthStats(key, now, delta); err != nil {
+			slog.Error("failed to upsert health stats", "port", key, "error", err)
+		}
+	}
+}
+
+// healthDelta returns current minus last, skipping counters that went
+// backwards.
+func healthDelta(current, last *types.TCPHealth) types.TCPHealth {
+	sub := func(c, l uint64) uint64 {
+		if c >= l {
+			return c - l
+		}
+		return 0
+	}
+	delta := types.TCPHealth{
+		Retransmits: sub(current.Retransmits, last.Retransmits),
+		Resets:      sub(current.Resets, last.Resets),
+	}
+	for i := range delta.RTT {
+		delta.RTT[i] = sub(current.RTT[i], last.RTT[i])
+	}
+	return delta
+}
+
+// persistHandshakes writes the connections accepted, initiated and failed
+// since the last persist. Failed connects carry no bytes, so this is
+// independent of the byte totals. Callers must hold a.mu.
+func (a *Aggregator) persistHandshakes(allStats map[types.PortKey]*types.PortStats, now time.Time) {
+	for key, stats := range allStats {
+		last := a.lastHandshakePersist[key]
+		a.lastHandshakePersist[key] = stats.Handshakes
+
+		delta := handshakeDelta(&stats.Handshakes, &last)
+		if delta.IsZero() {
+			continue
+		}
+
+		if err := a.db.UpsertHandshakeStats(key, now, delta); err != nil {
+			slog.Error("failed to upsert handshake stats", "port", key, "error", err)
+		}
+	}
+}
+
+// handshakeDelta returns current minus last, skipping counters that went
+// backwards.
+func handshakeDelta(current, last *types.HandshakeStats) types.HandshakeStats {
+	sub := func(c, l uint64) uint64 {
+		if c >= l {
+			return c - l
+		}
+		return 0
+	}
+	return types.HandshakeStats{
+		Accepted:  sub(current.Accepted, last.Accepted),
+		Initiated: sub(current.Initiated, last.Initiated),
+		Failed:    sub(current.Failed, last.Failed),
+	}
+}
+
+// persistSizes adds the sends and receives since the last persist to the
+// hourly size histograms. Callers must hold a.mu.
+func (a *Aggregator) persistSizes(allStats map[types.PortKey]*types.PortStats, now time.Time) {
+	for key, stats := range allStats {
+		last := a.lastSizePersist[key]
+		a.lastSizePersist[key] = stats.Sizes
+
+		delta := sizeDelta(&stats.Sizes, &last)
+		if delta.IsZero() {
+			continue
+		}
+
+		if err := a.db.UpsertSizeStats(key, now, delta); err != nil {
+			slog.Error("failed to upsert size stats", "port", key, "error", err)
+		}
+	}
+}
+
+// sizeDelta returns current minus last, skipping buckets that went
+// backwards.
+func sizeDelta(current, last *types.SizeStats) types.SizeStats {
+	sub := func(c, l uint64) uint64 {
+		if c >= l {
+			return c - l
+		}
+		return 0
+	}
+	var delta types.SizeStats
+	for i := range delta.Send {
+		delta.Send[i] = sub(current.Send[i], last.Send[i])
+		delta.Recv[i] = sub(current.Recv[i], last.Recv[i])
+	}
+	return delta
+}
+
+// persistWire writes IP-level traffic deltas since the last persist. Ports
+// never have wire traffic unless wire accounting is enabled. Callers must
+// hold a.mu.
+func (a *Aggregator) persistWire(allStats map[types.PortKey]*types.PortStats, now time.Time) {
+	sub := func(c, l uint64) uint64 {
+		if c >= l {
+			return c - l
+		}
+		return 0
+	}
+
+	for key, stats := range allStats {
+		current := storage.WireStats{
+			WireRxBytes:   stats.Wire.RxBytes,
+			WireTxBytes:   stats.Wire.TxBytes,
+			WireRxPackets: stats.Wire.RxPackets,
+			WireTxPackets: stats.Wire.TxPackets,
+		}
+		last := a.lastWirePersist[key]
+		a.lastWirePersist[key] = current
+
+		delta := storage.WireStats{
+			WireRxBytes:   sub(current.WireRxBytes, last.WireRxBytes),
+			WireTxBytes:   sub(current.WireTxBytes, last.WireTxBytes),
+			WireRxPackets: sub(current.WireRxPackets, last.WireRxPackets),
+			WireTxPackets: sub(current.WireTxPackets, last.WireTxPackets),
+		}
+		if delta.WireRxBytes == 0 && delta.WireTxBytes == 0 {
+			continue
+		}
+
+		if err := a.db.UpsertWireStats(key, now, delta); err != nil {
+			slog.Error("failed to upsert wire stats", "port", key, "error", err)
+		}
+	}
+}
+
+// persistCgroups writes per-cgroup deltas since the last persist. Callers
+// must hold a.mu.
+func (a *Aggregator) persistCgroups(now time.Time) {
+	for key, cgroups := range a.collector.GetAllCgroupStats() {
+		for _, cs := range cgroups {
+			pk := cgroupPersistKey{port: key, cgroupID: cs.CgroupID}
+			current := &persistedStats{
+				rxBytes:     cs.RxBytes,
+				txBytes:     cs.TxBytes,
+				connections: cs.Connections,
+				ops:         storage.OpCounts{RxOps: cs.RxOps, TxOps: cs.TxOps},
+			}
+
+			delta := *current
+			if last, ok := a.lastCgroupPersist[pk]; ok && current.rxBytes >= last.rxBytes && current.txBytes >= last.txBytes {
+				delta = persistedStats{
+					rxBytes:     current.rxBytes - last.rxBytes,
+					txBytes:     current.txBytes - last.txBytes,
+					connections: current.connections - last.connections,
+					ops:         opsDelta(current.ops, last.ops),
+				}
+			}
+			// Otherwise the entry is new, or was evicted from the LRU map
+			// and recreated, so its counters started again from zero
+
+			a.lastCgroupPersist[pk] = current
+			if delta.rxBytes == 0 && delta.txBytes == 0 {
+				continue
+			}
+
+			if err := a.db.UpsertCgroupStats(key, cs.Path, now, delta.rxBytes, delta.txBytes, delta.ops.RxOps, delta.ops.TxOps, delta.connections); err != nil {
+				slog.Error("failed to upsert cgroup stats", "port", key, "cgroup", cs.Path, "error", err)
+			}
+		}
+	}
+}
+
+// persistLocalAddrs writes per-local-address deltas since the last
+// persist. Callers must hold a.mu.
+func (a *Aggregator) persistLocalAddrs(now time.Time) {
+	for key, addrs := range a.collector.GetAllLocalAddrStats() {
+		for _, as := range addrs {
+			pk := addrPersistKey{port: key, addr: as.Addr}
+			current := &persistedStats{
+				rxBytes:     as.RxBytes,
+				txBytes:     as.TxBytes,
+				rxPackets:   as.RxPackets,
+				txPackets:   as.TxPackets,
+				connections: as.Connections,
+			}
+
+			delta := *current
+			if last, ok := a.lastAddrPersist[pk]; ok && current.rxBytes >= last.rxBytes && current.txBytes >= last.txBytes && current.connections >= last.connections {
+				delta = persistedStats{
+					rxBytes:     current.rxBytes - last.rxBytes,
+					txBytes:     current.txBytes - last.txBytes,
+					rxPackets:   current.rxPackets - last.rxPackets,
+					txPackets:   current.txPackets - last.txPackets,
+					connections: current.connections - last.connections,
+				}
+			}
+
+			a.lastAddrPersist[pk] = current
+			if delta.rxBytes == 0 && delta.txBytes == 0 && delta.connections == 0 {
+				continue
+			}
+
+			if err := a.db.UpsertLocalAddrStats(key, as.Addr.String(), now, delta.rxBytes, delta.txBytes, delta.rxPackets, delta.txPackets, delta.connections); err != nil {
+				slog.Error("failed to upsert local address stats", "port", key, "addr", as.Addr, "error", err)
+			}
+		}
+	}
 }
 
 // GetRealtimeStats returns current realtime stats for a port.
-func (a *Aggregator) GetRealtimeStats(port uint16) *ebpf.Collector {
+func (a *Aggregator) GetRealtimeStats(key types.PortKey) *ebpf.Collector {
 	return a.collector
 }
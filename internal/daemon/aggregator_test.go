@@ -0,0 +1,238 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/ebpf/ebpftest"
+	"github.com/wellsgz/portmon/internal/storage"
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+func TestAggregatorHandshakeOnlyInterval(t *testing.T) {
+	port := types.NewPortKey(8080, 0, types.ProtocolTCP)
+
+	src := ebpftest.NewSource(
+		ebpftest.Step{Port: port, RxBytes: 1000, RxPackets: 2, Connections: 1, Handshakes: types.HandshakeStats{Accepted: 1}},
+	)
+	agg, db := startAggregator(t, src, port)
+	waitReplayed(t, src)
+	agg.Flush()
+
+	// A second connection is accepted but carries no data yet
+	src.Push(ebpftest.Step{Port: port, RxPackets: 1, Connections: 2, Handshakes: types.HandshakeStats{Accepted: 1}})
+	waitReplayed(t, src)
+	agg.Flush()
+
+	today := time.Now().Format("2006-01-02")
+	rows, err := db.QueryDailyStats(port, today, today)
+	if err != nil {
+		t.Fatalf("QueryDailyStats: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("daily rows = %d, want 1", len(rows))
+	}
+	r := rows[0]
+	if r.RxBytes != 1000 || r.RxPackets != 3 {
+		t.Errorf("rx bytes/packets = %d/%d, want 1000/3", r.RxBytes, r.RxPackets)
+	}
+	if r.Connections != 2 || r.Handshakes.Accepted != 2 {
+		t.Errorf("connections = %d, accepted = %d; want 2, 2", r.Connections, r.Handshakes.Accepted)
+	}
+
+	// The interval was persisted, so it isn't pending any more
+	stats := agg.collector.GetStats(port)
+	agg.Unpersisted(port, stats)
+	if stats.RxPackets != 0 {
+		t.Errorf("unpersisted rx packets = %d, want 0", stats.RxPackets)
+	}
+}
+
+func TestBaselineRoundTrip(t *testing.T) {
+	port := types.NewPortKey(8080, 0, types.ProtocolTCP)
+	other := types.NewPortKey(5353, 0, types.ProtocolUDP)
+
+	src := ebpftest.NewSource(
+		ebpftest.Step{Port: port, RxBytes: 1000, TxBytes: 400, RxPackets: 3, TxPackets: 2, RxOps: 2, TxOps: 1, Connections: 1,
+			Handshakes: types.HandshakeStats{Accepted: 1, Failed: 2}},
+		ebpftest.Step{Port: other, RxBytes: 300, TxBytes: 100, RxPackets: 3, TxPackets: 1},
+	)
+	saved, db := startAggregator(t, src, port, other)
+	waitReplayed(t, src)
+	saved.Flush()
+
+	restored := NewAggregator(saved.collector, db, time.Hour)
+	if err := restored.LoadBaseline(); err != nil {
+		t.Fatalf("LoadBaseline: %v", err)
+	}
+
+	if len(restored.lastPersist) != 2 {
+		t.Errorf("restored %d ports, want 2", len(restored.lastPersist))
+	}
+	if !reflect.DeepEqual(restored.lastPersist, saved.lastPersist) {
+		t.Errorf("restored counters = %+v, want %+v", restored.lastPersist, saved.lastPersist)
+	}
+	if !reflect.DeepEqual(restored.lastHandshakePersist, saved.lastHandshakePersist) {
+		t.Errorf("restored handshakes = %+v, want %+v", restored.lastHandshakePersist, saved.lastHandshakePersist)
+	}
+	if !reflect.DeepEqual(restored.lastHealthPersist, saved.lastHealthPersist) {
+		t.Errorf("restored health = %+v, want %+v", restored.lastHealthPersist, saved.lastHealthPersist)
+	}
+	if !reflect.DeepEqual(restored.lastSizePersist, saved.lastSizePersist) {
+		t.Errorf("restored sizes = %+v, want %+v", restored.lastSizePersist, saved.lastSizePersist)
+	}
+
+	// A reset baseline restores nothing
+	if err := restored.ResetBaseline(); err != nil {
+		t.Fatalf("ResetBaseline: %v", err)
+	}
+	empty := NewAggregator(saved.collector, db, time.Hour)
+	if err := empty.LoadBaseline(); err != nil {
+		t.Fatalf("LoadBaseline: %v", err)
+	}
+	if len(empty.lastPersist) != 0 {
+		t.Errorf("restored %d ports from a reset baseline, want 0", len(empty.lastPersist))
+	}
+}
+
+// TestBaselineReusedPins restarts the pipeline over counters that continue
+// from the previous run, as with reused pinned maps, and checks that the
+// traffic persisted before the restart isn't written again.
+func TestBaselineReusedPins(t *testing.T) {
+	port := types.NewPortKey(8080, 0, types.ProtocolTCP)
+	dir := t.TempDir()
+
+	src := ebpftest.NewSource(
+		ebpftest.Step{Port: port, RxBytes: 1000, TxBytes: 400, RxPackets: 2, TxPackets: 1, Connections: 1,
+			Handshakes: types.HandshakeStats{Accepted: 1}},
+	)
+	first := runAggregator(t, src, openDB(t, dir), port)
+	waitReplayed(t, src)
+	first.Flush()
+
+	// The pinned counters carry on from 1000/400, with 500/100 more since
+	// the last persist
+	src = ebpftest.NewSource(
+		ebpftest.Step{Port: port, RxBytes: 1500, TxBytes: 500, RxPackets: 3, TxPackets: 2, Connections: 1,
+			Handshakes: types.HandshakeStats{Accepted: 2}},
+	)
+	db := openDB(t, dir)
+	second := runAggregator(t, src, db, port)
+	if err := second.LoadBaseline(); err != nil {
+		t.Fatalf("LoadBaseline: %v", err)
+	}
+	waitReplayed(t, src)
+	second.Flush()
+
+	today := time.Now().Format("2006-01-02")
+	rows, err := db.QueryDailyStats(port, today, today)
+	if err != nil {
+		t.Fatalf("QueryDailyStats: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("daily rows = %d, want 1", len(rows))
+	}
+	r := rows[0]
+	if r.RxBytes != 1500 || r.TxBytes != 500 {
+		t.Errorf("bytes = %d/%d, want 1500/500", r.RxBytes, r.TxBytes)
+	}
+	if r.RxPackets != 3 || r.TxPackets != 2 {
+		t.Errorf("packets = %d/%d, want 3/2", r.RxPackets, r.TxPackets)
+	}
+	if r.Handshakes.Accepted != 2 {
+		t.Errorf("accepted = %d, want 2", r.Handshakes.Accepted)
+	}
+}
+
+// TestAggregatorSizeHistograms checks that each persist adds only the new
+// sends and receives, and that a day's histograms sum its persists.
+func TestAggregatorSizeHistograms(t *testing.T) {
+	port := types.NewPortKey(8080, 0, types.ProtocolTCP)
+
+	var first, second types.SizeStats
+	first.Send[4], first.Recv[10] = 3, 1
+	second.Send[4], second.Send[12], second.Recv[10] = 2, 1, 4
+
+	src := ebpftest.NewSource(ebpftest.Step{Port: port, RxBytes: 1000, Sizes: first})
+	agg, db := startAggregator(t, src, port)
+	waitReplayed(t, src)
+	agg.Flush()
+
+	src.Push(ebpftest.Step{Port: port, RxBytes: 4000, Sizes: second})
+	waitReplayed(t, src)
+	agg.Flush()
+	agg.Flush() // Nothing new
+
+	now := time.Now()
+	y, m, d := now.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	got, err := db.QuerySizeStats(port, day, day.Add(24*time.Hour-time.Second))
+	if err != nil {
+		t.Fatalf("QuerySizeStats: %v", err)
+	}
+
+	want := first
+	want.Add(&second)
+	if got != want {
+		t.Errorf("sizes = %+v, want %+v", got, want)
+	}
+	if got.Send.Samples() != 6 || got.Recv.Samples() != 5 {
+		t.Errorf("samples = %d/%d, want 6/5", got.Send.Samples(), got.Recv.Samples())
+	}
+}
+
+// TestAggregatorRoles checks that the server/client split is persisted as
+// deltas alongside the totals.
+func TestAggregatorRoles(t *testing.T) {
+	port := types.NewPortKey(5432, 0, types.ProtocolTCP)
+
+	src := ebpftest.NewSource(ebpftest.Step{Port: port, RxBytes: 1000, TxBytes: 400,
+		Client: types.TrafficStats{RxBytes: 300, TxBytes: 100}})
+	agg, db := startAggregator(t, src, port)
+	waitReplayed(t, src)
+	agg.Flush()
+
+	src.Push(ebpftest.Step{Port: port, RxBytes: 500, TxBytes: 500,
+		Client: types.TrafficStats{TxBytes: 500}})
+	waitReplayed(t, src)
+	agg.Flush()
+
+	today := time.Now().Format("2006-01-02")
+	rows, err := db.QueryDailyStats(port, today, today)
+	if err != nil {
+		t.Fatalf("QueryDailyStats: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("daily rows = %d, want 1", len(rows))
+	}
+	want := storage.RoleBytes{ServerRxBytes: 1200, ServerTxBytes: 300, ClientRxBytes: 300, ClientTxBytes: 600}
+	if got := rows[0].RoleBytes; got != want {
+		t.Errorf("role split = %+v, want %+v", got, want)
+	}
+	if rows[0].RxBytes != 1500 || rows[0].TxBytes != 900 {
+		t.Errorf("totals = %d/%d, want 1500/900", rows[0].RxBytes, rows[0].TxBytes)
+	}
+}
+
+func TestRoleDelta(t *testing.T) {
+	last := storage.RoleBytes{ServerRxBytes: 100, ServerTxBytes: 200, ClientRxBytes: 30, ClientTxBytes: 40}
+
+	tests := []struct {
+		current, want storage.RoleBytes
+	}{
+		{last, storage.RoleBytes{}},
+		{storage.RoleBytes{ServerRxBytes: 150, ServerTxBytes: 200, ClientRxBytes: 35, ClientTxBytes: 40},
+			storage.RoleBytes{ServerRxBytes: 50, ClientRxBytes: 5}},
+		// Counters that went backwards, e.g. after the maps were reset,
+		// contribute nothing
+		{storage.RoleBytes{ServerRxBytes: 10, ServerTxBytes: 250, ClientRxBytes: 30, ClientTxBytes: 0},
+			storage.RoleBytes{ServerTxBytes: 50}},
+	}
+
+	for _, tt := range tests {
+		if got := roleDelta(tt.current, last); got != tt.want {
+			t.Errorf("roleDelta(%+v) = %+v, want %+v", tt.current, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/wellsgz/portmon/internal/storage"
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// baselineKey is the metadata key holding the counters last persisted from
+// the pinned maps. A restart that reuses the maps resumes from it, so only
+// traffic since the last persist is written.
+const baselineKey = "persist_baseline"
+
+// baselineEntry is the saved form of a port's last persisted counters.
+// Only counters read from pinned maps are kept; the others start from zero
+// with every run.
+type baselineEntry struct {
+	Port        types.PortKey         `json:"port"`
+	RxBytes     uint64                `json:"rx_bytes"`
+	TxBytes     uint64                `json:"tx_bytes"`
+	RxPackets   uint64                `json:"rx_packets"`
+	TxPackets   uint64                `json:"tx_packets"`
+	Connections uint64                `json:"connections"`
+	Ops         storage.OpCounts      `json:"ops"`
+	Roles       storage.RoleBytes     `json:"roles"`
+	Health      *types.TCPHealth      `json:"health,omitempty"`
+	Handshakes  *types.HandshakeStats `json:"handshakes,omitempty"`
+	Sizes       *types.SizeStats      `json:"sizes,omitempty"`
+}
+
+// LoadBaseline restores the counters last persisted by an earlier run. It
+// must only be called when the pinned maps were reused, since the baseline
+// is relative to their counters.
+func (a *Aggregator) LoadBaseline() error {
+	value, err := a.db.GetMetadata(baselineKey)
+	if err != nil {
+		return fmt.Errorf("reading persist baseline: %w", err)
+	}
+	if value == "" {
+		return nil
+	}
+
+	var entries []baselineEntry
+	if err := json.Unmarshal([]byte(value), &entries); err != nil {
+		return fmt.Errorf("decoding persist baseline: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, e := range entries {
+		a.lastPersist[e.Port] = &persistedStats{
+			rxBytes:     e.RxBytes,
+			txBytes:     e.TxBytes,
+			rxPackets:   e.RxPackets,
+			txPackets:   e.TxPackets,
+			connections: e.Connections,
+			ops:         e.Ops,
+			roles:       e.Roles,
+		}
+		if e.Health != nil {
+			a.lastHealthPersist[e.Port] = *e.Health
+		}
+		if e.Handshakes != nil {
+			a.lastHandshakePersist[e.Port] = *e.Handshakes
+		}
+		if e.Sizes != nil {
+			a.lastSizePersist[e.Port] = *e.Sizes
+		}
+	}
+
+	slog.Info("restored persist baseline", "ports", len(entries))
+	return nil
+}
+
+// ResetBaseline discards the saved baseline, for when the maps start from
+// zero.
+func (a *Aggregator) ResetBaseline() error {
+	return a.db.SetMetadata(baselineKey, "")
+}
+
+// DisableBaseline stops saving the baseline, for counters that don't come
+// from the pinned maps. The saved one is kept for the next run that reuses
+// them.
+func (a *Aggregator) DisableBaseline() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.noBaseline = true
+}
+
+// saveBaseline stores the counters last persisted for every port. Callers
+// must hold a.mu.
+func (a *Aggregator) saveBaseline() {
+	entries := make([]baselineEntry, 0, len(a.lastPersist))
+	for key, last := range a.lastPersist {
+		e := baselineEntry{
+			Port:        key,
+			RxBytes:     last.rxBytes,
+			TxBytes:     last.txBytes,
+			RxPackets:   last.rxPackets,
+			TxPackets:   last.txPackets,
+			Connections: last.connections,
+			Ops:         last.ops,
+			Roles:       last.roles,
+		}
+		if h, ok := a.lastHealthPersist[key]; ok {
+			e.Health = &h
+		}
+		if h, ok := a.lastHandshakePersist[key]; ok {
+			e.Handshakes = &h
+		}
+		if s, ok := a.lastSizePersist[key]; ok {
+			e.Sizes = &s
+		}
+		entries = append(entries, e)
+	}
+
+	value, err := json.Marshal(entries)
+	if err != nil {
+		slog.Error("failed to encode persist baseline", "error", err)
+		return
+	}
+	if err := a.db.SetMetadata(baselineKey, string(value)); err != nil {
+		slog.Error("failed to save persist baseline", "error", err)
+	}
+}
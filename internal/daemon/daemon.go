@@ -11,13 +11,14 @@ import (
 	"time"
 
 	"github.com/wellsgz/portmon/internal/ebpf"
+	"github.com/wellsgz/portmon/internal/sockdiag"
 	"github.com/wellsgz/portmon/internal/storage"
 )
 
 // Daemon orchestrates all daemon components.
 type Daemon struct {
 	config     *Config
-	loader     *ebpf.Loader
+	loader     *ebpf.Loader // nil in the socket diagnostics fallback
 	collector  *ebpf.Collector
 	aggregator *Aggregator
 	server     *Server
@@ -79,42 +80,100 @@ func (d *Daemon) Run() error {
 	d.db = db
 	defer db.Close()
 
-	// Load eBPF programs
-	loader := ebpf.NewLoader()
-	d.loader = loader
-
-	if err := loader.Load(); err != nil {
-		return fmt.Errorf("loading eBPF programs: %w", err)
+	// Load eBPF programs, falling back to polling socket diagnostics on
+	// hosts that won't run them
+	var source ebpf.StatsSource
+	pollInterval := 100 * time.Millisecond
+	loader, err := d.startEBPF()
+	if err != nil {
+		slog.Warn("eBPF unavailable, falling back to socket diagnostics with approximate TCP-only accounting", "error", err)
+		fallback, ferr := sockdiag.NewSource(err)
+		if ferr != nil {
+			return fmt.Errorf("%w; socket diagnostics fallback: %w", err, ferr)
+		}
+		defer fallback.Close()
+		source = fallback
+		pollInterval = sockdiag.PollInterval
+	} else {
+		defer loader.Close()
+		d.loader = loader
+		source = loader
 	}
-	defer loader.Close()
 
-	// Attach kprobes
-	if err := loader.Attach(); err != nil {
-		return fmt.Errorf("attaching kprobes: %w", err)
+	// Wire accounting counts IP packets on top of the payload probes
+	if d.config.Accounting == AccountingWire {
+		if loader == nil {
+			slog.Warn("wire accounting needs eBPF, counting payload only")
+		} else if err := loader.AttachWire(ebpf.DefaultCgroupRoot); err != nil {
+			return fmt.Errorf("attaching wire accounting: %w", err)
+		}
 	}
 
 	// Add target ports
 	for _, port := range d.config.Ports {
-		if err := loader.AddPort(port); err != nil {
+		if err := source.AddPort(port, d.config.portRoles(port)); err != nil {
 			slog.Warn("failed to add port", "port", port, "error", err)
+			continue
+		}
+		filters := d.config.portFilters(port)
+		addrs := d.config.portLocalAddrs(port)
+		if loader == nil {
+			if !filters.IsEmpty() || len(addrs) > 0 {
+				slog.Warn("address filters need eBPF, counting all traffic on port", "port", port)
+			}
+			continue
+		}
+		if !filters.IsEmpty() {
+			if err := loader.SetFilters(port, filters); err != nil {
+				slog.Warn("failed to set port filters", "port", port, "error", err)
+			}
+		}
+		if len(addrs) > 0 {
+			if err := loader.SetLocalAddrs(port, addrs); err != nil {
+				slog.Warn("failed to pin port to local addresses", "port", port, "error", err)
+			}
 		}
 	}
 
 	// Start stats collector
-	collector := ebpf.NewCollector(loader, 100*time.Millisecond)
+	collector := ebpf.NewCollector(source, pollInterval)
+	if d.config.PeakRateWindow != 0 {
+		if err := collector.SetPeakWindow(d.config.PeakRateWindow); err != nil {
+			return fmt.Errorf("peak rate window: %w", err)
+		}
+	}
 	d.collector = collector
 	go collector.Run(ctx)
 
+	// Start connection history writer fed by the lifecycle event stream
+	history := NewHistoryWriter(db, time.Second)
+	go history.Run(ctx)
+	if loader != nil {
+		go ebpf.NewEventReader(loader, history.Record).Run(ctx)
+	}
+
 	// Start aggregator (persists to DB)
 	aggregator := NewAggregator(collector, db, 60*time.Second)
 	d.aggregator = aggregator
+
+	// Reused counters include traffic persisted by the last run. The
+	// fallback's counters start from zero and leave the baseline alone.
+	if loader == nil {
+		aggregator.DisableBaseline()
+	} else if loader.PinnedMapsReused() {
+		if err := aggregator.LoadBaseline(); err != nil {
+			slog.Warn("failed to restore persist baseline", "error", err)
+		}
+	} else if err := aggregator.ResetBaseline(); err != nil {
+		slog.Warn("failed to reset persist baseline", "error", err)
+	}
 	go aggregator.Run(ctx)
 
 	// Start retention cleanup job
 	go d.runRetentionCleanup(ctx)
 
 	// Start IPC server
-	server := NewServer(socketPath, loader, collector, aggregator, db, d.config)
+	server := NewServer(socketPath, source, collector, aggregator, db, d.config)
 	d.server = server
 
 	go func() {
@@ -135,6 +194,29 @@ func (d *Daemon) Run() error {
 	return nil
 }
 
+// startEBPF loads and attaches the eBPF programs, reusing the counters
+// pinned by the last run.
+func (d *Daemon) startEBPF() (*ebpf.Loader, error) {
+	loader := ebpf.NewLoader(ebpf.DefaultPinPath, d.config.MapLimits)
+
+	if d.config.ResetMaps {
+		if err := loader.ResetPinnedMaps(); err != nil {
+			return nil, err
+		}
+	}
+	if err := loader.Load(); err != nil {
+		return nil, fmt.Errorf("loading eBPF programs: %w", err)
+	}
+
+	// Attach kprobes and tracepoints
+	if err := loader.Attach(); err != nil {
+		loader.Close()
+		return nil, fmt.Errorf("attaching probes: %w", err)
+	}
+
+	return loader, nil
+}
+
 // runRetentionCleanup runs daily cleanup of old data.
 func (d *Daemon) runRetentionCleanup(ctx context.Context) {
 	// Run once at startup
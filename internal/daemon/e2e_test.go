@@ -0,0 +1,430 @@
+package daemon
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/wellsgz/portmon/api"
+	"github.com/wellsgz/portmon/internal/client"
+	"github.com/wellsgz/portmon/internal/ebpf"
+	"github.com/wellsgz/portmon/internal/ebpf/ebpftest"
+	"github.com/wellsgz/portmon/internal/storage"
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// startAggregator runs a Collector over src, with the given ports
+// monitored, and returns an Aggregator over it with its database. The
+// aggregator only persists when flushed.
+func startAggregator(t *testing.T, src *ebpftest.Source, ports ...types.PortKey) (*Aggregator, *storage.DB) {
+	t.Helper()
+
+	db := openDB(t, t.TempDir())
+	return runAggregator(t, src, db, ports...), db
+}
+
+// openDB opens the database in dir, closing it when the test ends.
+func openDB(t *testing.T, dir string) *storage.DB {
+	t.Helper()
+
+	db, err := storage.Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// runAggregator is startAggregator over an open database, like a daemon
+// restarting over the previous run's data.
+func runAggregator(t *testing.T, src *ebpftest.Source, db *storage.DB, ports ...types.PortKey) *Aggregator {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	for _, port := range ports {
+		if err := src.AddPort(port, types.RoleBoth); err != nil {
+			t.Fatalf("AddPort(%s): %v", port, err)
+		}
+	}
+
+	collector := ebpf.NewCollector(src, time.Millisecond)
+	go collector.Run(ctx)
+
+	return NewAggregator(collector, db, time.Hour)
+}
+
+// startPipeline runs a Collector, Aggregator and Server over src, with the
+// given ports monitored, and returns a client connected to the server.
+func startPipeline(t *testing.T, src *ebpftest.Source, ports ...types.PortKey) *client.Client {
+	t.Helper()
+
+	aggregator, db := startAggregator(t, src, ports...)
+	return startServer(t, src, aggregator, db, ports...)
+}
+
+// startServer runs a Server over the pipeline of startAggregator and
+// returns a client connected to it.
+func startServer(t *testing.T, src *ebpftest.Source, aggregator *Aggregator, db *storage.DB, ports ...types.PortKey) *client.Client {
+	t.Helper()
+
+	collector := aggregator.collector
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "portmon.sock")
+	config := &Config{
+		Ports:         ports,
+		DataDir:       dir,
+		RetentionDays: 30,
+		SocketPath:    socketPath,
+		Accounting:    AccountingPayload,
+	}
+	server := NewServer(socketPath, src, collector, aggregator, db, config)
+	go server.Serve(ctx)
+
+	c := client.New(socketPath)
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Connect() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+// waitReplayed waits until the collector has taken in every step.
+func waitReplayed(t *testing.T, src *ebpftest.Source) {
+	t.Helper()
+
+	select {
+	case <-src.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("steps were not replayed")
+	}
+}
+
+func TestEndToEndTraffic(t *testing.T) {
+	port := types.NewPortKey(8080, 0, types.ProtocolTCP)
+	other := types.NewPortKey(9090, 0, types.ProtocolTCP)
+
+	src := ebpftest.NewSource(
+		ebpftest.Step{Port: port, RxBytes: 1000, TxBytes: 400, RxPackets: 2, TxPackets: 1, RxOps: 1, TxOps: 1, Connections: 1},
+		ebpftest.Step{Port: other, RxBytes: 5000, TxBytes: 5000, Connections: 3},
+		ebpftest.Step{Port: port, RxBytes: 2000, TxBytes: 600, RxPackets: 3, TxPackets: 2, RxOps: 2, TxOps: 1, Connections: 2},
+	)
+	c := startPipeline(t, src, port)
+	waitReplayed(t, src)
+
+	// Flushing twice must not persist the same traffic again
+	for i := 0; i < 2; i++ {
+		if err := c.FlushStats(); err != nil {
+			t.Fatalf("FlushStats: %v", err)
+		}
+	}
+
+	rt, err := c.GetRealtimeStats(port, "")
+	if err != nil {
+		t.Fatalf("GetRealtimeStats: %v", err)
+	}
+	if rt.RxBytes != 3000 || rt.TxBytes != 1000 {
+		t.Errorf("realtime bytes = %d/%d, want 3000/1000", rt.RxBytes, rt.TxBytes)
+	}
+	if rt.RxPackets != 5 || rt.TxPackets != 3 || rt.RxOps != 3 || rt.TxOps != 2 {
+		t.Errorf("realtime packets/ops = %d/%d %d/%d, want 5/3 3/2", rt.RxPackets, rt.TxPackets, rt.RxOps, rt.TxOps)
+	}
+	if rt.Connections != 2 {
+		t.Errorf("realtime connections = %d, want 2", rt.Connections)
+	}
+
+	today := time.Now().Format("2006-01-02")
+	hist, err := c.GetHistoricalStats(port, "", today, today)
+	if err != nil {
+		t.Fatalf("GetHistoricalStats: %v", err)
+	}
+	if hist.TotalRx != 3000 || hist.TotalTx != 1000 {
+		t.Errorf("historical bytes = %d/%d, want 3000/1000", hist.TotalRx, hist.TotalTx)
+	}
+	if len(hist.DailyStats) != 1 || hist.DailyStats[0].RxOps != 3 {
+		t.Errorf("daily stats = %+v, want one day with 3 receive ops", hist.DailyStats)
+	}
+
+	// Traffic on the unmonitored port was never counted
+	rt, err = c.GetRealtimeStats(other, "")
+	if err != nil {
+		t.Fatalf("GetRealtimeStats(%s): %v", other, err)
+	}
+	if rt.RxBytes != 0 || rt.TxBytes != 0 {
+		t.Errorf("unmonitored port bytes = %d/%d, want 0/0", rt.RxBytes, rt.TxBytes)
+	}
+}
+
+func TestEndToEndAddRemovePort(t *testing.T) {
+	port := types.NewPortKey(8080, 0, types.ProtocolTCP)
+	added := types.NewPortKey(5000, 5010, types.ProtocolUDP)
+
+	src := ebpftest.NewSource()
+	c := startPipeline(t, src, port)
+
+	if err := c.AddPort(added, "server"); err != nil {
+		t.Fatalf("AddPort: %v", err)
+	}
+	if roles, ok := src.Roles(added); !ok || roles != types.RoleServer {
+		t.Errorf("source roles = %d, %v; want %d, true", roles, ok, types.RoleServer)
+	}
+	if err := c.AddPort(types.NewPortKey(5005, 0, types.ProtocolUDP), ""); err == nil {
+		t.Error("AddPort of a port inside a monitored range succeeded")
+	}
+
+	ports, err := c.ListPorts()
+	if err != nil {
+		t.Fatalf("ListPorts: %v", err)
+	}
+	if !slices.Contains(ports, added.String()) {
+		t.Errorf("ListPorts = %v, missing %s", ports, added)
+	}
+
+	if err := c.RemovePort(added); err != nil {
+		t.Fatalf("RemovePort: %v", err)
+	}
+	if _, ok := src.Roles(added); ok {
+		t.Error("port still monitored by the source after RemovePort")
+	}
+	ports, err = c.ListPorts()
+	if err != nil {
+		t.Fatalf("ListPorts: %v", err)
+	}
+	if slices.Contains(ports, added.String()) {
+		t.Errorf("ListPorts = %v, still lists %s", ports, added)
+	}
+}
+
+func TestEndToEndRateHistory(t *testing.T) {
+	port := types.NewPortKey(8080, 0, types.ProtocolTCP)
+
+	src := ebpftest.NewSource(
+		ebpftest.Step{Port: port}, // Baseline
+		ebpftest.Step{Port: port, RxBytes: 5000, Connections: 1},
+	)
+	c := startPipeline(t, src, port)
+	waitReplayed(t, src)
+
+	// Samples are closed on the first poll of the next second
+	var samples []api.RateSample
+	deadline := time.Now().Add(5 * time.Second)
+	for len(samples) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no rate samples")
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		result, err := c.GetRateHistory(port, 0)
+		if err != nil {
+			t.Fatalf("GetRateHistory: %v", err)
+		}
+		samples = result.Samples
+	}
+
+	// The traffic is all in the first sample, which averages it over less
+	// than two seconds
+	if s := samples[0]; s.RxRate < 2500 || s.TxRate != 0 {
+		t.Errorf("first sample = %.0f/%.0f bytes/sec, want at least 2500/0", s.RxRate, s.TxRate)
+	}
+
+	rt, err := c.GetRealtimeStats(port, "")
+	if err != nil {
+		t.Fatalf("GetRealtimeStats: %v", err)
+	}
+	for _, w := range types.RateWindows {
+		if _, ok := rt.Rates[types.RateWindowName(w)]; !ok {
+			t.Errorf("realtime rates = %v, missing %s", rt.Rates, types.RateWindowName(w))
+		}
+	}
+
+	// The daily peak is the highest 1s rate, the first sample's
+	if err := c.FlushStats(); err != nil {
+		t.Fatalf("FlushStats: %v", err)
+	}
+	today := time.Now().Format("2006-01-02")
+	hist, err := c.GetHistoricalStats(port, "", today, today)
+	if err != nil {
+		t.Fatalf("GetHistoricalStats: %v", err)
+	}
+	if want := uint64(samples[0].RxRate); hist.PeakRxRate != want || hist.PeakTxRate != 0 {
+		t.Errorf("peak rates = %d/%d, want %d/0", hist.PeakRxRate, hist.PeakTxRate, want)
+	}
+}
+
+func TestEndToEndLocalAddr(t *testing.T) {
+	port := types.NewPortKey(8080, 0, types.ProtocolTCP)
+
+	src := ebpftest.NewSource(
+		ebpftest.Step{Port: port, RxBytes: 5000, TxBytes: 1500, RxPackets: 7, TxPackets: 4, Connections: 3},
+	)
+	aggregator, db := startAggregator(t, src, port)
+	c := startServer(t, src, aggregator, db, port)
+	waitReplayed(t, src)
+
+	now := time.Now()
+	if err := db.UpsertLocalAddrStats(port, "192.0.2.1", now, 3000, 1000, 4, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpsertLocalAddrStats(port, "2001:db8::1", now, 2000, 500, 3, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an address the totals are the port's
+	rt, err := c.GetRealtimeStats(port, "")
+	if err != nil {
+		t.Fatalf("GetRealtimeStats: %v", err)
+	}
+	if rt.RxBytes != 5000 || rt.TxBytes != 1500 || len(rt.LocalAddrs) != 2 {
+		t.Errorf("port realtime = %d/%d with %d addresses, want 5000/1500 with 2", rt.RxBytes, rt.TxBytes, len(rt.LocalAddrs))
+	}
+
+	rt, err = c.GetRealtimeStats(port, "2001:db8::1")
+	if err != nil {
+		t.Fatalf("GetRealtimeStats: %v", err)
+	}
+	if rt.RxBytes != 2000 || rt.TxBytes != 500 || rt.RxPackets != 3 || rt.TxPackets != 1 || rt.Connections != 1 {
+		t.Errorf("address realtime = %d/%d bytes %d/%d packets %d connections, want 2000/500 3/1 1",
+			rt.RxBytes, rt.TxBytes, rt.RxPackets, rt.TxPackets, rt.Connections)
+	}
+	if len(rt.LocalAddrs) != 1 || rt.LocalAddrs[0].Addr != "2001:db8::1" {
+		t.Errorf("address realtime rows = %+v, want just 2001:db8::1", rt.LocalAddrs)
+	}
+	if rt.Server.RxBytes != 0 || rt.Health != nil || rt.Wire != nil {
+		t.Errorf("address realtime has port-wide breakdowns: %+v", rt)
+	}
+
+	today := now.Format("2006-01-02")
+	hist, err := c.GetHistoricalStats(port, "192.0.2.1", today, today)
+	if err != nil {
+		t.Fatalf("GetHistoricalStats: %v", err)
+	}
+	if hist.TotalRx != 3000 || hist.TotalTx != 1000 || hist.TotalBytes != 4000 {
+		t.Errorf("address historical = %d/%d/%d, want 3000/1000/4000", hist.TotalRx, hist.TotalTx, hist.TotalBytes)
+	}
+	if len(hist.LocalAddrs) != 1 || hist.LocalAddrs[0].Addr != "192.0.2.1" || len(hist.DailyStats) != 0 {
+		t.Errorf("address historical rows = %+v, days = %+v, want just 192.0.2.1", hist.LocalAddrs, hist.DailyStats)
+	}
+
+	// An address without traffic reports zero rather than the port
+	hist, err = c.GetHistoricalStats(port, "198.51.100.1", today, today)
+	if err != nil {
+		t.Fatalf("GetHistoricalStats: %v", err)
+	}
+	if hist.TotalBytes != 0 || len(hist.LocalAddrs) != 0 {
+		t.Errorf("idle address historical = %d bytes, rows %+v, want none", hist.TotalBytes, hist.LocalAddrs)
+	}
+}
+
+func TestEndToEndBreakdowns(t *testing.T) {
+	port := types.NewPortKey(5000, 5010, types.ProtocolTCP)
+
+	src := ebpftest.NewSource(
+		ebpftest.Step{Port: port, RxBytes: 1000, TxBytes: 100, RxOps: 2, TxOps: 1, Member: 5001, Tgid: 42, Comm: "nginx", CgroupID: 7},
+		ebpftest.Step{Port: port, RxBytes: 3000, TxBytes: 300, RxOps: 3, TxOps: 2, Member: 5003, Tgid: 42, Comm: "nginx", CgroupID: 7},
+		ebpftest.Step{Port: port, RxBytes: 500, Member: 5001, Tgid: 43, Comm: "curl"},
+	)
+	src.SetMapUsage(ebpf.MapUsage{Name: "port_stats_map", Entries: 2, MaxEntries: 128, Drops: 5})
+	c := startPipeline(t, src, port)
+	waitReplayed(t, src)
+
+	breakdown, err := c.GetPortBreakdown(port)
+	if err != nil {
+		t.Fatalf("GetPortBreakdown: %v", err)
+	}
+	want := []api.PortBreakdownEntry{
+		{Port: 5003, RxBytes: 3000, TxBytes: 300},
+		{Port: 5001, RxBytes: 1500, TxBytes: 100},
+	}
+	if !slices.Equal(breakdown.Ports, want) {
+		t.Errorf("breakdown = %+v, want %+v", breakdown.Ports, want)
+	}
+
+	procs, err := c.GetProcessStats(port, 0)
+	if err != nil {
+		t.Fatalf("GetProcessStats: %v", err)
+	}
+	if len(procs.Processes) != 2 {
+		t.Fatalf("processes = %+v, want 2", procs.Processes)
+	}
+	if p := procs.Processes[0]; p.Tgid != 42 || p.Comm != "nginx" || p.RxBytes != 4000 || p.TxBytes != 400 || p.RxOps != 5 || p.TxOps != 3 {
+		t.Errorf("busiest process = %+v, want nginx (42) with 4000/400 bytes and 5/3 ops", p)
+	}
+
+	rt, err := c.GetRealtimeStats(port, "")
+	if err != nil {
+		t.Fatalf("GetRealtimeStats: %v", err)
+	}
+	if len(rt.Cgroups) != 1 || rt.Cgroups[0].RxBytes != 4000 || rt.Cgroups[0].TxOps != 3 {
+		t.Errorf("realtime cgroups = %+v, want one with 4000 bytes received and 3 sends", rt.Cgroups)
+	}
+	if rt.Server.RxBytes != 4500 || rt.Client.RxBytes != 0 {
+		t.Errorf("realtime roles = %d/%d bytes received, want 4500/0", rt.Server.RxBytes, rt.Client.RxBytes)
+	}
+
+	status, err := c.GetStatus()
+	if err != nil {
+		t.Fatalf("GetStatus: %v", err)
+	}
+	wantMaps := []api.MapUsage{{Name: "port_stats_map", Entries: 2, MaxEntries: 128, Drops: 5}}
+	if !slices.Equal(status.Maps, wantMaps) {
+		t.Errorf("status maps = %+v, want %+v", status.Maps, wantMaps)
+	}
+}
+
+// TestEndToEndConnectCounts pins down how connects are counted: once each,
+// as initiated or failed, so the two add up to the attempts.
+func TestEndToEndConnectCounts(t *testing.T) {
+	port := types.NewPortKey(5432, 0, types.ProtocolTCP)
+
+	refused := []ebpftest.Transition{
+		{From: ebpftest.TCPClose, To: ebpftest.TCPSynSent},
+		{From: ebpftest.TCPSynSent, To: ebpftest.TCPClose},
+	}
+	completed := []ebpftest.Transition{
+		{From: ebpftest.TCPClose, To: ebpftest.TCPSynSent},
+		{From: ebpftest.TCPSynSent, To: ebpftest.TCPEstablished},
+		{From: ebpftest.TCPEstablished, To: ebpftest.TCPFinWait1},
+		{From: ebpftest.TCPFinWait1, To: ebpftest.TCPFinWait2},
+		{From: ebpftest.TCPFinWait2, To: ebpftest.TCPClose},
+	}
+	src := ebpftest.NewSource(
+		ebpftest.Step{Port: port, Transitions: refused},
+		ebpftest.Step{Port: port, Transitions: completed},
+		ebpftest.Step{Port: port, Transitions: refused},
+	)
+	c := startPipeline(t, src, port)
+	waitReplayed(t, src)
+
+	want := api.HandshakeStats{Initiated: 1, Failed: 2}
+	rt, err := c.GetRealtimeStats(port, "")
+	if err != nil {
+		t.Fatalf("GetRealtimeStats: %v", err)
+	}
+	if rt.Handshakes == nil || *rt.Handshakes != want {
+		t.Errorf("realtime handshakes = %+v, want %+v", rt.Handshakes, want)
+	}
+
+	if err := c.FlushStats(); err != nil {
+		t.Fatalf("FlushStats: %v", err)
+	}
+	today := time.Now().Format("2006-01-02")
+	hist, err := c.GetHistoricalStats(port, "", today, today)
+	if err != nil {
+		t.Fatalf("GetHistoricalStats: %v", err)
+	}
+	if hist.Handshakes == nil || *hist.Handshakes != want {
+		t.Errorf("historical handshakes = %+v, want %+v", hist.Handshakes, want)
+	}
+}
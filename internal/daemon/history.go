@@ -0,0 +1,93 @@
+package daemon
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/storage"
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// HistoryWriter batches finished connections and writes them to the
+// connection_history table.
+type HistoryWriter struct {
+	db            *storage.DB
+	flushInterval time.Duration
+	records       chan *types.ConnectionRecord
+}
+
+// historyBatchSize is the number of records that triggers an early flush.
+const historyBatchSize = 500
+
+// NewHistoryWriter creates a new connection history writer.
+func NewHistoryWriter(db *storage.DB, flushInterval time.Duration) *HistoryWriter {
+	return &HistoryWriter{
+		db:            db,
+		flushInterval: flushInterval,
+		records:       make(chan *types.ConnectionRecord, 4*historyBatchSize),
+	}
+}
+
+// Record queues a finished connection for persistence. It never blocks;
+// records are dropped if the queue is full.
+func (w *HistoryWriter) Record(rec *types.ConnectionRecord) {
+	select {
+	case w.records <- rec:
+	default:
+		slog.Warn("connection history queue full, dropping record", "port", rec.Port)
+	}
+}
+
+// Run starts the writer loop.
+func (w *HistoryWriter) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]storage.ConnectionHistoryRow, 0, historyBatchSize)
+
+	flush := func() {
+		if err := w.db.InsertConnectionHistory(batch); err != nil {
+			slog.Error("failed to write connection history", "count", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Drain what's already queued before shutdown
+			for {
+				select {
+				case rec := <-w.records:
+					batch = append(batch, toHistoryRow(rec))
+				default:
+					flush()
+					return
+				}
+			}
+		case rec := <-w.records:
+			batch = append(batch, toHistoryRow(rec))
+			if len(batch) >= historyBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func toHistoryRow(rec *types.ConnectionRecord) storage.ConnectionHistoryRow {
+	return storage.ConnectionHistoryRow{
+		Port:       rec.Port,
+		Family:     rec.Family,
+		LocalAddr:  rec.LocalAddr.String(),
+		LocalPort:  rec.LocalPort,
+		RemoteAddr: rec.RemoteAddr.String(),
+		RemotePort: rec.RemotePort,
+		RxBytes:    rec.RxBytes,
+		TxBytes:    rec.TxBytes,
+		StartedAt:  rec.StartedAt.Unix(),
+		EndedAt:    rec.EndedAt.Unix(),
+	}
+}
@@ -8,20 +8,23 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/wellsgz/portmon/api"
 	"github.com/wellsgz/portmon/internal/ebpf"
 	"github.com/wellsgz/portmon/internal/storage"
+	"github.com/wellsgz/portmon/internal/types"
 )
 
 // Server handles IPC requests from clients.
 type Server struct {
 	socketPath string
 	listener   net.Listener
-	loader     *ebpf.Loader
+	source     ebpf.StatsSource
 	collector  *ebpf.Collector
 	aggregator *Aggregator
 	db         *storage.DB
@@ -32,27 +35,91 @@ type Server struct {
 	clients map[net.Conn]struct{}
 }
 
-// PortInfo holds port and its description.
+// PortInfo holds a port (or port range) and its description. NetnsName is
+// the network namespace as configured, for display. Roles selects which
+// side of connections is counted (types.RoleServer, RoleClient or both).
+// Filters restrict the remote addresses whose traffic is counted, and
+// LocalAddrs, if set, the local addresses.
 type PortInfo struct {
 	Port        uint16
+	PortEnd     uint16
+	Protocol    uint8
+	Netns       uint32
+	NetnsName   string
+	Roles       uint8
+	Filters     types.PortFilters
+	LocalAddrs  []netip.Addr
 	Description string
 }
 
+// key returns the PortKey the info describes.
+func (p PortInfo) key() types.PortKey {
+	key := types.NewPortKey(p.Port, p.PortEnd, p.Protocol)
+	key.Netns = p.Netns
+	return key
+}
+
+// Accounting modes. Payload accounting counts the bytes applications send
+// and receive; wire accounting additionally counts IP-level bytes,
+// including headers and retransmissions, as providers bill them.
+const (
+	AccountingPayload = "payload"
+	AccountingWire    = "wire"
+)
+
 // Config holds daemon configuration.
 type Config struct {
-	Ports         []uint16
+	Ports         []types.PortKey
 	PortInfos     []PortInfo
 	DataDir       string
 	RetentionDays int
 	SocketPath    string
 	LogLevel      string
+	Accounting    string // AccountingPayload (default) or AccountingWire
+	ResetMaps     bool   // Discard counters pinned by an earlier run
+	MapLimits     ebpf.MapLimits
+
+	// Rate window daily peaks are tracked over, one of types.RateWindows;
+	// zero for the shortest
+	PeakRateWindow time.Duration
+}
+
+// portRoles returns the roles configured for a port, both by default.
+func (c *Config) portRoles(key types.PortKey) uint8 {
+	for _, p := range c.PortInfos {
+		if p.key() == key && p.Roles != 0 {
+			return p.Roles
+		}
+	}
+	return types.RoleBoth
 }
 
-// NewServer creates a new IPC server.
-func NewServer(socketPath string, loader *ebpf.Loader, collector *ebpf.Collector, aggregator *Aggregator, db *storage.DB, config *Config) *Server {
+// portFilters returns the remote address filters configured for a port.
+func (c *Config) portFilters(key types.PortKey) types.PortFilters {
+	for _, p := range c.PortInfos {
+		if p.key() == key {
+			return p.Filters
+		}
+	}
+	return types.PortFilters{}
+}
+
+// portLocalAddrs returns the local addresses a port is pinned to, if any.
+func (c *Config) portLocalAddrs(key types.PortKey) []netip.Addr {
+	for _, p := range c.PortInfos {
+		if p.key() == key {
+			return p.LocalAddrs
+		}
+	}
+	return nil
+}
+
+// NewServer creates a new IPC server. Port breakdowns and map usage are
+// only reported when source is the eBPF Loader.
+func NewServer(socketPath string, source ebpf.StatsSource, collector *ebpf.Collector, aggregator *Aggregator, db *storage.DB, config *Config) *Server {
 	return &Server{
 		socketPath: socketPath,
-		loader:     loader,
+		source:     source,
 		collector:  collector,
 		aggregator: aggregator,
 		db:         db,
@@ -156,6 +223,18 @@ func (s *Server) handleRequest(req *api.Request) *api.Response {
 		return s.handleGetRealtimeStats(req)
 	case api.MethodGetHistoricalStats:
 		return s.handleGetHistoricalStats(req)
+	case api.MethodGetActiveConnections:
+		return s.handleGetActiveConnections(req)
+	case api.MethodGetConnectionHistory:
+		return s.handleGetConnectionHistory(req)
+	case api.MethodGetPortBreakdown:
+		return s.handleGetPortBreakdown(req)
+	case api.MethodGetProcessStats:
+		return s.handleGetProcessStats(req)
+	case api.MethodGetSizeHistogram:
+		return s.handleGetSizeHistogram(req)
+	case api.MethodGetRateHistory:
+		return s.handleGetRateHistory(req)
 	case api.MethodGetStatus:
 		return s.handleGetStatus(req)
 	case api.MethodAddPort:
@@ -177,57 +256,254 @@ func (s *Server) handleGetRealtimeStats(req *api.Request) *api.Response {
 		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "invalid params")
 	}
 
-	stats := s.collector.GetStats(params.Port)
+	key, err := portKey(params.Port, params.PortEnd, params.Protocol, params.Netns)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, err.Error())
+	}
+
+	localAddr, err := localAddrParam(params.LocalAddr)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, err.Error())
+	}
+
+	stats := s.collector.GetStats(key)
+	s.aggregator.Unpersisted(key, stats)
 
 	// Add today's persisted stats from SQLite
 	// This preserves accumulated traffic across daemon restarts
 	// Note: Connections is NOT added because we want current active count only
 	today := time.Now().Format("2006-01-02")
-	dbStats, err := s.db.QueryDailyStats(params.Port, today, today)
+	dbStats, err := s.db.QueryDailyStats(key, today, today)
 	if err == nil && len(dbStats) > 0 {
 		stats.RxBytes += dbStats[0].RxBytes
 		stats.TxBytes += dbStats[0].TxBytes
 		stats.RxPackets += dbStats[0].RxPackets
 		stats.TxPackets += dbStats[0].TxPackets
+		stats.RxOps += dbStats[0].RxOps
+		stats.TxOps += dbStats[0].TxOps
 		// Don't add dbStats[0].Connections - we show current active only
+		stats.Server.RxBytes += dbStats[0].ServerRxBytes
+		stats.Server.TxBytes += dbStats[0].ServerTxBytes
+		stats.Client.RxBytes += dbStats[0].ClientRxBytes
+		stats.Client.TxBytes += dbStats[0].ClientTxBytes
+		stats.Filtered.RxBytes += dbStats[0].FilteredRxBytes
+		stats.Filtered.TxBytes += dbStats[0].FilteredTxBytes
+		stats.Health.Add(&dbStats[0].Health)
+		stats.Handshakes.Add(&dbStats[0].Handshakes)
+		stats.Wire.RxBytes += dbStats[0].WireRxBytes
+		stats.Wire.TxBytes += dbStats[0].WireTxBytes
+		stats.Wire.RxPackets += dbStats[0].WireRxPackets
+		stats.Wire.TxPackets += dbStats[0].WireTxPackets
 	}
 
 	result := api.RealtimeStatsResult{
 		Port:        stats.Port,
+		PortEnd:     stats.PortEnd,
+		Protocol:    types.ProtocolName(stats.Protocol),
+		Netns:       stats.Netns,
 		RxBytes:     stats.RxBytes,
 		TxBytes:     stats.TxBytes,
 		RxPackets:   stats.RxPackets,
 		TxPackets:   stats.TxPackets,
+		RxOps:       stats.RxOps,
+		TxOps:       stats.TxOps,
 		Connections: stats.Connections,
 		RxRate:      stats.RxRate,
 		TxRate:      stats.TxRate,
+		Rates:       windowRates(&stats.Smoothed),
+		Server:      trafficStats(stats.Server),
+		Client:      trafficStats(stats.Client),
+		Filtered:    trafficStats(stats.Filtered),
+		Health:      tcpHealth(key, stats.Health),
+		Handshakes:  handshakeStats(key, stats.Handshakes),
+		Wire:        s.wireStats(stats.Wire),
+	}
+
+	cgroups := s.collector.GetCgroupStats(key)
+	sort.Slice(cgroups, func(i, j int) bool {
+		a, b := cgroups[i], cgroups[j]
+		if a.RxRate+a.TxRate != b.RxRate+b.TxRate {
+			return a.RxRate+a.TxRate > b.RxRate+b.TxRate
+		}
+		return a.RxBytes+a.TxBytes > b.RxBytes+b.TxBytes
+	})
+	for _, c := range cgroups {
+		result.Cgroups = append(result.Cgroups, api.CgroupStats{
+			Path:        c.Path,
+			Unit:        c.Unit,
+			Container:   c.Container,
+			RxBytes:     c.RxBytes,
+			TxBytes:     c.TxBytes,
+			RxOps:       c.RxOps,
+			TxOps:       c.TxOps,
+			Connections: c.Connections,
+			RxRate:      c.RxRate,
+			TxRate:      c.TxRate,
+		})
+	}
+
+	addrs, err := s.localAddrStats(key, today, today)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInternal, err.Error())
+	}
+	result.LocalAddrs = localAddrRows(addrs, localAddr, true)
+
+	// The stats of one local address replace the port's. The other
+	// breakdowns aren't kept per address, so they are left out.
+	if localAddr.IsValid() {
+		result = api.RealtimeStatsResult{
+			Port:       result.Port,
+			PortEnd:    result.PortEnd,
+			Protocol:   result.Protocol,
+			Netns:      result.Netns,
+			LocalAddrs: result.LocalAddrs,
+		}
+		for _, a := range result.LocalAddrs {
+			result.RxBytes, result.TxBytes = a.RxBytes, a.TxBytes
+			result.RxPackets, result.TxPackets = a.RxPackets, a.TxPackets
+			result.Connections = a.Connections
+			result.RxRate, result.TxRate = a.RxRate, a.TxRate
+		}
 	}
 
 	return s.successResponse(req.ID, result)
 }
 
+// localAddrStats returns a port's traffic per local address between
+// startDate and endDate: the persisted totals plus, if the range includes
+// today, the traffic since the last persist. Rates are the current ones.
+func (s *Server) localAddrStats(key types.PortKey, startDate, endDate string) ([]*types.LocalAddrStats, error) {
+	rows, err := s.db.QueryLocalAddrStats(key, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	byAddr := make(map[netip.Addr]*types.LocalAddrStats)
+	var result []*types.LocalAddrStats
+	today := time.Now().Format("2006-01-02")
+	if today >= startDate && today <= endDate {
+		for _, a := range s.collector.GetLocalAddrStats(key) {
+			s.aggregator.UnpersistedLocalAddr(key, a)
+			byAddr[a.Addr] = a
+			result = append(result, a)
+		}
+	}
+
+	for _, r := range rows {
+		addr, err := netip.ParseAddr(r.LocalAddr)
+		if err != nil {
+			continue
+		}
+		a, ok := byAddr[addr]
+		if !ok {
+			a = &types.LocalAddrStats{Addr: addr}
+			byAddr[addr] = a
+			result = append(result, a)
+		}
+		a.RxBytes += r.RxBytes
+		a.TxBytes += r.TxBytes
+		a.RxPackets += r.RxPackets
+		a.TxPackets += r.TxPackets
+		a.Connections += r.Connections
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.RxRate+a.TxRate != b.RxRate+b.TxRate {
+			return a.RxRate+a.TxRate > b.RxRate+b.TxRate
+		}
+		return a.RxBytes+a.TxBytes > b.RxBytes+b.TxBytes
+	})
+	return result, nil
+}
+
+// localAddrRows converts per-local-address stats for the API, keeping only
+// localAddr if it is valid.
+func localAddrRows(addrs []*types.LocalAddrStats, localAddr netip.Addr, withRates bool) []api.LocalAddrStats {
+	var rows []api.LocalAddrStats
+	for _, a := range addrs {
+		if localAddr.IsValid() && a.Addr != localAddr {
+			continue
+		}
+		row := api.LocalAddrStats{
+			Addr:        a.Addr.String(),
+			RxBytes:     a.RxBytes,
+			TxBytes:     a.TxBytes,
+			RxPackets:   a.RxPackets,
+			TxPackets:   a.TxPackets,
+			Connections: a.Connections,
+		}
+		if withRates {
+			row.RxRate, row.TxRate = a.RxRate, a.TxRate
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// localAddrParam parses the optional local address a stats query is
+// limited to. The zero Addr means every address.
+func localAddrParam(s string) (netip.Addr, error) {
+	if s == "" {
+		return netip.Addr{}, nil
+	}
+	addrs, err := types.ParseLocalAddrs([]string{s})
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addrs[0], nil
+}
+
 func (s *Server) handleGetHistoricalStats(req *api.Request) *api.Response {
 	var params api.HistoricalParams
 	if err := json.Unmarshal(req.Params, &params); err != nil {
 		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "invalid params")
 	}
 
+	key, err := portKey(params.Port, params.PortEnd, params.Protocol, params.Netns)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, err.Error())
+	}
+
+	localAddr, err := localAddrParam(params.LocalAddr)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, err.Error())
+	}
+
 	// Query daily stats from database
-	dailyStats, err := s.db.QueryDailyStats(params.Port, params.StartDate, params.EndDate)
+	dailyStats, err := s.db.QueryDailyStats(key, params.StartDate, params.EndDate)
 	if err != nil {
 		return s.errorResponse(req.ID, api.ErrCodeInternal, err.Error())
 	}
 
 	// Aggregate totals from DB
 	result := api.HistoricalStatsResult{
-		Port:      params.Port,
+		Port:      key.Port,
+		PortEnd:   key.PortEnd,
+		Protocol:  types.ProtocolName(key.Protocol),
+		Netns:     key.Netns,
 		StartDate: params.StartDate,
 		EndDate:   params.EndDate,
 	}
 
+	var health types.TCPHealth
+	var handshakes types.HandshakeStats
+	var wire types.TrafficStats
 	for _, d := range dailyStats {
 		result.TotalRx += d.RxBytes
 		result.TotalTx += d.TxBytes
+		result.Server.RxBytes += d.ServerRxBytes
+		result.Server.TxBytes += d.ServerTxBytes
+		result.Client.RxBytes += d.ClientRxBytes
+		result.Client.TxBytes += d.ClientTxBytes
+		result.Filtered.RxBytes += d.FilteredRxBytes
+		result.Filtered.TxBytes += d.FilteredTxBytes
+		health.Add(&d.Health)
+		handshakes.Add(&d.Handshakes)
+		wire.RxBytes += d.WireRxBytes
+		wire.TxBytes += d.WireTxBytes
+		wire.RxPackets += d.WireRxPackets
+		wire.TxPackets += d.WireTxPackets
 		if d.PeakRxRate > result.PeakRxRate {
 			result.PeakRxRate = d.PeakRxRate
 		}
@@ -241,6 +517,8 @@ func (s *Server) handleGetHistoricalStats(req *api.Request) *api.Response {
 			TxBytes:     d.TxBytes,
 			RxPackets:   d.RxPackets,
 			TxPackets:   d.TxPackets,
+			RxOps:       d.RxOps,
+			TxOps:       d.TxOps,
 			Connections: d.Connections,
 			PeakRxRate:  d.PeakRxRate,
 			PeakTxRate:  d.PeakTxRate,
@@ -251,14 +529,27 @@ func (s *Server) handleGetHistoricalStats(req *api.Request) *api.Response {
 	// This ensures Period Summary includes traffic not yet persisted to DB
 	today := time.Now().Format("2006-01-02")
 	if today >= params.StartDate && today <= params.EndDate {
-		ebpfStats := s.collector.GetStats(params.Port)
+		ebpfStats := s.collector.GetStats(key)
 		if ebpfStats != nil {
+			s.aggregator.Unpersisted(key, ebpfStats)
 			result.TotalRx += ebpfStats.RxBytes
 			result.TotalTx += ebpfStats.TxBytes
+			result.Server.RxBytes += ebpfStats.Server.RxBytes
+			result.Server.TxBytes += ebpfStats.Server.TxBytes
+			result.Client.RxBytes += ebpfStats.Client.RxBytes
+			result.Client.TxBytes += ebpfStats.Client.TxBytes
+			result.Filtered.RxBytes += ebpfStats.Filtered.RxBytes
+			result.Filtered.TxBytes += ebpfStats.Filtered.TxBytes
+			health.Add(&ebpfStats.Health)
+			handshakes.Add(&ebpfStats.Handshakes)
+			wire.RxBytes += ebpfStats.Wire.RxBytes
+			wire.TxBytes += ebpfStats.Wire.TxBytes
+			wire.RxPackets += ebpfStats.Wire.RxPackets
+			wire.TxPackets += ebpfStats.Wire.TxPackets
 
-			// Update peak rates if current rates are higher
-			rxRate := uint64(ebpfStats.RxRate)
-			txRate := uint64(ebpfStats.TxRate)
+			// Update peak rates if the ones since the last persist are higher
+			rxRate := uint64(ebpfStats.Peak.RxRate)
+			txRate := uint64(ebpfStats.Peak.TxRate)
 			if rxRate > result.PeakRxRate {
 				result.PeakRxRate = rxRate
 			}
@@ -287,10 +578,313 @@ func (s *Server) handleGetHistoricalStats(req *api.Request) *api.Response {
 	}
 
 	result.TotalBytes = result.TotalRx + result.TotalTx
+	result.Health = tcpHealth(key, health)
+	result.Handshakes = handshakeStats(key, handshakes)
+	result.Wire = s.wireStats(wire)
+
+	// Per-cgroup totals from persisted data
+	cgroupRows, err := s.db.QueryCgroupStats(key, params.StartDate, params.EndDate)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInternal, err.Error())
+	}
+	for _, r := range cgroupRows {
+		unit, container := ebpf.DescribeCgroup(r.Cgroup)
+		result.Cgroups = append(result.Cgroups, api.CgroupStats{
+			Path:        r.Cgroup,
+			Unit:        unit,
+			Container:   container,
+			RxBytes:     r.RxBytes,
+			TxBytes:     r.TxBytes,
+			RxOps:       r.RxOps,
+			TxOps:       r.TxOps,
+			Connections: r.Connections,
+		})
+	}
+
+	addrs, err := s.localAddrStats(key, params.StartDate, params.EndDate)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInternal, err.Error())
+	}
+	result.LocalAddrs = localAddrRows(addrs, localAddr, false)
+
+	// The totals of one local address replace the port's. The other
+	// breakdowns aren't kept per address, so they are left out.
+	if localAddr.IsValid() {
+		result = api.HistoricalStatsResult{
+			Port:       result.Port,
+			PortEnd:    result.PortEnd,
+			Protocol:   result.Protocol,
+			Netns:      result.Netns,
+			StartDate:  result.StartDate,
+			EndDate:    result.EndDate,
+			LocalAddrs: result.LocalAddrs,
+		}
+		for _, a := range result.LocalAddrs {
+			result.TotalRx, result.TotalTx = a.RxBytes, a.TxBytes
+			result.TotalBytes = a.RxBytes + a.TxBytes
+		}
+	}
+
+	return s.successResponse(req.ID, result)
+}
+
+func (s *Server) handleGetActiveConnections(req *api.Request) *api.Response {
+	var params api.PortParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "invalid params")
+	}
+
+	conns, err := s.source.GetActiveConnections(params.Port)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInternal, err.Error())
+	}
+
+	// Oldest connections first
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].StartedAt.Before(conns[j].StartedAt)
+	})
+
+	result := api.ActiveConnectionsResult{
+		Port:        params.Port,
+		Connections: make([]api.ConnectionInfo, 0, len(conns)),
+		Count:       len(conns),
+	}
+
+	now := time.Now()
+	for _, c := range conns {
+		result.Connections = append(result.Connections, api.ConnectionInfo{
+			Family:     familyName(c.Family),
+			LocalAddr:  c.LocalAddr.String(),
+			LocalPort:  c.LocalPort,
+			RemoteAddr: c.RemoteAddr.String(),
+			RemotePort: c.RemotePort,
+			RxBytes:    c.RxBytes,
+			TxBytes:    c.TxBytes,
+			StartedAt:  c.StartedAt,
+			Duration:   formatDuration(now.Sub(c.StartedAt)),
+		})
+	}
+
+	return s.successResponse(req.ID, result)
+}
+
+func (s *Server) handleGetConnectionHistory(req *api.Request) *api.Response {
+	var params api.ConnectionHistoryParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "invalid params")
+	}
+
+	start, err := time.ParseInLocation("2006-01-02", params.StartDate, time.Local)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "invalid start_date")
+	}
+	end, err := time.ParseInLocation("2006-01-02", params.EndDate, time.Local)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "invalid end_date")
+	}
+	end = end.AddDate(0, 0, 1).Add(-time.Second) // Inclusive end date
+
+	rows, err := s.db.QueryConnectionHistory(params.Port, start, end, params.Limit)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInternal, err.Error())
+	}
+
+	result := api.ConnectionHistoryResult{
+		Port:        params.Port,
+		StartDate:   params.StartDate,
+		EndDate:     params.EndDate,
+		Connections: make([]api.ConnectionHistoryEntry, 0, len(rows)),
+	}
+
+	for _, r := range rows {
+		result.Connections = append(result.Connections, api.ConnectionHistoryEntry{
+			Family:     familyName(r.Family),
+			LocalAddr:  r.LocalAddr,
+			LocalPort:  r.LocalPort,
+			RemoteAddr: r.RemoteAddr,
+			RemotePort: r.RemotePort,
+			RxBytes:    r.RxBytes,
+			TxBytes:    r.TxBytes,
+			StartedAt:  time.Unix(r.StartedAt, 0),
+			EndedAt:    time.Unix(r.EndedAt, 0),
+		})
+	}
+
+	return s.successResponse(req.ID, result)
+}
+
+func (s *Server) handleGetPortBreakdown(req *api.Request) *api.Response {
+	var params api.PortParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "invalid params")
+	}
+
+	key, err := portKey(params.Port, params.PortEnd, params.Protocol, params.Netns)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, err.Error())
+	}
+	if !key.IsRange() {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, fmt.Sprintf("port %s is not a range", key))
+	}
+
+	source, ok := s.source.(ebpf.MemberStatsSource)
+	if !ok {
+		return s.errorResponse(req.ID, api.ErrCodeInternal, "port breakdown needs eBPF")
+	}
+	members, err := source.GetMemberPortStats(key)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInternal, err.Error())
+	}
+
+	result := api.PortBreakdownResult{
+		Port:     key.Port,
+		PortEnd:  key.PortEnd,
+		Protocol: types.ProtocolName(key.Protocol),
+		Netns:    key.Netns,
+		Ports:    make([]api.PortBreakdownEntry, 0, len(members)),
+	}
+	for port, m := range members {
+		result.Ports = append(result.Ports, api.PortBreakdownEntry{
+			Port:        port,
+			RxBytes:     m.RxBytes,
+			TxBytes:     m.TxBytes,
+			RxPackets:   m.RxPackets,
+			TxPackets:   m.TxPackets,
+			Connections: m.Connections,
+		})
+	}
+
+	// Busiest ports first
+	sort.Slice(result.Ports, func(i, j int) bool {
+		a, b := result.Ports[i], result.Ports[j]
+		if a.RxBytes+a.TxBytes != b.RxBytes+b.TxBytes {
+			return a.RxBytes+a.TxBytes > b.RxBytes+b.TxBytes
+		}
+		return a.Port < b.Port
+	})
 
 	return s.successResponse(req.ID, result)
 }
 
+func (s *Server) handleGetProcessStats(req *api.Request) *api.Response {
+	var params api.ProcessStatsParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "invalid params")
+	}
+
+	key, err := portKey(params.Port, params.PortEnd, params.Protocol, params.Netns)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, err.Error())
+	}
+
+	procs := s.collector.GetProcessStats(key)
+
+	// Busiest first: by current rate, then by total bytes
+	sort.Slice(procs, func(i, j int) bool {
+		a, b := procs[i], procs[j]
+		if a.RxRate+a.TxRate != b.RxRate+b.TxRate {
+			return a.RxRate+a.TxRate > b.RxRate+b.TxRate
+		}
+		return a.RxBytes+a.TxBytes > b.RxBytes+b.TxBytes
+	})
+	if params.Limit > 0 && len(procs) > params.Limit {
+		procs = procs[:params.Limit]
+	}
+
+	result := api.ProcessStatsResult{
+		Port:      key.Port,
+		PortEnd:   key.PortEnd,
+		Protocol:  types.ProtocolName(key.Protocol),
+		Netns:     key.Netns,
+		Processes: make([]api.ProcessInfo, 0, len(procs)),
+	}
+	for _, p := range procs {
+		result.Processes = append(result.Processes, api.ProcessInfo{
+			Pid:     p.Pid,
+			Tgid:    p.Tgid,
+			Comm:    p.Comm,
+			RxBytes: p.RxBytes,
+			TxBytes: p.TxBytes,
+			RxOps:   p.RxOps,
+			TxOps:   p.TxOps,
+			RxRate:  p.RxRate,
+			TxRate:  p.TxRate,
+		})
+	}
+
+	return s.successResponse(req.ID, result)
+}
+
+func (s *Server) handleGetSizeHistogram(req *api.Request) *api.Response {
+	var params api.SizeHistogramParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "invalid params")
+	}
+
+	key, err := portKey(params.Port, params.PortEnd, params.Protocol, params.Netns)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, err.Error())
+	}
+	if key.Protocol != types.ProtocolTCP {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "size histograms are only kept for TCP ports")
+	}
+
+	hours := params.Hours
+	if hours <= 0 {
+		hours = 24
+	}
+
+	// Persisted hours, plus what the current hour hasn't written yet
+	now := time.Now()
+	sizes, err := s.db.QuerySizeStats(key, now.Add(-time.Duration(hours-1)*time.Hour), now)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInternal, err.Error())
+	}
+	stats := s.collector.GetStats(key)
+	s.aggregator.Unpersisted(key, stats)
+	sizes.Add(&stats.Sizes)
+
+	return s.successResponse(req.ID, api.SizeHistogramResult{
+		Port:     key.Port,
+		PortEnd:  key.PortEnd,
+		Protocol: types.ProtocolName(key.Protocol),
+		Netns:    key.Netns,
+		Hours:    hours,
+		Send:     sizeHistogram(sizes.Send),
+		Recv:     sizeHistogram(sizes.Recv),
+	})
+}
+
+func (s *Server) handleGetRateHistory(req *api.Request) *api.Response {
+	var params api.RateHistoryParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "invalid params")
+	}
+
+	key, err := portKey(params.Port, params.PortEnd, params.Protocol, params.Netns)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, err.Error())
+	}
+
+	history := s.collector.GetRateHistory(key, params.Seconds)
+	samples := make([]api.RateSample, 0, len(history))
+	for _, r := range history {
+		samples = append(samples, api.RateSample{
+			Time:   r.Time.Unix(),
+			RxRate: r.RxRate,
+			TxRate: r.TxRate,
+		})
+	}
+
+	return s.successResponse(req.ID, api.RateHistoryResult{
+		Port:     key.Port,
+		PortEnd:  key.PortEnd,
+		Protocol: types.ProtocolName(key.Protocol),
+		Netns:    key.Netns,
+		Samples:  samples,
+	})
+}
+
 func (s *Server) handleGetStatus(req *api.Request) *api.Response {
 	uptime := time.Since(s.startTime)
 
@@ -299,19 +893,53 @@ func (s *Server) handleGetStatus(req *api.Request) *api.Response {
 	for i, p := range s.config.PortInfos {
 		portInfos[i] = api.PortInfo{
 			Port:        p.Port,
+			PortEnd:     p.PortEnd,
+			Protocol:    types.ProtocolName(p.Protocol),
+			Netns:       p.Netns,
+			NetnsName:   p.NetnsName,
+			Role:        types.RolesName(p.Roles),
 			Description: p.Description,
 		}
+		if !p.Filters.IsEmpty() {
+			portInfos[i].Filters = &api.PortFilters{
+				Include: types.PrefixStrings(p.Filters.Include),
+				Exclude: types.PrefixStrings(p.Filters.Exclude),
+			}
+		}
+		portInfos[i].LocalAddrs = types.AddrStrings(p.LocalAddrs)
+	}
+
+	attach := s.source.AttachInfo()
+
+	var maps []api.MapUsage
+	if source, ok := s.source.(ebpf.MapUsageSource); ok {
+		if usage, err := source.GetMapUsage(); err == nil {
+			for _, u := range usage {
+				maps = append(maps, api.MapUsage{
+					Name:       u.Name,
+					Entries:    u.Entries,
+					MaxEntries: u.MaxEntries,
+					Drops:      u.Drops,
+				})
+			}
+		} else {
+			slog.Debug("failed to get map usage", "error", err)
+		}
 	}
 
 	result := api.StatusResult{
 		Running:        true,
 		Uptime:         formatDuration(uptime),
 		StartTime:      s.startTime.Format(time.RFC3339),
-		MonitoredPorts: s.config.Ports,
+		MonitoredPorts: portStrings(s.config.Ports),
 		PortInfos:      portInfos,
 		DataDir:        s.config.DataDir,
 		RetentionDays:  s.config.RetentionDays,
 		SocketPath:     s.config.SocketPath,
+		Accounting:     s.config.Accounting,
+		AttachMode:     attach.Mode,
+		DegradedProbes: attach.Degraded,
+		Maps:           maps,
 		Version:        "0.2.1",
 	}
 
@@ -324,16 +952,45 @@ func (s *Server) handleAddPort(req *api.Request) *api.Response {
 		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "invalid params")
 	}
 
-	if err := s.loader.AddPort(params.Port); err != nil {
+	key, err := portKey(params.Port, params.PortEnd, params.Protocol, params.Netns)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, err.Error())
+	}
+
+	for _, p := range s.config.Ports {
+		if p == key {
+			return s.successResponse(req.ID, api.SuccessResult{
+				Success: true,
+				Message: fmt.Sprintf("port %s already monitored", key),
+			})
+		}
+		if p.Overlaps(key) {
+			return s.errorResponse(req.ID, api.ErrCodeInvalidParams, fmt.Sprintf("port %s overlaps monitored port %s", key, p))
+		}
+	}
+
+	roles, err := types.ParseRoles(params.Role)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, err.Error())
+	}
+
+	if err := s.source.AddPort(key, roles); err != nil {
 		return s.errorResponse(req.ID, api.ErrCodeInternal, err.Error())
 	}
 
 	// Add to config
-	s.config.Ports = append(s.config.Ports, params.Port)
+	s.config.Ports = append(s.config.Ports, key)
+	s.config.PortInfos = append(s.config.PortInfos, PortInfo{
+		Port:     key.Port,
+		PortEnd:  key.PortEnd,
+		Protocol: key.Protocol,
+		Netns:    key.Netns,
+		Roles:    roles,
+	})
 
 	return s.successResponse(req.ID, api.SuccessResult{
 		Success: true,
-		Message: fmt.Sprintf("port %d added", params.Port),
+		Message: fmt.Sprintf("port %s added", key),
 	})
 }
 
@@ -343,28 +1000,41 @@ func (s *Server) handleRemovePort(req *api.Request) *api.Response {
 		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, "invalid params")
 	}
 
-	if err := s.loader.RemovePort(params.Port); err != nil {
+	key, err := portKey(params.Port, params.PortEnd, params.Protocol, params.Netns)
+	if err != nil {
+		return s.errorResponse(req.ID, api.ErrCodeInvalidParams, err.Error())
+	}
+
+	if err := s.source.RemovePort(key); err != nil {
 		return s.errorResponse(req.ID, api.ErrCodeInternal, err.Error())
 	}
 
 	// Remove from config
-	newPorts := make([]uint16, 0, len(s.config.Ports))
+	newPorts := make([]types.PortKey, 0, len(s.config.Ports))
 	for _, p := range s.config.Ports {
-		if p != params.Port {
+		if p != key {
 			newPorts = append(newPorts, p)
 		}
 	}
 	s.config.Ports = newPorts
 
+	newInfos := make([]PortInfo, 0, len(s.config.PortInfos))
+	for _, p := range s.config.PortInfos {
+		if p.key() != key {
+			newInfos = append(newInfos, p)
+		}
+	}
+	s.config.PortInfos = newInfos
+
 	return s.successResponse(req.ID, api.SuccessResult{
 		Success: true,
-		Message: fmt.Sprintf("port %d removed", params.Port),
+		Message: fmt.Sprintf("port %s removed", key),
 	})
 }
 
 func (s *Server) handleListPorts(req *api.Request) *api.Response {
 	return s.successResponse(req.ID, api.ListPortsResult{
-		Ports: s.config.Ports,
+		Ports: portStrings(s.config.Ports),
 	})
 }
 
@@ -400,6 +1070,117 @@ func (s *Server) sendError(encoder *json.Encoder, id, code int, message string)
 	encoder.Encode(s.errorResponse(id, code, message))
 }
 
+// portKey builds a PortKey from API parameters.
+func portKey(port, portEnd uint16, protocol string, netns uint32) (types.PortKey, error) {
+	proto, err := types.ParseProtocol(protocol)
+	if err != nil {
+		return types.PortKey{}, err
+	}
+	if portEnd != 0 && portEnd < port {
+		return types.PortKey{}, fmt.Errorf("invalid port range %d-%d", port, portEnd)
+	}
+	key := types.NewPortKey(port, portEnd, proto)
+	key.Netns = netns
+	return key, nil
+}
+
+// trafficStats converts a share of a port's stats to the API type.
+func trafficStats(rs types.TrafficStats) api.TrafficStats {
+	return api.TrafficStats{
+		RxBytes:   rs.RxBytes,
+		TxBytes:   rs.TxBytes,
+		RxPackets: rs.RxPackets,
+		TxPackets: rs.TxPackets,
+		RxRate:    rs.RxRate,
+		TxRate:    rs.TxRate,
+	}
+}
+
+// windowRates converts a port's smoothed rates to the API type, keyed by
+// window name.
+func windowRates(smoothed *types.SmoothedRates) map[string]api.WindowRate {
+	result := make(map[string]api.WindowRate, len(smoothed))
+	for i, r := range smoothed {
+		result[types.RateWindowName(types.RateWindows[i])] = api.WindowRate{RxRate: r.RxRate, TxRate: r.TxRate}
+	}
+	return result
+}
+
+// wireStats converts a port's IP-level traffic to the API type. It is only
+// reported in wire accounting mode, or when a period includes wire data
+// recorded earlier.
+func (s *Server) wireStats(w types.TrafficStats) *api.TrafficStats {
+	if s.config.Accounting != AccountingWire && w.RxBytes == 0 && w.TxBytes == 0 {
+		return nil
+	}
+	result := trafficStats(w)
+	return &result
+}
+
+// tcpHealth converts a port's TCP health counters to the API type. UDP
+// ports have no health data.
+func tcpHealth(key types.PortKey, h types.TCPHealth) *api.TCPHealth {
+	if key.Protocol != types.ProtocolTCP {
+		return nil
+	}
+	result := &api.TCPHealth{
+		Retransmits: h.Retransmits,
+		Resets:      h.Resets,
+		RTTSamples:  h.RTT.Samples(),
+		RTTP50:      h.RTT.Percentile(0.50),
+		RTTP90:      h.RTT.Percentile(0.90),
+		RTTP99:      h.RTT.Percentile(0.99),
+	}
+	if result.RTTSamples > 0 {
+		result.RTTHistogram = h.RTT[:]
+	}
+	return result
+}
+
+// sizeHistogram converts a payload size histogram to the API type.
+func sizeHistogram(h types.SizeHistogram) api.SizeHistogram {
+	result := api.SizeHistogram{
+		Samples: h.Samples(),
+		P50:     h.Percentile(0.50),
+		P90:     h.Percentile(0.90),
+		P99:     h.Percentile(0.99),
+	}
+	if result.Samples > 0 {
+		result.Buckets = h[:]
+	}
+	return result
+}
+
+// handshakeStats converts a port's connection establishment counters to
+// the API type. UDP ports have none.
+func handshakeStats(key types.PortKey, h types.HandshakeStats) *api.HandshakeStats {
+	if key.Protocol != types.ProtocolTCP {
+		return nil
+	}
+	return &api.HandshakeStats{
+		Accepted:  h.Accepted,
+		Initiated: h.Initiated,
+		Failed:    h.Failed,
+	}
+}
+
+// portStrings formats port keys as "port/proto" or "first-last/proto" strings.
+func portStrings(keys []types.PortKey) []string {
+	result := make([]string, len(keys))
+	for i, k := range keys {
+		result[i] = k.String()
+	}
+	return result
+}
+
+// familyName returns the API name for an address family.
+func familyName(family uint16) string {
+	if family == types.FamilyIPv6 {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
 func formatDuration(d time.Duration) string {
 	days := int(d.Hours() / 24)
 	hours := int(d.Hours()) % 24
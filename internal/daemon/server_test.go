@@ -0,0 +1,34 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+func TestTCPHealth(t *testing.T) {
+	tcp := types.TCPPort(443)
+
+	// No samples yet: zero percentiles and no histogram
+	got := tcpHealth(tcp, types.TCPHealth{Retransmits: 2})
+	if got == nil {
+		t.Fatal("tcpHealth = nil for a TCP port")
+	}
+	if got.Retransmits != 2 || got.RTTSamples != 0 || got.RTTP50 != 0 || got.RTTP99 != 0 || got.RTTHistogram != nil {
+		t.Errorf("empty health = %+v, want 2 retransmits and no RTT", got)
+	}
+
+	var h types.TCPHealth
+	h.RTT[9], h.RTT[10], h.RTT[14] = 80, 19, 1 // 0.5-1ms, 1-2ms and one 16-32ms sample
+	got = tcpHealth(tcp, h)
+	if got.RTTSamples != 100 || got.RTTP50 != 1024 || got.RTTP90 != 2048 || got.RTTP99 != 2048 {
+		t.Errorf("health = %+v, want 100 samples, p50/p90/p99 1024/2048/2048", got)
+	}
+	if len(got.RTTHistogram) != types.RTTBuckets || got.RTTHistogram[14] != 1 {
+		t.Errorf("histogram = %v, want %d buckets with 1 in bucket 14", got.RTTHistogram, types.RTTBuckets)
+	}
+
+	if got := tcpHealth(types.NewPortKey(53, 0, types.ProtocolUDP), h); got != nil {
+		t.Errorf("tcpHealth = %+v for a UDP port, want nil", got)
+	}
+}
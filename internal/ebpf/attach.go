@@ -0,0 +1,230 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/features"
+	"github.com/cilium/ebpf/link"
+)
+
+// Attach mechanisms of the TCP data path hooks, best first. AttachFentry
+// covers both fentry and fexit programs.
+const (
+	AttachFentry     = "fentry"
+	AttachKprobe     = "kprobe"
+	AttachTracepoint = "tracepoint"
+
+	// AttachMixed means the hooks ended up on different mechanisms.
+	AttachMixed = "mixed"
+
+	// AttachNetlink means eBPF isn't used and TCP socket counters are
+	// polled through socket diagnostics instead.
+	AttachNetlink = "netlink"
+)
+
+// AttachInfo describes how the probes were attached to the running kernel.
+type AttachInfo struct {
+	// Mode is the mechanism used by the TCP data path hooks.
+	Mode string
+
+	// Degraded lists probes that fell back to a weaker mechanism or were
+	// skipped, with the reason.
+	Degraded []string
+}
+
+// AttachInfo returns how the probes were attached.
+func (l *Loader) AttachInfo() AttachInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return AttachInfo{Mode: l.attach.Mode, Degraded: slices.Clone(l.attach.Degraded)}
+}
+
+// probeTracing returns the fentry/fexit programs in spec that the running
+// kernel cannot load, with the reason. Unlike kprobes, tracing programs are
+// checked against their target function's BTF at load time, so a missing
+// target would fail the whole collection.
+func probeTracing(spec *ebpf.CollectionSpec) map[string]error {
+	unsupported := make(map[string]error)
+
+	var kernel *btf.Spec
+	var reason error
+	for name, p := range spec.Programs {
+		if p.Type != ebpf.Tracing {
+			continue
+		}
+
+		if kernel == nil && reason == nil {
+			var err error
+			if kernel, err = btf.LoadKernelSpec(); err != nil {
+				reason = fmt.Errorf("no kernel BTF: %w", err)
+			} else if err := features.HaveProgramType(ebpf.Tracing); err != nil {
+				reason = fmt.Errorf("fentry not supported: %w", err)
+			}
+		}
+		if reason != nil {
+			unsupported[name] = reason
+			continue
+		}
+
+		var fn *btf.Func
+		if err := kernel.TypeByName(p.AttachTo, &fn); err != nil {
+			unsupported[name] = fmt.Errorf("%s not in kernel BTF, possibly inlined or renamed", p.AttachTo)
+		}
+	}
+
+	return unsupported
+}
+
+// loadObjects loads spec and assigns its maps and programs. Unlike
+// loadProbeObjects it tolerates programs removed from spec, leaving their
+// fields nil.
+func loadObjects(spec *ebpf.CollectionSpec, opts ebpf.CollectionOptions) (*probeObjects, error) {
+	coll, err := ebpf.NewCollectionWithOptions(spec, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+
+	objs := &probeObjects{}
+	if err := coll.Assign(&objs.probeMaps); err != nil {
+		return nil, err
+	}
+
+	progs := reflect.ValueOf(&objs.probePrograms).Elem()
+	for i := 0; i < progs.NumField(); i++ {
+		name := progs.Type().Field(i).Tag.Get("ebpf")
+		if prog := coll.DetachProgram(name); prog != nil {
+			progs.Field(i).Set(reflect.ValueOf(prog))
+		}
+	}
+
+	return objs, nil
+}
+
+// dataHook is a TCP data path hook with its program for each mechanism.
+// Hooks counting a return value have a kretprobe next to the kprobe that
+// stashes the call's arguments.
+type dataHook struct {
+	symbol string
+
+	fentry    *ebpf.Program // fentry or fexit
+	fentryErr error         // Why fentry is nil
+
+	kprobe    *ebpf.Program
+	kretprobe *ebpf.Program
+
+	tracepoint string // In the sock group, if the hook has one
+	tpProg     *ebpf.Program
+
+	// optional hooks may be missing from the kernel; only tcp_sendpage,
+	// removed in Linux 6.5 when sendfile moved to tcp_sendmsg
+	optional bool
+}
+
+// dataHooks returns the TCP data path hooks. Callers must hold l.mu.
+func (l *Loader) dataHooks() []dataHook {
+	return []dataHook{
+		{
+			symbol:     "tcp_sendmsg",
+			fentry:     l.objs.FexitTcpSendmsg,
+			fentryErr:  l.tracingErrs["fexit_tcp_sendmsg"],
+			kprobe:     l.objs.TraceTcpSendmsg,
+			kretprobe:  l.objs.TraceTcpSendmsgRet,
+			tracepoint: "sock_send_length",
+			tpProg:     l.objs.TraceSockSendLength,
+		},
+		{
+			symbol:    "tcp_sendpage",
+			fentry:    l.objs.FexitTcpSendpage,
+			fentryErr: l.tracingErrs["fexit_tcp_sendpage"],
+			kprobe:    l.objs.TraceTcpSendmsg,
+			kretprobe: l.objs.TraceTcpSendmsgRet,
+			optional:  true,
+		},
+		{
+			symbol:     "tcp_cleanup_rbuf",
+			fentry:     l.objs.FentryTcpCleanupRbuf,
+			fentryErr:  l.tracingErrs["fentry_tcp_cleanup_rbuf"],
+			kprobe:     l.objs.TraceTcpCleanupRbuf,
+			tracepoint: "sock_recv_length",
+			tpProg:     l.objs.TraceSockRecvLength,
+		},
+	}
+}
+
+// attachDataHook attaches h with the best mechanism that works and returns
+// it. Fallbacks are recorded as degraded. Callers must hold l.mu.
+func (l *Loader) attachDataHook(h dataHook) (string, error) {
+	var errs []error
+
+	if h.fentry != nil {
+		lnk, err := link.AttachTracing(link.TracingOptions{Program: h.fentry})
+		if err == nil {
+			l.links = append(l.links, lnk)
+			slog.Info("attached fentry", "function", h.symbol)
+			return AttachFentry, nil
+		}
+		errs = append(errs, fmt.Errorf("fentry: %w", err))
+	} else if h.fentryErr != nil {
+		errs = append(errs, fmt.Errorf("fentry: %w", h.fentryErr))
+	}
+
+	links, err := attachKprobes(h)
+	if err == nil {
+		l.links = append(l.links, links...)
+		slog.Info("attached kprobe", "function", h.symbol, "return", h.kretprobe != nil)
+		l.degrade(h.symbol, AttachKprobe, errs)
+		return AttachKprobe, nil
+	}
+	errs = append(errs, fmt.Errorf("kprobe: %w", err))
+
+	if h.tracepoint != "" {
+		lnk, err := link.Tracepoint("sock", h.tracepoint, h.tpProg, nil)
+		if err == nil {
+			l.links = append(l.links, lnk)
+			slog.Info("attached tracepoint", "name", "sock/"+h.tracepoint, "function", h.symbol)
+			l.degrade(h.symbol, AttachTracepoint, errs)
+			return AttachTracepoint, nil
+		}
+		errs = append(errs, fmt.Errorf("tracepoint: %w", err))
+	}
+
+	return "", errors.Join(errs...)
+}
+
+// attachKprobes attaches the kprobe of h and, if it has one, its
+// kretprobe. Either both are attached or neither is.
+func attachKprobes(h dataHook) ([]link.Link, error) {
+	entry, err := link.Kprobe(h.symbol, h.kprobe, nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.kretprobe == nil {
+		return []link.Link{entry}, nil
+	}
+
+	ret, err := link.Kretprobe(h.symbol, h.kretprobe, nil)
+	if err != nil {
+		entry.Close()
+		return nil, err
+	}
+	return []link.Link{entry, ret}, nil
+}
+
+// degrade records that probe fell back to mechanism because of errs.
+// Callers must hold l.mu.
+func (l *Loader) degrade(probe, mechanism string, errs []error) {
+	if len(errs) == 0 {
+		return
+	}
+	slog.Warn("probe degraded", "probe", probe, "mechanism", mechanism, "error", errors.Join(errs...))
+	l.attach.Degraded = append(l.attach.Degraded,
+		fmt.Sprintf("%s: using %s (%v)", probe, mechanism, errs[0]))
+}
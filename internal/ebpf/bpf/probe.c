@@ -1,7 +1,7 @@
 // go:build ignore
 
 // eBPF program for monitoring TCP traffic on specific ports.
-// Uses kprobes on tcp_sendmsg and tcp_cleanup_rbuf for passive observation.
+// Uses probes on tcp_sendmsg, tcp_sendpage and tcp_cleanup_rbuf for passive observation.
 
 #include "vmlinux.h"
 #include <bpf/bpf_core_read.h>
@@ -11,40 +11,269 @@
 
 char LICENSE[] SEC("license") = "GPL";
 
+// Address families (not exported by vmlinux.h)
+#define AF_INET 2
+#define AF_INET6 10
+
+#define IPPROTO_TCP 6
+#define IPPROTO_UDP 17
+
 // ============================================================================
 // Map Definitions
 // ============================================================================
 
-// Configuration map - holds target ports to monitor
+// Which side of a connection the monitored port is on. Server traffic is
+// on connections to the local port (e.g. our PostgreSQL on 5432); client
+// traffic is on connections we make to a remote port (e.g. our app talking
+// to a remote 5432).
+#define PM_ROLE_SERVER 1
+#define PM_ROLE_CLIENT 2
+
+// Port + transport protocol, so 5000/tcp and 5000/udp are tracked separately.
+// netns scopes the port to one network namespace; 0 matches every namespace.
+struct pm_port_key {
+  __u32 netns;   // network namespace inode, or 0
+  __u16 port;
+  __u8 protocol; // IPPROTO_TCP or IPPROTO_UDP
+  __u8 role;     // PM_ROLE_* in port_stats_map, 0 in the other maps
+};
+
+// Target port bitmap: one bit per port and protocol. Words [0, 1024) hold
+// TCP ports and words [1024, 2048) hold UDP ports, so a lookup for any port
+// costs a single array access regardless of how many ports are monitored.
+#define PM_PORT_WORDS 1024
+
 struct {
-  __uint(type, BPF_MAP_TYPE_HASH);
-  __uint(max_entries, 64);
-  __type(key, __u16);  // port number
-  __type(value, __u8); // enabled flag (1 = monitor)
+  __uint(type, BPF_MAP_TYPE_ARRAY);
+  __uint(max_entries, 2 * PM_PORT_WORDS);
+  __type(key, __u32);
+  __type(value, __u64);
+  __uint(pinning, LIBBPF_PIN_BY_NAME);
 } target_ports SEC(".maps");
 
-// Per-port aggregate statistics (renamed to avoid kernel conflict)
+// Service a monitored port belongs to. Port ranges are aggregated under
+// their first port; single ports map to themselves.
+struct pm_port_target {
+  __u16 service; // first port of the range (or the port itself)
+  __u8 range;    // 1 if the port is a member of a multi-port range
+  __u8 roles;    // PM_ROLE_* bits to count
+};
+
+struct {
+  __uint(type, BPF_MAP_TYPE_ARRAY);
+  __uint(max_entries, 2 * 65536);
+  __type(key, __u32);
+  __type(value, struct pm_port_target);
+} port_targets SEC(".maps");
+
+// Targets restricted to one network namespace, keyed by (netns, port,
+// protocol). These take precedence over port_targets, so a port can be
+// monitored separately in a container and everywhere else. The bitmap in
+// target_ports is set for both kinds of target.
+struct {
+  __uint(type, BPF_MAP_TYPE_HASH);
+  __uint(max_entries, 65536);
+  __uint(map_flags, BPF_F_NO_PREALLOC);
+  __type(key, struct pm_port_key);
+  __type(value, struct pm_port_target);
+} netns_targets SEC(".maps");
+
+// Smoothed RTT histogram buckets: bucket i counts samples in
+// [2^i, 2^(i+1)) microseconds, the last bucket everything above
+#define PM_RTT_BUCKETS 24
+
+// Payload size histogram buckets: bucket i counts sends or receives of
+// [2^i, 2^(i+1)) bytes, the last bucket everything above
+#define PM_SIZE_BUCKETS 32
+
+// Per-port aggregate statistics (renamed to avoid kernel conflict). The TCP
+// health counters and size histograms are only kept in port_stats_map.
+// Packets are TCP segments, counted per skb with GSO/GRO segments expanded,
+// and UDP datagrams; operations are send and receive calls. Segments are
+// counted in softirq context as often as not, so the per-cgroup stats only
+// have operations.
 struct pm_port_stats {
   __u64 rx_bytes;
   __u64 tx_bytes;
   __u64 rx_packets;
   __u64 tx_packets;
+  __u64 rx_ops;
+  __u64 tx_ops;
   __u64 connections;
+  __u64 retransmits;              // retransmissions
+  __u64 resets;                   // RSTs sent and received
+  __u64 accepts;                  // connections accepted (server role)
+  __u64 connects;                 // connections initiated (client role)
+  __u64 failures;                 // connects and handshakes that failed
+  __u64 rtt_hist[PM_RTT_BUCKETS]; // srtt sampled on each send/receive
+  __u64 tx_size_hist[PM_SIZE_BUCKETS]; // bytes per tcp_sendmsg
+  __u64 rx_size_hist[PM_SIZE_BUCKETS]; // bytes per tcp_cleanup_rbuf
 };
 
+// Per-service statistics, one entry per role. Per-CPU, since every send and
+// receive on a busy port updates it; userspace sums the CPUs. Updates are
+// still atomic: programs run with migration disabled but not preemption,
+// and a softirq on the same CPU (tcp_rcv_established, __tcp_transmit_skb
+// from a timer) can interrupt a process-context program such as
+// tcp_sendmsg halfway through a read-modify-write of the same entry. An
+// atomic add on a cacheline only this CPU writes stays uncontended, which
+// is what the per-CPU layout buys. Pinned, like target_ports and
+// conn_stats_map, so the counters survive daemon restarts.
 struct {
-  __uint(type, BPF_MAP_TYPE_HASH);
-  __uint(max_entries, 64);
-  __type(key, __u16);
+  __uint(type, BPF_MAP_TYPE_PERCPU_HASH);
+  __uint(max_entries, 128);
+  __type(key, struct pm_port_key);
   __type(value, struct pm_port_stats);
+  __uint(pinning, LIBBPF_PIN_BY_NAME);
 } port_stats_map SEC(".maps");
 
-// Per-connection key for tracking individual connections
+// Wire-level statistics: IP packet lengths, including headers and
+// retransmissions, seen by the cgroup_skb programs. One entry per role;
+// only attached in wire accounting mode.
+struct {
+  __uint(type, BPF_MAP_TYPE_HASH);
+  __uint(max_entries, 128);
+  __type(key, struct pm_port_key);
+  __type(value, struct pm_port_stats);
+} wire_stats_map SEC(".maps");
+
+// Traffic excluded by a service's CIDR filters, kept apart from
+// port_stats_map so billed totals plus filtered totals still add up to
+// everything seen on the port. Role is always 0.
+struct {
+  __uint(type, BPF_MAP_TYPE_HASH);
+  __uint(max_entries, 128);
+  __type(key, struct pm_port_key);
+  __type(value, struct pm_port_stats);
+} filtered_stats_map SEC(".maps");
+
+// CIDR filter actions
+#define PM_FILTER_INCLUDE 1
+#define PM_FILTER_EXCLUDE 2
+
+// Remote address filter rule of a service. The first 64 bits after
+// prefixlen (netns, service, protocol, family) are always part of the
+// prefix, so a rule's prefixlen is 64 plus its CIDR length. Addresses use
+// the same layout as pm_conn_key: network byte order, IPv4 in word 0.
+struct pm_filter_key {
+  __u32 prefixlen;
+  __u32 netns; // service network namespace, or 0
+  __u16 service;
+  __u8 protocol;
+  __u8 family; // AF_INET or AF_INET6
+  __u32 addr[4];
+};
+
+// Longest-prefix match decides, so "include 10.0.0.0/8" can be narrowed by
+// "exclude 10.1.0.0/16". Services with include rules also get a /0 exclude
+// rule per family so unmatched addresses are filtered.
+struct {
+  __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+  __uint(max_entries, 4096);
+  __uint(map_flags, BPF_F_NO_PREALLOC);
+  __type(key, struct pm_filter_key);
+  __type(value, __u8);
+} cidr_filters SEC(".maps");
+
+// Per-port statistics for members of port ranges, for drill-down below the
+// service level. Entries are allocated on first traffic.
+struct {
+  __uint(type, BPF_MAP_TYPE_HASH);
+  __uint(max_entries, 65536);
+  __uint(map_flags, BPF_F_NO_PREALLOC);
+  __type(key, struct pm_port_key);
+  __type(value, struct pm_port_stats);
+} member_stats_map SEC(".maps");
+
+// Per-(service, local address) key, for hosts with several addresses
+// bound on the same port. Addresses use the same layout as pm_conn_key:
+// network byte order, IPv4 in word 0.
+struct pm_addr_key {
+  __u32 netns; // service network namespace, or 0
+  __u16 port;  // service port (first port of a range)
+  __u8 protocol;
+  __u8 family; // AF_INET or AF_INET6
+  __u32 addr[4];
+};
+
+// Local addresses a service is pinned to; traffic on other local addresses
+// isn't counted. An entry with family 0 and no address marks a service as
+// pinned, so unpinned services cost a single lookup.
+struct {
+  __uint(type, BPF_MAP_TYPE_HASH);
+  __uint(max_entries, 1024);
+  __uint(map_flags, BPF_F_NO_PREALLOC);
+  __type(key, struct pm_addr_key);
+  __type(value, __u8);
+} local_addrs SEC(".maps");
+
+// Per-service statistics by local address. Entries are allocated on first
+// traffic.
+struct {
+  __uint(type, BPF_MAP_TYPE_HASH);
+  __uint(max_entries, 4096);
+  __uint(map_flags, BPF_F_NO_PREALLOC);
+  __type(key, struct pm_addr_key);
+  __type(value, struct pm_port_stats);
+} addr_stats_map SEC(".maps");
+
+// Per-(service, process) key. Traffic is attributed to the process whose
+// context the send/receive ran in.
+struct pm_proc_key {
+  __u32 netns; // service network namespace, or 0
+  __u32 tgid;  // process ID
+  __u16 port;  // service port (first port of a range)
+  __u8 protocol;
+  __u8 pad;
+};
+
+// Per-process statistics. pid is the most recent thread seen.
+struct pm_proc_stats {
+  __u64 rx_bytes;
+  __u64 tx_bytes;
+  __u64 rx_ops;
+  __u64 tx_ops;
+  __u32 pid;
+  char comm[16];
+  __u8 pad[4];
+};
+
+// LRU so short-lived processes age out instead of filling the map
+struct {
+  __uint(type, BPF_MAP_TYPE_LRU_HASH);
+  __uint(max_entries, 4096);
+  __type(key, struct pm_proc_key);
+  __type(value, struct pm_proc_stats);
+} proc_stats_map SEC(".maps");
+
+// Per-(service, cgroup) key. The cgroup v2 id identifies the systemd unit
+// or container the traffic was generated in.
+struct pm_cgroup_key {
+  __u64 cgroup_id;
+  __u32 netns; // service network namespace, or 0
+  __u16 port;  // service port (first port of a range)
+  __u8 protocol;
+  __u8 pad;
+};
+
+struct {
+  __uint(type, BPF_MAP_TYPE_LRU_HASH);
+  __uint(max_entries, 4096);
+  __type(key, struct pm_cgroup_key);
+  __type(value, struct pm_port_stats);
+} cgroup_stats_map SEC(".maps");
+
+// Per-connection key for tracking individual connections.
+// Addresses are stored as 128-bit values; IPv4 (and IPv4-mapped IPv6)
+// addresses are normalized to family AF_INET with the address in word 0.
 struct pm_conn_key {
-  __u32 saddr;
-  __u32 daddr;
+  __u32 saddr[4];
+  __u32 daddr[4];
   __u16 sport;
   __u16 dport;
+  __u16 family;
+  __u16 pad;
+  __u32 netns; // network namespace inode of the socket
 };
 
 // Per-connection statistics
@@ -60,165 +289,1178 @@ struct {
   __uint(max_entries, 10240);
   __type(key, struct pm_conn_key);
   __type(value, struct pm_conn_stats);
+  __uint(pinning, LIBBPF_PIN_BY_NAME);
 } conn_stats_map SEC(".maps");
 
+// Connection lifecycle event types
+#define PM_EVENT_OPEN 1
+#define PM_EVENT_CLOSE 2
+
+// Connection lifecycle event, emitted when a monitored connection is first
+// seen and when it closes (with its final byte counts).
+struct pm_conn_event {
+  __u32 saddr[4];
+  __u32 daddr[4];
+  __u64 start_ns;
+  __u64 end_ns;
+  __u64 rx_bytes;
+  __u64 tx_bytes;
+  __u16 sport;
+  __u16 dport;
+  __u16 family;
+  __u16 port; // monitored port the connection was attributed to
+  __u8 type;  // PM_EVENT_OPEN or PM_EVENT_CLOSE
+  __u8 pad[7];
+};
+
+// Ring buffer carrying pm_conn_event records to userspace
+struct {
+  __uint(type, BPF_MAP_TYPE_RINGBUF);
+  __uint(max_entries, 256 * 1024);
+} conn_events SEC(".maps");
+
+// Arguments of an in-flight udp_sendmsg or udp_recvmsg call. On receive
+// the kernel fills in the sender address in msg->msg_name before
+// returning.
+struct pm_udp_call {
+  __u64 sk;
+  __u64 msg;
+};
+
+// Stashed at udp_recvmsg entry, keyed by pid_tgid, so the return probe can
+// attribute the received byte count
+struct {
+  __uint(type, BPF_MAP_TYPE_HASH);
+  __uint(max_entries, 10240);
+  __type(key, __u64);
+  __type(value, struct pm_udp_call);
+} udp_recv_socks SEC(".maps");
+
+// Stashed at udp_sendmsg/udpv6_sendmsg entry, keyed by pid_tgid, so the
+// return probe counts only the bytes of sends that succeeded
+struct {
+  __uint(type, BPF_MAP_TYPE_HASH);
+  __uint(max_entries, 10240);
+  __type(key, __u64);
+  __type(value, struct pm_udp_call);
+} udp_send_socks SEC(".maps");
+
+// Stashed at tcp_sendmsg/tcp_sendpage entry, keyed by pid_tgid, so the
+// kprobe return probe can count the bytes the call actually accepted
+struct {
+  __uint(type, BPF_MAP_TYPE_HASH);
+  __uint(max_entries, 10240);
+  __type(key, __u64);
+  __type(value, __u64);
+} tcp_send_socks SEC(".maps");
+
+// Failed inserts per map, indexed by PM_MAP_*. When a hash map is full
+// bpf_map_update_elem fails and the traffic would otherwise be lost
+// silently; ring buffer drops are counted the same way.
+#define PM_MAP_PORT_STATS 0
+#define PM_MAP_MEMBER_STATS 1
+#define PM_MAP_FILTERED_STATS 2
+#define PM_MAP_WIRE_STATS 3
+#define PM_MAP_PROC_STATS 4
+#define PM_MAP_CGROUP_STATS 5
+#define PM_MAP_CONN_STATS 6
+#define PM_MAP_CONN_EVENTS 7
+#define PM_MAP_ADDR_STATS 8
+#define PM_MAP_COUNT 9
+
+struct {
+  __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+  __uint(max_entries, PM_MAP_COUNT);
+  __type(key, __u32);
+  __type(value, __u64);
+} map_errors SEC(".maps");
+
 // ============================================================================
 // Helper Functions
 // ============================================================================
 
+// Index of the protocol's first word in target_ports / port_targets
+static __always_inline __u32 proto_index(__u8 protocol) {
+  return protocol == IPPROTO_UDP ? 1 : 0;
+}
+
 // Check if a port is in our target list
-static __always_inline int is_target_port(__u16 port) {
-  __u8 *enabled = bpf_map_lookup_elem(&target_ports, &port);
-  return enabled != NULL && *enabled;
+static __always_inline int is_target_port(__u16 port, __u8 protocol) {
+  __u32 idx = proto_index(protocol) * PM_PORT_WORDS + (port >> 6);
+  __u64 *word = bpf_map_lookup_elem(&target_ports, &idx);
+  return word != NULL && (*word & (1ULL << (port & 63)));
+}
+
+// Network namespace inode of a socket
+static __always_inline __u32 sock_netns(struct sock *sk) {
+  __u32 inum = 0;
+  BPF_CORE_READ_INTO(&inum, sk, __sk_common.skc_net.net, ns.inum);
+  return inum;
+}
+
+// A matched target: the port that saw traffic, the service it counts
+// towards, the namespace the service is scoped to (0 for any) and the role
+// the port played on the connection
+struct pm_match {
+  __u32 netns;
+  __u16 port;
+  __u16 service;
+  __u8 range;
+  __u8 role;
+};
+
+// Resolve the service for a port seen in a network namespace in the given
+// role. Targets scoped to that namespace win over unscoped ones. Returns 0
+// if the port is not monitored there or not for that role.
+static __always_inline int match_target(__u16 port, __u8 protocol,
+                                        __u32 netns, __u8 role,
+                                        struct pm_match *m) {
+  if (!is_target_port(port, protocol)) {
+    return 0;
+  }
+
+  struct pm_port_key nk = {.netns = netns, .port = port, .protocol = protocol};
+  struct pm_port_target *t = bpf_map_lookup_elem(&netns_targets, &nk);
+  if (t) {
+    m->netns = netns;
+  } else {
+    __u32 idx = proto_index(protocol) * 65536 + port;
+    t = bpf_map_lookup_elem(&port_targets, &idx);
+    m->netns = 0;
+  }
+
+  // An empty entry means the port is only monitored in other namespaces
+  if (!t || !t->service || !(t->roles & role)) {
+    return 0;
+  }
+
+  m->port = port;
+  m->service = t->service;
+  m->range = t->range;
+  m->role = role;
+  return 1;
+}
+
+// Check a local address against the local addresses the matched service
+// is pinned to. Returns 1 if its traffic counts; services that aren't
+// pinned count every address. An unknown (unspecified) local address never
+// matches a pin.
+static __always_inline int local_addr_allowed(struct pm_match *m,
+                                              __u8 protocol, __u16 family,
+                                              __u32 *saddr) {
+  struct pm_addr_key ak = {
+      .netns = m->netns,
+      .port = m->service,
+      .protocol = protocol,
+  };
+  if (!bpf_map_lookup_elem(&local_addrs, &ak)) {
+    return 1;
+  }
+
+  ak.family = family;
+  __builtin_memcpy(ak.addr, saddr, sizeof(ak.addr));
+  return bpf_map_lookup_elem(&local_addrs, &ak) != NULL;
+}
+
+// Match a connection against the target list, preferring the local port.
+// A local match is server-side traffic, a remote match client-side.
+static __always_inline int match_conn(struct pm_conn_key *ck, __u8 protocol,
+                                      struct pm_match *m) {
+  if (!match_target(ck->sport, protocol, ck->netns, PM_ROLE_SERVER, m) &&
+      !match_target(ck->dport, protocol, ck->netns, PM_ROLE_CLIENT, m)) {
+    return 0;
+  }
+  return local_addr_allowed(m, protocol, ck->family, ck->saddr);
 }
 
-// Get or create port stats entry
-static __always_inline struct pm_port_stats *get_port_stats(__u16 port) {
-  struct pm_port_stats *ps = bpf_map_lookup_elem(&port_stats_map, &port);
+// Count a failed insert into the map with index PM_MAP_*. The counters are
+// per-CPU but shared between process and softirq context, so atomic.
+static __always_inline void count_map_error(__u32 idx) {
+  __u64 *errors = bpf_map_lookup_elem(&map_errors, &idx);
+  if (errors) {
+    __sync_fetch_and_add(errors, 1);
+  }
+}
+
+// Get or create a stats entry in the given map. map_idx is the map's
+// PM_MAP_* index for counting failed inserts.
+static __always_inline struct pm_port_stats *
+lookup_or_init_stats(void *map, __u32 map_idx, void *key) {
+  struct pm_port_stats *ps = bpf_map_lookup_elem(map, key);
   if (!ps) {
     struct pm_port_stats zero = {};
-    bpf_map_update_elem(&port_stats_map, &port, &zero, BPF_NOEXIST);
-    ps = bpf_map_lookup_elem(&port_stats_map, &port);
+    bpf_map_update_elem(map, key, &zero, BPF_NOEXIST);
+    ps = bpf_map_lookup_elem(map, key);
+    if (!ps) {
+      count_map_error(map_idx);
+    }
   }
   return ps;
 }
 
-// ============================================================================
-// Kprobe: tcp_sendmsg - Track outgoing TCP data
-// ============================================================================
+// Get or create the stats entry of a matched service and role
+static __always_inline struct pm_port_stats *get_port_stats(struct pm_match *m,
+                                                            __u8 protocol) {
+  struct pm_port_key pk = {
+      .netns = m->netns,
+      .port = m->service,
+      .protocol = protocol,
+      .role = m->role,
+  };
+  return lookup_or_init_stats(&port_stats_map, PM_MAP_PORT_STATS, &pk);
+}
 
-SEC("kprobe/tcp_sendmsg")
-int BPF_KPROBE(trace_tcp_sendmsg, struct sock *sk, struct msghdr *msg,
-               __u64 size) {
-  if (!sk || size == 0) {
+// Get or create the stats entry of a matched range member port
+static __always_inline struct pm_port_stats *
+get_member_stats(struct pm_match *m, __u8 protocol) {
+  struct pm_port_key pk = {
+      .netns = m->netns,
+      .port = m->port,
+      .protocol = protocol,
+  };
+  return lookup_or_init_stats(&member_stats_map, PM_MAP_MEMBER_STATS, &pk);
+}
+
+// Get or create the stats entry of a local address under a matched service
+static __always_inline struct pm_port_stats *
+get_addr_stats(struct pm_match *m, __u8 protocol, __u16 family, __u32 *saddr) {
+  struct pm_addr_key ak = {
+      .netns = m->netns,
+      .port = m->service,
+      .protocol = protocol,
+      .family = family,
+  };
+  __builtin_memcpy(ak.addr, saddr, sizeof(ak.addr));
+  return lookup_or_init_stats(&addr_stats_map, PM_MAP_ADDR_STATS, &ak);
+}
+
+// Add one send/receive operation's bytes to a stats entry
+static __always_inline void add_bytes(struct pm_port_stats *ps, __u64 bytes,
+                                      int is_tx) {
+  if (!ps) {
+    return;
+  }
+  if (is_tx) {
+    __sync_fetch_and_add(&ps->tx_bytes, bytes);
+    __sync_fetch_and_add(&ps->tx_ops, 1);
+  } else {
+    __sync_fetch_and_add(&ps->rx_bytes, bytes);
+    __sync_fetch_and_add(&ps->rx_ops, 1);
+  }
+}
+
+// Add packets to a stats entry
+static __always_inline void add_packets(struct pm_port_stats *ps, __u64 segs,
+                                        int is_tx) {
+  if (!ps) {
+    return;
+  }
+  if (is_tx) {
+    __sync_fetch_and_add(&ps->tx_packets, segs);
+  } else {
+    __sync_fetch_and_add(&ps->rx_packets, segs);
+  }
+}
+
+// Account bytes to the current process under a matched service
+static __always_inline void count_process(struct pm_match *m, __u8 protocol,
+                                          __u64 bytes, int is_tx) {
+  __u64 pid_tgid = bpf_get_current_pid_tgid();
+  struct pm_proc_key pk = {
+      .netns = m->netns,
+      .port = m->service,
+      .protocol = protocol,
+      .tgid = pid_tgid >> 32,
+  };
+
+  struct pm_proc_stats *ps = bpf_map_lookup_elem(&proc_stats_map, &pk);
+  if (!ps) {
+    struct pm_proc_stats init = {};
+    bpf_get_current_comm(&init.comm, sizeof(init.comm));
+    bpf_map_update_elem(&proc_stats_map, &pk, &init, BPF_NOEXIST);
+    ps = bpf_map_lookup_elem(&proc_stats_map, &pk);
+    if (!ps) {
+      count_map_error(PM_MAP_PROC_STATS);
+      return;
+    }
+  }
+
+  ps->pid = (__u32)pid_tgid;
+  if (is_tx) {
+    __sync_fetch_and_add(&ps->tx_bytes, bytes);
+    __sync_fetch_and_add(&ps->tx_ops, 1);
+  } else {
+    __sync_fetch_and_add(&ps->rx_bytes, bytes);
+    __sync_fetch_and_add(&ps->rx_ops, 1);
+  }
+}
+
+// Get or create the stats entry of the current cgroup under a service
+static __always_inline struct pm_port_stats *
+get_cgroup_stats(struct pm_match *m, __u8 protocol) {
+  struct pm_cgroup_key ck = {
+      .cgroup_id = bpf_get_current_cgroup_id(),
+      .netns = m->netns,
+      .port = m->service,
+      .protocol = protocol,
+  };
+
+  struct pm_port_stats *ps = bpf_map_lookup_elem(&cgroup_stats_map, &ck);
+  if (!ps) {
+    struct pm_port_stats zero = {};
+    bpf_map_update_elem(&cgroup_stats_map, &ck, &zero, BPF_NOEXIST);
+    ps = bpf_map_lookup_elem(&cgroup_stats_map, &ck);
+    if (!ps) {
+      count_map_error(PM_MAP_CGROUP_STATS);
+    }
+  }
+  return ps;
+}
+
+// Check a connection's remote address against the matched service's CIDR
+// filters. Returns 1 if the traffic is excluded. Traffic with an unknown
+// remote address is never filtered.
+static __always_inline int is_filtered(struct pm_match *m, __u8 protocol,
+                                       struct pm_conn_key *ck) {
+  if (ck->family != AF_INET && ck->family != AF_INET6) {
     return 0;
   }
 
-  // Read socket addresses and ports
-  __u16 sport = 0, dport = 0;
-  __u32 saddr = 0, daddr = 0;
+  struct pm_filter_key fk = {
+      .prefixlen = 64 + (ck->family == AF_INET6 ? 128 : 32),
+      .netns = m->netns,
+      .service = m->service,
+      .protocol = protocol,
+      .family = ck->family,
+  };
+  __builtin_memcpy(fk.addr, ck->daddr, sizeof(fk.addr));
 
-  BPF_CORE_READ_INTO(&sport, sk, __sk_common.skc_num);
-  BPF_CORE_READ_INTO(&dport, sk, __sk_common.skc_dport);
-  BPF_CORE_READ_INTO(&saddr, sk, __sk_common.skc_rcv_saddr);
-  BPF_CORE_READ_INTO(&daddr, sk, __sk_common.skc_daddr);
+  __u8 *action = bpf_map_lookup_elem(&cidr_filters, &fk);
+  return action && *action == PM_FILTER_EXCLUDE;
+}
 
-  // Convert dport from network byte order
-  dport = bpf_ntohs(dport);
+// Account bytes excluded by a service's filters
+static __always_inline void count_filtered(struct pm_match *m, __u8 protocol,
+                                           __u64 bytes, int is_tx) {
+  struct pm_port_key pk = {
+      .netns = m->netns,
+      .port = m->service,
+      .protocol = protocol,
+  };
+  add_bytes(
+      lookup_or_init_stats(&filtered_stats_map, PM_MAP_FILTERED_STATS, &pk),
+      bytes, is_tx);
+}
 
-  // Check if either port is monitored
-  __u16 target = 0;
-  if (is_target_port(sport)) {
-    target = sport;
-  } else if (is_target_port(dport)) {
-    target = dport;
+// Account bytes to a matched service and, for range members, to the
+// member port as well
+static __always_inline void count_bytes(struct pm_match *m, __u8 protocol,
+                                        __u64 bytes, int is_tx) {
+  add_bytes(get_port_stats(m, protocol), bytes, is_tx);
+  if (m->range) {
+    add_bytes(get_member_stats(m, protocol), bytes, is_tx);
+  }
+  add_bytes(get_cgroup_stats(m, protocol), bytes, is_tx);
+  count_process(m, protocol, bytes, is_tx);
+}
+
+// Account packets to a matched service, its range member and local
+// address. Unlike bytes, packets aren't attributed to processes or cgroups.
+static __always_inline void count_packets(struct pm_match *m, __u8 protocol,
+                                          __u16 family, __u32 *saddr,
+                                          __u64 segs, int is_tx) {
+  add_packets(get_port_stats(m, protocol), segs, is_tx);
+  if (m->range) {
+    add_packets(get_member_stats(m, protocol), segs, is_tx);
+  }
+  add_packets(get_addr_stats(m, protocol, family, saddr), segs, is_tx);
+}
+
+// Index of the highest set bit, i.e. floor(log2(v)) for v > 0
+static __always_inline __u32 log2_u32(__u32 v) {
+  __u32 r = 0, shift;
+  shift = (v > 0xffff) << 4;
+  v >>= shift;
+  r |= shift;
+  shift = (v > 0xff) << 3;
+  v >>= shift;
+  r |= shift;
+  shift = (v > 0xf) << 2;
+  v >>= shift;
+  r |= shift;
+  shift = (v > 0x3) << 1;
+  v >>= shift;
+  r |= shift;
+  r |= (v >> 1);
+  return r;
+}
+
+// Sample a TCP socket's smoothed RTT into the matched service's histogram
+static __always_inline void record_rtt(struct pm_match *m, struct sock *sk) {
+  struct tcp_sock *tp = (struct tcp_sock *)sk;
+  __u32 srtt = BPF_CORE_READ(tp, srtt_us) >> 3; // stored as 8 * srtt
+  if (srtt == 0) {
+    return;
+  }
+
+  __u32 bucket = log2_u32(srtt);
+  if (bucket >= PM_RTT_BUCKETS) {
+    bucket = PM_RTT_BUCKETS - 1;
+  }
+
+  struct pm_port_stats *ps = get_port_stats(m, IPPROTO_TCP);
+  if (ps) {
+    __sync_fetch_and_add(&ps->rtt_hist[bucket], 1);
+  }
+}
+
+// Record the size of a TCP send or receive in the matched service's
+// histogram
+static __always_inline void record_size(struct pm_match *m, __u64 bytes,
+                                        int is_tx) {
+  __u32 bucket = PM_SIZE_BUCKETS - 1;
+  if (bytes <= 0xffffffff) {
+    bucket = log2_u32((__u32)bytes);
+  }
+  if (bucket >= PM_SIZE_BUCKETS) {
+    bucket = PM_SIZE_BUCKETS - 1;
+  }
+
+  struct pm_port_stats *ps = get_port_stats(m, IPPROTO_TCP);
+  if (!ps) {
+    return;
+  }
+  if (is_tx) {
+    __sync_fetch_and_add(&ps->tx_size_hist[bucket], 1);
   } else {
-    return 0; // Not a monitored port
+    __sync_fetch_and_add(&ps->rx_size_hist[bucket], 1);
   }
+}
 
-  // Update port-level statistics (TX - sending data)
-  struct pm_port_stats *ps = get_port_stats(target);
+// Count a new connection against a matched service and member port
+static __always_inline void count_connection(struct pm_match *m,
+                                             __u8 protocol) {
+  struct pm_port_stats *ps = get_port_stats(m, protocol);
   if (ps) {
-    __sync_fetch_and_add(&ps->tx_bytes, size);
-    __sync_fetch_and_add(&ps->tx_packets, 1);
+    __sync_fetch_and_add(&ps->connections, 1);
+  }
+  if (m->range) {
+    ps = get_member_stats(m, protocol);
+    if (ps) {
+      __sync_fetch_and_add(&ps->connections, 1);
+    }
+  }
+  ps = get_cgroup_stats(m, protocol);
+  if (ps) {
+    __sync_fetch_and_add(&ps->connections, 1);
+  }
+}
+
+// Set the family of a key holding IPv6 addresses, normalizing IPv4-mapped
+// addresses (::ffff:a.b.c.d) to plain IPv4 so a dual-stack socket and an
+// IPv4 socket produce the same key.
+static __always_inline void normalize_mapped(struct pm_conn_key *ck) {
+  if (ck->saddr[0] == 0 && ck->saddr[1] == 0 &&
+      ck->saddr[2] == bpf_htonl(0x0000ffff)) {
+    ck->family = AF_INET;
+    ck->saddr[0] = ck->saddr[3];
+    ck->saddr[2] = 0;
+    ck->saddr[3] = 0;
+    ck->daddr[0] = ck->daddr[3];
+    ck->daddr[1] = 0;
+    ck->daddr[2] = 0;
+    ck->daddr[3] = 0;
+  } else {
+    ck->family = AF_INET6;
   }
+}
 
-  // Update per-connection statistics
-  struct pm_conn_key ck = {
-      .saddr = saddr,
-      .daddr = daddr,
-      .sport = sport,
-      .dport = dport,
-  };
+// Fill a connection key from a socket, handling both IPv4 and IPv6.
+// dport is converted to host byte order.
+static __always_inline void read_conn_key(struct sock *sk,
+                                          struct pm_conn_key *ck) {
+  __u16 family = 0;
+  BPF_CORE_READ_INTO(&family, sk, __sk_common.skc_family);
+  BPF_CORE_READ_INTO(&ck->sport, sk, __sk_common.skc_num);
+  BPF_CORE_READ_INTO(&ck->dport, sk, __sk_common.skc_dport);
+  ck->dport = bpf_ntohs(ck->dport);
+  ck->netns = sock_netns(sk);
+
+  if (family == AF_INET6) {
+    BPF_CORE_READ_INTO(&ck->saddr, sk,
+                       __sk_common.skc_v6_rcv_saddr.in6_u.u6_addr32);
+    BPF_CORE_READ_INTO(&ck->daddr, sk,
+                       __sk_common.skc_v6_daddr.in6_u.u6_addr32);
+
+    normalize_mapped(ck);
+    return;
+  }
+
+  ck->family = AF_INET;
+  BPF_CORE_READ_INTO(&ck->saddr[0], sk, __sk_common.skc_rcv_saddr);
+  BPF_CORE_READ_INTO(&ck->daddr[0], sk, __sk_common.skc_daddr);
+}
+
+// Emit a connection lifecycle event. Events are dropped if the ring buffer
+// is full; counters in the maps remain authoritative.
+static __always_inline void emit_conn_event(struct pm_conn_key *ck,
+                                            struct pm_conn_stats *cs,
+                                            __u16 port, __u8 type) {
+  struct pm_conn_event *ev =
+      bpf_ringbuf_reserve(&conn_events, sizeof(*ev), 0);
+  if (!ev) {
+    count_map_error(PM_MAP_CONN_EVENTS);
+    return;
+  }
+
+  __builtin_memcpy(ev->saddr, ck->saddr, sizeof(ev->saddr));
+  __builtin_memcpy(ev->daddr, ck->daddr, sizeof(ev->daddr));
+  ev->sport = ck->sport;
+  ev->dport = ck->dport;
+  ev->family = ck->family;
+  ev->port = port;
+  ev->type = type;
+  ev->start_ns = cs->start_ns;
+  ev->end_ns = type == PM_EVENT_CLOSE ? bpf_ktime_get_ns() : 0;
+  ev->rx_bytes = cs->rx_bytes;
+  ev->tx_bytes = cs->tx_bytes;
+  __builtin_memset(ev->pad, 0, sizeof(ev->pad));
+
+  bpf_ringbuf_submit(ev, 0);
+}
+
+// ============================================================================
+// TCP data path: tcp_sendmsg / tcp_sendpage / tcp_cleanup_rbuf
+//
+// Each hook has fentry/fexit, kprobe and tracepoint variants sharing the
+// same accounting; userspace attaches the best one the kernel supports.
+// Sends are counted on return, from the bytes the call accepted: a short
+// write or EAGAIN on a non-blocking socket counts less than was requested,
+// or nothing. MSG_ZEROCOPY sends, and sendfile/splice since Linux 6.5, go
+// through tcp_sendmsg; older kernels send file pages with tcp_sendpage.
+// tcp_cleanup_rbuf is more accurate than tcp_recvmsg for received data.
+// ============================================================================
+
+// Account TCP data sent (is_tx) or received on a socket
+static __always_inline void count_tcp_data(struct sock *sk, __u64 bytes,
+                                           int is_tx) {
+  // Read socket addresses and ports
+  struct pm_conn_key ck = {};
+  read_conn_key(sk, &ck);
+
+  // Check if either port is monitored
+  struct pm_match m = {};
+  if (!match_conn(&ck, IPPROTO_TCP, &m)) {
+    return; // Not a monitored port
+  }
+
+  // Excluded peers only show up in the filtered totals
+  if (is_filtered(&m, IPPROTO_TCP, &ck)) {
+    count_filtered(&m, IPPROTO_TCP, bytes, is_tx);
+    return;
+  }
+
+  // Update port-level statistics
+  count_bytes(&m, IPPROTO_TCP, bytes, is_tx);
+  add_bytes(get_addr_stats(&m, IPPROTO_TCP, ck.family, ck.saddr), bytes,
+            is_tx);
+  record_rtt(&m, sk);
+  record_size(&m, bytes, is_tx);
 
+  // Update per-connection statistics
   __u64 now = bpf_ktime_get_ns();
   struct pm_conn_stats *cs = bpf_map_lookup_elem(&conn_stats_map, &ck);
   if (cs) {
-    __sync_fetch_and_add(&cs->tx_bytes, size);
+    if (is_tx) {
+      __sync_fetch_and_add(&cs->tx_bytes, bytes);
+    } else {
+      __sync_fetch_and_add(&cs->rx_bytes, bytes);
+    }
     cs->last_update_ns = now;
   } else {
     struct pm_conn_stats new_cs = {
-        .tx_bytes = size,
         .start_ns = now,
         .last_update_ns = now,
     };
-    bpf_map_update_elem(&conn_stats_map, &ck, &new_cs, BPF_ANY);
-
-    // Increment connection count for the target port
-    if (ps) {
-      __sync_fetch_and_add(&ps->connections, 1);
+    if (is_tx) {
+      new_cs.tx_bytes = bytes;
+    } else {
+      new_cs.rx_bytes = bytes;
+    }
+    if (bpf_map_update_elem(&conn_stats_map, &ck, &new_cs, BPF_ANY)) {
+      // Table full: the connection is untracked, its bytes still count
+      count_map_error(PM_MAP_CONN_STATS);
+      return;
     }
+    emit_conn_event(&ck, &new_cs, m.port, PM_EVENT_OPEN);
+  }
+}
+
+SEC("fexit/tcp_sendmsg")
+int BPF_PROG(fexit_tcp_sendmsg, struct sock *sk, struct msghdr *msg,
+             size_t size, int ret) {
+  if (sk && ret > 0) {
+    count_tcp_data(sk, (__u64)ret, 1);
   }
+  return 0;
+}
 
+SEC("fexit/tcp_sendpage")
+int BPF_PROG(fexit_tcp_sendpage, struct sock *sk, struct page *page,
+             int offset, size_t size, int flags, int ret) {
+  if (sk && ret > 0) {
+    count_tcp_data(sk, (__u64)ret, 1);
+  }
   return 0;
 }
 
-// ============================================================================
-// Kprobe: tcp_cleanup_rbuf - Track incoming TCP data (more accurate than
-// tcp_recvmsg)
-// ============================================================================
+SEC("fentry/tcp_cleanup_rbuf")
+int BPF_PROG(fentry_tcp_cleanup_rbuf, struct sock *sk, int copied) {
+  if (sk && copied > 0) {
+    count_tcp_data(sk, (__u64)copied, 0);
+  }
+  return 0;
+}
+
+// Shared entry probe for tcp_sendmsg and tcp_sendpage. Neither calls the
+// other, so a thread is in at most one of them.
+SEC("kprobe/tcp_sendmsg")
+int BPF_KPROBE(trace_tcp_sendmsg, struct sock *sk) {
+  if (!sk) {
+    return 0;
+  }
+
+  __u64 id = bpf_get_current_pid_tgid();
+  __u64 skp = (__u64)sk;
+  bpf_map_update_elem(&tcp_send_socks, &id, &skp, BPF_ANY);
+  return 0;
+}
+
+// Shared return probe for tcp_sendmsg and tcp_sendpage. The return value
+// is the number of bytes queued for sending.
+SEC("kretprobe/tcp_sendmsg")
+int BPF_KRETPROBE(trace_tcp_sendmsg_ret, int ret) {
+  __u64 id = bpf_get_current_pid_tgid();
+  __u64 *skp = bpf_map_lookup_elem(&tcp_send_socks, &id);
+  if (!skp) {
+    return 0;
+  }
+
+  struct sock *sk = (struct sock *)*skp;
+  bpf_map_delete_elem(&tcp_send_socks, &id);
+
+  if (ret > 0) {
+    count_tcp_data(sk, (__u64)ret, 1);
+  }
+  return 0;
+}
 
 SEC("kprobe/tcp_cleanup_rbuf")
 int BPF_KPROBE(trace_tcp_cleanup_rbuf, struct sock *sk, int copied) {
-  if (!sk || copied <= 0) {
+  if (sk && copied > 0) {
+    count_tcp_data(sk, (__u64)copied, 0);
+  }
+  return 0;
+}
+
+// Layout of the sock/sock_send_length and sock/sock_recv_length tracepoints
+// (Linux 6.3+), used when neither fentry nor kprobes can attach. They fire
+// for every protocol from sock_sendmsg/sock_recvmsg.
+struct pm_sock_msg_length_ctx {
+  __u64 common;
+  const void *skaddr;
+  __u16 family;
+  __u16 protocol;
+  int ret;
+  int flags;
+};
+
+#define PM_MSG_PEEK 2
+
+SEC("tracepoint/sock/sock_send_length")
+int trace_sock_send_length(struct pm_sock_msg_length_ctx *ctx) {
+  if (ctx->protocol != IPPROTO_TCP || !ctx->skaddr || ctx->ret <= 0) {
     return 0;
   }
+  count_tcp_data((struct sock *)ctx->skaddr, (__u64)ctx->ret, 1);
+  return 0;
+}
 
-  // Read socket addresses and ports
-  __u16 sport = 0, dport = 0;
-  __u32 saddr = 0, daddr = 0;
+SEC("tracepoint/sock/sock_recv_length")
+int trace_sock_recv_length(struct pm_sock_msg_length_ctx *ctx) {
+  if (ctx->protocol != IPPROTO_TCP || !ctx->skaddr || ctx->ret <= 0 ||
+      (ctx->flags & PM_MSG_PEEK)) {
+    return 0;
+  }
+  count_tcp_data((struct sock *)ctx->skaddr, (__u64)ctx->ret, 0);
+  return 0;
+}
 
-  BPF_CORE_READ_INTO(&sport, sk, __sk_common.skc_num);
-  BPF_CORE_READ_INTO(&dport, sk, __sk_common.skc_dport);
-  BPF_CORE_READ_INTO(&saddr, sk, __sk_common.skc_rcv_saddr);
-  BPF_CORE_READ_INTO(&daddr, sk, __sk_common.skc_daddr);
+// ============================================================================
+// TCP segments: __tcp_transmit_skb / tcp_rcv_established
+//
+// Packet counts come from the skbs rather than the send and receive calls
+// above. A TSO/GSO skb on transmit, or a GRO-merged one on receive, stands
+// for several segments on the wire, which are counted individually. Every
+// segment of an established connection counts, pure ACKs and
+// retransmissions included.
+// ============================================================================
 
-  // Convert dport from network byte order
-  dport = bpf_ntohs(dport);
+static __always_inline void count_tcp_segments(struct sock *sk, __u64 segs,
+                                               int is_tx) {
+  struct pm_conn_key ck = {};
+  read_conn_key(sk, &ck);
 
-  // Check if either port is monitored
-  __u16 target = 0;
-  if (is_target_port(sport)) {
-    target = sport;
-  } else if (is_target_port(dport)) {
-    target = dport;
+  struct pm_match m = {};
+  if (!match_conn(&ck, IPPROTO_TCP, &m) || is_filtered(&m, IPPROTO_TCP, &ck)) {
+    return;
+  }
+  count_packets(&m, IPPROTO_TCP, ck.family, ck.saddr, segs, is_tx);
+}
+
+SEC("kprobe/__tcp_transmit_skb")
+int BPF_KPROBE(trace_tcp_transmit_skb, struct sock *sk, struct sk_buff *skb) {
+  if (!sk || !skb) {
+    return 0;
+  }
+
+  // tcp_skb_pcount(): the segment count is kept in the control block
+  struct tcp_skb_cb *tcb = (struct tcp_skb_cb *)&skb->cb;
+  __u16 segs = BPF_CORE_READ(tcb, tcp_gso_segs);
+  count_tcp_segments(sk, segs ? segs : 1, 1);
+  return 0;
+}
+
+SEC("kprobe/tcp_rcv_established")
+int BPF_KPROBE(trace_tcp_rcv_established, struct sock *sk,
+               struct sk_buff *skb) {
+  if (!sk || !skb) {
+    return 0;
+  }
+
+  // skb_shinfo(): on 64-bit kernels skb->end is an offset from skb->head
+  unsigned char *head = BPF_CORE_READ(skb, head);
+  __u32 end = BPF_CORE_READ(skb, end);
+  struct skb_shared_info *shinfo = (struct skb_shared_info *)(head + end);
+  __u16 segs = BPF_CORE_READ(shinfo, gso_segs);
+  count_tcp_segments(sk, segs ? segs : 1, 0);
+  return 0;
+}
+
+// ============================================================================
+// Connection establishment: inet_csk_accept / inet_sock_set_state
+//
+// Connections are counted when they are established rather than on their
+// first byte, so idle connections are included and reused tuples are not
+// counted twice. Initiated and failed handshakes are both counted from
+// inet_sock_set_state, so every connect that sent a SYN is counted exactly
+// once: initiated if it reached ESTABLISHED, failed if it closed first.
+// ============================================================================
+
+#define PM_CONN_ACCEPTED 1
+#define PM_CONN_INITIATED 2
+#define PM_CONN_FAILED 3
+
+// Count an accepted, initiated or failed connection on a monitored port
+static __always_inline void count_handshake(struct sock *sk, int event) {
+  struct pm_conn_key ck = {};
+  read_conn_key(sk, &ck);
+
+  struct pm_match m = {};
+  if (!match_conn(&ck, IPPROTO_TCP, &m) || is_filtered(&m, IPPROTO_TCP, &ck)) {
+    return;
+  }
+
+  struct pm_port_stats *ps = get_port_stats(&m, IPPROTO_TCP);
+  if (!ps) {
+    return;
+  }
+  if (event == PM_CONN_FAILED) {
+    __sync_fetch_and_add(&ps->failures, 1);
+    return;
+  }
+  if (event == PM_CONN_ACCEPTED) {
+    __sync_fetch_and_add(&ps->accepts, 1);
   } else {
-    return 0; // Not a monitored port
+    __sync_fetch_and_add(&ps->connects, 1);
   }
+  count_connection(&m, IPPROTO_TCP);
 
-  // Update port-level statistics (RX - receiving data)
-  struct pm_port_stats *ps = get_port_stats(target);
+  ps = get_addr_stats(&m, IPPROTO_TCP, ck.family, ck.saddr);
   if (ps) {
-    __sync_fetch_and_add(&ps->rx_bytes, (__u64)copied);
-    __sync_fetch_and_add(&ps->rx_packets, 1);
+    __sync_fetch_and_add(&ps->connections, 1);
   }
+}
 
-  // Update per-connection statistics
-  struct pm_conn_key ck = {
-      .saddr = saddr,
-      .daddr = daddr,
-      .sport = sport,
-      .dport = dport,
-  };
+// The returned socket is fully established
+SEC("kretprobe/inet_csk_accept")
+int BPF_KRETPROBE(trace_inet_csk_accept_ret, struct sock *newsk) {
+  if (newsk) {
+    count_handshake(newsk, PM_CONN_ACCEPTED);
+  }
+  return 0;
+}
 
-  __u64 now = bpf_ktime_get_ns();
+// ============================================================================
+// Tracepoint: sock/inet_sock_set_state - Count handshakes and evict closed
+// connections
+// ============================================================================
+
+SEC("tracepoint/sock/inet_sock_set_state")
+int trace_inet_sock_set_state(
+    struct trace_event_raw_inet_sock_set_state *ctx) {
+  if (ctx->protocol != IPPROTO_TCP) {
+    return 0;
+  }
+
+  struct sock *sk = (struct sock *)ctx->skaddr;
+  if (!sk) {
+    return 0;
+  }
+
+  // A connect whose handshake completed
+  if (ctx->oldstate == TCP_SYN_SENT && ctx->newstate == TCP_ESTABLISHED) {
+    count_handshake(sk, PM_CONN_INITIATED);
+    return 0;
+  }
+
+  if (ctx->newstate != TCP_CLOSE) {
+    return 0;
+  }
+
+  // A handshake that never completed: the SYN timed out or was refused,
+  // or the connect call failed after sending it
+  if (ctx->oldstate == TCP_SYN_SENT || ctx->oldstate == TCP_SYN_RECV) {
+    count_handshake(sk, PM_CONN_FAILED);
+  }
+
+  struct pm_conn_key ck = {};
+  read_conn_key(sk, &ck);
+
+  // Only connections we were tracking are present in the map
   struct pm_conn_stats *cs = bpf_map_lookup_elem(&conn_stats_map, &ck);
-  if (cs) {
-    __sync_fetch_and_add(&cs->rx_bytes, (__u64)copied);
-    cs->last_update_ns = now;
+  if (!cs) {
+    return 0;
+  }
+
+  // A connection whose port was removed while it was open is no longer
+  // reported, only forgotten
+  struct pm_match m = {};
+  if (match_conn(&ck, IPPROTO_TCP, &m)) {
+    emit_conn_event(&ck, cs, m.port, PM_EVENT_CLOSE);
+  }
+
+  bpf_map_delete_elem(&conn_stats_map, &ck);
+  return 0;
+}
+
+// ============================================================================
+// Tracepoints: tcp/tcp_retransmit_skb, tcp/tcp_send_reset,
+// tcp/tcp_receive_reset - TCP health counters
+// ============================================================================
+
+// Leading fields of the tcp tracepoint records used below. These are
+// stable across kernel versions, unlike the full record layouts.
+struct pm_tcp_sk_skb_ctx {
+  __u64 common;
+  const void *skbaddr;
+  const void *skaddr;
+};
+
+struct pm_tcp_sk_ctx {
+  __u64 common;
+  const void *skaddr;
+};
+
+#define PM_TCP_RETRANSMIT 1
+#define PM_TCP_RESET 2
+
+// Count a retransmit or reset on a monitored connection
+static __always_inline void count_tcp_event(struct sock *sk, int event) {
+  if (!sk) {
+    return; // Resets sent for packets without a socket
+  }
+
+  struct pm_conn_key ck = {};
+  read_conn_key(sk, &ck);
+
+  struct pm_match m = {};
+  if (!match_conn(&ck, IPPROTO_TCP, &m) || is_filtered(&m, IPPROTO_TCP, &ck)) {
+    return;
+  }
+
+  struct pm_port_stats *ps = get_port_stats(&m, IPPROTO_TCP);
+  if (!ps) {
+    return;
+  }
+  if (event == PM_TCP_RESET) {
+    __sync_fetch_and_add(&ps->resets, 1);
   } else {
-    struct pm_conn_stats new_cs = {
-        .rx_bytes = (__u64)copied,
-        .start_ns = now,
-        .last_update_ns = now,
-    };
-    bpf_map_update_elem(&conn_stats_map, &ck, &new_cs, BPF_ANY);
+    __sync_fetch_and_add(&ps->retransmits, 1);
+  }
+}
 
-    // Increment connection count for the target port
-    if (ps) {
-      __sync_fetch_and_add(&ps->connections, 1);
+SEC("tracepoint/tcp/tcp_retransmit_skb")
+int trace_tcp_retransmit_skb(struct pm_tcp_sk_skb_ctx *ctx) {
+  count_tcp_event((struct sock *)ctx->skaddr, PM_TCP_RETRANSMIT);
+  return 0;
+}
+
+SEC("tracepoint/tcp/tcp_send_reset")
+int trace_tcp_send_reset(struct pm_tcp_sk_skb_ctx *ctx) {
+  count_tcp_event((struct sock *)ctx->skaddr, PM_TCP_RESET);
+  return 0;
+}
+
+SEC("tracepoint/tcp/tcp_receive_reset")
+int trace_tcp_receive_reset(struct pm_tcp_sk_ctx *ctx) {
+  count_tcp_event((struct sock *)ctx->skaddr, PM_TCP_RESET);
+  return 0;
+}
+
+// ============================================================================
+// UDP: udp_sendmsg / udpv6_sendmsg / udp_recvmsg / udpv6_recvmsg
+//
+// UDP is connectionless, so only port-level statistics are kept. For
+// unconnected sockets the remote address and port come from msg_name.
+// ============================================================================
+
+// Fill the remote side of a connection key from msg->msg_name, if present
+static __always_inline void read_msg_name(struct msghdr *msg,
+                                          struct pm_conn_key *ck) {
+  void *name = NULL;
+  BPF_CORE_READ_INTO(&name, msg, msg_name);
+  if (!name) {
+    return;
+  }
+
+  __u16 family = 0;
+  bpf_probe_read_kernel(&family, sizeof(family), name);
+
+  // sin_port and sin6_port share the same offset
+  __u16 port = 0;
+  bpf_probe_read_kernel(&port, sizeof(port),
+                        name + offsetof(struct sockaddr_in, sin_port));
+  ck->dport = bpf_ntohs(port);
+
+  __builtin_memset(ck->daddr, 0, sizeof(ck->daddr));
+  if (family == AF_INET) {
+    ck->family = AF_INET;
+    bpf_probe_read_kernel(&ck->daddr[0], sizeof(ck->daddr[0]),
+                          name + offsetof(struct sockaddr_in, sin_addr));
+  } else if (family == AF_INET6) {
+    bpf_probe_read_kernel(&ck->daddr, sizeof(ck->daddr),
+                          name + offsetof(struct sockaddr_in6, sin6_addr));
+    if (ck->daddr[0] == 0 && ck->daddr[1] == 0 &&
+        ck->daddr[2] == bpf_htonl(0x0000ffff)) {
+      ck->family = AF_INET;
+      ck->daddr[0] = ck->daddr[3];
+      ck->daddr[2] = 0;
+      ck->daddr[3] = 0;
+    } else {
+      ck->family = AF_INET6;
     }
+  } else {
+    ck->family = 0; // Unknown remote address
+  }
+}
+
+// Account UDP traffic on a socket against its monitored port, if any.
+// msg supplies the remote address of unconnected sockets (NULL if unknown).
+static __always_inline void count_udp(struct sock *sk, struct msghdr *msg,
+                                      __u64 bytes, int is_tx) {
+  struct pm_conn_key ck = {};
+  read_conn_key(sk, &ck);
+  __u16 local_family = ck.family; // ck.family is the remote's from here on
+  if (ck.dport == 0) {
+    ck.family = 0;
+    if (msg) {
+      read_msg_name(msg, &ck);
+    }
+  }
+
+  struct pm_match m = {};
+  if (!match_target(ck.sport, IPPROTO_UDP, ck.netns, PM_ROLE_SERVER, &m) &&
+      !(ck.dport &&
+        match_target(ck.dport, IPPROTO_UDP, ck.netns, PM_ROLE_CLIENT, &m))) {
+    return;
+  }
+
+  // Sockets bound to the wildcard address have no local address here
+  if (!local_addr_allowed(&m, IPPROTO_UDP, local_family, ck.saddr)) {
+    return;
+  }
+
+  if (is_filtered(&m, IPPROTO_UDP, &ck)) {
+    count_filtered(&m, IPPROTO_UDP, bytes, is_tx);
+    return;
+  }
+
+  count_bytes(&m, IPPROTO_UDP, bytes, is_tx);
+  add_bytes(get_addr_stats(&m, IPPROTO_UDP, local_family, ck.saddr), bytes,
+            is_tx);
+  count_packets(&m, IPPROTO_UDP, local_family, ck.saddr, 1, is_tx);
+}
+
+// Stash the arguments of a send for trace_udp_sendmsg_ret
+static __always_inline void stash_udp_send(struct sock *sk,
+                                           struct msghdr *msg) {
+  __u64 id = bpf_get_current_pid_tgid();
+  struct pm_udp_call args = {.sk = (__u64)sk, .msg = (__u64)msg};
+  bpf_map_update_elem(&udp_send_socks, &id, &args, BPF_ANY);
+}
+
+SEC("kprobe/udp_sendmsg")
+int BPF_KPROBE(trace_udp_sendmsg, struct sock *sk, struct msghdr *msg) {
+  if (!sk) {
+    return 0;
+  }
+
+  // udpv6_sendmsg falls through to udp_sendmsg for IPv4-mapped
+  // destinations; those were already stashed by the IPv6 probe.
+  __u16 family = 0;
+  BPF_CORE_READ_INTO(&family, sk, __sk_common.skc_family);
+  if (family != AF_INET) {
+    return 0;
+  }
+
+  stash_udp_send(sk, msg);
+  return 0;
+}
+
+SEC("kprobe/udpv6_sendmsg")
+int BPF_KPROBE(trace_udpv6_sendmsg, struct sock *sk, struct msghdr *msg) {
+  if (!sk) {
+    return 0;
+  }
+
+  stash_udp_send(sk, msg);
+  return 0;
+}
+
+// Shared return probe for udp_sendmsg and udpv6_sendmsg. The return value
+// is the number of bytes sent; failed sends (EAGAIN, EMSGSIZE, a refused
+// connected socket) return an error and aren't counted. For IPv4-mapped
+// destinations the nested udp_sendmsg returns first and takes the stash,
+// so the send is still counted once.
+SEC("kretprobe/udp_sendmsg")
+int BPF_KRETPROBE(trace_udp_sendmsg_ret, int ret) {
+  __u64 id = bpf_get_current_pid_tgid();
+  struct pm_udp_call *args = bpf_map_lookup_elem(&udp_send_socks, &id);
+  if (!args) {
+    return 0;
+  }
+
+  struct sock *sk = (struct sock *)args->sk;
+  struct msghdr *msg = (struct msghdr *)args->msg;
+  bpf_map_delete_elem(&udp_send_socks, &id);
+
+  if (ret > 0) {
+    count_udp(sk, msg, (__u64)ret, 1);
+  }
+  return 0;
+}
+
+// Shared entry probe for udp_recvmsg and udpv6_recvmsg
+SEC("kprobe/udp_recvmsg")
+int BPF_KPROBE(trace_udp_recvmsg, struct sock *sk, struct msghdr *msg) {
+  if (!sk) {
+    return 0;
+  }
+
+  __u64 id = bpf_get_current_pid_tgid();
+  struct pm_udp_call args = {.sk = (__u64)sk, .msg = (__u64)msg};
+  bpf_map_update_elem(&udp_recv_socks, &id, &args, BPF_ANY);
+  return 0;
+}
+
+// Shared return probe for udp_recvmsg and udpv6_recvmsg. The return value
+// is the number of bytes copied to userspace.
+SEC("kretprobe/udp_recvmsg")
+int BPF_KRETPROBE(trace_udp_recvmsg_ret, int ret) {
+  __u64 id = bpf_get_current_pid_tgid();
+  struct pm_udp_call *args = bpf_map_lookup_elem(&udp_recv_socks, &id);
+  if (!args) {
+    return 0;
   }
 
+  struct sock *sk = (struct sock *)args->sk;
+  struct msghdr *msg = (struct msghdr *)args->msg;
+  bpf_map_delete_elem(&udp_recv_socks, &id);
+
+  if (ret > 0) {
+    count_udp(sk, msg, (__u64)ret, 0);
+  }
   return 0;
 }
+
+// ============================================================================
+// cgroup_skb: ingress / egress - Wire-level accounting
+//
+// Attached to the root cgroup in wire accounting mode. skb->len is the IP
+// packet length, so headers and retransmitted segments are counted as the
+// network sees them. The socket's network namespace is not available here,
+// so only targets that are not scoped to a namespace are matched.
+// ============================================================================
+
+static __always_inline void count_wire(struct __sk_buff *skb, int is_tx) {
+  struct bpf_sock *sk = skb->sk;
+  if (!sk) {
+    return;
+  }
+  sk = bpf_sk_fullsock(sk);
+  if (!sk) {
+    return;
+  }
+
+  __u8 protocol = sk->protocol;
+  if (protocol != IPPROTO_TCP && protocol != IPPROTO_UDP) {
+    return;
+  }
+
+  struct pm_conn_key ck = {};
+  ck.sport = sk->src_port;
+  ck.dport = bpf_ntohs((__u16)sk->dst_port);
+  if (sk->family == AF_INET6) {
+    ck.saddr[0] = sk->src_ip6[0];
+    ck.saddr[1] = sk->src_ip6[1];
+    ck.saddr[2] = sk->src_ip6[2];
+    ck.saddr[3] = sk->src_ip6[3];
+    ck.daddr[0] = sk->dst_ip6[0];
+    ck.daddr[1] = sk->dst_ip6[1];
+    ck.daddr[2] = sk->dst_ip6[2];
+    ck.daddr[3] = sk->dst_ip6[3];
+    normalize_mapped(&ck);
+  } else {
+    ck.family = AF_INET;
+    ck.saddr[0] = sk->src_ip4;
+    ck.daddr[0] = sk->dst_ip4;
+  }
+
+  struct pm_match m = {};
+  if (!match_conn(&ck, protocol, &m) || is_filtered(&m, protocol, &ck)) {
+    return;
+  }
+
+  struct pm_port_key pk = {
+      .port = m.service,
+      .protocol = protocol,
+      .role = m.role,
+  };
+  struct pm_port_stats *ps =
+      lookup_or_init_stats(&wire_stats_map, PM_MAP_WIRE_STATS, &pk);
+  add_bytes(ps, skb->len, is_tx);
+  add_packets(ps, skb->gso_segs ? skb->gso_segs : 1, is_tx);
+}
+
+SEC("cgroup_skb/ingress")
+int wire_ingress(struct __sk_buff *skb) {
+  count_wire(skb, 0);
+  return 1; // Always allow the packet
+}
+
+SEC("cgroup_skb/egress")
+int wire_egress(struct __sk_buff *skb) {
+  count_wire(skb, 1);
+  return 1;
+}
@@ -0,0 +1,196 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// DefaultCgroupRoot is where the cgroup v2 hierarchy is normally mounted.
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// cgroupRescanInterval limits how often an unknown cgroup id triggers a
+// rescan of the hierarchy.
+const cgroupRescanInterval = 5 * time.Second
+
+// CgroupResolver maps cgroup v2 ids, as returned by
+// bpf_get_current_cgroup_id(), to their paths. In cgroup v2 the id is the
+// inode number of the cgroup's directory.
+type CgroupResolver struct {
+	root string
+
+	mu       sync.Mutex
+	paths    map[uint64]string
+	lastScan time.Time
+}
+
+// CgroupV2Root returns the cgroup v2 hierarchy for the cgroup mount at
+// root. On hybrid cgroup v1/v2 hosts that is root/unified.
+func CgroupV2Root(root string) string {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); errors.Is(err, fs.ErrNotExist) {
+		if _, err := os.Stat(filepath.Join(root, "unified")); err == nil {
+			return filepath.Join(root, "unified")
+		}
+	}
+	return root
+}
+
+// NewCgroupResolver creates a resolver for the hierarchy mounted at root.
+// On hybrid cgroup v1/v2 hosts the v2 hierarchy under root/unified is used.
+func NewCgroupResolver(root string) *CgroupResolver {
+	return &CgroupResolver{
+		root:  CgroupV2Root(root),
+		paths: make(map[uint64]string),
+	}
+}
+
+// Resolve returns the path of a cgroup relative to the hierarchy root,
+// e.g. "/system.slice/nginx.service". Unknown ids resolve to "cgroup:<id>"
+// since the cgroup may already have been removed.
+func (r *CgroupResolver) Resolve(id uint64) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p, ok := r.paths[id]; ok {
+		return p
+	}
+
+	if time.Since(r.lastScan) >= cgroupRescanInterval {
+		r.lastScan = time.Now()
+		if err := r.scan(); err == nil {
+			if p, ok := r.paths[id]; ok {
+				return p
+			}
+		}
+	}
+
+	return fmt.Sprintf("cgroup:%d", id)
+}
+
+// scan walks the hierarchy and records the id of every cgroup. Ids of
+// removed cgroups are kept, since their traffic may still be reported.
+// Callers must hold r.mu.
+func (r *CgroupResolver) scan() error {
+	return filepath.WalkDir(r.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == r.root {
+				return err
+			}
+			return nil // Cgroup removed during the walk
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+
+		rel, err := filepath.Rel(r.root, p)
+		if err != nil {
+			return nil
+		}
+		if rel == "." {
+			rel = ""
+		}
+		r.paths[uint64(st.Ino)] = "/" + filepath.ToSlash(rel)
+		return nil
+	})
+}
+
+// containerScopes match the cgroup names container runtimes create, with
+// the runtime name each one maps to.
+var containerScopes = []struct {
+	re      *regexp.Regexp
+	runtime string
+}{
+	{regexp.MustCompile(`^docker-([0-9a-f]{12,})\.scope$`), "docker"},
+	{regexp.MustCompile(`^cri-containerd-([0-9a-f]{12,})\.scope$`), "containerd"},
+	{regexp.MustCompile(`^crio-([0-9a-f]{12,})\.scope$`), "cri-o"},
+	{regexp.MustCompile(`^libpod-([0-9a-f]{12,})\.scope$`), "podman"},
+	{regexp.MustCompile(`^([0-9a-f]{64})$`), ""}, // cgroupfs driver: /docker/<id>
+}
+
+// DescribeCgroup derives the systemd unit and container (as
+// "runtime://id", with the id shortened to 12 characters) from a cgroup
+// path. Either may be empty.
+func DescribeCgroup(cgroupPath string) (unit, container string) {
+	elems := strings.Split(strings.Trim(cgroupPath, "/"), "/")
+
+	for i := len(elems) - 1; i >= 0; i-- {
+		name := elems[i]
+
+		if container == "" {
+			for _, s := range containerScopes {
+				m := s.re.FindStringSubmatch(name)
+				if m == nil {
+					continue
+				}
+				runtime := s.runtime
+				if runtime == "" && i > 0 {
+					runtime = elems[i-1] // e.g. "docker" in /docker/<id>
+				}
+				if runtime == "" {
+					runtime = "container"
+				}
+				container = runtime + "://" + m[1][:12]
+				break
+			}
+		}
+
+		if unit == "" && (path.Ext(name) == ".service" || path.Ext(name) == ".scope") {
+			unit = name
+		}
+	}
+
+	return unit, container
+}
+
+// GetAllCgroupStats retrieves per-cgroup statistics for all monitored
+// services.
+func (l *Loader) GetAllCgroupStats() (map[CgroupKey]*types.CgroupStats, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return nil, errors.New("eBPF programs not loaded")
+	}
+
+	result := make(map[CgroupKey]*types.CgroupStats)
+
+	var key probePmCgroupKey
+	var stats probePmPortStats
+	iter := l.objs.CgroupStatsMap.Iterate()
+	for iter.Next(&key, &stats) {
+		port := l.portKey(probePmPortKey{Netns: key.Netns, Port: key.Port, Protocol: key.Protocol})
+		result[CgroupKey{Port: port, ID: key.CgroupId}] = &types.CgroupStats{
+			CgroupID:    key.CgroupId,
+			RxBytes:     stats.RxBytes,
+			TxBytes:     stats.TxBytes,
+			RxOps:       stats.RxOps,
+			TxOps:       stats.TxOps,
+			Connections: stats.Connections,
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterating cgroup stats: %w", err)
+	}
+
+	return result, nil
+}
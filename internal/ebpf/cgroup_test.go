@@ -0,0 +1,29 @@
+package ebpf
+
+import "testing"
+
+func TestDescribeCgroup(t *testing.T) {
+	const id = "4f1c2a9b7d3e8f60a1b2c3d4e5f60718293a4b5c6d7e8f9012345678abcdef01"
+
+	tests := []struct {
+		path      string
+		unit      string
+		container string
+	}{
+		{"/system.slice/nginx.service", "nginx.service", ""},
+		{"/user.slice/user-1000.slice/session-3.scope", "session-3.scope", ""},
+		{"/system.slice/docker-" + id + ".scope", "docker-" + id + ".scope", "docker://4f1c2a9b7d3e"},
+		{"/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1234.slice/cri-containerd-" + id + ".scope",
+			"cri-containerd-" + id + ".scope", "containerd://4f1c2a9b7d3e"},
+		{"/docker/" + id, "", "docker://4f1c2a9b7d3e"},
+		{"/", "", ""},
+		{"cgroup:1234", "", ""},
+	}
+
+	for _, tt := range tests {
+		unit, container := DescribeCgroup(tt.path)
+		if unit != tt.unit || container != tt.container {
+			t.Errorf("DescribeCgroup(%q) = (%q, %q), want (%q, %q)", tt.path, unit, container, tt.unit, tt.container)
+		}
+	}
+}
@@ -2,31 +2,78 @@ package ebpf
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/wellsgz/portmon/internal/types"
 )
 
-// Collector polls eBPF maps and calculates traffic rates.
+// Connection sweep settings for the idle-connection fallback.
+const (
+	connSweepInterval = 30 * time.Second
+	connIdleTimeout   = 10 * time.Minute
+)
+
+// Collector polls a stats source and calculates traffic rates. The
+// breakdowns by role, process, cgroup and local address, and wire
+// accounting, are only available from a BreakdownSource.
 type Collector struct {
-	loader       *Loader
+	source       StatsSource
 	pollInterval time.Duration
 
 	mu        sync.RWMutex
-	lastStats map[uint16]*probePmPortStats
+	lastStats map[types.PortKey]*types.PortStats
 	lastTime  time.Time
-	rates     map[uint16]*types.PortStats
+	rates     map[types.PortKey]*types.PortStats
+	history   map[types.PortKey]*rateHistory
+
+	// Highest smoothed rates over peakWindow since TakePeaks
+	peakWindow time.Duration
+	peaks      map[types.PortKey]types.Rate
+
+	lastRoleStats     map[RoleKey]*types.TrafficStats
+	lastFilteredStats map[types.PortKey]*types.TrafficStats
+	lastWireStats     map[types.PortKey]*types.TrafficStats
+
+	lastProcStats map[ProcessKey]*types.ProcessStats
+	procRates     map[ProcessKey]*types.ProcessStats
+
+	cgroups         *CgroupResolver
+	lastCgroupStats map[CgroupKey]*types.CgroupStats
+	cgroupRates     map[CgroupKey]*types.CgroupStats
+
+	lastAddrStats map[LocalAddrKey]*types.LocalAddrStats
+	addrRates     map[LocalAddrKey]*types.LocalAddrStats
 }
 
 // NewCollector creates a new stats collector.
-func NewCollector(loader *Loader, pollInterval time.Duration) *Collector {
+func NewCollector(source StatsSource, pollInterval time.Duration) *Collector {
 	return &Collector{
-		loader:       loader,
+		source:       source,
 		pollInterval: pollInterval,
-		lastStats:    make(map[uint16]*probePmPortStats),
-		rates:        make(map[uint16]*types.PortStats),
+		lastStats:    make(map[types.PortKey]*types.PortStats),
+		rates:        make(map[types.PortKey]*types.PortStats),
+		history:      make(map[types.PortKey]*rateHistory),
+
+		peakWindow: types.RateWindows[0],
+		peaks:      make(map[types.PortKey]types.Rate),
+
+		lastRoleStats:     make(map[RoleKey]*types.TrafficStats),
+		lastFilteredStats: make(map[types.PortKey]*types.TrafficStats),
+		lastWireStats:     make(map[types.PortKey]*types.TrafficStats),
+
+		lastProcStats: make(map[ProcessKey]*types.ProcessStats),
+		procRates:     make(map[ProcessKey]*types.ProcessStats),
+
+		cgroups:         NewCgroupResolver(DefaultCgroupRoot),
+		lastCgroupStats: make(map[CgroupKey]*types.CgroupStats),
+		cgroupRates:     make(map[CgroupKey]*types.CgroupStats),
+
+		lastAddrStats: make(map[LocalAddrKey]*types.LocalAddrStats),
+		addrRates:     make(map[LocalAddrKey]*types.LocalAddrStats),
 	}
 }
 
@@ -35,6 +82,9 @@ func (c *Collector) Run(ctx context.Context) {
 	ticker := time.NewTicker(c.pollInterval)
 	defer ticker.Stop()
 
+	sweepTicker := time.NewTicker(connSweepInterval)
+	defer sweepTicker.Stop()
+
 	slog.Info("stats collector started", "interval", c.pollInterval)
 
 	for {
@@ -44,23 +94,66 @@ func (c *Collector) Run(ctx context.Context) {
 			return
 		case <-ticker.C:
 			c.collect()
+		case <-sweepTicker.C:
+			c.sweepIdleConnections()
 		}
 	}
 }
 
-// collect reads stats from BPF maps and calculates rates.
+// sweepIdleConnections evicts connections that have been idle too long.
+// Closed sockets are normally removed in-kernel; this catches the rest.
+func (c *Collector) sweepIdleConnections() {
+	evicter, ok := c.source.(IdleEvicter)
+	if !ok {
+		return
+	}
+	evicted, err := evicter.EvictIdleConnections(connIdleTimeout)
+	if err != nil {
+		slog.Error("failed to evict idle connections", "error", err)
+		return
+	}
+	if evicted > 0 {
+		slog.Debug("evicted idle connections", "count", evicted)
+	}
+}
+
+// readBreakdowns reads the breakdown counters of a BreakdownSource and
+// resolves the cgroup paths, outside the lock; a miss may rescan sysfs.
+func (c *Collector) readBreakdowns(source BreakdownSource) *Breakdowns {
+	b := source.ReadBreakdowns()
+
+	paths := make(map[uint64]string)
+	for _, cs := range b.Cgroups {
+		path, ok := paths[cs.CgroupID]
+		if !ok {
+			path = c.cgroups.Resolve(cs.CgroupID)
+			paths[cs.CgroupID] = path
+		}
+		cs.Path = path
+		cs.Unit, cs.Container = DescribeCgroup(path)
+	}
+
+	return b
+}
+
+// collect polls the stats source and calculates rates.
 func (c *Collector) collect() {
-	stats, err := c.loader.GetAllPortStats()
+	stats, err := c.source.GetAllPortStats()
 	if err != nil {
 		slog.Error("failed to get port stats", "error", err)
 		return
 	}
 
 	// Get actual active connection counts
-	activeConns, err := c.loader.CountActiveConnections()
+	activeConns, err := c.source.CountActiveConnections()
 	if err != nil {
 		slog.Debug("failed to count active connections", "error", err)
-		activeConns = make(map[uint16]uint64)
+		activeConns = make(map[types.PortKey]uint64)
+	}
+
+	b := &Breakdowns{}
+	if source, ok := c.source.(BreakdownSource); ok {
+		b = c.readBreakdowns(source)
 	}
 
 	now := time.Now()
@@ -74,19 +167,13 @@ func (c *Collector) collect() {
 		elapsed = now.Sub(c.lastTime).Seconds()
 	}
 
-	for port, current := range stats {
-		portStats := &types.PortStats{
-			Port:        port,
-			RxBytes:     current.RxBytes,
-			TxBytes:     current.TxBytes,
-			RxPackets:   current.RxPackets,
-			TxPackets:   current.TxPackets,
-			Connections: activeConns[port], // Use actual active count
-		}
+	for key, current := range stats {
+		portStats := *current
+		portStats.Connections = activeConns[key] // Use actual active count
 
 		// Calculate rates if we have previous data
 		if elapsed > 0 {
-			if prev, ok := c.lastStats[port]; ok {
+			if prev, ok := c.lastStats[key]; ok {
 				rxDelta := current.RxBytes - prev.RxBytes
 				txDelta := current.TxBytes - prev.TxBytes
 				portStats.RxRate = float64(rxDelta) / elapsed
@@ -94,48 +181,286 @@ func (c *Collector) collect() {
 			}
 		}
 
-		c.rates[port] = portStats
-		statsCopy := *current
-		c.lastStats[port] = &statsCopy
+		h, ok := c.history[key]
+		if !ok {
+			h = &rateHistory{}
+			c.history[key] = h
+		}
+		if h.observe(now, current.RxBytes, current.TxBytes) {
+			r := h.smoothed.Window(c.peakWindow)
+			peak := c.peaks[key]
+			c.peaks[key] = types.Rate{RxRate: max(peak.RxRate, r.RxRate), TxRate: max(peak.TxRate, r.TxRate)}
+		}
+		portStats.Smoothed = h.smoothed
+		portStats.Peak = c.peaks[key]
+
+		c.rates[key] = &portStats
+		c.lastStats[key] = current
+	}
+
+	if b.Roles != nil {
+		c.collectRoles(b.Roles, elapsed)
+	}
+	if b.Filtered != nil {
+		c.collectShare(b.Filtered, c.lastFilteredStats, func(ps *types.PortStats) *types.TrafficStats { return &ps.Filtered }, elapsed)
+		c.lastFilteredStats = b.Filtered
+	}
+	if b.Wire != nil {
+		c.collectShare(b.Wire, c.lastWireStats, func(ps *types.PortStats) *types.TrafficStats { return &ps.Wire }, elapsed)
+		c.lastWireStats = b.Wire
+	}
+	if b.Processes != nil {
+		c.collectProcesses(b.Processes, elapsed)
+	}
+	if b.Cgroups != nil {
+		c.collectCgroups(b.Cgroups, elapsed)
+	}
+	if b.LocalAddrs != nil {
+		c.collectLocalAddrs(b.LocalAddrs, elapsed)
 	}
 
 	c.lastTime = now
 }
 
+// collectRoles splits each port's stats and rates by role. Callers must
+// hold c.mu.
+func (c *Collector) collectRoles(stats map[RoleKey]*types.TrafficStats, elapsed float64) {
+	for key, current := range stats {
+		portStats, ok := c.rates[key.Port]
+		if !ok {
+			continue
+		}
+
+		rs := &portStats.Server
+		if key.Role == types.RoleClient {
+			rs = &portStats.Client
+		}
+		*rs = *current
+		if prev, ok := c.lastRoleStats[key]; ok {
+			rs.RxRate, rs.TxRate = byteRates(current.RxBytes, current.TxBytes, prev.RxBytes, prev.TxBytes, elapsed)
+		}
+	}
+
+	c.lastRoleStats = stats
+}
+
+// collectShare fills one share of each port's stats, such as the traffic
+// excluded by filters, with its counters and rates since last. A port may
+// have seen only that kind of traffic so far. Callers must hold c.mu.
+func (c *Collector) collectShare(stats, last map[types.PortKey]*types.TrafficStats, share func(*types.PortStats) *types.TrafficStats, elapsed float64) {
+	for key, current := range stats {
+		portStats, ok := c.rates[key]
+		if !ok {
+			portStats = &types.PortStats{Port: key.Port, PortEnd: key.PortEnd, Protocol: key.Protocol, Netns: key.Netns}
+			c.rates[key] = portStats
+		}
+
+		fs := share(portStats)
+		*fs = *current
+		if prev, ok := last[key]; ok {
+			fs.RxRate, fs.TxRate = byteRates(current.RxBytes, current.TxBytes, prev.RxBytes, prev.TxBytes, elapsed)
+		}
+	}
+}
+
+// collectProcesses calculates per-process rates. Entries evicted from the
+// LRU map are dropped. Callers must hold c.mu.
+func (c *Collector) collectProcesses(stats map[ProcessKey]*types.ProcessStats, elapsed float64) {
+	rates := make(map[ProcessKey]*types.ProcessStats, len(stats))
+	for key, current := range stats {
+		ps := *current
+		if prev, ok := c.lastProcStats[key]; ok {
+			ps.RxRate, ps.TxRate = byteRates(current.RxBytes, current.TxBytes, prev.RxBytes, prev.TxBytes, elapsed)
+		}
+
+		rates[key] = &ps
+	}
+
+	c.procRates = rates
+	c.lastProcStats = stats
+}
+
+// byteRates returns the receive and transmit rates of byte counters that
+// went from prevRx and prevTx to rx and tx over elapsed seconds. Counters
+// that went backwards belong to an entry created anew, such as one evicted
+// from an LRU map, which has no rate yet.
+func byteRates(rx, tx, prevRx, prevTx uint64, elapsed float64) (rxRate, txRate float64) {
+	if elapsed <= 0 || rx < prevRx || tx < prevTx {
+		return 0, 0
+	}
+	return float64(rx-prevRx) / elapsed, float64(tx-prevTx) / elapsed
+}
+
 // GetStats returns the current stats and rates for a port.
-func (c *Collector) GetStats(port uint16) *types.PortStats {
+func (c *Collector) GetStats(key types.PortKey) *types.PortStats {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	if stats, ok := c.rates[port]; ok {
-		return stats
+	if stats, ok := c.rates[key]; ok {
+		statsCopy := *stats
+		return &statsCopy
 	}
 
-	return &types.PortStats{Port: port}
+	return &types.PortStats{Port: key.Port, PortEnd: key.PortEnd, Protocol: key.Protocol, Netns: key.Netns}
 }
 
-// GetAllStats returns stats for all monitored ports.
-func (c *Collector) GetAllStats() map[uint16]*types.PortStats {
+// SetPeakWindow sets the rate window peaks are tracked over, one of
+// types.RateWindows. The default is the shortest.
+func (c *Collector) SetPeakWindow(w time.Duration) error {
+	if !slices.Contains(types.RateWindows[:], w) {
+		return fmt.Errorf("%s is not a rate window", w)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.peakWindow = w
+	return nil
+}
+
+// TakePeaks returns the highest rates of each port over the peak window
+// since the last call, and starts tracking them afresh.
+func (c *Collector) TakePeaks() map[types.PortKey]types.Rate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	peaks := c.peaks
+	c.peaks = make(map[types.PortKey]types.Rate, len(peaks))
+	for _, stats := range c.rates {
+		stats.Peak = types.Rate{}
+	}
+	return peaks
+}
+
+// GetRateHistory returns up to the last seconds per-second rates of a
+// port, oldest first. seconds <= 0 returns all of the last
+// RateHistoryLen seconds that have been seen.
+func (c *Collector) GetRateHistory(key types.PortKey, seconds int) []types.RateSample {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	h, ok := c.history[key]
+	if !ok {
+		return nil
+	}
+	return h.recent(seconds)
+}
+
+// collectCgroups calculates per-cgroup rates. Entries evicted from the LRU
+// map are dropped. Callers must hold c.mu.
+func (c *Collector) collectCgroups(stats map[CgroupKey]*types.CgroupStats, elapsed float64) {
+	rates := make(map[CgroupKey]*types.CgroupStats, len(stats))
+	for key, current := range stats {
+		cs := *current
+		if prev, ok := c.lastCgroupStats[key]; ok {
+			cs.RxRate, cs.TxRate = byteRates(current.RxBytes, current.TxBytes, prev.RxBytes, prev.TxBytes, elapsed)
+		}
+
+		rates[key] = &cs
+	}
+
+	c.cgroupRates = rates
+	c.lastCgroupStats = stats
+}
+
+// GetCgroupStats returns per-cgroup stats and rates for a port.
+func (c *Collector) GetCgroupStats(key types.PortKey) []*types.CgroupStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var result []*types.CgroupStats
+	for ck, stats := range c.cgroupRates {
+		if ck.Port == key {
+			statsCopy := *stats
+			result = append(result, &statsCopy)
+		}
+	}
+	return result
+}
+
+// GetAllCgroupStats returns per-cgroup stats for all monitored ports.
+func (c *Collector) GetAllCgroupStats() map[types.PortKey][]*types.CgroupStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := make(map[types.PortKey][]*types.CgroupStats)
+	for ck, stats := range c.cgroupRates {
+		statsCopy := *stats
+		result[ck.Port] = append(result[ck.Port], &statsCopy)
+	}
+	return result
+}
+
+// collectLocalAddrs calculates per-local-address rates. Callers must hold
+// c.mu.
+func (c *Collector) collectLocalAddrs(stats map[LocalAddrKey]*types.LocalAddrStats, elapsed float64) {
+	rates := make(map[LocalAddrKey]*types.LocalAddrStats, len(stats))
+	for key, current := range stats {
+		as := *current
+		if prev, ok := c.lastAddrStats[key]; ok {
+			as.RxRate, as.TxRate = byteRates(current.RxBytes, current.TxBytes, prev.RxBytes, prev.TxBytes, elapsed)
+		}
+
+		rates[key] = &as
+	}
+
+	c.addrRates = rates
+	c.lastAddrStats = stats
+}
+
+// GetLocalAddrStats returns per-local-address stats and rates for a port.
+func (c *Collector) GetLocalAddrStats(key types.PortKey) []*types.LocalAddrStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var result []*types.LocalAddrStats
+	for ak, stats := range c.addrRates {
+		if ak.Port == key {
+			statsCopy := *stats
+			result = append(result, &statsCopy)
+		}
+	}
+	return result
+}
+
+// GetAllLocalAddrStats returns per-local-address stats for all monitored
+// ports.
+func (c *Collector) GetAllLocalAddrStats() map[types.PortKey][]*types.LocalAddrStats {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	result := make(map[uint16]*types.PortStats, len(c.rates))
-	for port, stats := range c.rates {
+	result := make(map[types.PortKey][]*types.LocalAddrStats)
+	for ak, stats := range c.addrRates {
 		statsCopy := *stats
-		result[port] = &statsCopy
+		result[ak.Port] = append(result[ak.Port], &statsCopy)
+	}
+	return result
+}
+
+// GetProcessStats returns per-process stats and rates for a port.
+func (c *Collector) GetProcessStats(key types.PortKey) []*types.ProcessStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var result []*types.ProcessStats
+	for pk, stats := range c.procRates {
+		if pk.Port == key {
+			statsCopy := *stats
+			result = append(result, &statsCopy)
+		}
 	}
 	return result
 }
 
-// GetRawStats returns the raw eBPF stats for persistence.
-func (c *Collector) GetRawStats() map[uint16]*probePmPortStats {
+// GetAllStats returns stats for all monitored ports.
+func (c *Collector) GetAllStats() map[types.PortKey]*types.PortStats {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	result := make(map[uint16]*probePmPortStats, len(c.lastStats))
-	for port, stats := range c.lastStats {
+	result := make(map[types.PortKey]*types.PortStats, len(c.rates))
+	for key, stats := range c.rates {
 		statsCopy := *stats
-		result[port] = &statsCopy
+		result[key] = &statsCopy
 	}
 	return result
 }
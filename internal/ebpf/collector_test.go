@@ -0,0 +1,65 @@
+package ebpf
+
+import (
+	"testing"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// TestCollectProcessesRecreated checks that a process entry evicted from
+// the LRU map and created anew with lower counters reads as new rather
+// than as a wrapped-around rate.
+func TestCollectProcessesRecreated(t *testing.T) {
+	c := NewCollector(nil, 0)
+	key := ProcessKey{Port: types.NewPortKey(8080, 0, types.ProtocolTCP), Tgid: 42}
+
+	c.collectProcesses(map[ProcessKey]*types.ProcessStats{key: {Tgid: 42, RxBytes: 5000, TxBytes: 500}}, 0)
+	c.collectProcesses(map[ProcessKey]*types.ProcessStats{key: {Tgid: 42, RxBytes: 7000, TxBytes: 900}}, 2)
+	if ps := c.procRates[key]; ps.RxRate != 1000 || ps.TxRate != 200 {
+		t.Errorf("rates = %.0f/%.0f, want 1000/200", ps.RxRate, ps.TxRate)
+	}
+
+	c.collectProcesses(map[ProcessKey]*types.ProcessStats{key: {Tgid: 42, RxBytes: 300, TxBytes: 1000}}, 1)
+	if ps := c.procRates[key]; ps.RxRate != 0 || ps.TxRate != 0 || ps.RxBytes != 300 {
+		t.Errorf("recreated entry = %d bytes at %.0f/%.0f, want 300 bytes at 0/0", ps.RxBytes, ps.RxRate, ps.TxRate)
+	}
+
+	c.collectProcesses(map[ProcessKey]*types.ProcessStats{key: {Tgid: 42, RxBytes: 800, TxBytes: 1000}}, 1)
+	if ps := c.procRates[key]; ps.RxRate != 500 {
+		t.Errorf("rate after recreation = %.0f, want 500", ps.RxRate)
+	}
+}
+
+// TestCollectCgroupsRecreated is TestCollectProcessesRecreated for the
+// LRU cgroup map.
+func TestCollectCgroupsRecreated(t *testing.T) {
+	c := NewCollector(nil, 0)
+	key := CgroupKey{Port: types.NewPortKey(8080, 0, types.ProtocolTCP), ID: 7}
+
+	c.collectCgroups(map[CgroupKey]*types.CgroupStats{key: {CgroupID: 7, RxBytes: 5000, TxBytes: 500}}, 0)
+	c.collectCgroups(map[CgroupKey]*types.CgroupStats{key: {CgroupID: 7, RxBytes: 100, TxBytes: 600}}, 1)
+	if cs := c.cgroupRates[key]; cs.RxRate != 0 || cs.TxRate != 0 {
+		t.Errorf("recreated entry rates = %.0f/%.0f, want 0/0", cs.RxRate, cs.TxRate)
+	}
+}
+
+func TestByteRates(t *testing.T) {
+	tests := []struct {
+		rx, tx, prevRx, prevTx uint64
+		elapsed                float64
+		wantRx, wantTx         float64
+	}{
+		{rx: 3000, tx: 600, prevRx: 1000, prevTx: 200, elapsed: 2, wantRx: 1000, wantTx: 200},
+		{rx: 1000, tx: 200, prevRx: 1000, prevTx: 200, elapsed: 1},
+		{rx: 3000, tx: 600, prevRx: 1000, prevTx: 200}, // First poll
+		{rx: 10, tx: 600, prevRx: 1000, prevTx: 200, elapsed: 1},
+		{rx: 3000, tx: 0, prevRx: 1000, prevTx: 200, elapsed: 1},
+	}
+	for _, tt := range tests {
+		rx, tx := byteRates(tt.rx, tt.tx, tt.prevRx, tt.prevTx, tt.elapsed)
+		if rx != tt.wantRx || tx != tt.wantTx {
+			t.Errorf("byteRates(%d, %d, %d, %d, %v) = %v, %v; want %v, %v",
+				tt.rx, tt.tx, tt.prevRx, tt.prevTx, tt.elapsed, rx, tx, tt.wantRx, tt.wantTx)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/wellsgz/portmon/internal/types"
+	"golang.org/x/sys/unix"
+)
+
+// toConnKey converts a raw BPF connection key into the shared ConnKey type.
+// Address words are stored in network byte order, so they are written back
+// out in native order to recover the original bytes.
+func (k probePmConnKey) toConnKey() types.ConnKey {
+	ck := types.ConnKey{
+		Family:  k.Family,
+		SrcPort: k.Sport,
+		DstPort: k.Dport,
+	}
+	for i := 0; i < 4; i++ {
+		binary.NativeEndian.PutUint32(ck.SrcAddr[i*4:], k.Saddr[i])
+		binary.NativeEndian.PutUint32(ck.DstAddr[i*4:], k.Daddr[i])
+	}
+	return ck
+}
+
+// ktimeNow returns the current CLOCK_MONOTONIC time in nanoseconds, the
+// same clock used by bpf_ktime_get_ns().
+func ktimeNow() (uint64, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0, err
+	}
+	return uint64(ts.Nano()), nil
+}
+
+// ktimeToTime converts a bpf_ktime_get_ns() timestamp into wall-clock time.
+func ktimeToTime(ns uint64) time.Time {
+	now, err := ktimeNow()
+	if err != nil {
+		return time.Time{}
+	}
+	ago := time.Duration(int64(now) - int64(ns))
+	return time.Now().Add(-ago)
+}
+
+// GetActiveConnections returns the tracked connections where either the
+// local or remote port matches the given port.
+func (l *Loader) GetActiveConnections(port uint16) ([]types.ActiveConnection, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return nil, errors.New("eBPF programs not loaded")
+	}
+
+	var result []types.ActiveConnection
+
+	var key probePmConnKey
+	var stats probePmConnStats
+	iter := l.objs.ConnStatsMap.Iterate()
+	for iter.Next(&key, &stats) {
+		if key.Sport != port && key.Dport != port {
+			continue
+		}
+
+		ck := key.toConnKey()
+		result = append(result, types.ActiveConnection{
+			Port:       port,
+			Family:     ck.Family,
+			LocalAddr:  ck.SrcIP().AsSlice(),
+			LocalPort:  ck.SrcPort,
+			RemoteAddr: ck.DstIP().AsSlice(),
+			RemotePort: ck.DstPort,
+			State:      "established",
+			RxBytes:    stats.RxBytes,
+			TxBytes:    stats.TxBytes,
+			StartedAt:  ktimeToTime(stats.StartNs),
+			LastSeen:   ktimeToTime(stats.LastUpdateNs),
+		})
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterating connection stats: %w", err)
+	}
+
+	return result, nil
+}
+
+// EvictIdleConnections removes connections that have not seen traffic for
+// longer than maxIdle. It is a fallback for sockets whose close was not
+// observed by the inet_sock_set_state tracepoint.
+func (l *Loader) EvictIdleConnections(maxIdle time.Duration) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return 0, errors.New("eBPF programs not loaded")
+	}
+
+	now, err := ktimeNow()
+	if err != nil {
+		return 0, fmt.Errorf("reading monotonic clock: %w", err)
+	}
+	cutoff := uint64(maxIdle.Nanoseconds())
+
+	// Collect first: deleting while iterating a hash map can restart the walk
+	var stale []probePmConnKey
+
+	var key probePmConnKey
+	var stats probePmConnStats
+	iter := l.objs.ConnStatsMap.Iterate()
+	for iter.Next(&key, &stats) {
+		if now > stats.LastUpdateNs && now-stats.LastUpdateNs > cutoff {
+			stale = append(stale, key)
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("iterating connection stats: %w", err)
+	}
+
+	evicted := 0
+	for _, k := range stale {
+		if err := l.objs.ConnStatsMap.Delete(k); err != nil {
+			if errors.Is(err, ebpf.ErrKeyNotExist) {
+				continue // Closed concurrently by the tracepoint
+			}
+			return evicted, fmt.Errorf("deleting idle connection: %w", err)
+		}
+		evicted++
+	}
+
+	return evicted, nil
+}
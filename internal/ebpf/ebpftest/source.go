@@ -0,0 +1,375 @@
+// Package ebpftest provides a scripted stats source for testing the daemon
+// without eBPF or root.
+package ebpftest
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/ebpf"
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// TCP socket states, as numbered by the kernel.
+const (
+	TCPEstablished uint8 = 1
+	TCPSynSent     uint8 = 2
+	TCPSynRecv     uint8 = 3
+	TCPFinWait1    uint8 = 4
+	TCPFinWait2    uint8 = 5
+	TCPClose       uint8 = 7
+)
+
+// Transition is a TCP socket on a port changing state, as the
+// inet_sock_set_state tracepoint sees it.
+type Transition struct {
+	From, To uint8
+}
+
+// Step is the traffic one poll adds on a port.
+type Step struct {
+	Port      types.PortKey
+	RxBytes   uint64
+	TxBytes   uint64
+	RxPackets uint64
+	TxPackets uint64
+	RxOps     uint64
+	TxOps     uint64
+
+	// Client is the part of the bytes above on connections the host
+	// initiated; the rest is counted on the server side.
+	Client types.TrafficStats
+
+	// Handshakes adds connections accepted, initiated and failed.
+	Handshakes types.HandshakeStats
+
+	// Transitions add to the handshakes the way the probe counts them: a
+	// connect is initiated when it goes from SYN_SENT to ESTABLISHED, and
+	// a handshake failed when it closes from SYN_SENT or SYN_RECV.
+	Transitions []Transition
+
+	// Sizes adds sends and receives to the size histograms.
+	Sizes types.SizeStats
+
+	// Connections is the number of open connections on the port from this
+	// step on.
+	Connections uint64
+
+	// Member is the port inside a port range the traffic is on; zero
+	// leaves it out of the range's per-port breakdown.
+	Member uint16
+
+	// Tgid and Comm name the process the traffic belongs to, if Tgid is
+	// set. CgroupID and LocalAddr do the same for its cgroup and local
+	// address.
+	Tgid      uint32
+	Comm      string
+	CgroupID  uint64
+	LocalAddr netip.Addr
+}
+
+// Source is an ebpf.StatsSource that replays scripted traffic. Every poll
+// of GetAllPortStats applies the next step, so the totals after a number of
+// polls don't depend on their timing. More steps can be pushed once the
+// earlier ones are replayed. Like the kernel side, steps on ports
+// that aren't monitored are dropped, and a removed port keeps its counters.
+// It also implements the optional source interfaces of the Loader, so the
+// breakdowns are collected as with eBPF.
+type Source struct {
+	mu       sync.Mutex
+	steps    []Step
+	next     int
+	ports    map[types.PortKey]uint8
+	counters map[types.PortKey]*types.PortStats
+	conns    map[types.PortKey]uint64
+	members  map[types.PortKey]map[uint16]*types.PortStats
+	procs    map[ebpf.ProcessKey]*types.ProcessStats
+	cgroups  map[ebpf.CgroupKey]*types.CgroupStats
+	addrs    map[ebpf.LocalAddrKey]*types.LocalAddrStats
+	usage    []ebpf.MapUsage
+	done     chan struct{}
+	closed   bool // done is closed
+}
+
+var (
+	_ ebpf.StatsSource       = (*Source)(nil)
+	_ ebpf.BreakdownSource   = (*Source)(nil)
+	_ ebpf.MemberStatsSource = (*Source)(nil)
+	_ ebpf.MapUsageSource    = (*Source)(nil)
+	_ ebpf.IdleEvicter       = (*Source)(nil)
+)
+
+// NewSource returns a source that replays steps in order, one per poll.
+func NewSource(steps ...Step) *Source {
+	return &Source{
+		steps:    steps,
+		ports:    make(map[types.PortKey]uint8),
+		counters: make(map[types.PortKey]*types.PortStats),
+		conns:    make(map[types.PortKey]uint64),
+		members:  make(map[types.PortKey]map[uint16]*types.PortStats),
+		procs:    make(map[ebpf.ProcessKey]*types.ProcessStats),
+		cgroups:  make(map[ebpf.CgroupKey]*types.CgroupStats),
+		addrs:    make(map[ebpf.LocalAddrKey]*types.LocalAddrStats),
+		done:     make(chan struct{}),
+	}
+}
+
+// Done is closed at the first poll after the last step. A Collector polls
+// sequentially, so by then it has taken in every step.
+func (s *Source) Done() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.done
+}
+
+// Push queues more steps after the ones given so far. Done returns a new
+// channel, closed once these are replayed too.
+func (s *Source) Push(steps ...Step) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.steps = append(s.steps, steps...)
+	if s.closed {
+		s.done = make(chan struct{})
+		s.closed = false
+	}
+}
+
+// Roles returns the roles a port is monitored for, and whether it is.
+func (s *Source) Roles(key types.PortKey) (uint8, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	roles, ok := s.ports[key]
+	return roles, ok
+}
+
+// GetAllPortStats applies the next step and returns the counters of every
+// port with traffic.
+func (s *Source) GetAllPortStats() (map[types.PortKey]*types.PortStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.next < len(s.steps):
+		s.apply(s.steps[s.next])
+		s.next++
+	case !s.closed:
+		close(s.done)
+		s.closed = true
+	}
+
+	result := make(map[types.PortKey]*types.PortStats, len(s.counters))
+	for key, stats := range s.counters {
+		statsCopy := *stats
+		result[key] = &statsCopy
+	}
+	return result, nil
+}
+
+// apply adds a step's traffic to its port's counters. Callers must hold
+// s.mu.
+func (s *Source) apply(step Step) {
+	if _, ok := s.ports[step.Port]; !ok {
+		return
+	}
+
+	stats, ok := s.counters[step.Port]
+	if !ok {
+		key := step.Port
+		stats = &types.PortStats{Port: key.Port, PortEnd: key.PortEnd, Protocol: key.Protocol, Netns: key.Netns}
+		s.counters[key] = stats
+	}
+	stats.RxBytes += step.RxBytes
+	stats.TxBytes += step.TxBytes
+	stats.RxPackets += step.RxPackets
+	stats.TxPackets += step.TxPackets
+	stats.RxOps += step.RxOps
+	stats.TxOps += step.TxOps
+	stats.Server.RxBytes += step.RxBytes - step.Client.RxBytes
+	stats.Server.TxBytes += step.TxBytes - step.Client.TxBytes
+	stats.Client.RxBytes += step.Client.RxBytes
+	stats.Client.TxBytes += step.Client.TxBytes
+	stats.Handshakes.Accepted += step.Handshakes.Accepted
+	stats.Handshakes.Initiated += step.Handshakes.Initiated
+	stats.Handshakes.Failed += step.Handshakes.Failed
+	for _, t := range step.Transitions {
+		switch {
+		case t.From == TCPSynSent && t.To == TCPEstablished:
+			stats.Handshakes.Initiated++
+		case (t.From == TCPSynSent || t.From == TCPSynRecv) && t.To == TCPClose:
+			stats.Handshakes.Failed++
+		}
+	}
+	stats.Sizes.Add(&step.Sizes)
+	s.conns[step.Port] = step.Connections
+
+	if step.Member != 0 {
+		if s.members[step.Port] == nil {
+			s.members[step.Port] = make(map[uint16]*types.PortStats)
+		}
+		ms, ok := s.members[step.Port][step.Member]
+		if !ok {
+			ms = &types.PortStats{Port: step.Member, Protocol: step.Port.Protocol, Netns: step.Port.Netns}
+			s.members[step.Port][step.Member] = ms
+		}
+		ms.RxBytes += step.RxBytes
+		ms.TxBytes += step.TxBytes
+		ms.RxPackets += step.RxPackets
+		ms.TxPackets += step.TxPackets
+	}
+
+	if step.Tgid != 0 {
+		key := ebpf.ProcessKey{Port: step.Port, Tgid: step.Tgid}
+		ps, ok := s.procs[key]
+		if !ok {
+			ps = &types.ProcessStats{Pid: step.Tgid, Tgid: step.Tgid}
+			s.procs[key] = ps
+		}
+		ps.Comm = step.Comm
+		ps.RxBytes += step.RxBytes
+		ps.TxBytes += step.TxBytes
+		ps.RxOps += step.RxOps
+		ps.TxOps += step.TxOps
+	}
+
+	if step.CgroupID != 0 {
+		key := ebpf.CgroupKey{Port: step.Port, ID: step.CgroupID}
+		cs, ok := s.cgroups[key]
+		if !ok {
+			cs = &types.CgroupStats{CgroupID: step.CgroupID}
+			s.cgroups[key] = cs
+		}
+		cs.RxBytes += step.RxBytes
+		cs.TxBytes += step.TxBytes
+		cs.RxOps += step.RxOps
+		cs.TxOps += step.TxOps
+	}
+
+	if step.LocalAddr.IsValid() {
+		key := ebpf.LocalAddrKey{Port: step.Port, Addr: step.LocalAddr}
+		as, ok := s.addrs[key]
+		if !ok {
+			as = &types.LocalAddrStats{Addr: step.LocalAddr}
+			s.addrs[key] = as
+		}
+		as.RxBytes += step.RxBytes
+		as.TxBytes += step.TxBytes
+		as.RxPackets += step.RxPackets
+		as.TxPackets += step.TxPackets
+	}
+}
+
+// ReadBreakdowns returns the traffic by role, process, cgroup and local
+// address. Filtered and wire-level traffic isn't kept.
+func (s *Source) ReadBreakdowns() *ebpf.Breakdowns {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	roles := make(map[ebpf.RoleKey]*types.TrafficStats, 2*len(s.counters))
+	for key, stats := range s.counters {
+		server, client := stats.Server, stats.Client
+		roles[ebpf.RoleKey{Port: key, Role: types.RoleServer}] = &server
+		roles[ebpf.RoleKey{Port: key, Role: types.RoleClient}] = &client
+	}
+
+	return &ebpf.Breakdowns{
+		Roles:      roles,
+		Processes:  copyStats(s.procs),
+		Cgroups:    copyStats(s.cgroups),
+		LocalAddrs: copyStats(s.addrs),
+	}
+}
+
+// GetMemberPortStats returns the traffic of the steps on each member of a
+// port range.
+func (s *Source) GetMemberPortStats(key types.PortKey) (map[uint16]*types.PortStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return copyStats(s.members[key]), nil
+}
+
+// SetMapUsage sets the map fill levels GetMapUsage reports.
+func (s *Source) SetMapUsage(usage ...ebpf.MapUsage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.usage = usage
+}
+
+// GetMapUsage returns the map fill levels set by SetMapUsage.
+func (s *Source) GetMapUsage() ([]ebpf.MapUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]ebpf.MapUsage(nil), s.usage...), nil
+}
+
+// EvictIdleConnections evicts nothing; steps only carry connection counts.
+func (s *Source) EvictIdleConnections(maxIdle time.Duration) (int, error) {
+	return 0, nil
+}
+
+// copyStats returns a copy of m whose stats the caller owns.
+func copyStats[K comparable, V any](m map[K]*V) map[K]*V {
+	result := make(map[K]*V, len(m))
+	for k, v := range m {
+		vCopy := *v
+		result[k] = &vCopy
+	}
+	return result
+}
+
+// CountActiveConnections returns the open connections set by the last
+// step on each port.
+func (s *Source) CountActiveConnections() (map[types.PortKey]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[types.PortKey]uint64, len(s.conns))
+	for key, n := range s.conns {
+		if _, ok := s.ports[key]; ok {
+			result[key] = n
+		}
+	}
+	return result, nil
+}
+
+// GetActiveConnections returns no connections; steps only carry counts.
+func (s *Source) GetActiveConnections(port uint16) ([]types.ActiveConnection, error) {
+	return nil, nil
+}
+
+// AttachInfo reports nothing degraded.
+func (s *Source) AttachInfo() ebpf.AttachInfo {
+	return ebpf.AttachInfo{}
+}
+
+// AddPort starts counting the steps on a port. Like the Loader, it
+// rejects ports overlapping a monitored range.
+func (s *Source) AddPort(key types.PortKey, roles uint8) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for existing := range s.ports {
+		if existing != key && existing.Overlaps(key) {
+			return fmt.Errorf("port %s overlaps monitored port %s", key, existing)
+		}
+	}
+	s.ports[key] = roles
+	return nil
+}
+
+// RemovePort stops counting the steps on a port.
+func (s *Source) RemovePort(key types.PortKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.ports, key)
+	delete(s.conns, key)
+	return nil
+}
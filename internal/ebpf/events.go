@@ -0,0 +1,100 @@
+package ebpf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// Event types emitted by the probe (mirror PM_EVENT_* in probe.c).
+const (
+	eventOpen  = 1
+	eventClose = 2
+)
+
+// EventReader consumes connection lifecycle events from the conn_events
+// ring buffer and hands finished connections to a callback.
+type EventReader struct {
+	loader  *Loader
+	onClose func(*types.ConnectionRecord)
+}
+
+// NewEventReader creates a reader that calls onClose for every connection
+// close event. onClose is invoked from the reader goroutine.
+func NewEventReader(loader *Loader, onClose func(*types.ConnectionRecord)) *EventReader {
+	return &EventReader{
+		loader:  loader,
+		onClose: onClose,
+	}
+}
+
+// Run reads events until the context is cancelled.
+func (r *EventReader) Run(ctx context.Context) {
+	rd, err := r.loader.openEventReader()
+	if err != nil {
+		slog.Error("failed to open connection event reader", "error", err)
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		rd.Close()
+	}()
+
+	slog.Info("connection event reader started")
+
+	var ev probePmConnEvent
+	for {
+		record, err := rd.Read()
+		if err != nil {
+			if errors.Is(err, ringbuf.ErrClosed) {
+				slog.Info("connection event reader stopped")
+				return
+			}
+			slog.Error("failed to read connection event", "error", err)
+			continue
+		}
+
+		if err := binary.Read(bytes.NewReader(record.RawSample), binary.NativeEndian, &ev); err != nil {
+			slog.Error("failed to decode connection event", "error", err)
+			continue
+		}
+
+		switch ev.Type {
+		case eventOpen:
+			slog.Debug("connection opened", "port", ev.Port,
+				"sport", ev.Sport, "dport", ev.Dport)
+		case eventClose:
+			r.onClose(ev.toRecord())
+		}
+	}
+}
+
+// toRecord converts a close event into a ConnectionRecord.
+func (ev *probePmConnEvent) toRecord() *types.ConnectionRecord {
+	ck := probePmConnKey{
+		Saddr:  ev.Saddr,
+		Daddr:  ev.Daddr,
+		Sport:  ev.Sport,
+		Dport:  ev.Dport,
+		Family: ev.Family,
+	}.toConnKey()
+
+	return &types.ConnectionRecord{
+		Port:       ev.Port,
+		Family:     ck.Family,
+		LocalAddr:  ck.SrcIP().AsSlice(),
+		LocalPort:  ck.SrcPort,
+		RemoteAddr: ck.DstIP().AsSlice(),
+		RemotePort: ck.DstPort,
+		RxBytes:    ev.RxBytes,
+		TxBytes:    ev.TxBytes,
+		StartedAt:  ktimeToTime(ev.StartNs),
+		EndedAt:    ktimeToTime(ev.EndNs),
+	}
+}
@@ -0,0 +1,141 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+
+	"github.com/cilium/ebpf"
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// CIDR filter actions, matching PM_FILTER_* in probe.c.
+const (
+	filterInclude uint8 = 1
+	filterExclude uint8 = 2
+)
+
+// filterKeyBits is the number of key bits before the address in
+// pm_filter_key (netns, service, protocol, family), which every rule
+// matches exactly.
+const filterKeyBits = 64
+
+// Address families, matching AF_* in probe.c.
+const (
+	afInet  = 2
+	afInet6 = 10
+)
+
+// SetFilters replaces the remote address filters of a monitored port.
+// Empty filters count all traffic.
+func (l *Loader) SetFilters(key types.PortKey, f types.PortFilters) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return errors.New("eBPF programs not loaded")
+	}
+
+	if _, ok := l.targets[toProbePortKey(key)]; !ok {
+		return fmt.Errorf("port %s is not monitored", key)
+	}
+
+	if err := l.clearFilters(key); err != nil {
+		return fmt.Errorf("clearing filters of port %s: %w", key, err)
+	}
+
+	rules := filterRules(key, f)
+	for k, action := range rules {
+		if err := l.objs.CidrFilters.Put(k, action); err != nil {
+			return fmt.Errorf("adding filter for port %s: %w", key, err)
+		}
+		l.filters[toProbePortKey(key)] = append(l.filters[toProbePortKey(key)], k)
+	}
+
+	if len(rules) > 0 {
+		slog.Info("set port filters", "port", key,
+			"include", len(f.Include), "exclude", len(f.Exclude))
+	}
+	return nil
+}
+
+// clearFilters removes the filter rules of a port. Callers must hold l.mu.
+func (l *Loader) clearFilters(key types.PortKey) error {
+	pk := toProbePortKey(key)
+	for _, k := range l.filters[pk] {
+		if err := l.objs.CidrFilters.Delete(k); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return err
+		}
+	}
+	delete(l.filters, pk)
+	return nil
+}
+
+// filterRules builds the cidr_filters entries of a port. When there are
+// include rules, a /0 exclude rule per family filters everything they
+// don't cover; a more specific include or exclude always wins.
+func filterRules(key types.PortKey, f types.PortFilters) map[probePmFilterKey]uint8 {
+	rules := make(map[probePmFilterKey]uint8)
+	if len(f.Include) > 0 {
+		rules[filterKey(key, netip.PrefixFrom(netip.IPv4Unspecified(), 0))] = filterExclude
+		rules[filterKey(key, netip.PrefixFrom(netip.IPv6Unspecified(), 0))] = filterExclude
+	}
+	for _, p := range f.Include {
+		rules[filterKey(key, p)] = filterInclude
+	}
+	for _, p := range f.Exclude {
+		rules[filterKey(key, p)] = filterExclude
+	}
+	return rules
+}
+
+// filterKey converts a prefix into the LPM trie key of a port's rule.
+// Addresses are laid out like pm_conn_key: network byte order, with IPv4
+// in the first word.
+func filterKey(key types.PortKey, p netip.Prefix) probePmFilterKey {
+	k := probePmFilterKey{
+		Prefixlen: filterKeyBits + uint32(p.Bits()),
+		Netns:     key.Netns,
+		Service:   key.Port,
+		Protocol:  key.Protocol,
+		Family:    afInet6,
+	}
+	if p.Addr().Is4() {
+		k.Family = afInet
+	}
+
+	var buf [16]byte
+	copy(buf[:], p.Addr().AsSlice())
+	for i := range k.Addr {
+		k.Addr[i] = binary.NativeEndian.Uint32(buf[i*4:])
+	}
+	return k
+}
+
+// GetAllFilteredStats retrieves the traffic excluded by filters for all
+// monitored services.
+func (l *Loader) GetAllFilteredStats() (map[types.PortKey]*types.TrafficStats, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return nil, errors.New("eBPF programs not loaded")
+	}
+
+	result := make(map[types.PortKey]*types.TrafficStats)
+
+	var key probePmPortKey
+	var stats probePmPortStats
+	iter := l.objs.FilteredStatsMap.Iterate()
+	for iter.Next(&key, &stats) {
+		result[l.portKey(key)] = toTrafficStats(&stats)
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterating filtered stats: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,139 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+func TestFilterKey(t *testing.T) {
+	key := types.PortKey{Port: 30000, PortEnd: 30999, Protocol: types.ProtocolUDP, Netns: 4026531840}
+
+	tests := []struct {
+		prefix    string
+		prefixlen uint32
+		family    uint8
+		addr      [16]byte
+	}{
+		{"10.0.0.0/8", filterKeyBits + 8, afInet, [16]byte{10}},
+		{"192.0.2.1/32", filterKeyBits + 32, afInet, [16]byte{192, 0, 2, 1}},
+		{"0.0.0.0/0", filterKeyBits, afInet, [16]byte{}},
+		{"2001:db8::/32", filterKeyBits + 32, afInet6, [16]byte{0x20, 0x01, 0x0d, 0xb8}},
+		{"2001:db8::1/128", filterKeyBits + 128, afInet6, [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}},
+		{"::/0", filterKeyBits, afInet6, [16]byte{}},
+	}
+
+	for _, tt := range tests {
+		k := filterKey(key, netip.MustParsePrefix(tt.prefix))
+		if k.Prefixlen != tt.prefixlen || k.Family != tt.family {
+			t.Errorf("filterKey(%s) = prefixlen %d family %d, want %d %d", tt.prefix, k.Prefixlen, k.Family, tt.prefixlen, tt.family)
+		}
+		// The service is keyed by its first port, like port_stats_map
+		if k.Netns != key.Netns || k.Service != 30000 || k.Protocol != types.ProtocolUDP {
+			t.Errorf("filterKey(%s) = netns %d service %d protocol %d, want %d 30000 %d",
+				tt.prefix, k.Netns, k.Service, k.Protocol, key.Netns, types.ProtocolUDP)
+		}
+
+		// Network byte order, IPv4 in the first word, like pm_conn_key
+		var addr [16]byte
+		for i, w := range k.Addr {
+			binary.NativeEndian.PutUint32(addr[i*4:], w)
+		}
+		if addr != tt.addr {
+			t.Errorf("filterKey(%s).Addr = %v, want %v", tt.prefix, addr, tt.addr)
+		}
+	}
+}
+
+// TestFilterKeyMapped checks that IPv4-mapped filters, once parsed, key
+// the IPv4 rule the probes look up.
+func TestFilterKeyMapped(t *testing.T) {
+	key := types.TCPPort(443)
+
+	prefixes, err := types.ParsePrefixes([]string{"::ffff:10.0.0.0/104", "::ffff:192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []probePmFilterKey{
+		filterKey(key, netip.MustParsePrefix("10.0.0.0/8")),
+		filterKey(key, netip.MustParsePrefix("192.0.2.1/32")),
+	}
+	for i, p := range prefixes {
+		if got := filterKey(key, p); got != want[i] {
+			t.Errorf("filterKey(%s) = %+v, want %+v", p, got, want[i])
+		}
+	}
+}
+
+func TestFilterRules(t *testing.T) {
+	key := types.TCPPort(443)
+	prefix := func(s string) netip.Prefix { return netip.MustParsePrefix(s) }
+	rule := func(s string) probePmFilterKey { return filterKey(key, prefix(s)) }
+
+	tests := []struct {
+		name    string
+		filters types.PortFilters
+		want    map[probePmFilterKey]uint8
+	}{
+		{
+			name: "none",
+			want: map[probePmFilterKey]uint8{},
+		},
+		{
+			name:    "exclude only",
+			filters: types.PortFilters{Exclude: []netip.Prefix{prefix("10.0.0.0/8"), prefix("fc00::/7")}},
+			want: map[probePmFilterKey]uint8{
+				rule("10.0.0.0/8"): filterExclude,
+				rule("fc00::/7"):   filterExclude,
+			},
+		},
+		{
+			// Everything the includes don't cover, in both families, is
+			// excluded by a /0 rule
+			name:    "include only",
+			filters: types.PortFilters{Include: []netip.Prefix{prefix("192.0.2.0/24")}},
+			want: map[probePmFilterKey]uint8{
+				rule("0.0.0.0/0"):    filterExclude,
+				rule("::/0"):         filterExclude,
+				rule("192.0.2.0/24"): filterInclude,
+			},
+		},
+		{
+			name: "include and exclude",
+			filters: types.PortFilters{
+				Include: []netip.Prefix{prefix("10.0.0.0/8"), prefix("2001:db8::/32")},
+				Exclude: []netip.Prefix{prefix("10.1.0.0/16")},
+			},
+			want: map[probePmFilterKey]uint8{
+				rule("0.0.0.0/0"):     filterExclude,
+				rule("::/0"):          filterExclude,
+				rule("10.0.0.0/8"):    filterInclude,
+				rule("2001:db8::/32"): filterInclude,
+				rule("10.1.0.0/16"):   filterExclude,
+			},
+		},
+		{
+			// An include of everything overrides the implicit /0 exclude
+			name:    "include all IPv4",
+			filters: types.PortFilters{Include: []netip.Prefix{prefix("0.0.0.0/0")}},
+			want: map[probePmFilterKey]uint8{
+				rule("0.0.0.0/0"): filterInclude,
+				rule("::/0"):      filterExclude,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := filterRules(key, tt.filters)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %d rules, want %d", tt.name, len(got), len(tt.want))
+		}
+		for k, action := range tt.want {
+			if got[k] != action {
+				t.Errorf("%s: rule %+v = %d, want %d", tt.name, k, got[k], action)
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+)
+
+// MapLimits sets the capacity of the BPF maps that grow with the number of
+// monitored services and tracked connections.
+type MapLimits struct {
+	MaxPorts       int // Monitored ports and port ranges
+	MaxConnections int // Tracked TCP connections
+}
+
+// DefaultMapLimits are the capacities compiled into probe.c.
+var DefaultMapLimits = MapLimits{MaxPorts: 64, MaxConnections: 10240}
+
+// withDefaults fills unset limits from DefaultMapLimits.
+func (m MapLimits) withDefaults() MapLimits {
+	if m.MaxPorts <= 0 {
+		m.MaxPorts = DefaultMapLimits.MaxPorts
+	}
+	if m.MaxConnections <= 0 {
+		m.MaxConnections = DefaultMapLimits.MaxConnections
+	}
+	return m
+}
+
+// perServiceMaps are the maps with one entry per monitored service and
+// role.
+var perServiceMaps = []string{"port_stats_map", "wire_stats_map", "filtered_stats_map"}
+
+// apply rewrites the capacity of the affected maps in spec.
+func (m MapLimits) apply(spec *ebpf.CollectionSpec) error {
+	for _, name := range perServiceMaps {
+		ms, ok := spec.Maps[name]
+		if !ok {
+			return fmt.Errorf("missing map %q", name)
+		}
+		ms.MaxEntries = uint32(m.MaxPorts * 2) // Server and client role
+	}
+
+	ms, ok := spec.Maps["conn_stats_map"]
+	if !ok {
+		return errors.New(`missing map "conn_stats_map"`)
+	}
+	ms.MaxEntries = uint32(m.MaxConnections)
+	return nil
+}
+
+// MapUsage is the fill level of a BPF map and the number of inserts that
+// failed because it was full.
+type MapUsage struct {
+	Name       string
+	Entries    int // Zero for maps that can't be counted, like ring buffers
+	MaxEntries int
+	Drops      uint64
+}
+
+// GetMapUsage returns the fill level and failed inserts of each map that
+// can run out of space.
+func (l *Loader) GetMapUsage() ([]MapUsage, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return nil, errors.New("eBPF programs not loaded")
+	}
+
+	// In the order of their PM_MAP_* index in map_errors
+	maps := []struct {
+		name string
+		m    *ebpf.Map
+	}{
+		{"port_stats_map", l.objs.PortStatsMap},
+		{"member_stats_map", l.objs.MemberStatsMap},
+		{"filtered_stats_map", l.objs.FilteredStatsMap},
+		{"wire_stats_map", l.objs.WireStatsMap},
+		{"proc_stats_map", l.objs.ProcStatsMap},
+		{"cgroup_stats_map", l.objs.CgroupStatsMap},
+		{"conn_stats_map", l.objs.ConnStatsMap},
+		{"conn_events", l.objs.ConnEvents},
+		{"addr_stats_map", l.objs.AddrStatsMap},
+	}
+
+	var usage []MapUsage
+	for i, entry := range maps {
+		var perCPU []uint64
+		if err := l.objs.MapErrors.Lookup(uint32(i), &perCPU); err != nil {
+			return nil, fmt.Errorf("looking up %s errors: %w", entry.name, err)
+		}
+
+		u := MapUsage{Name: entry.name}
+		for _, n := range perCPU {
+			u.Drops += n
+		}
+
+		if entry.m.Type() != ebpf.RingBuf {
+			n, err := countEntries(entry.m)
+			if err != nil {
+				return nil, fmt.Errorf("counting %s entries: %w", entry.name, err)
+			}
+			u.Entries = n
+			u.MaxEntries = int(entry.m.MaxEntries())
+		}
+
+		usage = append(usage, u)
+	}
+
+	return usage, nil
+}
+
+// countEntries returns the number of keys in a hash map. Concurrent
+// deletes can restart the walk, so it stops at the map's capacity.
+func countEntries(m *ebpf.Map) (int, error) {
+	var n int
+	var key interface{}
+	for n < int(m.MaxEntries()) {
+		next, err := m.NextKeyBytes(key)
+		if err != nil {
+			return 0, err
+		}
+		if next == nil {
+			break
+		}
+		n++
+		key = next
+	}
+	return n, nil
+}
@@ -1,16 +1,19 @@
 // Package ebpf handles loading and managing eBPF programs for traffic monitoring.
 package ebpf
 
-//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -target amd64 -type pm_port_stats -type pm_conn_key -type pm_conn_stats probe ./bpf/probe.c -- -I./bpf -O2 -g -Wall
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -target amd64 -type pm_port_key -type pm_port_target -type pm_port_stats -type pm_filter_key -type pm_proc_key -type pm_proc_stats -type pm_cgroup_key -type pm_addr_key -type pm_conn_key -type pm_conn_stats -type pm_conn_event probe ./bpf/probe.c -- -I./bpf -O2 -g -Wall
 
 import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 	"sync"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/wellsgz/portmon/internal/types"
 )
 
 // Loader handles loading and attaching eBPF programs.
@@ -18,11 +21,43 @@ type Loader struct {
 	objs  *probeObjects
 	links []link.Link
 	mu    sync.Mutex
+
+	// targets maps the BPF stats key of each monitored service (the first
+	// port of a range) back to its full PortKey.
+	targets map[probePmPortKey]types.PortKey
+
+	// filters holds the cidr_filters keys written for each service, so
+	// they can be removed when its filters change.
+	filters map[probePmPortKey][]probePmFilterKey
+
+	// localAddrs holds the local_addrs keys written for each service
+	// pinned to local addresses.
+	localAddrs map[probePmPortKey][]probePmAddrKey
+
+	// tracingErrs holds why each fentry program not loaded couldn't be,
+	// and attach how the probes were attached.
+	tracingErrs map[string]error
+	attach      AttachInfo
+
+	// pinPath is where the counter maps are pinned, empty to not pin.
+	pinPath    string
+	pinsReused bool
+
+	limits MapLimits
 }
 
-// NewLoader creates a new eBPF loader.
-func NewLoader() *Loader {
-	return &Loader{}
+// NewLoader creates a new eBPF loader that pins its counter maps under
+// pinPath, or doesn't pin them if pinPath is empty. Unset limits default
+// to DefaultMapLimits.
+func NewLoader(pinPath string, limits MapLimits) *Loader {
+	return &Loader{
+		pinPath: pinPath,
+		limits:  limits.withDefaults(),
+		targets: make(map[probePmPortKey]types.PortKey),
+		filters: make(map[probePmPortKey][]probePmFilterKey),
+
+		localAddrs: make(map[probePmPortKey][]probePmAddrKey),
+	}
 }
 
 // Load loads the eBPF programs and maps.
@@ -34,17 +69,36 @@ func (l *Loader) Load() error {
 		return errors.New("eBPF programs already loaded")
 	}
 
-	objs := &probeObjects{}
-	if err := loadProbeObjects(objs, nil); err != nil {
+	spec, err := loadProbe()
+	if err != nil {
+		return fmt.Errorf("loading eBPF spec: %w", err)
+	}
+
+	if err := l.limits.apply(spec); err != nil {
+		return fmt.Errorf("sizing eBPF maps: %w", err)
+	}
+
+	// Drop fentry programs the kernel can't load; their hooks fall back to
+	// kprobes or tracepoints at attach time
+	unsupported := probeTracing(spec)
+	for name := range unsupported {
+		delete(spec.Programs, name)
+	}
+
+	objs, err := l.loadPinned(spec)
+	if err != nil {
 		return fmt.Errorf("loading eBPF objects: %w", err)
 	}
 
 	l.objs = objs
+	l.tracingErrs = unsupported
 	slog.Info("eBPF programs loaded successfully")
 	return nil
 }
 
-// Attach attaches the kprobes to the kernel.
+// Attach attaches the probes to the kernel. The TCP data path hooks use
+// fentry where the kernel supports it, falling back to kprobes and then to
+// the generic socket tracepoints.
 func (l *Loader) Attach() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -53,27 +107,132 @@ func (l *Loader) Attach() error {
 		return errors.New("eBPF programs not loaded")
 	}
 
-	// Attach kprobe for tcp_sendmsg
-	sendmsgLink, err := link.Kprobe("tcp_sendmsg", l.objs.TraceTcpSendmsg, nil)
-	if err != nil {
-		return fmt.Errorf("attaching tcp_sendmsg kprobe: %w", err)
+	l.attach = AttachInfo{}
+	for _, h := range l.dataHooks() {
+		mode, err := l.attachDataHook(h)
+		if err != nil && h.optional && errors.Is(err, os.ErrNotExist) {
+			slog.Info("skipping data hook missing from kernel", "function", h.symbol)
+			continue
+		}
+		if err != nil && h.optional {
+			slog.Warn("skipping optional data hook", "function", h.symbol, "error", err)
+			l.attach.Degraded = append(l.attach.Degraded, fmt.Sprintf("%s: not attached (%v)", h.symbol, err))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("attaching %s: %w", h.symbol, err)
+		}
+		if l.attach.Mode == "" {
+			l.attach.Mode = mode
+		} else if l.attach.Mode != mode {
+			l.attach.Mode = AttachMixed
+		}
 	}
-	l.links = append(l.links, sendmsgLink)
-	slog.Info("attached kprobe", "function", "tcp_sendmsg")
 
-	// Attach kprobe for tcp_cleanup_rbuf
-	cleanupLink, err := link.Kprobe("tcp_cleanup_rbuf", l.objs.TraceTcpCleanupRbuf, nil)
+	// Attach tracepoint for TCP state changes (counts connects and failed
+	// handshakes, evicts closed connections)
+	stateLink, err := link.Tracepoint("sock", "inet_sock_set_state", l.objs.TraceInetSockSetState, nil)
 	if err != nil {
-		return fmt.Errorf("attaching tcp_cleanup_rbuf kprobe: %w", err)
+		return fmt.Errorf("attaching inet_sock_set_state tracepoint: %w", err)
+	}
+	l.links = append(l.links, stateLink)
+	slog.Info("attached tracepoint", "name", "sock/inet_sock_set_state")
+
+	// Attach TCP health tracepoints. These are optional; without them only
+	// the retransmit and reset counters stay at zero.
+	tcpTracepoints := []struct {
+		name string
+		prog *ebpf.Program
+	}{
+		{"tcp_retransmit_skb", l.objs.TraceTcpRetransmitSkb},
+		{"tcp_send_reset", l.objs.TraceTcpSendReset},
+		{"tcp_receive_reset", l.objs.TraceTcpReceiveReset},
+	}
+	for _, tp := range tcpTracepoints {
+		lnk, err := link.Tracepoint("tcp", tp.name, tp.prog, nil)
+		if err != nil {
+			slog.Warn("skipping optional tracepoint", "name", "tcp/"+tp.name, "error", err)
+			l.attach.Degraded = append(l.attach.Degraded, fmt.Sprintf("tcp/%s: not attached (%v)", tp.name, err))
+			continue
+		}
+		l.links = append(l.links, lnk)
+		slog.Info("attached tracepoint", "name", "tcp/"+tp.name)
+	}
+
+	// Attach the accept probe. It is optional; without it the accept
+	// counter stays at zero. Initiated and failed connections are counted
+	// by the inet_sock_set_state tracepoint.
+	if lnk, err := link.Kretprobe("inet_csk_accept", l.objs.TraceInetCskAcceptRet, nil); err != nil {
+		slog.Warn("skipping optional probe", "function", "inet_csk_accept", "return", true, "error", err)
+		l.attach.Degraded = append(l.attach.Degraded, fmt.Sprintf("inet_csk_accept: not attached (%v)", err))
+	} else {
+		l.links = append(l.links, lnk)
+		slog.Info("attached kprobe", "function", "inet_csk_accept", "return", true)
+	}
+
+	// Attach TCP segment probes. These are optional; without them TCP
+	// packet counts stay at zero while byte and operation counts go on.
+	segmentProbes := []struct {
+		symbol string
+		prog   *ebpf.Program
+	}{
+		{"__tcp_transmit_skb", l.objs.TraceTcpTransmitSkb},
+		{"tcp_rcv_established", l.objs.TraceTcpRcvEstablished},
+	}
+	for _, p := range segmentProbes {
+		lnk, err := link.Kprobe(p.symbol, p.prog, nil)
+		if err != nil {
+			slog.Warn("skipping optional probe", "function", p.symbol, "error", err)
+			l.attach.Degraded = append(l.attach.Degraded, fmt.Sprintf("%s: not attached (%v)", p.symbol, err))
+			continue
+		}
+		l.links = append(l.links, lnk)
+		slog.Info("attached kprobe", "function", p.symbol)
+	}
+
+	// Attach UDP probes. The IPv6 variants are optional since IPv6 may be
+	// disabled or built as a module that isn't loaded.
+	udpProbes := []struct {
+		symbol   string
+		prog     *ebpf.Program
+		ret      bool
+		optional bool
+	}{
+		{"udp_sendmsg", l.objs.TraceUdpSendmsg, false, false},
+		{"udp_sendmsg", l.objs.TraceUdpSendmsgRet, true, false},
+		{"udp_recvmsg", l.objs.TraceUdpRecvmsg, false, false},
+		{"udp_recvmsg", l.objs.TraceUdpRecvmsgRet, true, false},
+		{"udpv6_sendmsg", l.objs.TraceUdpv6Sendmsg, false, true},
+		{"udpv6_sendmsg", l.objs.TraceUdpSendmsgRet, true, true},
+		{"udpv6_recvmsg", l.objs.TraceUdpRecvmsg, false, true},
+		{"udpv6_recvmsg", l.objs.TraceUdpRecvmsgRet, true, true},
+	}
+	for _, p := range udpProbes {
+		var lnk link.Link
+		if p.ret {
+			lnk, err = link.Kretprobe(p.symbol, p.prog, nil)
+		} else {
+			lnk, err = link.Kprobe(p.symbol, p.prog, nil)
+		}
+		if err != nil {
+			if p.optional {
+				slog.Warn("skipping optional probe", "function", p.symbol, "error", err)
+				l.attach.Degraded = append(l.attach.Degraded, fmt.Sprintf("%s: not attached (%v)", p.symbol, err))
+				continue
+			}
+			return fmt.Errorf("attaching %s probe: %w", p.symbol, err)
+		}
+		l.links = append(l.links, lnk)
+		slog.Info("attached kprobe", "function", p.symbol, "return", p.ret)
 	}
-	l.links = append(l.links, cleanupLink)
-	slog.Info("attached kprobe", "function", "tcp_cleanup_rbuf")
 
 	return nil
 }
 
-// AddPort adds a port to the monitoring list.
-func (l *Loader) AddPort(port uint16) error {
+// AddPort adds a port or port range to the monitoring list. All ports of a
+// range are aggregated under the range's first port. roles selects whether
+// server-side traffic, client-side traffic or both are counted.
+func (l *Loader) AddPort(key types.PortKey, roles uint8) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -81,17 +240,38 @@ func (l *Loader) AddPort(port uint16) error {
 		return errors.New("eBPF programs not loaded")
 	}
 
-	enabled := uint8(1)
-	if err := l.objs.TargetPorts.Put(port, enabled); err != nil {
-		return fmt.Errorf("adding port %d to target_ports map: %w", port, err)
+	for _, existing := range l.targets {
+		if existing != key && existing.Overlaps(key) {
+			return fmt.Errorf("port %s overlaps monitored port %s", key, existing)
+		}
+	}
+
+	if _, ok := l.targets[toProbePortKey(key)]; !ok && len(l.targets) >= l.limits.MaxPorts {
+		return fmt.Errorf("port %s: max_ports limit of %d reached", key, l.limits.MaxPorts)
 	}
 
-	slog.Info("added port to monitoring", "port", port)
+	if roles&types.RoleBoth == 0 {
+		return fmt.Errorf("port %s: no roles to count", key)
+	}
+
+	target := probePmPortTarget{Service: key.Port, Roles: roles & types.RoleBoth}
+	if key.IsRange() {
+		target.Range = 1
+	}
+	if err := l.updatePortTargets(key, target); err != nil {
+		return fmt.Errorf("adding port %s to target map: %w", key, err)
+	}
+	if err := l.updateTargetBits(key, true); err != nil {
+		return fmt.Errorf("adding port %s to target_ports map: %w", key, err)
+	}
+
+	l.targets[toProbePortKey(key)] = key
+	slog.Info("added port to monitoring", "port", key, "role", types.RolesName(roles))
 	return nil
 }
 
-// RemovePort removes a port from the monitoring list.
-func (l *Loader) RemovePort(port uint16) error {
+// RemovePort removes a port or port range from the monitoring list.
+func (l *Loader) RemovePort(key types.PortKey) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -99,19 +279,155 @@ func (l *Loader) RemovePort(port uint16) error {
 		return errors.New("eBPF programs not loaded")
 	}
 
-	if err := l.objs.TargetPorts.Delete(port); err != nil {
-		if errors.Is(err, ebpf.ErrKeyNotExist) {
-			return nil // Port wasn't being monitored
-		}
-		return fmt.Errorf("removing port %d from target_ports map: %w", port, err)
+	if _, ok := l.targets[toProbePortKey(key)]; !ok {
+		return nil // Port wasn't being monitored
+	}
+
+	delete(l.targets, toProbePortKey(key))
+
+	// Clear the bitmap first so no new traffic resolves a stale service
+	if err := l.updateTargetBits(key, false); err != nil {
+		return fmt.Errorf("removing port %s from target_ports map: %w", key, err)
+	}
+	if err := l.clearPortTargets(key); err != nil {
+		return fmt.Errorf("removing port %s from target map: %w", key, err)
+	}
+	if err := l.clearFilters(key); err != nil {
+		return fmt.Errorf("removing filters of port %s: %w", key, err)
+	}
+	if err := l.clearLocalAddrs(key); err != nil {
+		return fmt.Errorf("removing local addresses of port %s: %w", key, err)
 	}
 
-	slog.Info("removed port from monitoring", "port", port)
+	slog.Info("removed port from monitoring", "port", key)
 	return nil
 }
 
-// GetPortStats retrieves current statistics for a specific port.
-func (l *Loader) GetPortStats(port uint16) (*probePmPortStats, error) {
+// updateTargetBits sets or clears the target_ports bits for every port in
+// key. The bitmap is shared by all namespaces, so a bit stays set while
+// another monitored target still covers its port. Callers must hold l.mu.
+func (l *Loader) updateTargetBits(key types.PortKey, set bool) error {
+	base := protoIndex(key.Protocol) * portWords
+
+	var indices []uint32
+	var words []uint64
+	for w := uint32(key.Port >> 6); w <= uint32(key.Last()>>6); w++ {
+		idx := base + w
+		var word uint64
+		if err := l.objs.TargetPorts.Lookup(idx, &word); err != nil {
+			return err
+		}
+		for bit := uint32(0); bit < 64; bit++ {
+			port := w<<6 | bit
+			if port < uint32(key.Port) || port > uint32(key.Last()) {
+				continue
+			}
+			if set || l.targeted(uint16(port), key.Protocol) {
+				word |= 1 << bit
+			} else {
+				word &^= 1 << bit
+			}
+		}
+		indices = append(indices, idx)
+		words = append(words, word)
+	}
+
+	_, err := l.objs.TargetPorts.BatchUpdate(indices, words, nil)
+	return err
+}
+
+// updatePortTargets writes target as the target entry of every port in
+// key: port_targets for unscoped keys, netns_targets for keys scoped to a
+// network namespace. Callers must hold l.mu.
+func (l *Loader) updatePortTargets(key types.PortKey, target probePmPortTarget) error {
+	if key.Netns != 0 {
+		keys := netnsTargetKeys(key)
+		values := make([]probePmPortTarget, len(keys))
+		for i := range values {
+			values[i] = target
+		}
+		_, err := l.objs.NetnsTargets.BatchUpdate(keys, values, nil)
+		return err
+	}
+
+	base := protoIndex(key.Protocol) * 65536
+
+	n := int(key.Last()) - int(key.Port) + 1
+	indices := make([]uint32, n)
+	values := make([]probePmPortTarget, n)
+	for i := range indices {
+		indices[i] = base + uint32(key.Port) + uint32(i)
+		values[i] = target
+	}
+
+	_, err := l.objs.PortTargets.BatchUpdate(indices, values, nil)
+	return err
+}
+
+// clearPortTargets removes the target entries written by updatePortTargets.
+// Callers must hold l.mu.
+func (l *Loader) clearPortTargets(key types.PortKey) error {
+	if key.Netns != 0 {
+		_, err := l.objs.NetnsTargets.BatchDelete(netnsTargetKeys(key), nil)
+		return err
+	}
+	return l.updatePortTargets(key, probePmPortTarget{})
+}
+
+// netnsTargetKeys returns the netns_targets keys of every port in key.
+func netnsTargetKeys(key types.PortKey) []probePmPortKey {
+	keys := make([]probePmPortKey, 0, int(key.Last())-int(key.Port)+1)
+	for port := int(key.Port); port <= int(key.Last()); port++ {
+		keys = append(keys, probePmPortKey{Netns: key.Netns, Port: uint16(port), Protocol: key.Protocol})
+	}
+	return keys
+}
+
+// portWords is the number of 64-bit target_ports words per protocol.
+const portWords = 65536 / 64
+
+// protoIndex returns the per-protocol section of the target maps.
+func protoIndex(proto uint8) uint32 {
+	if proto == types.ProtocolUDP {
+		return 1
+	}
+	return 0
+}
+
+// serviceFor returns the monitored service containing port as seen in the
+// network namespace netns. Like the probe, a service scoped to netns takes
+// precedence over an unscoped one. Callers must hold l.mu.
+func (l *Loader) serviceFor(port uint16, proto uint8, netns uint32) (types.PortKey, bool) {
+	var found types.PortKey
+	var ok bool
+	for _, key := range l.targets {
+		if key.Protocol != proto || !key.Contains(port) {
+			continue
+		}
+		if key.Netns == netns && netns != 0 {
+			return key, true
+		}
+		if key.Netns == 0 {
+			found, ok = key, true
+		}
+	}
+	return found, ok
+}
+
+// targeted reports whether any monitored service, in any namespace,
+// contains port. Callers must hold l.mu.
+func (l *Loader) targeted(port uint16, proto uint8) bool {
+	for _, key := range l.targets {
+		if key.Protocol == proto && key.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPortStats retrieves current statistics for a specific port, summed
+// over both roles.
+func (l *Loader) GetPortStats(key types.PortKey) (*probePmPortStats, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -119,19 +435,29 @@ func (l *Loader) GetPortStats(port uint16) (*probePmPortStats, error) {
 		return nil, errors.New("eBPF programs not loaded")
 	}
 
-	var stats probePmPortStats
-	if err := l.objs.PortStatsMap.Lookup(port, &stats); err != nil {
-		if errors.Is(err, ebpf.ErrKeyNotExist) {
-			return &probePmPortStats{}, nil // No stats yet
+	var total probePmPortStats
+	for _, role := range []uint8{types.RoleServer, types.RoleClient} {
+		pk := toProbePortKey(key)
+		pk.Role = role
+
+		var perCPU []probePmPortStats
+		if err := l.objs.PortStatsMap.Lookup(pk, &perCPU); err != nil {
+			if errors.Is(err, ebpf.ErrKeyNotExist) {
+				continue // No stats yet
+			}
+			return nil, fmt.Errorf("looking up port stats: %w", err)
+		}
+		for i := range perCPU {
+			addStats(&total, &perCPU[i])
 		}
-		return nil, fmt.Errorf("looking up port stats: %w", err)
 	}
 
-	return &stats, nil
+	return &total, nil
 }
 
-// GetAllPortStats retrieves statistics for all monitored ports.
-func (l *Loader) GetAllPortStats() (map[uint16]*probePmPortStats, error) {
+// GetAllPortStats retrieves statistics for all monitored ports, summed
+// over both roles. Rates and connection counts are left unset.
+func (l *Loader) GetAllPortStats() (map[types.PortKey]*types.PortStats, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -139,20 +465,141 @@ func (l *Loader) GetAllPortStats() (map[uint16]*probePmPortStats, error) {
 		return nil, errors.New("eBPF programs not loaded")
 	}
 
-	result := make(map[uint16]*probePmPortStats)
+	result := make(map[types.PortKey]*probePmPortStats)
 
-	var port uint16
-	var stats probePmPortStats
+	var key probePmPortKey
+	var perCPU []probePmPortStats
 	iter := l.objs.PortStatsMap.Iterate()
-	for iter.Next(&port, &stats) {
-		statsCopy := stats
-		result[port] = &statsCopy
+	for iter.Next(&key, &perCPU) {
+		port := l.portKey(key)
+		if result[port] == nil {
+			result[port] = &probePmPortStats{}
+		}
+		for i := range perCPU {
+			addStats(result[port], &perCPU[i])
+		}
 	}
 
 	if err := iter.Err(); err != nil {
 		return nil, fmt.Errorf("iterating port stats: %w", err)
 	}
 
+	stats := make(map[types.PortKey]*types.PortStats, len(result))
+	for port, total := range result {
+		stats[port] = toPortStats(port, total)
+	}
+	return stats, nil
+}
+
+// toPortStats converts a port's summed BPF counters into the shared
+// PortStats type.
+func toPortStats(key types.PortKey, s *probePmPortStats) *types.PortStats {
+	return &types.PortStats{
+		Port:      key.Port,
+		PortEnd:   key.PortEnd,
+		Protocol:  key.Protocol,
+		Netns:     key.Netns,
+		RxBytes:   s.RxBytes,
+		TxBytes:   s.TxBytes,
+		RxPackets: s.RxPackets,
+		TxPackets: s.TxPackets,
+		RxOps:     s.RxOps,
+		TxOps:     s.TxOps,
+		Health: types.TCPHealth{
+			Retransmits: s.Retransmits,
+			Resets:      s.Resets,
+			RTT:         s.RttHist,
+		},
+		Handshakes: types.HandshakeStats{
+			Accepted:  s.Accepts,
+			Initiated: s.Connects,
+			Failed:    s.Failures,
+		},
+		Sizes: types.SizeStats{
+			Send: s.TxSizeHist,
+			Recv: s.RxSizeHist,
+		},
+	}
+}
+
+// ReadBreakdowns reads the breakdown counters from the eBPF maps. A map
+// that can't be read is left nil.
+func (l *Loader) ReadBreakdowns() *Breakdowns {
+	var b Breakdowns
+	var err error
+
+	b.Roles, err = l.GetAllRoleStats()
+	if err != nil {
+		slog.Debug("failed to get role stats", "error", err)
+	}
+
+	b.Filtered, err = l.GetAllFilteredStats()
+	if err != nil {
+		slog.Debug("failed to get filtered stats", "error", err)
+	}
+
+	b.Wire, err = l.GetAllWireStats()
+	if err != nil {
+		slog.Debug("failed to get wire stats", "error", err)
+	}
+
+	b.Processes, err = l.GetAllProcessStats()
+	if err != nil {
+		slog.Debug("failed to get process stats", "error", err)
+	}
+
+	b.Cgroups, err = l.GetAllCgroupStats()
+	if err != nil {
+		slog.Debug("failed to get cgroup stats", "error", err)
+	}
+
+	b.LocalAddrs, err = l.GetAllLocalAddrStats()
+	if err != nil {
+		slog.Debug("failed to get local address stats", "error", err)
+	}
+
+	return &b
+}
+
+// toTrafficStats converts summed BPF counters into a share of a port's
+// traffic.
+func toTrafficStats(s *probePmPortStats) *types.TrafficStats {
+	return &types.TrafficStats{
+		RxBytes:   s.RxBytes,
+		TxBytes:   s.TxBytes,
+		RxPackets: s.RxPackets,
+		TxPackets: s.TxPackets,
+	}
+}
+
+// GetMemberPortStats retrieves per-port statistics for the members of a
+// port range, keyed by port. Ports without traffic are omitted.
+func (l *Loader) GetMemberPortStats(key types.PortKey) (map[uint16]*types.PortStats, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return nil, errors.New("eBPF programs not loaded")
+	}
+
+	result := make(map[uint16]*types.PortStats)
+
+	var member probePmPortKey
+	var stats probePmPortStats
+	iter := l.objs.MemberStatsMap.Iterate()
+	for iter.Next(&member, &stats) {
+		if member.Protocol != key.Protocol || member.Netns != key.Netns || !key.Contains(member.Port) {
+			continue
+		}
+		ps := toPortStats(types.PortKey{Port: member.Port, Protocol: member.Protocol, Netns: member.Netns}, &stats)
+		ps.Connections = stats.Connections
+		result[member.Port] = ps
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterating member port stats: %w", err)
+	}
+
 	return result, nil
 }
 
@@ -183,7 +630,7 @@ func (l *Loader) GetConnectionStats() (map[probePmConnKey]*probePmConnStats, err
 }
 
 // ClearPortStats resets statistics for a port.
-func (l *Loader) ClearPortStats(port uint16) error {
+func (l *Loader) ClearPortStats(key types.PortKey) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -191,17 +638,46 @@ func (l *Loader) ClearPortStats(port uint16) error {
 		return errors.New("eBPF programs not loaded")
 	}
 
-	zeroStats := probePmPortStats{}
-	if err := l.objs.PortStatsMap.Put(port, zeroStats); err != nil {
-		return fmt.Errorf("clearing port stats: %w", err)
+	// Missing per-CPU values are written as zero
+	var zeroStats []probePmPortStats
+	for _, role := range []uint8{types.RoleServer, types.RoleClient} {
+		pk := toProbePortKey(key)
+		pk.Role = role
+		if err := l.objs.PortStatsMap.Put(pk, zeroStats); err != nil {
+			return fmt.Errorf("clearing port stats: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// addStats adds the counters of s to total.
+func addStats(total, s *probePmPortStats) {
+	total.RxBytes += s.RxBytes
+	total.TxBytes += s.TxBytes
+	total.RxPackets += s.RxPackets
+	total.TxPackets += s.TxPackets
+	total.RxOps += s.RxOps
+	total.TxOps += s.TxOps
+	total.Connections += s.Connections
+	total.Retransmits += s.Retransmits
+	total.Resets += s.Resets
+	total.Accepts += s.Accepts
+	total.Connects += s.Connects
+	total.Failures += s.Failures
+	for i := range total.RttHist {
+		total.RttHist[i] += s.RttHist[i]
+	}
+	for i := range total.TxSizeHist {
+		total.TxSizeHist[i] += s.TxSizeHist[i]
+		total.RxSizeHist[i] += s.RxSizeHist[i]
+	}
+}
+
 // CountActiveConnections counts actual entries in conn_stats_map per port.
 // This gives accurate active connection counts instead of cumulative totals.
-func (l *Loader) CountActiveConnections() (map[uint16]uint64, error) {
+// Only TCP connections are tracked, so all keys are TCP ports.
+func (l *Loader) CountActiveConnections() (map[types.PortKey]uint64, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -209,23 +685,19 @@ func (l *Loader) CountActiveConnections() (map[uint16]uint64, error) {
 		return nil, errors.New("eBPF programs not loaded")
 	}
 
-	counts := make(map[uint16]uint64)
+	counts := make(map[types.PortKey]uint64)
 
 	var key probePmConnKey
 	var stats probePmConnStats
 	iter := l.objs.ConnStatsMap.Iterate()
 	for iter.Next(&key, &stats) {
-		// Count connections where sport OR dport matches a monitored port
-		// We check which port is monitored by looking at both
-		var enabled uint8
-
-		// Check if sport is monitored
-		if err := l.objs.TargetPorts.Lookup(key.Sport, &enabled); err == nil && enabled == 1 {
-			counts[key.Sport]++
+		// Count connections where sport OR dport matches a monitored port,
+		// attributing range members to their service
+		if svc, ok := l.serviceFor(key.Sport, types.ProtocolTCP, key.Netns); ok {
+			counts[svc]++
 		}
-		// Check if dport is monitored
-		if err := l.objs.TargetPorts.Lookup(key.Dport, &enabled); err == nil && enabled == 1 {
-			counts[key.Dport]++
+		if svc, ok := l.serviceFor(key.Dport, types.ProtocolTCP, key.Netns); ok {
+			counts[svc]++
 		}
 	}
 
@@ -236,6 +708,22 @@ func (l *Loader) CountActiveConnections() (map[uint16]uint64, error) {
 	return counts, nil
 }
 
+// openEventReader opens a reader on the connection events ring buffer.
+func (l *Loader) openEventReader() (*ringbuf.Reader, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return nil, errors.New("eBPF programs not loaded")
+	}
+
+	rd, err := ringbuf.NewReader(l.objs.ConnEvents)
+	if err != nil {
+		return nil, fmt.Errorf("opening conn_events ring buffer: %w", err)
+	}
+	return rd, nil
+}
+
 // Close releases all eBPF resources.
 func (l *Loader) Close() error {
 	l.mu.Lock()
@@ -267,6 +755,21 @@ func (l *Loader) Close() error {
 	return nil
 }
 
+// toProbePortKey converts a PortKey into the BPF map key.
+func toProbePortKey(k types.PortKey) probePmPortKey {
+	return probePmPortKey{Netns: k.Netns, Port: k.Port, Protocol: k.Protocol}
+}
+
+// portKey converts a BPF stats key into the PortKey of the service it
+// belongs to. Callers must hold l.mu.
+func (l *Loader) portKey(k probePmPortKey) types.PortKey {
+	k.Role = 0
+	if key, ok := l.targets[k]; ok {
+		return key
+	}
+	return types.PortKey{Port: k.Port, Protocol: k.Protocol, Netns: k.Netns}
+}
+
 // IsLoaded returns true if eBPF programs are loaded.
 func (l *Loader) IsLoaded() bool {
 	l.mu.Lock()
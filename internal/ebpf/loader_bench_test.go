@@ -0,0 +1,87 @@
+package ebpf
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// BenchmarkTCPSend measures the overhead the probes add to each send on a
+// monitored port, as the difference between the "detached" and "attached"
+// ns/op. Writers run in parallel, so contention on the port's stats entry
+// shows up as the CPU count grows. To compare map layouts, run it on both
+// revisions and feed the results to benchstat:
+//
+//	sudo go test ./internal/ebpf -run '^$' -bench TCPSend -cpu 1,4,16 -count 10
+//
+// Loading the programs requires root; the attached case is skipped
+// otherwise.
+func BenchmarkTCPSend(b *testing.B) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	addr := ln.Addr().String()
+	b.Run("detached", func(b *testing.B) { benchmarkSend(b, addr) })
+
+	l := NewLoader("", MapLimits{})
+	if err := l.Load(); err != nil {
+		b.Skipf("loading eBPF programs: %v", err)
+	}
+	defer l.Close()
+
+	if err := l.Attach(); err != nil {
+		b.Fatalf("Attach: %v", err)
+	}
+	port := types.TCPPort(uint16(ln.Addr().(*net.TCPAddr).Port))
+	if err := l.AddPort(port, types.RoleBoth); err != nil {
+		b.Fatalf("AddPort: %v", err)
+	}
+
+	b.Run("attached", func(b *testing.B) { benchmarkSend(b, addr) })
+
+	stats, err := l.GetPortStats(port)
+	if err != nil {
+		b.Fatalf("GetPortStats: %v", err)
+	}
+	if stats.TxPackets == 0 {
+		b.Error("no sends counted on the monitored port")
+	}
+}
+
+// benchmarkSend writes small messages to addr from parallel connections.
+func benchmarkSend(b *testing.B, addr string) {
+	b.RunParallel(func(pb *testing.PB) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 64)
+		for pb.Next() {
+			if _, err := conn.Write(buf); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
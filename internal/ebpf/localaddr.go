@@ -0,0 +1,136 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+
+	"github.com/cilium/ebpf"
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// SetLocalAddrs pins a monitored port to the given local addresses, so
+// traffic on the port's other addresses isn't counted. No addresses count
+// every local address.
+func (l *Loader) SetLocalAddrs(key types.PortKey, addrs []netip.Addr) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return errors.New("eBPF programs not loaded")
+	}
+
+	if _, ok := l.targets[toProbePortKey(key)]; !ok {
+		return fmt.Errorf("port %s is not monitored", key)
+	}
+
+	if err := l.clearLocalAddrs(key); err != nil {
+		return fmt.Errorf("clearing local addresses of port %s: %w", key, err)
+	}
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	// The marker goes in last, so the port isn't briefly pinned to nothing
+	keys := make([]probePmAddrKey, 0, len(addrs)+1)
+	for _, addr := range addrs {
+		keys = append(keys, addrKey(key, addr))
+	}
+	keys = append(keys, probePmAddrKey{Netns: key.Netns, Port: key.Port, Protocol: key.Protocol})
+
+	pk := toProbePortKey(key)
+	for _, k := range keys {
+		if err := l.objs.LocalAddrs.Put(k, uint8(1)); err != nil {
+			return fmt.Errorf("pinning port %s to local address: %w", key, err)
+		}
+		l.localAddrs[pk] = append(l.localAddrs[pk], k)
+	}
+
+	slog.Info("pinned port to local addresses", "port", key, "addrs", addrs)
+	return nil
+}
+
+// clearLocalAddrs removes the local address pins of a port, the marker
+// first. Callers must hold l.mu.
+func (l *Loader) clearLocalAddrs(key types.PortKey) error {
+	pk := toProbePortKey(key)
+	keys := l.localAddrs[pk]
+	for i := len(keys) - 1; i >= 0; i-- {
+		if err := l.objs.LocalAddrs.Delete(keys[i]); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return err
+		}
+	}
+	delete(l.localAddrs, pk)
+	return nil
+}
+
+// addrKey returns the pm_addr_key of a local address under a port.
+// Addresses are laid out like pm_conn_key: network byte order, with IPv4
+// in the first word.
+func addrKey(key types.PortKey, addr netip.Addr) probePmAddrKey {
+	k := probePmAddrKey{
+		Netns:    key.Netns,
+		Port:     key.Port,
+		Protocol: key.Protocol,
+		Family:   afInet6,
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		k.Family = afInet
+	}
+
+	var buf [16]byte
+	copy(buf[:], addr.AsSlice())
+	for i := range k.Addr {
+		k.Addr[i] = binary.NativeEndian.Uint32(buf[i*4:])
+	}
+	return k
+}
+
+// addr returns the local address of a pm_addr_key.
+func (k probePmAddrKey) addr() netip.Addr {
+	var buf [16]byte
+	for i, w := range k.Addr {
+		binary.NativeEndian.PutUint32(buf[i*4:], w)
+	}
+	if k.Family == afInet {
+		return netip.AddrFrom4([4]byte(buf[:4]))
+	}
+	return netip.AddrFrom16(buf)
+}
+
+// GetAllLocalAddrStats retrieves per-local-address statistics for all
+// monitored services.
+func (l *Loader) GetAllLocalAddrStats() (map[LocalAddrKey]*types.LocalAddrStats, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return nil, errors.New("eBPF programs not loaded")
+	}
+
+	result := make(map[LocalAddrKey]*types.LocalAddrStats)
+
+	var key probePmAddrKey
+	var stats probePmPortStats
+	iter := l.objs.AddrStatsMap.Iterate()
+	for iter.Next(&key, &stats) {
+		port := l.portKey(probePmPortKey{Netns: key.Netns, Port: key.Port, Protocol: key.Protocol})
+		result[LocalAddrKey{Port: port, Addr: key.addr()}] = &types.LocalAddrStats{
+			Addr:        key.addr(),
+			RxBytes:     stats.RxBytes,
+			TxBytes:     stats.TxBytes,
+			RxPackets:   stats.RxPackets,
+			TxPackets:   stats.TxPackets,
+			Connections: stats.Connections,
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterating local address stats: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,42 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+func TestAddrKey(t *testing.T) {
+	key := types.NewPortKey(443, 0, types.ProtocolTCP)
+
+	tests := []struct {
+		addr   string
+		want   string
+		family uint8
+	}{
+		{"203.0.113.5", "203.0.113.5", afInet},
+		{"::ffff:203.0.113.5", "203.0.113.5", afInet},
+		{"2001:db8::7", "2001:db8::7", afInet6},
+	}
+
+	for _, tt := range tests {
+		k := addrKey(key, netip.MustParseAddr(tt.addr))
+		if k.Port != 443 || k.Family != tt.family {
+			t.Errorf("addrKey(%s) = port %d family %d, want port 443 family %d", tt.addr, k.Port, k.Family, tt.family)
+		}
+		if got := k.addr().String(); got != tt.want {
+			t.Errorf("addrKey(%s).addr() = %s, want %s", tt.addr, got, tt.want)
+		}
+	}
+
+	// IPv4 addresses sit in the first word, in network byte order, like
+	// the probes' connection keys
+	k := addrKey(key, netip.MustParseAddr("203.0.113.5"))
+	var first [4]byte
+	binary.NativeEndian.PutUint32(first[:], k.Addr[0])
+	if first != [4]byte{203, 0, 113, 5} || k.Addr[1] != 0 {
+		t.Errorf("addrKey(203.0.113.5).Addr = %v, want 203.0.113.5 in word 0", k.Addr)
+	}
+}
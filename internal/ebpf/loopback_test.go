@@ -0,0 +1,155 @@
+package ebpf
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// TestLoopbackTransfer sends a known number of bytes over loopback, through
+// both write and sendfile, and checks the port counts exactly that. The
+// reader starts late so the writer's socket buffer fills and sends come up
+// short or fail with EAGAIN, which must not be counted at their requested
+// size. Loading the programs requires root; the test is skipped otherwise.
+//
+//	sudo go test ./internal/ebpf -run LoopbackTransfer
+func TestLoopbackTransfer(t *testing.T) {
+	const (
+		written = 8 << 20
+		sent    = 3<<20 + 12345 // Not a multiple of the page size
+	)
+
+	l := NewLoader("", MapLimits{})
+	if err := l.Load(); err != nil {
+		t.Skipf("loading eBPF programs: %v", err)
+	}
+	defer l.Close()
+
+	if err := l.Attach(); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	port := types.TCPPort(uint16(ln.Addr().(*net.TCPAddr).Port))
+	if err := l.AddPort(port, types.RoleBoth); err != nil {
+		t.Fatalf("AddPort: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(file, bytes.Repeat([]byte{'x'}, sent), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan int64, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- -1
+			return
+		}
+		defer conn.Close()
+
+		time.Sleep(200 * time.Millisecond)
+		n, _ := io.Copy(io.Discard, conn)
+		received <- n
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Write(make([]byte, written)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// TCPConn.ReadFrom uses sendfile, which is tcp_sendpage before Linux
+	// 6.5 and tcp_sendmsg after
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := conn.(*net.TCPConn).ReadFrom(f); err != nil {
+		t.Fatalf("sendfile: %v", err)
+	}
+	conn.Close()
+
+	if n := <-received; n != written+sent {
+		t.Fatalf("receiver read %d bytes, want %d", n, written+sent)
+	}
+
+	stats, err := l.GetPortStats(port)
+	if err != nil {
+		t.Fatalf("GetPortStats: %v", err)
+	}
+	if stats.TxBytes != written+sent {
+		t.Errorf("TxBytes = %d, want %d", stats.TxBytes, written+sent)
+	}
+	if stats.RxBytes != written+sent {
+		t.Errorf("RxBytes = %d, want %d", stats.RxBytes, written+sent)
+	}
+}
+
+// TestLoopbackUDPFailedSend checks that a UDP send that fails, here with
+// EMSGSIZE, isn't counted at its requested size. Loading the programs
+// requires root; the test is skipped otherwise.
+//
+//	sudo go test ./internal/ebpf -run LoopbackUDPFailedSend
+func TestLoopbackUDPFailedSend(t *testing.T) {
+	const sent = 1234
+
+	l := NewLoader("", MapLimits{})
+	if err := l.Load(); err != nil {
+		t.Skipf("loading eBPF programs: %v", err)
+	}
+	defer l.Close()
+
+	if err := l.Attach(); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	port := types.NewPortKey(uint16(server.LocalAddr().(*net.UDPAddr).Port), 0, types.ProtocolUDP)
+	if err := l.AddPort(port, types.RoleBoth); err != nil {
+		t.Fatalf("AddPort: %v", err)
+	}
+
+	conn, err := net.Dial("udp4", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(make([]byte, sent)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	// Larger than the largest IPv4 datagram
+	if _, err := conn.Write(make([]byte, 70000)); err == nil {
+		t.Fatal("oversized write succeeded")
+	}
+
+	stats, err := l.GetPortStats(port)
+	if err != nil {
+		t.Fatalf("GetPortStats: %v", err)
+	}
+	if stats.TxBytes != sent {
+		t.Errorf("TxBytes = %d, want %d", stats.TxBytes, sent)
+	}
+}
@@ -0,0 +1,252 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultPinPath is the bpffs directory the counter maps are pinned under,
+// so they outlive the daemon across restarts and upgrades.
+const DefaultPinPath = "/sys/fs/bpf/portmon"
+
+// PinnedMapsReused reports whether Load reused the maps pinned by an
+// earlier run, so their counters include traffic from before this run.
+func (l *Loader) PinnedMapsReused() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.pinsReused
+}
+
+// ResetPinnedMaps removes the maps pinned by earlier runs, so the next Load
+// starts counting from zero.
+func (l *Loader) ResetPinnedMaps() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs != nil {
+		return errors.New("eBPF programs already loaded")
+	}
+	if l.pinPath == "" {
+		return nil
+	}
+
+	if err := os.RemoveAll(l.pinPath); err != nil {
+		return fmt.Errorf("removing pinned maps: %w", err)
+	}
+	slog.Info("removed pinned maps", "path", l.pinPath)
+	return nil
+}
+
+// loadPinned loads spec, reusing the maps pinned under l.pinPath when they
+// are compatible. Pins sized by other map limits, or of single-value maps
+// since made per-CPU, are migrated keeping their entries; other
+// incompatible pins, e.g. from a version with a different map layout, are
+// replaced. If pinning is unavailable the maps are created unpinned.
+// Callers must hold l.mu.
+func (l *Loader) loadPinned(spec *ebpf.CollectionSpec) (*probeObjects, error) {
+	names := pinnedMaps(spec)
+	if l.pinPath == "" || len(names) == 0 {
+		unpinSpec(spec)
+		return loadObjects(spec, ebpf.CollectionOptions{})
+	}
+
+	if err := os.MkdirAll(l.pinPath, 0o700); err != nil {
+		slog.Warn("map pinning unavailable, counters reset on restart", "path", l.pinPath, "error", err)
+		unpinSpec(spec)
+		return loadObjects(spec, ebpf.CollectionOptions{})
+	}
+
+	// Only a complete set of pins is reused; the counters must agree
+	existing := 0
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(l.pinPath, name)); err == nil {
+			existing++
+		}
+	}
+	if existing > 0 && existing < len(names) {
+		slog.Warn("discarding incomplete set of pinned maps", "path", l.pinPath)
+		l.removePins(names)
+		existing = 0
+	}
+
+	// A change of the map limits or a move to per-CPU values keeps the
+	// layout of the entries; carry the counters over instead of letting
+	// the mismatch discard them below
+	if existing == len(names) {
+		if err := l.migratePins(spec, names); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := ebpf.CollectionOptions{Maps: ebpf.MapOptions{PinPath: l.pinPath}}
+	objs, err := loadObjects(spec, opts)
+	if errors.Is(err, ebpf.ErrMapIncompatible) {
+		slog.Warn("pinned maps incompatible, recreating", "path", l.pinPath, "error", err)
+		l.removePins(names)
+		existing = 0
+		objs, err = loadObjects(spec, opts)
+	}
+	if err != nil {
+		slog.Warn("loading pinned maps failed, counters reset on restart", "path", l.pinPath, "error", err)
+		unpinSpec(spec)
+		return loadObjects(spec, ebpf.CollectionOptions{})
+	}
+
+	l.pinsReused = existing == len(names)
+	slog.Info("pinned maps", "path", l.pinPath, "reused", l.pinsReused)
+	return objs, nil
+}
+
+// migratePins replaces each pinned map that migratable finds differing
+// from spec with a map created from spec holding the same entries. The old
+// pin is only replaced once its entries are copied, so a failure leaves it
+// intact. Callers must hold l.mu.
+func (l *Loader) migratePins(spec *ebpf.CollectionSpec, names []string) error {
+	for _, name := range names {
+		path := filepath.Join(l.pinPath, name)
+		old, err := ebpf.LoadPinnedMap(path, nil)
+		if err != nil {
+			continue // Left to loading, which handles unusable pins
+		}
+
+		ms := spec.Maps[name]
+		if !migratable(ms, old) {
+			old.Close()
+			continue
+		}
+
+		oldType, oldEntries := old.Type(), old.MaxEntries()
+		err = migratePin(ms, old, path)
+		old.Close()
+		if err != nil {
+			return fmt.Errorf("migrating pinned map %s from %s with %d entries to %s with %d: %w (run with --reset-maps to discard the counters)",
+				name, oldType, oldEntries, ms.Type, ms.MaxEntries, err)
+		}
+		if oldType != ms.Type {
+			slog.Info("converted pinned map to per-CPU values, counters kept", "map", name, "from", oldType, "to", ms.Type)
+		}
+		if oldEntries != ms.MaxEntries {
+			slog.Info("resized pinned map", "map", name, "max_entries", ms.MaxEntries)
+		}
+	}
+	return nil
+}
+
+// perCPUVariants maps single-value map types to their per-CPU variant.
+var perCPUVariants = map[ebpf.MapType]ebpf.MapType{
+	ebpf.Hash:    ebpf.PerCPUHash,
+	ebpf.LRUHash: ebpf.LRUCPUHash,
+	ebpf.Array:   ebpf.PerCPUArray,
+}
+
+// migratable reports whether m has the entry layout of ms and differs
+// from it only in its capacity, in holding a single value where ms holds
+// one per CPU, or both.
+func migratable(ms *ebpf.MapSpec, m *ebpf.Map) bool {
+	if m.KeySize() != ms.KeySize || m.ValueSize() != ms.ValueSize || m.Flags() != ms.Flags {
+		return false
+	}
+	if m.Type() == ms.Type {
+		return m.MaxEntries() != ms.MaxEntries
+	}
+	return perCPUVariants[m.Type()] == ms.Type
+}
+
+// migratePin creates a map from ms, copies the entries of old into it and
+// pins it at path in place of old.
+func migratePin(ms *ebpf.MapSpec, old *ebpf.Map, path string) error {
+	ms = ms.Copy()
+	ms.Pinning = ebpf.PinNone
+	m, err := ebpf.NewMap(ms)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := copyEntries(m, old); err != nil {
+		return err
+	}
+
+	tmp := path + "_migrate" // bpffs rejects names with dots
+	if err := m.Pin(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// copyEntries copies every entry of src into dst, failing if dst can't hold
+// them all. Single values copied into a per-CPU map go to the first CPU, so
+// the per-CPU sum is the old value.
+func copyEntries(dst, src *ebpf.Map) error {
+	var (
+		key    []byte
+		value  []byte
+		values [][]byte // Per-CPU maps
+	)
+	srcPerCPU, dstPerCPU := perCPU(src.Type()), perCPU(dst.Type())
+	out := any(&value)
+	if srcPerCPU {
+		out = &values
+	}
+
+	it := src.Iterate()
+	for it.Next(&key, out) {
+		in := any(value)
+		switch {
+		case srcPerCPU:
+			in = values
+		case dstPerCPU:
+			in = [][]byte{value} // The other CPUs start at zero
+		}
+		if err := dst.Update(key, in, ebpf.UpdateAny); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// perCPU reports whether maps of type t hold a value per CPU.
+func perCPU(t ebpf.MapType) bool {
+	switch t {
+	case ebpf.PerCPUHash, ebpf.PerCPUArray, ebpf.LRUCPUHash, ebpf.PerCPUCGroupStorage:
+		return true
+	}
+	return false
+}
+
+// removePins deletes the pins of the named maps. Callers must hold l.mu.
+func (l *Loader) removePins(names []string) {
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(l.pinPath, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("failed to remove pinned map", "map", name, "error", err)
+		}
+	}
+}
+
+// pinnedMaps returns the names of the maps in spec that are pinned by name.
+func pinnedMaps(spec *ebpf.CollectionSpec) []string {
+	var names []string
+	for name, m := range spec.Maps {
+		if m.Pinning == ebpf.PinByName {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// unpinSpec clears the pinning of every map in spec.
+func unpinSpec(spec *ebpf.CollectionSpec) {
+	for _, m := range spec.Maps {
+		m.Pinning = ebpf.PinNone
+	}
+}
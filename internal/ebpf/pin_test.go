@@ -0,0 +1,186 @@
+package ebpf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+// pinTestSpec returns a spec with a per-CPU and a plain hash map pinned by
+// name, holding up to n entries each.
+func pinTestSpec(n uint32) *ebpf.CollectionSpec {
+	return &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+		"counts": {Name: "counts", Type: ebpf.PerCPUHash, KeySize: 4, ValueSize: 8, MaxEntries: n, Pinning: ebpf.PinByName},
+		"conns":  {Name: "conns", Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: n, Pinning: ebpf.PinByName},
+	}}
+}
+
+// pinTestMaps creates the maps of spec pinned under dir and fills each with
+// the given number of entries. Creating maps requires root and a bpffs
+// under /sys/fs/bpf; the test is skipped otherwise.
+func pinTestMaps(t *testing.T, spec *ebpf.CollectionSpec, dir string, entries uint32) {
+	t.Helper()
+
+	coll, err := ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{Maps: ebpf.MapOptions{PinPath: dir}})
+	if err != nil {
+		t.Skipf("creating pinned maps: %v", err)
+	}
+	defer coll.Close()
+
+	for k := uint32(0); k < entries; k++ {
+		if err := coll.Maps["counts"].Update(k, []uint64{uint64(k) + 100}, ebpf.UpdateAny); err != nil {
+			t.Fatal(err)
+		}
+		if err := coll.Maps["conns"].Update(k, uint64(k)+200, ebpf.UpdateAny); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func pinTestDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("/sys/fs/bpf", "portmon-test-")
+	if err != nil {
+		t.Skipf("bpffs unavailable: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// TestResizePinsKeepsCounts checks that a change of the map limits carries
+// the pinned counters over into maps of the new capacity.
+func TestResizePinsKeepsCounts(t *testing.T) {
+	dir := pinTestDir(t)
+	pinTestMaps(t, pinTestSpec(4), dir, 3)
+
+	spec := pinTestSpec(16)
+	l := NewLoader(dir, MapLimits{})
+	if err := l.migratePins(spec, pinnedMaps(spec)); err != nil {
+		t.Fatalf("migratePins: %v", err)
+	}
+
+	counts, err := ebpf.LoadPinnedMap(filepath.Join(dir, "counts"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer counts.Close()
+	conns, err := ebpf.LoadPinnedMap(filepath.Join(dir, "conns"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conns.Close()
+
+	for name, m := range map[string]*ebpf.Map{"counts": counts, "conns": conns} {
+		if err := spec.Maps[name].Compatible(m); err != nil {
+			t.Errorf("resized %s still incompatible: %v", name, err)
+		}
+	}
+
+	for k := uint32(0); k < 3; k++ {
+		var perCPU []uint64
+		if err := counts.Lookup(k, &perCPU); err != nil {
+			t.Fatalf("counts[%d]: %v", k, err)
+		}
+		var sum uint64
+		for _, v := range perCPU {
+			sum += v
+		}
+		if sum != uint64(k)+100 {
+			t.Errorf("counts[%d] = %d, want %d", k, sum, k+100)
+		}
+
+		var v uint64
+		if err := conns.Lookup(k, &v); err != nil {
+			t.Fatalf("conns[%d]: %v", k, err)
+		}
+		if v != uint64(k)+200 {
+			t.Errorf("conns[%d] = %d, want %d", k, v, k+200)
+		}
+	}
+}
+
+// TestResizePinsTooSmall checks that shrinking the maps below their pinned
+// entries fails, pointing at --reset-maps, and leaves the pins intact.
+func TestResizePinsTooSmall(t *testing.T) {
+	dir := pinTestDir(t)
+	pinTestMaps(t, pinTestSpec(8), dir, 6)
+
+	spec := pinTestSpec(4)
+	l := NewLoader(dir, MapLimits{})
+	err := l.migratePins(spec, pinnedMaps(spec))
+	if err == nil || !strings.Contains(err.Error(), "--reset-maps") {
+		t.Fatalf("migratePins = %v, want error mentioning --reset-maps", err)
+	}
+
+	for _, name := range []string{"counts", "conns"} {
+		m, err := ebpf.LoadPinnedMap(filepath.Join(dir, name), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := countEntries(m)
+		m.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 6 {
+			t.Errorf("%s has %d entries after failed resize, want 6", name, n)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "counts_migrate")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary pin left behind: %v", err)
+	}
+}
+
+// TestMigratePinsToPerCPU checks that the pin of a single-value map, as
+// written before port_stats_map became per-CPU, is converted keeping its
+// counters.
+func TestMigratePinsToPerCPU(t *testing.T) {
+	dir := pinTestDir(t)
+
+	old := pinTestSpec(4)
+	old.Maps["counts"].Type = ebpf.Hash
+	coll, err := ebpf.NewCollectionWithOptions(old, ebpf.CollectionOptions{Maps: ebpf.MapOptions{PinPath: dir}})
+	if err != nil {
+		t.Skipf("creating pinned maps: %v", err)
+	}
+	for k := uint32(0); k < 3; k++ {
+		if err := coll.Maps["counts"].Update(k, uint64(k)+100, ebpf.UpdateAny); err != nil {
+			t.Fatal(err)
+		}
+	}
+	coll.Close()
+
+	spec := pinTestSpec(4)
+	l := NewLoader(dir, MapLimits{})
+	if err := l.migratePins(spec, pinnedMaps(spec)); err != nil {
+		t.Fatalf("migratePins: %v", err)
+	}
+
+	counts, err := ebpf.LoadPinnedMap(filepath.Join(dir, "counts"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer counts.Close()
+	if err := spec.Maps["counts"].Compatible(counts); err != nil {
+		t.Fatalf("converted map still incompatible: %v", err)
+	}
+
+	for k := uint32(0); k < 3; k++ {
+		var perCPU []uint64
+		if err := counts.Lookup(k, &perCPU); err != nil {
+			t.Fatalf("counts[%d]: %v", k, err)
+		}
+		var sum uint64
+		for _, v := range perCPU {
+			sum += v
+		}
+		if sum != uint64(k)+100 {
+			t.Errorf("counts[%d] = %d, want %d", k, sum, k+100)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// GetAllProcessStats retrieves per-process statistics for all monitored
+// services.
+func (l *Loader) GetAllProcessStats() (map[ProcessKey]*types.ProcessStats, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return nil, errors.New("eBPF programs not loaded")
+	}
+
+	result := make(map[ProcessKey]*types.ProcessStats)
+
+	var key probePmProcKey
+	var stats probePmProcStats
+	iter := l.objs.ProcStatsMap.Iterate()
+	for iter.Next(&key, &stats) {
+		port := l.portKey(probePmPortKey{Netns: key.Netns, Port: key.Port, Protocol: key.Protocol})
+		result[ProcessKey{Port: port, Tgid: key.Tgid}] = &types.ProcessStats{
+			Pid:     stats.Pid,
+			Tgid:    key.Tgid,
+			Comm:    commString(stats.Comm),
+			RxBytes: stats.RxBytes,
+			TxBytes: stats.TxBytes,
+			RxOps:   stats.RxOps,
+			TxOps:   stats.TxOps,
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterating process stats: %w", err)
+	}
+
+	return result, nil
+}
+
+// commString converts a NUL-terminated task comm into a string.
+func commString(comm [16]int8) string {
+	b := make([]byte, 0, len(comm))
+	for _, c := range comm {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
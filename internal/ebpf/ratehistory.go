@@ -0,0 +1,91 @@
+package ebpf
+
+import (
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// RateHistoryLen is the number of per-second rate samples kept per port:
+// the last 15 minutes.
+const RateHistoryLen = 15 * 60
+
+// rateHistory is a fixed-size ring of a port's per-second rates. Samples
+// are aligned to wall-clock seconds: the first poll in a new second closes
+// the seconds since the previous one, each at the average rate in between.
+// With a poll interval above a second, the skipped seconds share a rate.
+type rateHistory struct {
+	samples [RateHistoryLen]types.RateSample
+	next    int // Index the next sample is written at
+	count   int
+
+	// Averages of the latest samples over each rate window
+	smoothed types.SmoothedRates
+
+	second int64 // Unix second of the last observation
+	last   time.Time
+	lastRx uint64
+	lastTx uint64
+}
+
+// observe records a port's cumulative byte counters read at now, and
+// reports whether it closed any samples.
+func (h *rateHistory) observe(now time.Time, rx, tx uint64) bool {
+	sec := now.Unix()
+	if h.last.IsZero() {
+		h.second, h.last, h.lastRx, h.lastTx = sec, now, rx, tx
+		return false
+	}
+	if sec <= h.second {
+		return false
+	}
+
+	// Counters only go backwards if the maps were reset; count that as idle
+	var rxRate, txRate float64
+	if elapsed := now.Sub(h.last).Seconds(); elapsed > 0 && rx >= h.lastRx && tx >= h.lastTx {
+		rxRate = float64(rx-h.lastRx) / elapsed
+		txRate = float64(tx-h.lastTx) / elapsed
+	}
+	for s := max(h.second, sec-RateHistoryLen); s < sec; s++ {
+		h.samples[h.next] = types.RateSample{Time: time.Unix(s, 0), RxRate: rxRate, TxRate: txRate}
+		h.next = (h.next + 1) % RateHistoryLen
+		h.count = min(h.count+1, RateHistoryLen)
+	}
+
+	h.second, h.last, h.lastRx, h.lastTx = sec, now, rx, tx
+	h.smooth()
+	return true
+}
+
+// smooth averages the latest samples over each rate window. Seconds before
+// the first sample count as idle, so like load averages the longer windows
+// start from zero rather than from a few busy seconds.
+func (h *rateHistory) smooth() {
+	var rx, tx float64
+	w := 0
+	for n := 1; w < len(types.RateWindows); n++ {
+		if n <= h.count {
+			s := h.samples[(h.next-n+RateHistoryLen)%RateHistoryLen]
+			rx += s.RxRate
+			tx += s.TxRate
+		}
+		if time.Duration(n)*time.Second == types.RateWindows[w] {
+			h.smoothed[w] = types.Rate{RxRate: rx / float64(n), TxRate: tx / float64(n)}
+			w++
+		}
+	}
+}
+
+// recent returns up to n of the latest samples, oldest first. n <= 0
+// returns every sample kept.
+func (h *rateHistory) recent(n int) []types.RateSample {
+	if n <= 0 || n > h.count {
+		n = h.count
+	}
+	result := make([]types.RateSample, n)
+	start := h.next - n + RateHistoryLen
+	for i := range result {
+		result[i] = h.samples[(start+i)%RateHistoryLen]
+	}
+	return result
+}
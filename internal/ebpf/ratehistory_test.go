@@ -0,0 +1,110 @@
+package ebpf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateHistory(t *testing.T) {
+	var h rateHistory
+	start := time.Unix(1000, 0)
+
+	// The first observation only sets the baseline, and later polls in the
+	// same second don't close it
+	h.observe(start.Add(100*time.Millisecond), 0, 0)
+	h.observe(start.Add(600*time.Millisecond), 500, 50)
+	if got := h.recent(0); len(got) != 0 {
+		t.Fatalf("recent after one second = %d samples, want 0", len(got))
+	}
+
+	h.observe(start.Add(1100*time.Millisecond), 1000, 100)
+	// A three-second gap fills the skipped seconds at the average rate
+	h.observe(start.Add(4100*time.Millisecond), 4000, 100)
+	// Counters going backwards read as idle
+	h.observe(start.Add(5100*time.Millisecond), 10, 0)
+
+	got := h.recent(0)
+	want := []struct {
+		sec    int64
+		rx, tx float64
+	}{
+		{1000, 1000, 100},
+		{1001, 1000, 0},
+		{1002, 1000, 0},
+		{1003, 1000, 0},
+		{1004, 0, 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("recent = %d samples, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].Time.Unix() != w.sec || got[i].RxRate != w.rx || got[i].TxRate != w.tx {
+			t.Errorf("sample %d = %d %.0f/%.0f, want %d %.0f/%.0f", i,
+				got[i].Time.Unix(), got[i].RxRate, got[i].TxRate, w.sec, w.rx, w.tx)
+		}
+	}
+
+	if got := h.recent(2); len(got) != 2 || got[0].Time.Unix() != 1003 {
+		t.Errorf("recent(2) starts at %v, want 1003", got)
+	}
+}
+
+func TestRateHistoryWraps(t *testing.T) {
+	var h rateHistory
+	start := time.Unix(1000, 0)
+
+	// After an idle gap longer than the ring, only the latest seconds are
+	// kept
+	h.observe(start, 0, 0)
+	h.observe(start.Add(2*RateHistoryLen*time.Second), 0, 0)
+	for i := int64(1); i <= 10; i++ {
+		h.observe(start.Add(time.Duration(2*RateHistoryLen+i)*time.Second), uint64(i)*100, 0)
+	}
+
+	got := h.recent(0)
+	if len(got) != RateHistoryLen {
+		t.Fatalf("recent = %d samples, want %d", len(got), RateHistoryLen)
+	}
+	last := start.Unix() + 2*RateHistoryLen + 9
+	for i, s := range got {
+		if want := last - RateHistoryLen + 1 + int64(i); s.Time.Unix() != want {
+			t.Fatalf("sample %d at %d, want %d", i, s.Time.Unix(), want)
+		}
+	}
+	if got[len(got)-1].RxRate != 100 || got[len(got)-11].RxRate != 0 {
+		t.Errorf("rx rates = %.0f ... %.0f, want 0 ... 100", got[len(got)-11].RxRate, got[len(got)-1].RxRate)
+	}
+}
+
+func TestRateHistorySmoothed(t *testing.T) {
+	var h rateHistory
+	start := time.Unix(1000, 0)
+
+	// 20 seconds at 1000 bytes/sec, then 10 idle ones
+	h.observe(start, 0, 0)
+	var rx uint64
+	for i := int64(1); i <= 30; i++ {
+		if i <= 20 {
+			rx += 1000
+		}
+		h.observe(start.Add(time.Duration(i)*time.Second), rx, 0)
+	}
+
+	// The longer windows count the seconds before the first sample as idle
+	want := map[time.Duration]float64{
+		time.Second:      0,
+		10 * time.Second: 0,
+		time.Minute:      20000.0 / 60,
+		5 * time.Minute:  20000.0 / 300,
+	}
+	for w, rate := range want {
+		if got := h.smoothed.Window(w); got.RxRate != rate || got.TxRate != 0 {
+			t.Errorf("%s rate = %.1f/%.1f, want %.1f/0", w, got.RxRate, got.TxRate, rate)
+		}
+	}
+
+	h.observe(start.Add(31*time.Second), rx+500, 0)
+	if got := h.smoothed.Window(10 * time.Second).RxRate; got != 50 {
+		t.Errorf("10s rate after a burst = %.1f, want 50", got)
+	}
+}
@@ -0,0 +1,37 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// GetAllRoleStats retrieves per-role statistics for all monitored services.
+func (l *Loader) GetAllRoleStats() (map[RoleKey]*types.TrafficStats, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return nil, errors.New("eBPF programs not loaded")
+	}
+
+	result := make(map[RoleKey]*types.TrafficStats)
+
+	var key probePmPortKey
+	var perCPU []probePmPortStats
+	iter := l.objs.PortStatsMap.Iterate()
+	for iter.Next(&key, &perCPU) {
+		var total probePmPortStats
+		for i := range perCPU {
+			addStats(&total, &perCPU[i])
+		}
+		result[RoleKey{Port: l.portKey(key), Role: key.Role}] = toTrafficStats(&total)
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterating port stats: %w", err)
+	}
+
+	return result, nil
+}
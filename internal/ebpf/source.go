@@ -0,0 +1,113 @@
+package ebpf
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// StatsSource supplies the per-port counters the Collector polls and keeps
+// the list of monitored ports. The Loader reads them from the eBPF maps;
+// other sources let the daemon run, or be tested, without eBPF.
+type StatsSource interface {
+	// GetAllPortStats returns the cumulative counters of every port with
+	// traffic. Rates and connection counts are left unset, and the caller
+	// owns the returned stats.
+	GetAllPortStats() (map[types.PortKey]*types.PortStats, error)
+
+	// CountActiveConnections returns the number of open connections per
+	// port.
+	CountActiveConnections() (map[types.PortKey]uint64, error)
+
+	// GetActiveConnections returns the open connections where either the
+	// local or remote port matches port.
+	GetActiveConnections(port uint16) ([]types.ActiveConnection, error)
+
+	// AddPort starts counting traffic on a port or port range, for the
+	// given roles.
+	AddPort(key types.PortKey, roles uint8) error
+
+	// RemovePort stops counting traffic on a port. Its counters are kept.
+	RemovePort(key types.PortKey) error
+
+	// AttachInfo reports how traffic is collected, and what isn't.
+	AttachInfo() AttachInfo
+}
+
+// The interfaces below are optional: a StatsSource implements those it
+// can, and the Collector and Server leave out what the source lacks.
+
+// BreakdownSource is a StatsSource that splits the port counters further.
+type BreakdownSource interface {
+	// ReadBreakdowns returns the cumulative breakdown counters. Rates are
+	// left unset, and the caller owns the returned stats.
+	ReadBreakdowns() *Breakdowns
+}
+
+// MemberStatsSource is a StatsSource that counts the ports inside a port
+// range separately.
+type MemberStatsSource interface {
+	// GetMemberPortStats returns the counters of the members of a port
+	// range with traffic, keyed by port.
+	GetMemberPortStats(key types.PortKey) (map[uint16]*types.PortStats, error)
+}
+
+// MapUsageSource is a StatsSource kept in maps that can run full.
+type MapUsageSource interface {
+	GetMapUsage() ([]MapUsage, error)
+}
+
+// IdleEvicter is a StatsSource that needs idle connections swept from its
+// connection table.
+type IdleEvicter interface {
+	// EvictIdleConnections removes connections idle for longer than
+	// maxIdle and returns how many it removed.
+	EvictIdleConnections(maxIdle time.Duration) (int, error)
+}
+
+// Breakdowns holds the counters of a BreakdownSource besides the port
+// totals. A nil map isn't kept by the source, or couldn't be read, and
+// keeps its last values.
+type Breakdowns struct {
+	Roles      map[RoleKey]*types.TrafficStats
+	Filtered   map[types.PortKey]*types.TrafficStats // Excluded by filters
+	Wire       map[types.PortKey]*types.TrafficStats // Summed over both roles
+	Processes  map[ProcessKey]*types.ProcessStats
+	Cgroups    map[CgroupKey]*types.CgroupStats // Path, Unit and Container unset
+	LocalAddrs map[LocalAddrKey]*types.LocalAddrStats
+}
+
+// RoleKey identifies the traffic of a monitored service in one role
+// (types.RoleServer or types.RoleClient).
+type RoleKey struct {
+	Port types.PortKey
+	Role uint8
+}
+
+// ProcessKey identifies a process's traffic on a monitored service.
+type ProcessKey struct {
+	Port types.PortKey
+	Tgid uint32
+}
+
+// CgroupKey identifies a cgroup's traffic on a monitored service.
+type CgroupKey struct {
+	Port types.PortKey
+	ID   uint64
+}
+
+// LocalAddrKey identifies a local address's traffic on a monitored
+// service.
+type LocalAddrKey struct {
+	Port types.PortKey
+	Addr netip.Addr
+}
+
+var (
+	_ StatsSource       = (*Loader)(nil)
+	_ BreakdownSource   = (*Loader)(nil)
+	_ MemberStatsSource = (*Loader)(nil)
+	_ MapUsageSource    = (*Loader)(nil)
+	_ IdleEvicter       = (*Loader)(nil)
+)
@@ -16,14 +16,64 @@ type probeObjects struct {
 func (o *probeObjects) Close() error { return nil }
 
 type probePrograms struct {
-	TraceTcpSendmsg     *ebpf.Program
-	TraceTcpCleanupRbuf *ebpf.Program
+	FexitTcpSendmsg        *ebpf.Program `ebpf:"fexit_tcp_sendmsg"`
+	FexitTcpSendpage       *ebpf.Program `ebpf:"fexit_tcp_sendpage"`
+	FentryTcpCleanupRbuf   *ebpf.Program `ebpf:"fentry_tcp_cleanup_rbuf"`
+	TraceTcpSendmsg        *ebpf.Program `ebpf:"trace_tcp_sendmsg"`
+	TraceTcpSendmsgRet     *ebpf.Program `ebpf:"trace_tcp_sendmsg_ret"`
+	TraceTcpCleanupRbuf    *ebpf.Program `ebpf:"trace_tcp_cleanup_rbuf"`
+	TraceSockSendLength    *ebpf.Program `ebpf:"trace_sock_send_length"`
+	TraceSockRecvLength    *ebpf.Program `ebpf:"trace_sock_recv_length"`
+	TraceTcpTransmitSkb    *ebpf.Program `ebpf:"trace_tcp_transmit_skb"`
+	TraceTcpRcvEstablished *ebpf.Program `ebpf:"trace_tcp_rcv_established"`
+	TraceInetCskAcceptRet  *ebpf.Program `ebpf:"trace_inet_csk_accept_ret"`
+	TraceInetSockSetState  *ebpf.Program `ebpf:"trace_inet_sock_set_state"`
+	TraceTcpRetransmitSkb  *ebpf.Program `ebpf:"trace_tcp_retransmit_skb"`
+	TraceTcpSendReset      *ebpf.Program `ebpf:"trace_tcp_send_reset"`
+	TraceTcpReceiveReset   *ebpf.Program `ebpf:"trace_tcp_receive_reset"`
+	TraceUdpSendmsg        *ebpf.Program `ebpf:"trace_udp_sendmsg"`
+	TraceUdpSendmsgRet     *ebpf.Program `ebpf:"trace_udp_sendmsg_ret"`
+	TraceUdpv6Sendmsg      *ebpf.Program `ebpf:"trace_udpv6_sendmsg"`
+	TraceUdpRecvmsg        *ebpf.Program `ebpf:"trace_udp_recvmsg"`
+	TraceUdpRecvmsgRet     *ebpf.Program `ebpf:"trace_udp_recvmsg_ret"`
+	WireIngress            *ebpf.Program `ebpf:"wire_ingress"`
+	WireEgress             *ebpf.Program `ebpf:"wire_egress"`
 }
 
 type probeMaps struct {
-	TargetPorts  *ebpf.Map
-	PortStatsMap *ebpf.Map
-	ConnStatsMap *ebpf.Map
+	TargetPorts      *ebpf.Map `ebpf:"target_ports"`
+	PortTargets      *ebpf.Map `ebpf:"port_targets"`
+	NetnsTargets     *ebpf.Map `ebpf:"netns_targets"`
+	PortStatsMap     *ebpf.Map `ebpf:"port_stats_map"`
+	WireStatsMap     *ebpf.Map `ebpf:"wire_stats_map"`
+	FilteredStatsMap *ebpf.Map `ebpf:"filtered_stats_map"`
+	CidrFilters      *ebpf.Map `ebpf:"cidr_filters"`
+	MemberStatsMap   *ebpf.Map `ebpf:"member_stats_map"`
+	ProcStatsMap     *ebpf.Map `ebpf:"proc_stats_map"`
+	CgroupStatsMap   *ebpf.Map `ebpf:"cgroup_stats_map"`
+	ConnStatsMap     *ebpf.Map `ebpf:"conn_stats_map"`
+	ConnEvents       *ebpf.Map `ebpf:"conn_events"`
+	UdpRecvSocks     *ebpf.Map `ebpf:"udp_recv_socks"`
+	UdpSendSocks     *ebpf.Map `ebpf:"udp_send_socks"`
+	TcpSendSocks     *ebpf.Map `ebpf:"tcp_send_socks"`
+	LocalAddrs       *ebpf.Map `ebpf:"local_addrs"`
+	AddrStatsMap     *ebpf.Map `ebpf:"addr_stats_map"`
+	MapErrors        *ebpf.Map `ebpf:"map_errors"`
+}
+
+// probePmPortKey mirrors the C struct pm_port_key.
+type probePmPortKey struct {
+	Netns    uint32
+	Port     uint16
+	Protocol uint8
+	Role     uint8
+}
+
+// probePmPortTarget mirrors the C struct pm_port_target.
+type probePmPortTarget struct {
+	Service uint16
+	Range   uint8
+	Roles   uint8
 }
 
 // probePmPortStats mirrors the C struct pm_port_stats.
@@ -32,15 +82,76 @@ type probePmPortStats struct {
 	TxBytes     uint64
 	RxPackets   uint64
 	TxPackets   uint64
+	RxOps       uint64
+	TxOps       uint64
 	Connections uint64
+	Retransmits uint64
+	Resets      uint64
+	Accepts     uint64
+	Connects    uint64
+	Failures    uint64
+	RttHist     [24]uint64
+	TxSizeHist  [32]uint64
+	RxSizeHist  [32]uint64
+}
+
+// probePmFilterKey mirrors the C struct pm_filter_key.
+type probePmFilterKey struct {
+	Prefixlen uint32
+	Netns     uint32
+	Service   uint16
+	Protocol  uint8
+	Family    uint8
+	Addr      [4]uint32
+}
+
+// probePmProcKey mirrors the C struct pm_proc_key.
+type probePmProcKey struct {
+	Netns    uint32
+	Tgid     uint32
+	Port     uint16
+	Protocol uint8
+	Pad      uint8
+}
+
+// probePmProcStats mirrors the C struct pm_proc_stats.
+type probePmProcStats struct {
+	RxBytes uint64
+	TxBytes uint64
+	RxOps   uint64
+	TxOps   uint64
+	Pid     uint32
+	Comm    [16]int8
+	Pad     [4]uint8
+}
+
+// probePmCgroupKey mirrors the C struct pm_cgroup_key.
+type probePmCgroupKey struct {
+	CgroupId uint64
+	Netns    uint32
+	Port     uint16
+	Protocol uint8
+	Pad      uint8
+}
+
+// probePmAddrKey mirrors the C struct pm_addr_key.
+type probePmAddrKey struct {
+	Netns    uint32
+	Port     uint16
+	Protocol uint8
+	Family   uint8
+	Addr     [4]uint32
 }
 
 // probePmConnKey mirrors the C struct pm_conn_key.
 type probePmConnKey struct {
-	Saddr uint32
-	Daddr uint32
-	Sport uint16
-	Dport uint16
+	Saddr  [4]uint32
+	Daddr  [4]uint32
+	Sport  uint16
+	Dport  uint16
+	Family uint16
+	Pad    uint16
+	Netns  uint32
 }
 
 // probePmConnStats mirrors the C struct pm_conn_stats.
@@ -51,6 +162,33 @@ type probePmConnStats struct {
 	LastUpdateNs uint64
 }
 
+// probePmConnEvent mirrors the C struct pm_conn_event.
+type probePmConnEvent struct {
+	Saddr   [4]uint32
+	Daddr   [4]uint32
+	StartNs uint64
+	EndNs   uint64
+	RxBytes uint64
+	TxBytes uint64
+	Sport   uint16
+	Dport   uint16
+	Family  uint16
+	Port    uint16
+	Type    uint8
+	Pad     [7]uint8
+}
+
+// probePmUdpCall mirrors the C struct pm_udp_call.
+type probePmUdpCall struct {
+	Sk  uint64
+	Msg uint64
+}
+
+// loadProbe is a stub that returns an error on non-Linux.
+func loadProbe() (*ebpf.CollectionSpec, error) {
+	return nil, errNotLinux
+}
+
 // loadProbeObjects is a stub that returns an error on non-Linux.
 func loadProbeObjects(obj *probeObjects, opts *ebpf.CollectionOptions) error {
 	return errNotLinux
@@ -0,0 +1,82 @@
+package ebpf
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// AttachWire attaches the cgroup_skb programs for wire-level accounting to
+// the cgroup v2 hierarchy mounted at cgroupRoot. Attached to the root
+// cgroup they see every IP packet of every socket on the host, alongside
+// the payload-level probes attached by Attach.
+func (l *Loader) AttachWire(cgroupRoot string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return errors.New("eBPF programs not loaded")
+	}
+
+	root := CgroupV2Root(cgroupRoot)
+	programs := []struct {
+		attach ebpf.AttachType
+		prog   *ebpf.Program
+		name   string
+	}{
+		{ebpf.AttachCGroupInetIngress, l.objs.WireIngress, "ingress"},
+		{ebpf.AttachCGroupInetEgress, l.objs.WireEgress, "egress"},
+	}
+	for _, p := range programs {
+		lnk, err := link.AttachCgroup(link.CgroupOptions{
+			Path:    root,
+			Attach:  p.attach,
+			Program: p.prog,
+		})
+		if err != nil {
+			return fmt.Errorf("attaching cgroup_skb %s program to %s: %w", p.name, root, err)
+		}
+		l.links = append(l.links, lnk)
+		slog.Info("attached cgroup_skb program", "direction", p.name, "cgroup", root)
+	}
+
+	return nil
+}
+
+// GetAllWireStats retrieves wire-level statistics for all monitored ports,
+// summed over both roles. The map is empty unless AttachWire was called.
+func (l *Loader) GetAllWireStats() (map[types.PortKey]*types.TrafficStats, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.objs == nil {
+		return nil, errors.New("eBPF programs not loaded")
+	}
+
+	result := make(map[types.PortKey]*probePmPortStats)
+
+	var key probePmPortKey
+	var s probePmPortStats
+	iter := l.objs.WireStatsMap.Iterate()
+	for iter.Next(&key, &s) {
+		port := l.portKey(key)
+		if result[port] == nil {
+			result[port] = &probePmPortStats{}
+		}
+		addStats(result[port], &s)
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterating wire stats: %w", err)
+	}
+
+	stats := make(map[types.PortKey]*types.TrafficStats, len(result))
+	for port, total := range result {
+		stats[port] = toTrafficStats(total)
+	}
+	return stats, nil
+}
@@ -0,0 +1,56 @@
+// Package netns resolves network namespace references to the namespace
+// inode numbers reported by the eBPF probes.
+package netns
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// RunDir is where "ip netns add" creates named network namespaces.
+const RunDir = "/run/netns"
+
+// Resolve returns the inode of the network namespace spec refers to:
+//
+//   - a path to a namespace file, e.g. /run/netns/blue or /proc/1/ns/net
+//   - a name created by "ip netns add", e.g. blue
+//   - "pid:<pid>", the namespace of a running process
+//   - a bare inode number, as shown by "lsns -t net"
+//
+// A PID is resolved once; if the process restarts in a new namespace the
+// daemon must be restarted to follow it.
+func Resolve(spec string) (uint32, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return 0, fmt.Errorf("empty network namespace")
+	}
+
+	if ino, err := strconv.ParseUint(spec, 10, 32); err == nil && ino != 0 {
+		return uint32(ino), nil
+	}
+
+	path := spec
+	if pidStr, ok := strings.CutPrefix(spec, "pid:"); ok {
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil || pid <= 0 {
+			return 0, fmt.Errorf("invalid pid in network namespace %q", spec)
+		}
+		path = fmt.Sprintf("/proc/%d/ns/net", pid)
+	} else if !strings.ContainsRune(spec, '/') {
+		path = filepath.Join(RunDir, spec)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("resolving network namespace %q: %w", spec, err)
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("resolving network namespace %q: no inode information", spec)
+	}
+	return uint32(st.Ino), nil
+}
@@ -0,0 +1,97 @@
+package netns
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// inode returns the inode of the file at path.
+func inode(t *testing.T, path string) uint32 {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Skipf("stat %s: %v", path, err)
+	}
+	return uint32(info.Sys().(*syscall.Stat_t).Ino)
+}
+
+func TestResolve(t *testing.T) {
+	self := inode(t, "/proc/self/ns/net")
+
+	// Any file resolves to its inode, so a temporary one stands in for a
+	// bind-mounted namespace
+	file := filepath.Join(t.TempDir(), "blue")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spec string
+		want uint32
+	}{
+		{"4026531840", 4026531840},
+		{" 4026531840 ", 4026531840},
+		{"/proc/self/ns/net", self},
+		{fmt.Sprintf("/proc/%d/ns/net", os.Getpid()), self},
+		{fmt.Sprintf("pid:%d", os.Getpid()), self},
+		{file, inode(t, file)},
+	}
+
+	for _, tt := range tests {
+		got, err := Resolve(tt.spec)
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%q) = %d, want %d", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		spec  string
+		error string
+	}{
+		{"", "empty network namespace"},
+		{"   ", "empty network namespace"},
+		{"pid:", "invalid pid"},
+		{"pid:abc", "invalid pid"},
+		{"pid:0", "invalid pid"},
+		{"pid:-1", "invalid pid"},
+		{"pid:999999999", "no such file"},                    // No such process
+		{"portmon-test-missing", "no such file"},             // Looked up in RunDir
+		{"0", "no such file"},                                // Not an inode, so a name
+		{filepath.Join(t.TempDir(), "gone"), "no such file"}, // Missing path
+	}
+
+	for _, tt := range tests {
+		_, err := Resolve(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.error) {
+			t.Errorf("Resolve(%q) error = %v, want %q", tt.spec, err, tt.error)
+		}
+	}
+}
+
+// TestResolveName checks that a bare name is looked up under RunDir.
+func TestResolveName(t *testing.T) {
+	entries, err := os.ReadDir(RunDir)
+	if err != nil || len(entries) == 0 {
+		t.Skipf("no named network namespaces in %s", RunDir)
+	}
+
+	name := entries[0].Name()
+	got, err := Resolve(name)
+	if err != nil {
+		t.Fatalf("Resolve(%q): %v", name, err)
+	}
+	if want := inode(t, filepath.Join(RunDir, name)); got != want {
+		t.Errorf("Resolve(%q) = %d, want %d", name, got, want)
+	}
+}
@@ -0,0 +1,227 @@
+package sockdiag
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inet_diag constants from include/uapi/linux/inet_diag.h and
+// sock_diag.h.
+const (
+	inetDiagInfo = 2 // INET_DIAG_INFO attribute
+
+	sizeofInetDiagReqV2 = 56
+	sizeofInetDiagMsg   = 72
+
+	// Multicast groups announcing destroyed TCP sockets, with their final
+	// tcp_info.
+	sknlgrpInetTCPDestroy  = 1
+	sknlgrpInet6TCPDestroy = 3
+)
+
+// dumpStates selects every TCP state with tcp_info: listening sockets
+// carry no traffic, and time-wait sockets have no tcp_info.
+const dumpStates = 0xfff &^ (1<<tcpListen | 1<<tcpTimeWait)
+
+// dumpTCP returns every IPv4 and IPv6 TCP socket in the daemon's network
+// namespace.
+func dumpTCP() ([]sockInfo, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_SOCK_DIAG)
+	if err != nil {
+		return nil, fmt.Errorf("opening sock_diag socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("binding sock_diag socket: %w", err)
+	}
+
+	var result []sockInfo
+	for seq, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		socks, err := dumpFamily(fd, family, uint32(seq+1))
+		if err != nil {
+			if family == unix.AF_INET6 && errors.Is(err, unix.ENOENT) {
+				continue // IPv6 disabled
+			}
+			return nil, err
+		}
+		result = append(result, socks...)
+	}
+	return result, nil
+}
+
+// dumpFamily dumps the TCP sockets of one address family.
+func dumpFamily(fd int, family uint8, seq uint32) ([]sockInfo, error) {
+	req := make([]byte, unix.SizeofNlMsghdr+sizeofInetDiagReqV2)
+	binary.NativeEndian.PutUint32(req[0:], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:], unix.SOCK_DIAG_BY_FAMILY)
+	binary.NativeEndian.PutUint16(req[6:], unix.NLM_F_REQUEST|unix.NLM_F_DUMP)
+	binary.NativeEndian.PutUint32(req[8:], seq)
+
+	r := req[unix.SizeofNlMsghdr:]
+	r[0] = family
+	r[1] = unix.IPPROTO_TCP
+	r[2] = 1 << (inetDiagInfo - 1)
+	binary.NativeEndian.PutUint32(r[4:], dumpStates)
+
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("sending sock_diag request: %w", err)
+	}
+
+	var result []sockInfo
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("receiving sock_diag reply: %w", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("parsing sock_diag reply: %w", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return result, nil
+			case unix.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := int32(binary.NativeEndian.Uint32(m.Data)); errno < 0 {
+						return nil, fmt.Errorf("sock_diag dump: %w", unix.Errno(-errno))
+					}
+				}
+				return result, nil
+			case unix.SOCK_DIAG_BY_FAMILY:
+				if info, ok := parseDiagMsg(m.Data); ok {
+					result = append(result, info)
+				}
+			}
+		}
+	}
+}
+
+// parseDiagMsg decodes an inet_diag_msg and its tcp_info attribute.
+func parseDiagMsg(data []byte) (sockInfo, bool) {
+	if len(data) < sizeofInetDiagMsg {
+		return sockInfo{}, false
+	}
+
+	// struct inet_diag_sockid follows the four leading bytes
+	id := data[4:52]
+	info := sockInfo{
+		family:     uint16(data[0]),
+		state:      data[1],
+		localPort:  binary.BigEndian.Uint16(id[0:]),
+		remotePort: binary.BigEndian.Uint16(id[2:]),
+		cookie:     uint64(binary.NativeEndian.Uint32(id[40:])) | uint64(binary.NativeEndian.Uint32(id[44:]))<<32,
+	}
+	switch info.family {
+	case unix.AF_INET:
+		info.localAddr = netip.AddrFrom4([4]byte(id[4:8]))
+		info.remoteAddr = netip.AddrFrom4([4]byte(id[20:24]))
+	case unix.AF_INET6:
+		info.localAddr = netip.AddrFrom16([16]byte(id[4:20])).Unmap()
+		info.remoteAddr = netip.AddrFrom16([16]byte(id[20:36])).Unmap()
+	default:
+		return sockInfo{}, false
+	}
+
+	// Attributes, each a struct rtattr padded to four bytes
+	attrs := data[sizeofInetDiagMsg:]
+	for len(attrs) >= unix.SizeofRtAttr {
+		l := int(binary.NativeEndian.Uint16(attrs[0:]))
+		typ := binary.NativeEndian.Uint16(attrs[2:])
+		if l < unix.SizeofRtAttr || l > len(attrs) {
+			break
+		}
+		if typ == inetDiagInfo {
+			// Older kernels send a shorter tcp_info; the rest stays zero
+			var ti unix.TCPInfo
+			copy(unsafe.Slice((*byte)(unsafe.Pointer(&ti)), unix.SizeofTCPInfo), attrs[unix.SizeofRtAttr:l])
+			info.hasInfo = true
+			info.rxBytes = ti.Bytes_received
+			info.txBytes = ti.Bytes_acked
+			info.rxSegs = uint64(ti.Segs_in)
+			info.txSegs = uint64(ti.Segs_out)
+			info.retransmits = uint64(ti.Total_retrans)
+		}
+		attrs = attrs[(l+unix.NLMSG_ALIGNTO-1) & ^(unix.NLMSG_ALIGNTO-1):]
+	}
+
+	return info, true
+}
+
+// listenDestroy subscribes to destroyed TCP sockets and passes each to fn
+// until the returned stop function is called. Joining the groups needs
+// CAP_NET_ADMIN.
+func listenDestroy(fn func(sockInfo)) (func(), error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_SOCK_DIAG)
+	if err != nil {
+		return nil, fmt.Errorf("opening sock_diag socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("binding sock_diag socket: %w", err)
+	}
+	for _, group := range []int{sknlgrpInetTCPDestroy, sknlgrpInet6TCPDestroy} {
+		if err := unix.SetsockoptInt(fd, unix.SOL_NETLINK, unix.NETLINK_ADD_MEMBERSHIP, group); err != nil {
+			unix.Close(fd)
+			return nil, fmt.Errorf("joining sock_diag destroy group %d: %w", group, err)
+		}
+	}
+
+	// Wake up regularly to notice the stop
+	tv := unix.Timeval{Sec: 1}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("setting sock_diag receive timeout: %w", err)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer unix.Close(fd)
+
+		buf := make([]byte, 64*1024)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err != nil {
+				// ENOBUFS means events were dropped; the dumps catch up
+				continue
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				if m.Header.Type != unix.SOCK_DIAG_BY_FAMILY {
+					continue
+				}
+				if info, ok := parseDiagMsg(m.Data); ok {
+					fn(info)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}, nil
+}
@@ -0,0 +1,74 @@
+package sockdiag
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestDumpLoopback sends a known number of bytes over a loopback TCP
+// connection and checks them in the dumped tcp_info of both ends. The
+// client's bytes_acked also counts its SYN.
+func TestDumpLoopback(t *testing.T) {
+	if _, err := dumpTCP(); err != nil {
+		t.Skipf("socket diagnostics unavailable: %v", err)
+	}
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	// Both ends stay open so their sockets keep tcp_info
+	defer peer.Close()
+
+	const size = 100000
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, size))
+		written <- err
+	}()
+	if _, err := io.CopyN(io.Discard, peer, size); err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		socks, err := dumpTCP()
+		if err != nil {
+			t.Fatalf("dumpTCP: %v", err)
+		}
+
+		var rx, tx uint64
+		for _, s := range socks {
+			if s.localPort == port {
+				rx = s.rxBytes
+			}
+			if s.remotePort == port {
+				tx = s.txBytes
+			}
+		}
+		if rx == size && tx == size+1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server received %d, client acked %d; want %d, %d", rx, tx, size, size+1)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
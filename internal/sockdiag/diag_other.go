@@ -0,0 +1,15 @@
+//go:build !linux
+
+package sockdiag
+
+import "errors"
+
+// dumpTCP is only supported on Linux.
+func dumpTCP() ([]sockInfo, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// listenDestroy is only supported on Linux.
+func listenDestroy(fn func(sockInfo)) (func(), error) {
+	return nil, errors.ErrUnsupported
+}
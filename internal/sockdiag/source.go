@@ -0,0 +1,405 @@
+// Package sockdiag is a fallback stats source for hosts that can't run the
+// eBPF probes. It polls the kernel's socket diagnostics (NETLINK_SOCK_DIAG)
+// for the tcp_info byte counters of sockets on monitored ports and counts
+// the growth between polls, so accounting is approximate: UDP isn't
+// counted, nor are the breakdowns that need the probes.
+package sockdiag
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/ebpf"
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// PollInterval is how often the source should be polled. Every poll dumps
+// all TCP sockets, so it is much slower than for the eBPF maps.
+const PollInterval = time.Second
+
+// goneTTL is how long a socket missing from the dumps is remembered, in
+// case its destroy event is still on the way.
+const goneTTL = 30 * time.Second
+
+// TCP states, as in include/net/tcp_states.h.
+const (
+	tcpEstablished = 1
+	tcpTimeWait    = 6
+	tcpListen      = 10
+)
+
+// tcpStateNames names the TCP states for active connections.
+var tcpStateNames = map[uint8]string{
+	1:  "established",
+	2:  "syn_sent",
+	3:  "syn_recv",
+	4:  "fin_wait1",
+	5:  "fin_wait2",
+	6:  "time_wait",
+	7:  "close",
+	8:  "close_wait",
+	9:  "last_ack",
+	10: "listen",
+	11: "closing",
+}
+
+// sockInfo is one TCP socket as reported by the kernel. The counters come
+// from tcp_info and only grow over the socket's life.
+type sockInfo struct {
+	cookie     uint64
+	family     uint16
+	state      uint8
+	localAddr  netip.Addr
+	localPort  uint16
+	remoteAddr netip.Addr
+	remotePort uint16
+
+	hasInfo     bool // false for sockets without tcp_info, e.g. time-wait
+	rxBytes     uint64
+	txBytes     uint64
+	rxSegs      uint64
+	txSegs      uint64
+	retransmits uint64
+}
+
+// trackedSock is a socket on a monitored port and its counters at the
+// last poll.
+type trackedSock struct {
+	port      types.PortKey
+	role      uint8
+	last      sockInfo
+	firstSeen time.Time
+	lastSeen  time.Time
+	goneSince time.Time // zero while the socket is in the dumps
+}
+
+// dumpFunc returns every TCP socket on the host.
+type dumpFunc func() ([]sockInfo, error)
+
+// Source is an ebpf.StatsSource reading TCP socket counters through
+// socket diagnostics.
+type Source struct {
+	dump     dumpFunc
+	stop     func()
+	degraded []string
+
+	mu       sync.Mutex
+	ports    map[types.PortKey]uint8
+	fresh    map[types.PortKey]bool // added since the last poll
+	socks    map[uint64]*trackedSock
+	closed   map[uint64]time.Time // destroyed sockets, ignored in dumps
+	counters map[types.PortKey]*types.PortStats
+	active   []*trackedSock // matched sockets in the last dump
+}
+
+var _ ebpf.StatsSource = (*Source)(nil)
+
+// NewSource opens the socket diagnostics fallback. reason is why eBPF
+// can't be used, reported by AttachInfo.
+func NewSource(reason error) (*Source, error) {
+	if _, err := dumpTCP(); err != nil {
+		return nil, fmt.Errorf("dumping TCP sockets: %w", err)
+	}
+
+	s := newSource(dumpTCP)
+	s.degraded = append(s.degraded,
+		fmt.Sprintf("ebpf: not loaded (%v)", reason),
+		"udp: not counted",
+		"tcp: byte and segment counts from tcp_info, a byte high per FIN; no send/receive operations",
+		"processes, cgroups, local addresses, filters and wire accounting: not available",
+		"network namespaces: only the daemon's own is visible",
+	)
+
+	stop, err := listenDestroy(s.closeSocket)
+	if err != nil {
+		slog.Warn("not listening for closed sockets; traffic since the last poll of a closing socket is lost", "error", err)
+		s.degraded = append(s.degraded, fmt.Sprintf("tcp destroy events: not received (%v)", err))
+	} else {
+		s.stop = stop
+	}
+
+	slog.Info("socket diagnostics fallback started")
+	return s, nil
+}
+
+// newSource returns a source reading sockets from dump.
+func newSource(dump dumpFunc) *Source {
+	return &Source{
+		dump:     dump,
+		ports:    make(map[types.PortKey]uint8),
+		fresh:    make(map[types.PortKey]bool),
+		socks:    make(map[uint64]*trackedSock),
+		closed:   make(map[uint64]time.Time),
+		counters: make(map[types.PortKey]*types.PortStats),
+	}
+}
+
+// Close stops listening for closed sockets.
+func (s *Source) Close() error {
+	if s.stop != nil {
+		s.stop()
+	}
+	return nil
+}
+
+// AttachInfo reports the fallback mode and what it can't count.
+func (s *Source) AttachInfo() ebpf.AttachInfo {
+	return ebpf.AttachInfo{Mode: ebpf.AttachNetlink, Degraded: slices.Clone(s.degraded)}
+}
+
+// match returns the monitored port a socket belongs to and the role the
+// port plays. Like the eBPF probe, a monitored local port takes precedence
+// over a monitored remote port, so a socket between two monitored ports is
+// always counted as the server side. Callers must hold s.mu.
+func (s *Source) match(info *sockInfo) (types.PortKey, uint8, bool) {
+	for key, roles := range s.ports {
+		if roles&types.RoleServer != 0 && key.Contains(info.localPort) {
+			return key, types.RoleServer, true
+		}
+	}
+	for key, roles := range s.ports {
+		if roles&types.RoleClient != 0 && key.Contains(info.remotePort) {
+			return key, types.RoleClient, true
+		}
+	}
+	return types.PortKey{}, 0, false
+}
+
+// GetAllPortStats dumps the TCP sockets and adds the growth of their
+// counters since the last poll. Sockets already open when their port was
+// added count from the first poll after.
+func (s *Source) GetAllPortStats() (map[types.PortKey]*types.PortStats, error) {
+	infos, err := s.dump()
+	if err != nil {
+		return nil, fmt.Errorf("dumping TCP sockets: %w", err)
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[uint64]bool, len(s.socks))
+	s.active = s.active[:0]
+	for i := range infos {
+		info := &infos[i]
+		if !info.hasInfo {
+			continue
+		}
+		if _, ok := s.closed[info.cookie]; ok {
+			continue
+		}
+
+		key, role, ok := s.match(info)
+		if !ok {
+			continue
+		}
+
+		t, ok := s.socks[info.cookie]
+		if !ok || t.port != key {
+			t = newTrackedSock(key, role, now)
+			if s.fresh[key] {
+				t.last = *info // Traffic before monitoring began isn't counted
+			}
+			s.socks[info.cookie] = t
+		}
+		s.account(t, info)
+		t.lastSeen = now
+		t.goneSince = time.Time{}
+		seen[info.cookie] = true
+		s.active = append(s.active, t)
+	}
+
+	// A socket missing from the dump may still have a destroy event coming
+	for cookie, t := range s.socks {
+		if seen[cookie] {
+			continue
+		}
+		if t.goneSince.IsZero() {
+			t.goneSince = now
+		} else if now.Sub(t.goneSince) > goneTTL {
+			delete(s.socks, cookie)
+		}
+	}
+	for cookie, at := range s.closed {
+		if now.Sub(at) > goneTTL {
+			delete(s.closed, cookie)
+		}
+	}
+
+	clear(s.fresh)
+
+	result := make(map[types.PortKey]*types.PortStats, len(s.counters))
+	for key, stats := range s.counters {
+		statsCopy := *stats
+		result[key] = &statsCopy
+	}
+	return result, nil
+}
+
+// closeSocket counts a socket's traffic since the last poll from its
+// destroy event. A socket opened and closed between polls counts whole.
+func (s *Source) closeSocket(info sockInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !info.hasInfo {
+		return
+	}
+	s.closed[info.cookie] = time.Now()
+
+	t, ok := s.socks[info.cookie]
+	if ok {
+		delete(s.socks, info.cookie)
+		if _, monitored := s.ports[t.port]; !monitored {
+			return
+		}
+	} else {
+		key, role, matched := s.match(&info)
+		if !matched || s.fresh[key] {
+			return
+		}
+		t = newTrackedSock(key, role, time.Now())
+	}
+	s.account(t, &info)
+}
+
+// newTrackedSock starts tracking a socket from zero. bytes_acked counts
+// the SYN of connections opened locally, which client-side sockets are.
+func newTrackedSock(port types.PortKey, role uint8, now time.Time) *trackedSock {
+	t := &trackedSock{port: port, role: role, firstSeen: now}
+	if role == types.RoleClient {
+		t.last.txBytes = 1
+	}
+	return t
+}
+
+// account adds the growth of a socket's counters to its port and moves
+// the socket's last counters forward. Callers must hold s.mu.
+func (s *Source) account(t *trackedSock, info *sockInfo) {
+	delta := func(cur, last uint64) uint64 {
+		if cur >= last {
+			return cur - last
+		}
+		return 0
+	}
+	rx := delta(info.rxBytes, t.last.rxBytes)
+	tx := delta(info.txBytes, t.last.txBytes)
+	rxSegs := delta(info.rxSegs, t.last.rxSegs)
+	txSegs := delta(info.txSegs, t.last.txSegs)
+	retrans := delta(info.retransmits, t.last.retransmits)
+	t.last = *info
+
+	stats, ok := s.counters[t.port]
+	if !ok {
+		key := t.port
+		stats = &types.PortStats{Port: key.Port, PortEnd: key.PortEnd, Protocol: key.Protocol, Netns: key.Netns}
+		s.counters[key] = stats
+	}
+	stats.RxBytes += rx
+	stats.TxBytes += tx
+	stats.RxPackets += rxSegs
+	stats.TxPackets += txSegs
+	stats.Health.Retransmits += retrans
+
+	share := &stats.Server
+	if t.role == types.RoleClient {
+		share = &stats.Client
+	}
+	share.RxBytes += rx
+	share.TxBytes += tx
+	share.RxPackets += rxSegs
+	share.TxPackets += txSegs
+}
+
+// CountActiveConnections returns the established sockets per port at the
+// last poll.
+func (s *Source) CountActiveConnections() (map[types.PortKey]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[types.PortKey]uint64)
+	for _, t := range s.active {
+		if t.last.state == tcpEstablished {
+			result[t.port]++
+		}
+	}
+	return result, nil
+}
+
+// GetActiveConnections returns the sockets at the last poll where either
+// the local or remote port matches the given port.
+func (s *Source) GetActiveConnections(port uint16) ([]types.ActiveConnection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []types.ActiveConnection
+	for _, t := range s.active {
+		info := &t.last
+		if info.localPort != port && info.remotePort != port {
+			continue
+		}
+		result = append(result, types.ActiveConnection{
+			Port:       port,
+			Family:     info.family,
+			LocalAddr:  info.localAddr.AsSlice(),
+			LocalPort:  info.localPort,
+			RemoteAddr: info.remoteAddr.AsSlice(),
+			RemotePort: info.remotePort,
+			State:      tcpStateNames[info.state],
+			RxBytes:    info.rxBytes,
+			TxBytes:    info.txBytes,
+			StartedAt:  t.firstSeen,
+			LastSeen:   t.lastSeen,
+		})
+	}
+	return result, nil
+}
+
+// AddPort starts counting a TCP port or port range. UDP and ports scoped
+// to a network namespace need eBPF.
+func (s *Source) AddPort(key types.PortKey, roles uint8) error {
+	if key.Protocol != types.ProtocolTCP {
+		return fmt.Errorf("port %s: only TCP is counted without eBPF", key)
+	}
+	if key.Netns != 0 {
+		return fmt.Errorf("port %s: network namespaces need eBPF", key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for existing := range s.ports {
+		if existing != key && existing.Overlaps(key) {
+			return fmt.Errorf("port %s overlaps monitored port %s", key, existing)
+		}
+	}
+	if _, ok := s.ports[key]; !ok {
+		s.fresh[key] = true
+	}
+	s.ports[key] = roles
+
+	slog.Info("added port to monitoring", "port", key, "source", "sockdiag")
+	return nil
+}
+
+// RemovePort stops counting a port. Its counters are kept.
+func (s *Source) RemovePort(key types.PortKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.ports, key)
+	delete(s.fresh, key)
+	for cookie, t := range s.socks {
+		if t.port == key {
+			delete(s.socks, cookie)
+		}
+	}
+
+	slog.Info("removed port from monitoring", "port", key, "source", "sockdiag")
+	return nil
+}
@@ -0,0 +1,190 @@
+package sockdiag
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// scriptedDump returns a dump function serving the socket lists of *polls
+// in turn, the last one repeating.
+func scriptedDump(polls *[][]sockInfo) dumpFunc {
+	return func() ([]sockInfo, error) {
+		p := *polls
+		socks := p[0]
+		if len(p) > 1 {
+			*polls = p[1:]
+		}
+		return socks, nil
+	}
+}
+
+// sock returns an established socket between local and remote port with
+// the given byte counters.
+func sock(cookie uint64, local, remote uint16, rx, tx uint64) sockInfo {
+	return sockInfo{
+		cookie:     cookie,
+		family:     2,
+		state:      tcpEstablished,
+		localAddr:  netip.MustParseAddr("127.0.0.1"),
+		localPort:  local,
+		remoteAddr: netip.MustParseAddr("127.0.0.1"),
+		remotePort: remote,
+		hasInfo:    true,
+		rxBytes:    rx,
+		txBytes:    tx,
+	}
+}
+
+func TestSourceDeltas(t *testing.T) {
+	port := types.TCPPort(8080)
+	polls := [][]sockInfo{
+		// Already open when monitoring starts: not counted
+		{sock(1, 8080, 40000, 1000, 500)},
+		// Grows, and a new connection counts from zero
+		{sock(1, 8080, 40000, 1300, 600), sock(2, 8080, 40001, 50, 20)},
+		// Connection 2 closed with its destroy event delivered below
+		{sock(1, 8080, 40000, 1400, 600)},
+	}
+	s := newSource(scriptedDump(&polls))
+	if err := s.AddPort(port, types.RoleBoth); err != nil {
+		t.Fatalf("AddPort: %v", err)
+	}
+
+	check := func(wantRx, wantTx uint64) {
+		t.Helper()
+		stats, err := s.GetAllPortStats()
+		if err != nil {
+			t.Fatalf("GetAllPortStats: %v", err)
+		}
+		got := stats[port]
+		if got == nil {
+			got = &types.PortStats{}
+		}
+		if got.RxBytes != wantRx || got.TxBytes != wantTx {
+			t.Errorf("bytes = %d/%d, want %d/%d", got.RxBytes, got.TxBytes, wantRx, wantTx)
+		}
+		if got.Server.RxBytes != wantRx {
+			t.Errorf("server rx bytes = %d, want %d", got.Server.RxBytes, wantRx)
+		}
+	}
+
+	check(0, 0)
+	check(300+50, 100+20)
+
+	// Connection 2 sent more before closing, and a connection opened and
+	// closed between polls
+	s.closeSocket(sock(2, 8080, 40001, 80, 20))
+	s.closeSocket(sock(3, 8080, 40002, 10, 5))
+	check(350+30+10+100, 120+5)
+
+	conns, err := s.CountActiveConnections()
+	if err != nil {
+		t.Fatalf("CountActiveConnections: %v", err)
+	}
+	if conns[port] != 1 {
+		t.Errorf("active connections = %d, want 1", conns[port])
+	}
+}
+
+func TestSourceClosedSocketNotRecounted(t *testing.T) {
+	port := types.TCPPort(8080)
+	polls := [][]sockInfo{
+		{},
+		// A dump racing the destroy event still lists the socket
+		{sock(1, 8080, 40000, 100, 0)},
+	}
+	s := newSource(scriptedDump(&polls))
+	if err := s.AddPort(port, types.RoleBoth); err != nil {
+		t.Fatalf("AddPort: %v", err)
+	}
+	if _, err := s.GetAllPortStats(); err != nil {
+		t.Fatalf("GetAllPortStats: %v", err)
+	}
+
+	s.closeSocket(sock(1, 8080, 40000, 100, 0))
+	stats, err := s.GetAllPortStats()
+	if err != nil {
+		t.Fatalf("GetAllPortStats: %v", err)
+	}
+	if got := stats[port].RxBytes; got != 100 {
+		t.Errorf("rx bytes = %d, want 100", got)
+	}
+}
+
+func TestSourceRoles(t *testing.T) {
+	server := types.TCPPort(8080)
+	client := types.TCPPort(5432)
+	polls := [][]sockInfo{
+		{},
+		{
+			sock(1, 8080, 40000, 10, 20), // inbound to the server port
+			sock(2, 40001, 5432, 30, 40), // outbound to the client port
+			sock(3, 40002, 8080, 50, 60), // outbound to 8080, a server-only port
+		},
+	}
+	s := newSource(scriptedDump(&polls))
+	if err := s.AddPort(server, types.RoleServer); err != nil {
+		t.Fatalf("AddPort(%s): %v", server, err)
+	}
+	if err := s.AddPort(client, types.RoleClient); err != nil {
+		t.Fatalf("AddPort(%s): %v", client, err)
+	}
+	if _, err := s.GetAllPortStats(); err != nil {
+		t.Fatalf("GetAllPortStats: %v", err)
+	}
+
+	stats, err := s.GetAllPortStats()
+	if err != nil {
+		t.Fatalf("GetAllPortStats: %v", err)
+	}
+	if got := stats[server]; got.RxBytes != 10 || got.Server.RxBytes != 10 {
+		t.Errorf("%s rx bytes = %d (server %d), want 10", server, got.RxBytes, got.Server.RxBytes)
+	}
+	// The client's bytes_acked includes its SYN
+	if got := stats[client]; got.TxBytes != 39 || got.Client.TxBytes != 39 {
+		t.Errorf("%s tx bytes = %d (client %d), want 39", client, got.TxBytes, got.Client.TxBytes)
+	}
+}
+
+// TestSourceOverlappingRoles checks that a socket between two monitored
+// ports is always counted on its local port, as the server side, rather
+// than on whichever port map iteration finds first.
+func TestSourceOverlappingRoles(t *testing.T) {
+	local := types.TCPPort(9090)
+	remote := types.TCPPort(8080)
+
+	polls := [][]sockInfo{{}}
+	for i := uint64(1); i <= 20; i++ {
+		polls = append(polls, []sockInfo{sock(1, 9090, 8080, 100*i, 10*i)})
+	}
+	s := newSource(scriptedDump(&polls))
+	for _, key := range []types.PortKey{remote, local} {
+		if err := s.AddPort(key, types.RoleBoth); err != nil {
+			t.Fatalf("AddPort(%s): %v", key, err)
+		}
+	}
+
+	var stats map[types.PortKey]*types.PortStats
+	for i := 0; i < 21; i++ {
+		var err error
+		if stats, err = s.GetAllPortStats(); err != nil {
+			t.Fatalf("GetAllPortStats: %v", err)
+		}
+	}
+
+	if got := stats[local]; got == nil || got.RxBytes != 2000 || got.Server.RxBytes != 2000 {
+		t.Errorf("%s stats = %+v, want 2000 server rx bytes", local, got)
+	}
+	if got := stats[remote]; got != nil && (got.RxBytes != 0 || got.TxBytes != 0) {
+		t.Errorf("%s bytes = %d/%d, want none", remote, got.RxBytes, got.TxBytes)
+	}
+}
+
+func TestSourceRejectsUDP(t *testing.T) {
+	s := newSource(func() ([]sockInfo, error) { return nil, nil })
+	if err := s.AddPort(types.NewPortKey(53, 0, types.ProtocolUDP), types.RoleBoth); err == nil {
+		t.Error("AddPort accepted a UDP port")
+	}
+}
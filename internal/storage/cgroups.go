@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// hourlyCgroupStatsTable holds hourly traffic per port and cgroup path.
+const hourlyCgroupStatsTable = `
+CREATE TABLE IF NOT EXISTS hourly_cgroup_stats (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    port INTEGER NOT NULL,
+    port_end INTEGER NOT NULL DEFAULT 0,
+    protocol INTEGER NOT NULL DEFAULT 6,
+    netns INTEGER NOT NULL DEFAULT 0,
+    cgroup TEXT NOT NULL,  -- path under the cgroup v2 mount
+    timestamp INTEGER NOT NULL,  -- Unix timestamp (hour granularity)
+    rx_bytes INTEGER DEFAULT 0,
+    tx_bytes INTEGER DEFAULT 0,
+    rx_ops INTEGER DEFAULT 0,  -- send/receive calls; packets aren't attributed to cgroups
+    tx_ops INTEGER DEFAULT 0,
+    connections INTEGER DEFAULT 0,
+    created_at INTEGER DEFAULT (strftime('%s', 'now')),
+    UNIQUE(port, port_end, protocol, netns, cgroup, timestamp)
+);
+`
+
+// dailyCgroupStatsTable holds daily traffic per port and cgroup path.
+const dailyCgroupStatsTable = `
+CREATE TABLE IF NOT EXISTS daily_cgroup_stats (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    port INTEGER NOT NULL,
+    port_end INTEGER NOT NULL DEFAULT 0,
+    protocol INTEGER NOT NULL DEFAULT 6,
+    netns INTEGER NOT NULL DEFAULT 0,
+    cgroup TEXT NOT NULL,
+    date TEXT NOT NULL,  -- YYYY-MM-DD format
+    rx_bytes INTEGER DEFAULT 0,
+    tx_bytes INTEGER DEFAULT 0,
+    rx_ops INTEGER DEFAULT 0,
+    tx_ops INTEGER DEFAULT 0,
+    connections INTEGER DEFAULT 0,
+    created_at INTEGER DEFAULT (strftime('%s', 'now')),
+    UNIQUE(port, port_end, protocol, netns, cgroup, date)
+);
+`
+
+// CgroupStatsRow holds a cgroup's traffic on a port over a period.
+type CgroupStatsRow struct {
+	Cgroup      string
+	RxBytes     uint64
+	TxBytes     uint64
+	RxOps       uint64
+	TxOps       uint64
+	Connections uint64
+}
+
+// UpsertCgroupStats adds a cgroup's traffic delta to both the hourly and
+// daily cgroup tables.
+func (d *DB) UpsertCgroupStats(key types.PortKey, cgroup string, ts time.Time, rxBytes, txBytes, rxOps, txOps, connections uint64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO hourly_cgroup_stats (port, port_end, protocol, netns, cgroup, timestamp, rx_bytes, tx_bytes, rx_ops, tx_ops, connections)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, cgroup, timestamp) DO UPDATE SET
+			rx_bytes = rx_bytes + excluded.rx_bytes,
+			tx_bytes = tx_bytes + excluded.tx_bytes,
+			rx_ops = rx_ops + excluded.rx_ops,
+			tx_ops = tx_ops + excluded.tx_ops,
+			connections = connections + excluded.connections
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, cgroup, ts.Truncate(time.Hour).Unix(), rxBytes, txBytes, rxOps, txOps, connections)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO daily_cgroup_stats (port, port_end, protocol, netns, cgroup, date, rx_bytes, tx_bytes, rx_ops, tx_ops, connections)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, cgroup, date) DO UPDATE SET
+			rx_bytes = rx_bytes + excluded.rx_bytes,
+			tx_bytes = tx_bytes + excluded.tx_bytes,
+			rx_ops = rx_ops + excluded.rx_ops,
+			tx_ops = tx_ops + excluded.tx_ops,
+			connections = connections + excluded.connections
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, cgroup, ts.Format("2006-01-02"), rxBytes, txBytes, rxOps, txOps, connections)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// QueryCgroupStats returns per-cgroup totals for a port over a date range,
+// largest first.
+func (d *DB) QueryCgroupStats(key types.PortKey, startDate, endDate string) ([]CgroupStatsRow, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query(`
+		SELECT cgroup, SUM(rx_bytes), SUM(tx_bytes), SUM(rx_ops), SUM(tx_ops), SUM(connections)
+		FROM daily_cgroup_stats
+		WHERE port = ? AND port_end = ? AND protocol = ? AND netns = ? AND date >= ? AND date <= ?
+		GROUP BY cgroup
+		ORDER BY SUM(rx_bytes) + SUM(tx_bytes) DESC
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []CgroupStatsRow
+	for rows.Next() {
+		var r CgroupStatsRow
+		if err := rows.Scan(&r.Cgroup, &r.RxBytes, &r.TxBytes, &r.RxOps, &r.TxOps, &r.Connections); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
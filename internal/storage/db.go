@@ -7,9 +7,11 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/wellsgz/portmon/internal/types"
 	_ "modernc.org/sqlite"
 )
 
@@ -20,38 +22,87 @@ type DB struct {
 	mu   sync.Mutex
 }
 
-// schema defines the database tables.
-const schema = `
--- Hourly aggregated statistics
+// hourlyStatsTable defines the hourly aggregated statistics table.
+const hourlyStatsTable = `
 CREATE TABLE IF NOT EXISTS hourly_stats (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     port INTEGER NOT NULL,
+    port_end INTEGER NOT NULL DEFAULT 0,  -- last port of a range, 0 for a single port
+    protocol INTEGER NOT NULL DEFAULT 6,  -- IPPROTO_TCP / IPPROTO_UDP
+    netns INTEGER NOT NULL DEFAULT 0,  -- network namespace inode, 0 for all namespaces
     timestamp INTEGER NOT NULL,  -- Unix timestamp (hour granularity)
     rx_bytes INTEGER DEFAULT 0,
     tx_bytes INTEGER DEFAULT 0,
-    rx_packets INTEGER DEFAULT 0,
+    rx_packets INTEGER DEFAULT 0,  -- TCP segments / UDP datagrams; 0 in rows from before schema 10
     tx_packets INTEGER DEFAULT 0,
+    rx_ops INTEGER DEFAULT 0,  -- send/receive calls
+    tx_ops INTEGER DEFAULT 0,
     connections INTEGER DEFAULT 0,
+    server_rx_bytes INTEGER DEFAULT 0,  -- share of rx/tx_bytes on connections to the local port
+    server_tx_bytes INTEGER DEFAULT 0,
+    client_rx_bytes INTEGER DEFAULT 0,  -- share on connections to the remote port
+    client_tx_bytes INTEGER DEFAULT 0,
+    filtered_rx_bytes INTEGER DEFAULT 0,  -- excluded by CIDR filters, not part of rx/tx_bytes
+    filtered_tx_bytes INTEGER DEFAULT 0,
+    retransmits INTEGER DEFAULT 0,
+    resets INTEGER DEFAULT 0,
+    rtt_hist TEXT NOT NULL DEFAULT '',  -- comma-separated log2 microsecond bucket counts
+    wire_rx_bytes INTEGER DEFAULT 0,  -- IP-level bytes, only in wire accounting mode
+    wire_tx_bytes INTEGER DEFAULT 0,
+    wire_rx_packets INTEGER DEFAULT 0,
+    wire_tx_packets INTEGER DEFAULT 0,
+    conn_accepted INTEGER DEFAULT 0,  -- TCP connections established, counted at the handshake
+    conn_initiated INTEGER DEFAULT 0,
+    conn_failed INTEGER DEFAULT 0,
+    send_size_hist TEXT NOT NULL DEFAULT '',  -- comma-separated log2 byte bucket counts per send
+    recv_size_hist TEXT NOT NULL DEFAULT '',
     created_at INTEGER DEFAULT (strftime('%s', 'now')),
-    UNIQUE(port, timestamp)
+    UNIQUE(port, port_end, protocol, netns, timestamp)
 );
+`
 
--- Daily aggregated statistics
+// dailyStatsTable defines the daily aggregated statistics table.
+const dailyStatsTable = `
 CREATE TABLE IF NOT EXISTS daily_stats (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     port INTEGER NOT NULL,
+    port_end INTEGER NOT NULL DEFAULT 0,
+    protocol INTEGER NOT NULL DEFAULT 6,
+    netns INTEGER NOT NULL DEFAULT 0,
     date TEXT NOT NULL,  -- YYYY-MM-DD format
     rx_bytes INTEGER DEFAULT 0,
     tx_bytes INTEGER DEFAULT 0,
     rx_packets INTEGER DEFAULT 0,
     tx_packets INTEGER DEFAULT 0,
+    rx_ops INTEGER DEFAULT 0,
+    tx_ops INTEGER DEFAULT 0,
     connections INTEGER DEFAULT 0,
     peak_rx_rate INTEGER DEFAULT 0,
     peak_tx_rate INTEGER DEFAULT 0,
+    server_rx_bytes INTEGER DEFAULT 0,
+    server_tx_bytes INTEGER DEFAULT 0,
+    client_rx_bytes INTEGER DEFAULT 0,
+    client_tx_bytes INTEGER DEFAULT 0,
+    filtered_rx_bytes INTEGER DEFAULT 0,
+    filtered_tx_bytes INTEGER DEFAULT 0,
+    retransmits INTEGER DEFAULT 0,
+    resets INTEGER DEFAULT 0,
+    rtt_hist TEXT NOT NULL DEFAULT '',
+    wire_rx_bytes INTEGER DEFAULT 0,
+    wire_tx_bytes INTEGER DEFAULT 0,
+    wire_rx_packets INTEGER DEFAULT 0,
+    wire_tx_packets INTEGER DEFAULT 0,
+    conn_accepted INTEGER DEFAULT 0,
+    conn_initiated INTEGER DEFAULT 0,
+    conn_failed INTEGER DEFAULT 0,
     created_at INTEGER DEFAULT (strftime('%s', 'now')),
-    UNIQUE(port, date)
+    UNIQUE(port, port_end, protocol, netns, date)
 );
+`
 
+// schema defines the database tables.
+const schema = hourlyStatsTable + dailyStatsTable + hourlyCgroupStatsTable + dailyCgroupStatsTable +
+	hourlyLocalAddrStatsTable + dailyLocalAddrStatsTable + `
 -- Active connections (ephemeral, cleared on restart)
 CREATE TABLE IF NOT EXISTS active_connections (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -65,16 +116,37 @@ CREATE TABLE IF NOT EXISTS active_connections (
     last_seen INTEGER NOT NULL
 );
 
+-- Finished connections (one row per closed connection)
+CREATE TABLE IF NOT EXISTS connection_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    port INTEGER NOT NULL,
+    family INTEGER NOT NULL,
+    local_addr TEXT NOT NULL,
+    local_port INTEGER NOT NULL,
+    remote_addr TEXT NOT NULL,
+    remote_port INTEGER NOT NULL,
+    rx_bytes INTEGER DEFAULT 0,
+    tx_bytes INTEGER DEFAULT 0,
+    started_at INTEGER NOT NULL,  -- Unix timestamp (seconds)
+    ended_at INTEGER NOT NULL
+);
+
 -- Metadata
 CREATE TABLE IF NOT EXISTS metadata (
     key TEXT PRIMARY KEY,
     value TEXT NOT NULL
 );
+`
 
--- Indexes
-CREATE INDEX IF NOT EXISTS idx_hourly_port_ts ON hourly_stats(port, timestamp);
-CREATE INDEX IF NOT EXISTS idx_daily_port_date ON daily_stats(port, date);
+// indexes are applied after migrations, since they may reference columns
+// that older databases only gain during migration.
+const indexes = `
+CREATE INDEX IF NOT EXISTS idx_hourly_port_ts ON hourly_stats(port, port_end, protocol, netns, timestamp);
+CREATE INDEX IF NOT EXISTS idx_daily_port_date ON daily_stats(port, port_end, protocol, netns, date);
+CREATE INDEX IF NOT EXISTS idx_daily_cgroup_port_date ON daily_cgroup_stats(port, port_end, protocol, netns, date);
+CREATE INDEX IF NOT EXISTS idx_daily_local_addr_port_date ON daily_local_addr_stats(port, port_end, protocol, netns, date);
 CREATE INDEX IF NOT EXISTS idx_active_port ON active_connections(port);
+CREATE INDEX IF NOT EXISTS idx_history_port_ended ON connection_history(port, ended_at);
 `
 
 // Open opens or creates the SQLite database.
@@ -98,6 +170,17 @@ func Open(dataDir string) (*DB, error) {
 		return nil, fmt.Errorf("applying schema: %w", err)
 	}
 
+	// Bring tables created by older versions up to date
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating schema: %w", err)
+	}
+
+	if _, err := db.Exec(indexes); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating indexes: %w", err)
+	}
+
 	// Clear ephemeral connections table on startup
 	if _, err := db.Exec("DELETE FROM active_connections"); err != nil {
 		slog.Warn("failed to clear active connections", "error", err)
@@ -125,7 +208,7 @@ func (d *DB) Path() string {
 }
 
 // UpsertHourlyStats inserts or updates hourly statistics.
-func (d *DB) UpsertHourlyStats(port uint16, ts time.Time, rxBytes, txBytes, rxPackets, txPackets, connections uint64) error {
+func (d *DB) UpsertHourlyStats(key types.PortKey, ts time.Time, rxBytes, txBytes, rxPackets, txPackets, connections uint64) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
@@ -133,28 +216,28 @@ func (d *DB) UpsertHourlyStats(port uint16, ts time.Time, rxBytes, txBytes, rxPa
 	hourTs := ts.Truncate(time.Hour).Unix()
 
 	_, err := d.db.Exec(`
-		INSERT INTO hourly_stats (port, timestamp, rx_bytes, tx_bytes, rx_packets, tx_packets, connections)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(port, timestamp) DO UPDATE SET
+		INSERT INTO hourly_stats (port, port_end, protocol, netns, timestamp, rx_bytes, tx_bytes, rx_packets, tx_packets, connections)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, timestamp) DO UPDATE SET
 			rx_bytes = rx_bytes + excluded.rx_bytes,
 			tx_bytes = tx_bytes + excluded.tx_bytes,
 			rx_packets = rx_packets + excluded.rx_packets,
 			tx_packets = tx_packets + excluded.tx_packets,
 			connections = connections + excluded.connections
-	`, port, hourTs, rxBytes, txBytes, rxPackets, txPackets, connections)
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, hourTs, rxBytes, txBytes, rxPackets, txPackets, connections)
 
 	return err
 }
 
 // UpsertDailyStats inserts or updates daily statistics.
-func (d *DB) UpsertDailyStats(port uint16, date string, rxBytes, txBytes, rxPackets, txPackets, connections uint64, peakRx, peakTx uint64) error {
+func (d *DB) UpsertDailyStats(key types.PortKey, date string, rxBytes, txBytes, rxPackets, txPackets, connections uint64, peakRx, peakTx uint64) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	_, err := d.db.Exec(`
-		INSERT INTO daily_stats (port, date, rx_bytes, tx_bytes, rx_packets, tx_packets, connections, peak_rx_rate, peak_tx_rate)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(port, date) DO UPDATE SET
+		INSERT INTO daily_stats (port, port_end, protocol, netns, date, rx_bytes, tx_bytes, rx_packets, tx_packets, connections, peak_rx_rate, peak_tx_rate)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, date) DO UPDATE SET
 			rx_bytes = rx_bytes + excluded.rx_bytes,
 			tx_bytes = tx_bytes + excluded.tx_bytes,
 			rx_packets = rx_packets + excluded.rx_packets,
@@ -162,7 +245,7 @@ func (d *DB) UpsertDailyStats(port uint16, date string, rxBytes, txBytes, rxPack
 			connections = connections + excluded.connections,
 			peak_rx_rate = MAX(peak_rx_rate, excluded.peak_rx_rate),
 			peak_tx_rate = MAX(peak_tx_rate, excluded.peak_tx_rate)
-	`, port, date, rxBytes, txBytes, rxPackets, txPackets, connections, peakRx, peakTx)
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, date, rxBytes, txBytes, rxPackets, txPackets, connections, peakRx, peakTx)
 
 	return err
 }
@@ -170,17 +253,60 @@ func (d *DB) UpsertDailyStats(port uint16, date string, rxBytes, txBytes, rxPack
 // HourlyStatsRow represents a row from hourly_stats table.
 type HourlyStatsRow struct {
 	Port        uint16
+	PortEnd     uint16
+	Protocol    uint8
+	Netns       uint32
 	Timestamp   int64
 	RxBytes     uint64
 	TxBytes     uint64
 	RxPackets   uint64
 	TxPackets   uint64
 	Connections uint64
+	OpCounts
+	RoleBytes
+	FilteredBytes
+	WireStats
+	Health     types.TCPHealth
+	Handshakes types.HandshakeStats
+}
+
+// OpCounts holds the send and receive calls behind a row's totals,
+// alongside its packet counts.
+type OpCounts struct {
+	RxOps uint64
+	TxOps uint64
+}
+
+// RoleBytes splits a row's byte counts by the role the port played.
+type RoleBytes struct {
+	ServerRxBytes uint64
+	ServerTxBytes uint64
+	ClientRxBytes uint64
+	ClientTxBytes uint64
+}
+
+// WireStats holds IP-level traffic counted in wire accounting mode,
+// alongside the payload totals of a row.
+type WireStats struct {
+	WireRxBytes   uint64
+	WireTxBytes   uint64
+	WireRxPackets uint64
+	WireTxPackets uint64
+}
+
+// FilteredBytes holds the bytes excluded by a port's CIDR filters, which
+// are not included in a row's totals.
+type FilteredBytes struct {
+	FilteredRxBytes uint64
+	FilteredTxBytes uint64
 }
 
 // DailyStatsRow represents a row from daily_stats table.
 type DailyStatsRow struct {
 	Port        uint16
+	PortEnd     uint16
+	Protocol    uint8
+	Netns       uint32
 	Date        string
 	RxBytes     uint64
 	TxBytes     uint64
@@ -189,19 +315,28 @@ type DailyStatsRow struct {
 	Connections uint64
 	PeakRxRate  uint64
 	PeakTxRate  uint64
+	OpCounts
+	RoleBytes
+	FilteredBytes
+	WireStats
+	Health     types.TCPHealth
+	Handshakes types.HandshakeStats
 }
 
 // QueryHourlyStats queries hourly stats for a port within a time range.
-func (d *DB) QueryHourlyStats(port uint16, start, end time.Time) ([]HourlyStatsRow, error) {
+func (d *DB) QueryHourlyStats(key types.PortKey, start, end time.Time) ([]HourlyStatsRow, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	rows, err := d.db.Query(`
-		SELECT port, timestamp, rx_bytes, tx_bytes, rx_packets, tx_packets, connections
+		SELECT port, port_end, protocol, netns, timestamp, rx_bytes, tx_bytes, rx_packets, tx_packets, rx_ops, tx_ops, connections,
+			server_rx_bytes, server_tx_bytes, client_rx_bytes, client_tx_bytes, filtered_rx_bytes, filtered_tx_bytes,
+			retransmits, resets, rtt_hist, wire_rx_bytes, wire_tx_bytes, wire_rx_packets, wire_tx_packets,
+			conn_accepted, conn_initiated, conn_failed
 		FROM hourly_stats
-		WHERE port = ? AND timestamp >= ? AND timestamp <= ?
+		WHERE port = ? AND port_end = ? AND protocol = ? AND netns = ? AND timestamp >= ? AND timestamp <= ?
 		ORDER BY timestamp
-	`, port, start.Unix(), end.Unix())
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, start.Unix(), end.Unix())
 	if err != nil {
 		return nil, err
 	}
@@ -210,9 +345,14 @@ func (d *DB) QueryHourlyStats(port uint16, start, end time.Time) ([]HourlyStatsR
 	var result []HourlyStatsRow
 	for rows.Next() {
 		var r HourlyStatsRow
-		if err := rows.Scan(&r.Port, &r.Timestamp, &r.RxBytes, &r.TxBytes, &r.RxPackets, &r.TxPackets, &r.Connections); err != nil {
+		var rttHist string
+		if err := rows.Scan(&r.Port, &r.PortEnd, &r.Protocol, &r.Netns, &r.Timestamp, &r.RxBytes, &r.TxBytes, &r.RxPackets, &r.TxPackets, &r.RxOps, &r.TxOps, &r.Connections,
+			&r.ServerRxBytes, &r.ServerTxBytes, &r.ClientRxBytes, &r.ClientTxBytes, &r.FilteredRxBytes, &r.FilteredTxBytes,
+			&r.Health.Retransmits, &r.Health.Resets, &rttHist, &r.WireRxBytes, &r.WireTxBytes, &r.WireRxPackets, &r.WireTxPackets,
+			&r.Handshakes.Accepted, &r.Handshakes.Initiated, &r.Handshakes.Failed); err != nil {
 			return nil, err
 		}
+		decodeHist(rttHist, r.Health.RTT[:])
 		result = append(result, r)
 	}
 
@@ -220,16 +360,19 @@ func (d *DB) QueryHourlyStats(port uint16, start, end time.Time) ([]HourlyStatsR
 }
 
 // QueryDailyStats queries daily stats for a port within a date range.
-func (d *DB) QueryDailyStats(port uint16, startDate, endDate string) ([]DailyStatsRow, error) {
+func (d *DB) QueryDailyStats(key types.PortKey, startDate, endDate string) ([]DailyStatsRow, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	rows, err := d.db.Query(`
-		SELECT port, date, rx_bytes, tx_bytes, rx_packets, tx_packets, connections, peak_rx_rate, peak_tx_rate
+		SELECT port, port_end, protocol, netns, date, rx_bytes, tx_bytes, rx_packets, tx_packets, rx_ops, tx_ops, connections, peak_rx_rate, peak_tx_rate,
+			server_rx_bytes, server_tx_bytes, client_rx_bytes, client_tx_bytes, filtered_rx_bytes, filtered_tx_bytes,
+			retransmits, resets, rtt_hist, wire_rx_bytes, wire_tx_bytes, wire_rx_packets, wire_tx_packets,
+			conn_accepted, conn_initiated, conn_failed
 		FROM daily_stats
-		WHERE port = ? AND date >= ? AND date <= ?
+		WHERE port = ? AND port_end = ? AND protocol = ? AND netns = ? AND date >= ? AND date <= ?
 		ORDER BY date
-	`, port, startDate, endDate)
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, startDate, endDate)
 	if err != nil {
 		return nil, err
 	}
@@ -238,9 +381,14 @@ func (d *DB) QueryDailyStats(port uint16, startDate, endDate string) ([]DailySta
 	var result []DailyStatsRow
 	for rows.Next() {
 		var r DailyStatsRow
-		if err := rows.Scan(&r.Port, &r.Date, &r.RxBytes, &r.TxBytes, &r.RxPackets, &r.TxPackets, &r.Connections, &r.PeakRxRate, &r.PeakTxRate); err != nil {
+		var rttHist string
+		if err := rows.Scan(&r.Port, &r.PortEnd, &r.Protocol, &r.Netns, &r.Date, &r.RxBytes, &r.TxBytes, &r.RxPackets, &r.TxPackets, &r.RxOps, &r.TxOps, &r.Connections, &r.PeakRxRate, &r.PeakTxRate,
+			&r.ServerRxBytes, &r.ServerTxBytes, &r.ClientRxBytes, &r.ClientTxBytes, &r.FilteredRxBytes, &r.FilteredTxBytes,
+			&r.Health.Retransmits, &r.Health.Resets, &rttHist, &r.WireRxBytes, &r.WireTxBytes, &r.WireRxPackets, &r.WireTxPackets,
+			&r.Handshakes.Accepted, &r.Handshakes.Initiated, &r.Handshakes.Failed); err != nil {
 			return nil, err
 		}
+		decodeHist(rttHist, r.Health.RTT[:])
 		result = append(result, r)
 	}
 
@@ -248,36 +396,152 @@ func (d *DB) QueryDailyStats(port uint16, startDate, endDate string) ([]DailySta
 }
 
 // GetPeriodSummary returns aggregated stats for a port over a date range.
-func (d *DB) GetPeriodSummary(port uint16, startDate, endDate string) (*DailyStatsRow, error) {
+func (d *DB) GetPeriodSummary(key types.PortKey, startDate, endDate string) (*DailyStatsRow, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	var r DailyStatsRow
-	r.Port = port
+	r.Port = key.Port
+	r.PortEnd = key.PortEnd
+	r.Protocol = key.Protocol
+	r.Netns = key.Netns
 
+	var rttHists string
 	err := d.db.QueryRow(`
 		SELECT 
 			COALESCE(SUM(rx_bytes), 0),
 			COALESCE(SUM(tx_bytes), 0),
 			COALESCE(SUM(rx_packets), 0),
 			COALESCE(SUM(tx_packets), 0),
+			COALESCE(SUM(rx_ops), 0),
+			COALESCE(SUM(tx_ops), 0),
 			COALESCE(SUM(connections), 0),
 			COALESCE(MAX(peak_rx_rate), 0),
-			COALESCE(MAX(peak_tx_rate), 0)
+			COALESCE(MAX(peak_tx_rate), 0),
+			COALESCE(SUM(server_rx_bytes), 0),
+			COALESCE(SUM(server_tx_bytes), 0),
+			COALESCE(SUM(client_rx_bytes), 0),
+			COALESCE(SUM(client_tx_bytes), 0),
+			COALESCE(SUM(filtered_rx_bytes), 0),
+			COALESCE(SUM(filtered_tx_bytes), 0),
+			COALESCE(SUM(retransmits), 0),
+			COALESCE(SUM(resets), 0),
+			COALESCE(GROUP_CONCAT(rtt_hist, ';'), ''),
+			COALESCE(SUM(wire_rx_bytes), 0),
+			COALESCE(SUM(wire_tx_bytes), 0),
+			COALESCE(SUM(wire_rx_packets), 0),
+			COALESCE(SUM(wire_tx_packets), 0),
+			COALESCE(SUM(conn_accepted), 0),
+			COALESCE(SUM(conn_initiated), 0),
+			COALESCE(SUM(conn_failed), 0)
 		FROM daily_stats
-		WHERE port = ? AND date >= ? AND date <= ?
-	`, port, startDate, endDate).Scan(
-		&r.RxBytes, &r.TxBytes, &r.RxPackets, &r.TxPackets,
+		WHERE port = ? AND port_end = ? AND protocol = ? AND netns = ? AND date >= ? AND date <= ?
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, startDate, endDate).Scan(
+		&r.RxBytes, &r.TxBytes, &r.RxPackets, &r.TxPackets, &r.RxOps, &r.TxOps,
 		&r.Connections, &r.PeakRxRate, &r.PeakTxRate,
+		&r.ServerRxBytes, &r.ServerTxBytes, &r.ClientRxBytes, &r.ClientTxBytes,
+		&r.FilteredRxBytes, &r.FilteredTxBytes,
+		&r.Health.Retransmits, &r.Health.Resets, &rttHists,
+		&r.WireRxBytes, &r.WireTxBytes, &r.WireRxPackets, &r.WireTxPackets,
+		&r.Handshakes.Accepted, &r.Handshakes.Initiated, &r.Handshakes.Failed,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	for _, h := range strings.Split(rttHists, ";") {
+		var hist types.RTTHistogram
+		decodeHist(h, hist[:])
+		r.Health.RTT.Add(&hist)
+	}
+
 	r.Date = startDate + " to " + endDate
 	return &r, nil
 }
 
+// ConnectionHistoryRow represents a row from connection_history table.
+type ConnectionHistoryRow struct {
+	Port       uint16
+	Family     uint16
+	LocalAddr  string
+	LocalPort  uint16
+	RemoteAddr string
+	RemotePort uint16
+	RxBytes    uint64
+	TxBytes    uint64
+	StartedAt  int64
+	EndedAt    int64
+}
+
+// InsertConnectionHistory records finished connections in a single transaction.
+func (d *DB) InsertConnectionHistory(rows []ConnectionHistoryRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO connection_history (port, family, local_addr, local_port, remote_addr, remote_port, rx_bytes, tx_bytes, started_at, ended_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range rows {
+		if _, err := stmt.Exec(r.Port, r.Family, r.LocalAddr, r.LocalPort, r.RemoteAddr, r.RemotePort,
+			r.RxBytes, r.TxBytes, r.StartedAt, r.EndedAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// QueryConnectionHistory returns connections on a port that ended within a
+// time range, largest transfers first. A limit of 0 returns all rows.
+func (d *DB) QueryConnectionHistory(port uint16, start, end time.Time, limit int) ([]ConnectionHistoryRow, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if limit <= 0 {
+		limit = -1 // SQLite: no limit
+	}
+
+	rows, err := d.db.Query(`
+		SELECT port, family, local_addr, local_port, remote_addr, remote_port, rx_bytes, tx_bytes, started_at, ended_at
+		FROM connection_history
+		WHERE port = ? AND ended_at >= ? AND ended_at <= ?
+		ORDER BY rx_bytes + tx_bytes DESC
+		LIMIT ?
+	`, port, start.Unix(), end.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []ConnectionHistoryRow
+	for rows.Next() {
+		var r ConnectionHistoryRow
+		if err := rows.Scan(&r.Port, &r.Family, &r.LocalAddr, &r.LocalPort, &r.RemoteAddr, &r.RemotePort,
+			&r.RxBytes, &r.TxBytes, &r.StartedAt, &r.EndedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
 // DeleteOldData removes data older than the specified number of days.
 func (d *DB) DeleteOldData(retentionDays int) (int64, error) {
 	d.mu.Lock()
@@ -305,6 +569,44 @@ func (d *DB) DeleteOldData(retentionDays int) (int64, error) {
 	n, _ = result.RowsAffected()
 	totalDeleted += n
 
+	// Delete from the per-cgroup tables
+	result, err = d.db.Exec("DELETE FROM hourly_cgroup_stats WHERE timestamp < ?", cutoffTs)
+	if err != nil {
+		return 0, fmt.Errorf("deleting old hourly cgroup stats: %w", err)
+	}
+	n, _ = result.RowsAffected()
+	totalDeleted += n
+
+	result, err = d.db.Exec("DELETE FROM daily_cgroup_stats WHERE date < ?", cutoffDate)
+	if err != nil {
+		return 0, fmt.Errorf("deleting old daily cgroup stats: %w", err)
+	}
+	n, _ = result.RowsAffected()
+	totalDeleted += n
+
+	// Delete from the per-local-address tables
+	result, err = d.db.Exec("DELETE FROM hourly_local_addr_stats WHERE timestamp < ?", cutoffTs)
+	if err != nil {
+		return 0, fmt.Errorf("deleting old hourly local address stats: %w", err)
+	}
+	n, _ = result.RowsAffected()
+	totalDeleted += n
+
+	result, err = d.db.Exec("DELETE FROM daily_local_addr_stats WHERE date < ?", cutoffDate)
+	if err != nil {
+		return 0, fmt.Errorf("deleting old daily local address stats: %w", err)
+	}
+	n, _ = result.RowsAffected()
+	totalDeleted += n
+
+	// Delete from connection_history
+	result, err = d.db.Exec("DELETE FROM connection_history WHERE ended_at < ?", cutoffTs)
+	if err != nil {
+		return 0, fmt.Errorf("deleting old connection history: %w", err)
+	}
+	n, _ = result.RowsAffected()
+	totalDeleted += n
+
 	if totalDeleted > 0 {
 		slog.Info("cleaned up old data", "deleted_rows", totalDeleted, "retention_days", retentionDays)
 	}
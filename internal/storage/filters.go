@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// UpsertFilteredStats adds bytes excluded by a port's CIDR filters to both
+// its hourly and daily rows. Filtered bytes are kept apart from the billed
+// totals so both together account for all traffic seen on the port.
+func (d *DB) UpsertFilteredStats(key types.PortKey, ts time.Time, delta FilteredBytes) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO hourly_stats (port, port_end, protocol, netns, timestamp, filtered_rx_bytes, filtered_tx_bytes)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, timestamp) DO UPDATE SET
+			filtered_rx_bytes = filtered_rx_bytes + excluded.filtered_rx_bytes,
+			filtered_tx_bytes = filtered_tx_bytes + excluded.filtered_tx_bytes
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, ts.Truncate(time.Hour).Unix(),
+		delta.FilteredRxBytes, delta.FilteredTxBytes)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO daily_stats (port, port_end, protocol, netns, date, filtered_rx_bytes, filtered_tx_bytes)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, date) DO UPDATE SET
+			filtered_rx_bytes = filtered_rx_bytes + excluded.filtered_rx_bytes,
+			filtered_tx_bytes = filtered_tx_bytes + excluded.filtered_tx_bytes
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, ts.Format("2006-01-02"),
+		delta.FilteredRxBytes, delta.FilteredTxBytes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
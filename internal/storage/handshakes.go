@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// UpsertHandshakeStats adds a delta of accepted, initiated and failed TCP
+// connections to both the hourly and daily rows of a port. Unlike the
+// connections column these are counted at the handshake, so connections
+// that never carry data are included.
+func (d *DB) UpsertHandshakeStats(key types.PortKey, ts time.Time, delta types.HandshakeStats) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO hourly_stats (port, port_end, protocol, netns, timestamp, conn_accepted, conn_initiated, conn_failed)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, timestamp) DO UPDATE SET
+			conn_accepted = conn_accepted + excluded.conn_accepted,
+			conn_initiated = conn_initiated + excluded.conn_initiated,
+			conn_failed = conn_failed + excluded.conn_failed
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, ts.Truncate(time.Hour).Unix(),
+		delta.Accepted, delta.Initiated, delta.Failed)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO daily_stats (port, port_end, protocol, netns, date, conn_accepted, conn_initiated, conn_failed)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, date) DO UPDATE SET
+			conn_accepted = conn_accepted + excluded.conn_accepted,
+			conn_initiated = conn_initiated + excluded.conn_initiated,
+			conn_failed = conn_failed + excluded.conn_failed
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, ts.Format("2006-01-02"),
+		delta.Accepted, delta.Initiated, delta.Failed)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
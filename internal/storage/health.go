@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// UpsertHealthStats adds a TCP health delta (retransmits, resets and RTT
+// samples) to both the hourly and daily rows of a port. RTT histograms
+// are stored as text, so they are merged here rather than in SQL.
+func (d *DB) UpsertHealthStats(key types.PortKey, ts time.Time, delta types.TCPHealth) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows := []struct {
+		table  string
+		column string
+		value  interface{}
+	}{
+		{"hourly_stats", "timestamp", ts.Truncate(time.Hour).Unix()},
+		{"daily_stats", "date", ts.Format("2006-01-02")},
+	}
+	for _, row := range rows {
+		var existing string
+		err := tx.QueryRow(fmt.Sprintf(`
+			SELECT rtt_hist FROM %s
+			WHERE port = ? AND port_end = ? AND protocol = ? AND netns = ? AND %s = ?
+		`, row.table, row.column), key.Port, key.PortEnd, key.Protocol, key.Netns, row.value).Scan(&existing)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		var hist types.RTTHistogram
+		decodeHist(existing, hist[:])
+		hist.Add(&delta.RTT)
+
+		_, err = tx.Exec(fmt.Sprintf(`
+			INSERT INTO %[1]s (port, port_end, protocol, netns, %[2]s, retransmits, resets, rtt_hist)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(port, port_end, protocol, netns, %[2]s) DO UPDATE SET
+				retransmits = retransmits + excluded.retransmits,
+				resets = resets + excluded.resets,
+				rtt_hist = excluded.rtt_hist
+		`, row.table, row.column), key.Port, key.PortEnd, key.Protocol, key.Netns, row.value,
+			delta.Retransmits, delta.Resets, encodeHist(hist[:]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// encodeHist formats histogram bucket counts as a comma-separated list,
+// without trailing empty buckets.
+func encodeHist(counts []uint64) string {
+	n := len(counts)
+	for n > 0 && counts[n-1] == 0 {
+		n--
+	}
+	parts := make([]string, n)
+	for i := 0; i < n; i++ {
+		parts[i] = strconv.FormatUint(counts[i], 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// decodeHist parses bucket counts written by encodeHist into counts.
+// Malformed or surplus buckets are ignored.
+func decodeHist(s string, counts []uint64) {
+	if s == "" {
+		return
+	}
+	for i, part := range strings.Split(s, ",") {
+		if i >= len(counts) {
+			break
+		}
+		counts[i], _ = strconv.ParseUint(part, 10, 64)
+	}
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+func TestHealthStatsRoundTrip(t *testing.T) {
+	db := openTestDB(t)
+	port := types.TCPPort(443)
+	ts := time.Date(2025, 1, 15, 10, 15, 0, 0, time.Local)
+	date := ts.Format("2006-01-02")
+
+	upsert := func(ts time.Time, h types.TCPHealth) {
+		t.Helper()
+		if err := db.UpsertHealthStats(port, ts, h); err != nil {
+			t.Fatalf("UpsertHealthStats: %v", err)
+		}
+	}
+
+	first := types.TCPHealth{Retransmits: 4, Resets: 1}
+	first.RTT[6], first.RTT[7] = 10, 2
+	second := types.TCPHealth{Retransmits: 1}
+	second.RTT[7], second.RTT[types.RTTBuckets-1] = 5, 1
+	third := types.TCPHealth{Resets: 2}
+	third.RTT[3] = 1
+
+	upsert(ts, first)
+	if err := db.UpsertHourlyStats(port, ts, 1000, 500, 4, 3, 1); err != nil {
+		t.Fatalf("UpsertHourlyStats: %v", err)
+	}
+	if err := db.UpsertDailyStats(port, date, 1000, 500, 4, 3, 1, 0, 0); err != nil {
+		t.Fatalf("UpsertDailyStats: %v", err)
+	}
+	upsert(ts.Add(30*time.Minute), second)
+	upsert(ts.Add(time.Hour), third)
+
+	var hour, day types.TCPHealth
+	hour.Add(&first)
+	hour.Add(&second)
+	day = hour
+	day.Add(&third)
+
+	hourly, err := db.QueryHourlyStats(port, ts.Truncate(time.Hour), ts.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("QueryHourlyStats: %v", err)
+	}
+	if len(hourly) != 2 {
+		t.Fatalf("hourly rows = %d, want 2", len(hourly))
+	}
+	if hourly[0].Health != hour {
+		t.Errorf("first hour health = %+v, want %+v", hourly[0].Health, hour)
+	}
+	if hourly[1].Health != third {
+		t.Errorf("second hour health = %+v, want %+v", hourly[1].Health, third)
+	}
+	if hourly[0].RxBytes != 1000 {
+		t.Errorf("first hour rx bytes = %d, want 1000", hourly[0].RxBytes)
+	}
+
+	daily, err := db.QueryDailyStats(port, date, date)
+	if err != nil {
+		t.Fatalf("QueryDailyStats: %v", err)
+	}
+	if len(daily) != 1 {
+		t.Fatalf("daily rows = %d, want 1", len(daily))
+	}
+	if daily[0].Health != day {
+		t.Errorf("daily health = %+v, want %+v", daily[0].Health, day)
+	}
+	if daily[0].Health.RTT.Samples() != 19 {
+		t.Errorf("daily RTT samples = %d, want 19", daily[0].Health.RTT.Samples())
+	}
+}
+
+// TestHealthStatsEmpty checks that rows written without health data read
+// back as an empty histogram.
+func TestHealthStatsEmpty(t *testing.T) {
+	db := openTestDB(t)
+	port := types.TCPPort(443)
+	date := "2025-01-15"
+
+	if err := db.UpsertDailyStats(port, date, 1000, 500, 4, 3, 1, 0, 0); err != nil {
+		t.Fatalf("UpsertDailyStats: %v", err)
+	}
+	daily, err := db.QueryDailyStats(port, date, date)
+	if err != nil {
+		t.Fatalf("QueryDailyStats: %v", err)
+	}
+	if len(daily) != 1 || !daily[0].Health.IsZero() {
+		t.Fatalf("daily rows = %+v, want one without health data", daily)
+	}
+	if p := daily[0].Health.RTT.Percentile(0.5); p != 0 {
+		t.Errorf("empty RTT p50 = %d, want 0", p)
+	}
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// hourlyLocalAddrStatsTable holds hourly traffic per port and local
+// address.
+const hourlyLocalAddrStatsTable = `
+CREATE TABLE IF NOT EXISTS hourly_local_addr_stats (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    port INTEGER NOT NULL,
+    port_end INTEGER NOT NULL DEFAULT 0,
+    protocol INTEGER NOT NULL DEFAULT 6,
+    netns INTEGER NOT NULL DEFAULT 0,
+    local_addr TEXT NOT NULL,  -- e.g. 203.0.113.5 or 2001:db8::1
+    timestamp INTEGER NOT NULL,  -- Unix timestamp (hour granularity)
+    rx_bytes INTEGER DEFAULT 0,
+    tx_bytes INTEGER DEFAULT 0,
+    rx_packets INTEGER DEFAULT 0,
+    tx_packets INTEGER DEFAULT 0,
+    connections INTEGER DEFAULT 0,
+    created_at INTEGER DEFAULT (strftime('%s', 'now')),
+    UNIQUE(port, port_end, protocol, netns, local_addr, timestamp)
+);
+`
+
+// dailyLocalAddrStatsTable holds daily traffic per port and local address.
+const dailyLocalAddrStatsTable = `
+CREATE TABLE IF NOT EXISTS daily_local_addr_stats (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    port INTEGER NOT NULL,
+    port_end INTEGER NOT NULL DEFAULT 0,
+    protocol INTEGER NOT NULL DEFAULT 6,
+    netns INTEGER NOT NULL DEFAULT 0,
+    local_addr TEXT NOT NULL,
+    date TEXT NOT NULL,  -- YYYY-MM-DD format
+    rx_bytes INTEGER DEFAULT 0,
+    tx_bytes INTEGER DEFAULT 0,
+    rx_packets INTEGER DEFAULT 0,
+    tx_packets INTEGER DEFAULT 0,
+    connections INTEGER DEFAULT 0,
+    created_at INTEGER DEFAULT (strftime('%s', 'now')),
+    UNIQUE(port, port_end, protocol, netns, local_addr, date)
+);
+`
+
+// LocalAddrStatsRow holds a local address's traffic on a port over a
+// period.
+type LocalAddrStatsRow struct {
+	LocalAddr   string
+	RxBytes     uint64
+	TxBytes     uint64
+	RxPackets   uint64
+	TxPackets   uint64
+	Connections uint64
+}
+
+// UpsertLocalAddrStats adds a local address's traffic delta to both the
+// hourly and daily local address tables.
+func (d *DB) UpsertLocalAddrStats(key types.PortKey, localAddr string, ts time.Time, rxBytes, txBytes, rxPackets, txPackets, connections uint64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO hourly_local_addr_stats (port, port_end, protocol, netns, local_addr, timestamp, rx_bytes, tx_bytes, rx_packets, tx_packets, connections)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, local_addr, timestamp) DO UPDATE SET
+			rx_bytes = rx_bytes + excluded.rx_bytes,
+			tx_bytes = tx_bytes + excluded.tx_bytes,
+			rx_packets = rx_packets + excluded.rx_packets,
+			tx_packets = tx_packets + excluded.tx_packets,
+			connections = connections + excluded.connections
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, localAddr, ts.Truncate(time.Hour).Unix(), rxBytes, txBytes, rxPackets, txPackets, connections)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO daily_local_addr_stats (port, port_end, protocol, netns, local_addr, date, rx_bytes, tx_bytes, rx_packets, tx_packets, connections)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, local_addr, date) DO UPDATE SET
+			rx_bytes = rx_bytes + excluded.rx_bytes,
+			tx_bytes = tx_bytes + excluded.tx_bytes,
+			rx_packets = rx_packets + excluded.rx_packets,
+			tx_packets = tx_packets + excluded.tx_packets,
+			connections = connections + excluded.connections
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, localAddr, ts.Format("2006-01-02"), rxBytes, txBytes, rxPackets, txPackets, connections)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// QueryLocalAddrStats returns per-local-address totals for a port over a
+// date range, largest first.
+func (d *DB) QueryLocalAddrStats(key types.PortKey, startDate, endDate string) ([]LocalAddrStatsRow, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query(`
+		SELECT local_addr, SUM(rx_bytes), SUM(tx_bytes), SUM(rx_packets), SUM(tx_packets), SUM(connections)
+		FROM daily_local_addr_stats
+		WHERE port = ? AND port_end = ? AND protocol = ? AND netns = ? AND date >= ? AND date <= ?
+		GROUP BY local_addr
+		ORDER BY SUM(rx_bytes) + SUM(tx_bytes) DESC
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []LocalAddrStatsRow
+	for rows.Next() {
+		var r LocalAddrStatsRow
+		if err := rows.Scan(&r.LocalAddr, &r.RxBytes, &r.TxBytes, &r.RxPackets, &r.TxPackets, &r.Connections); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
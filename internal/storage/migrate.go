@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// schemaVersion is bumped whenever a managed table definition changes.
+const schemaVersion = 10
+
+// managedTables are rebuilt from their current definition when an existing
+// database is missing any of their columns. SQLite cannot alter UNIQUE
+// constraints in place, so new key columns require a table rebuild.
+var managedTables = []struct {
+	name  string
+	ddl   string
+	moved map[string]string
+}{
+	{"hourly_stats", hourlyStatsTable, opsMoved},
+	{"daily_stats", dailyStatsTable, opsMoved},
+	{"hourly_cgroup_stats", hourlyCgroupStatsTable, opsMoved},
+	{"daily_cgroup_stats", dailyCgroupStatsTable, opsMoved},
+	{"hourly_local_addr_stats", hourlyLocalAddrStatsTable, nil},
+	{"daily_local_addr_stats", dailyLocalAddrStatsTable, nil},
+}
+
+// opsMoved moves the packet counts of rows from before schema 10, which
+// counted send and receive calls, to the operation columns. Their packet
+// counts are unknown and left at zero.
+var opsMoved = map[string]string{"rx_ops": "rx_packets", "tx_ops": "tx_packets"}
+
+// migrate upgrades tables created by older versions of portmon.
+func migrate(db *sql.DB) error {
+	var value string
+	err := db.QueryRow("SELECT value FROM metadata WHERE key = 'schema_version'").Scan(&value)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	if current, _ := strconv.Atoi(value); current >= schemaVersion {
+		return nil
+	}
+
+	for _, t := range managedTables {
+		if err := rebuildIfChanged(db, t.name, t.ddl, t.moved); err != nil {
+			return fmt.Errorf("migrating %s: %w", t.name, err)
+		}
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO metadata (key, value) VALUES ('schema_version', ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, strconv.Itoa(schemaVersion))
+	return err
+}
+
+// rebuildIfChanged recreates a table from ddl when the existing table lacks
+// any of the columns ddl defines, copying over all shared columns. moved
+// maps new columns to the old column whose values they take over, if the
+// old table has it but not the new column; the old column is then not
+// copied.
+func rebuildIfChanged(db *sql.DB, name, ddl string, moved map[string]string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	oldCols, err := tableColumns(tx, name)
+	if err != nil {
+		return err
+	}
+
+	tmp := name + "__new"
+	createTmp := strings.Replace(ddl, "CREATE TABLE IF NOT EXISTS "+name, "CREATE TABLE "+tmp, 1)
+	if _, err := tx.Exec(createTmp); err != nil {
+		return fmt.Errorf("creating %s: %w", tmp, err)
+	}
+
+	newCols, err := tableColumns(tx, tmp)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool, len(oldCols))
+	for _, c := range oldCols {
+		existing[c] = true
+	}
+
+	upToDate := true
+	for _, c := range newCols {
+		upToDate = upToDate && existing[c]
+	}
+	if upToDate {
+		return nil // Rollback drops the temporary table
+	}
+
+	moving := make(map[string]bool)
+	for to, from := range moved {
+		if !existing[to] && existing[from] {
+			moving[from] = true
+		}
+	}
+
+	var dst, src []string
+	for _, c := range newCols {
+		switch {
+		case existing[c] && !moving[c]:
+			dst = append(dst, c)
+			src = append(src, c)
+		case !existing[c] && existing[moved[c]]:
+			dst = append(dst, c)
+			src = append(src, moved[c])
+		}
+	}
+
+	stmts := []string{
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", tmp, strings.Join(dst, ", "), strings.Join(src, ", "), name),
+		fmt.Sprintf("DROP TABLE %s", name),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", tmp, name),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	slog.Info("migrated table", "table", name, "columns", len(newCols))
+	return nil
+}
+
+// tableColumns returns the column names of a table in definition order.
+func tableColumns(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		cols = append(cols, c)
+	}
+	return cols, rows.Err()
+}
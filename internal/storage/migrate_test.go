@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// legacySchema is the hourly/daily layout used before protocol tracking.
+const legacySchema = `
+CREATE TABLE hourly_stats (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    port INTEGER NOT NULL,
+    timestamp INTEGER NOT NULL,
+    rx_bytes INTEGER DEFAULT 0,
+    tx_bytes INTEGER DEFAULT 0,
+    rx_packets INTEGER DEFAULT 0,
+    tx_packets INTEGER DEFAULT 0,
+    connections INTEGER DEFAULT 0,
+    created_at INTEGER DEFAULT (strftime('%s', 'now')),
+    UNIQUE(port, timestamp)
+);
+CREATE TABLE daily_stats (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    port INTEGER NOT NULL,
+    date TEXT NOT NULL,
+    rx_bytes INTEGER DEFAULT 0,
+    tx_bytes INTEGER DEFAULT 0,
+    rx_packets INTEGER DEFAULT 0,
+    tx_packets INTEGER DEFAULT 0,
+    connections INTEGER DEFAULT 0,
+    peak_rx_rate INTEGER DEFAULT 0,
+    peak_tx_rate INTEGER DEFAULT 0,
+    created_at INTEGER DEFAULT (strftime('%s', 'now')),
+    UNIQUE(port, date)
+);
+CREATE INDEX idx_daily_port_date ON daily_stats(port, date);
+INSERT INTO daily_stats (port, date, rx_bytes, tx_bytes, rx_packets, tx_packets) VALUES (5000, '2025-01-15', 100, 200, 3, 4);
+`
+
+func TestOpenMigratesLegacySchema(t *testing.T) {
+	dir := t.TempDir()
+
+	legacy, err := sql.Open("sqlite", filepath.Join(dir, "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec(legacySchema); err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	// Existing rows are kept and attributed to TCP
+	rows, err := db.QueryDailyStats(types.TCPPort(5000), "2025-01-15", "2025-01-15")
+	if err != nil {
+		t.Fatalf("QueryDailyStats: %v", err)
+	}
+	if len(rows) != 1 || rows[0].RxBytes != 100 || rows[0].TxBytes != 200 {
+		t.Fatalf("legacy row not preserved: %+v", rows)
+	}
+
+	// Its packet counts were send/receive calls, and are now reported as such
+	if r := rows[0]; r.RxOps != 3 || r.TxOps != 4 || r.RxPackets != 0 || r.TxPackets != 0 {
+		t.Errorf("legacy packet counts not moved to ops: %+v", r)
+	}
+
+	// The same port on UDP is now a separate row
+	udp := types.PortKey{Port: 5000, Protocol: types.ProtocolUDP}
+	if err := db.UpsertDailyStats(udp, "2025-01-15", 7, 8, 1, 1, 0, 0, 0); err != nil {
+		t.Fatalf("UpsertDailyStats: %v", err)
+	}
+	if err := db.UpsertHourlyStats(udp, time.Now(), 7, 8, 1, 1, 0); err != nil {
+		t.Fatalf("UpsertHourlyStats: %v", err)
+	}
+
+	rows, err = db.QueryDailyStats(types.TCPPort(5000), "2025-01-15", "2025-01-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].RxBytes != 100 {
+		t.Errorf("tcp row changed after udp upsert: %+v", rows)
+	}
+
+	// So is the same port scoped to a network namespace
+	scoped := types.PortKey{Port: 5000, Protocol: types.ProtocolTCP, Netns: 4026532000}
+	if err := db.UpsertDailyStats(scoped, "2025-01-15", 1, 2, 1, 1, 0, 0, 0); err != nil {
+		t.Fatalf("UpsertDailyStats: %v", err)
+	}
+	rows, err = db.QueryDailyStats(scoped, "2025-01-15", "2025-01-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].RxBytes != 1 || rows[0].Netns != scoped.Netns {
+		t.Errorf("netns row not separate: %+v", rows)
+	}
+}
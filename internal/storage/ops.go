@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// UpsertOpStats adds the send and receive calls behind a traffic delta to
+// both the hourly and daily rows of a port. The totals themselves are
+// written by UpsertHourlyStats and UpsertDailyStats.
+func (d *DB) UpsertOpStats(key types.PortKey, ts time.Time, delta OpCounts) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO hourly_stats (port, port_end, protocol, netns, timestamp, rx_ops, tx_ops)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, timestamp) DO UPDATE SET
+			rx_ops = rx_ops + excluded.rx_ops,
+			tx_ops = tx_ops + excluded.tx_ops
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, ts.Truncate(time.Hour).Unix(), delta.RxOps, delta.TxOps)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO daily_stats (port, port_end, protocol, netns, date, rx_ops, tx_ops)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, date) DO UPDATE SET
+			rx_ops = rx_ops + excluded.rx_ops,
+			tx_ops = tx_ops + excluded.tx_ops
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, ts.Format("2006-01-02"), delta.RxOps, delta.TxOps)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
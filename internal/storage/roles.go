@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// UpsertRoleStats adds the server/client split of a traffic delta to both
+// the hourly and daily rows of a port. The totals themselves are written by
+// UpsertHourlyStats and UpsertDailyStats.
+func (d *DB) UpsertRoleStats(key types.PortKey, ts time.Time, delta RoleBytes) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO hourly_stats (port, port_end, protocol, netns, timestamp, server_rx_bytes, server_tx_bytes, client_rx_bytes, client_tx_bytes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, timestamp) DO UPDATE SET
+			server_rx_bytes = server_rx_bytes + excluded.server_rx_bytes,
+			server_tx_bytes = server_tx_bytes + excluded.server_tx_bytes,
+			client_rx_bytes = client_rx_bytes + excluded.client_rx_bytes,
+			client_tx_bytes = client_tx_bytes + excluded.client_tx_bytes
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, ts.Truncate(time.Hour).Unix(),
+		delta.ServerRxBytes, delta.ServerTxBytes, delta.ClientRxBytes, delta.ClientTxBytes)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO daily_stats (port, port_end, protocol, netns, date, server_rx_bytes, server_tx_bytes, client_rx_bytes, client_tx_bytes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, date) DO UPDATE SET
+			server_rx_bytes = server_rx_bytes + excluded.server_rx_bytes,
+			server_tx_bytes = server_tx_bytes + excluded.server_tx_bytes,
+			client_rx_bytes = client_rx_bytes + excluded.client_rx_bytes,
+			client_tx_bytes = client_tx_bytes + excluded.client_tx_bytes
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, ts.Format("2006-01-02"),
+		delta.ServerRxBytes, delta.ServerTxBytes, delta.ClientRxBytes, delta.ClientTxBytes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
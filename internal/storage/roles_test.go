@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+func TestRoleStatsRoundTrip(t *testing.T) {
+	db := openTestDB(t)
+	port := types.TCPPort(5432)
+	ts := time.Date(2025, 1, 15, 10, 15, 0, 0, time.Local)
+	date := ts.Format("2006-01-02")
+
+	// The totals and the split are written separately, in either order
+	if err := db.UpsertRoleStats(port, ts, RoleBytes{ServerRxBytes: 100, ServerTxBytes: 200, ClientRxBytes: 30, ClientTxBytes: 40}); err != nil {
+		t.Fatalf("UpsertRoleStats: %v", err)
+	}
+	if err := db.UpsertHourlyStats(port, ts, 1130, 2040, 5, 6, 1); err != nil {
+		t.Fatalf("UpsertHourlyStats: %v", err)
+	}
+	if err := db.UpsertDailyStats(port, date, 1130, 2040, 5, 6, 1, 0, 0); err != nil {
+		t.Fatalf("UpsertDailyStats: %v", err)
+	}
+	if err := db.UpsertRoleStats(port, ts.Add(30*time.Minute), RoleBytes{ServerRxBytes: 1000, ServerTxBytes: 1800}); err != nil {
+		t.Fatalf("UpsertRoleStats: %v", err)
+	}
+
+	want := RoleBytes{ServerRxBytes: 1100, ServerTxBytes: 2000, ClientRxBytes: 30, ClientTxBytes: 40}
+
+	hourly, err := db.QueryHourlyStats(port, ts.Truncate(time.Hour), ts.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("QueryHourlyStats: %v", err)
+	}
+	if len(hourly) != 1 {
+		t.Fatalf("hourly rows = %d, want 1", len(hourly))
+	}
+	h := hourly[0]
+	if got := h.RoleBytes; got != want {
+		t.Errorf("hourly split = %+v, want %+v", got, want)
+	}
+	if h.RxBytes != 1130 || h.TxBytes != 2040 {
+		t.Errorf("hourly totals = %d/%d, want 1130/2040", h.RxBytes, h.TxBytes)
+	}
+
+	daily, err := db.QueryDailyStats(port, date, date)
+	if err != nil {
+		t.Fatalf("QueryDailyStats: %v", err)
+	}
+	if len(daily) != 1 {
+		t.Fatalf("daily rows = %d, want 1", len(daily))
+	}
+	d := daily[0]
+	if got := d.RoleBytes; got != want {
+		t.Errorf("daily split = %+v, want %+v", got, want)
+	}
+	if d.RxBytes != 1130 || d.TxBytes != 2040 {
+		t.Errorf("daily totals = %d/%d, want 1130/2040", d.RxBytes, d.TxBytes)
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// UpsertSizeStats adds a delta of the send and receive size histograms to
+// the hourly row of a port. They are only kept hourly; QuerySizeStats sums
+// them over any range.
+func (d *DB) UpsertSizeStats(key types.PortKey, ts time.Time, delta types.SizeStats) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	hour := ts.Truncate(time.Hour).Unix()
+	var send, recv string
+	err = tx.QueryRow(`
+		SELECT send_size_hist, recv_size_hist FROM hourly_stats
+		WHERE port = ? AND port_end = ? AND protocol = ? AND netns = ? AND timestamp = ?
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, hour).Scan(&send, &recv)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	var sizes types.SizeStats
+	decodeHist(send, sizes.Send[:])
+	decodeHist(recv, sizes.Recv[:])
+	sizes.Add(&delta)
+
+	_, err = tx.Exec(`
+		INSERT INTO hourly_stats (port, port_end, protocol, netns, timestamp, send_size_hist, recv_size_hist)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, timestamp) DO UPDATE SET
+			send_size_hist = excluded.send_size_hist,
+			recv_size_hist = excluded.recv_size_hist
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, hour,
+		encodeHist(sizes.Send[:]), encodeHist(sizes.Recv[:]))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// QuerySizeStats sums the send and receive size histograms of a port over
+// the hours from start to end.
+func (d *DB) QuerySizeStats(key types.PortKey, start, end time.Time) (types.SizeStats, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var sizes types.SizeStats
+	rows, err := d.db.Query(`
+		SELECT send_size_hist, recv_size_hist FROM hourly_stats
+		WHERE port = ? AND port_end = ? AND protocol = ? AND netns = ? AND timestamp >= ? AND timestamp <= ?
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, start.Truncate(time.Hour).Unix(), end.Unix())
+	if err != nil {
+		return sizes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var send, recv string
+		if err := rows.Scan(&send, &recv); err != nil {
+			return sizes, err
+		}
+		var hour types.SizeStats
+		decodeHist(send, hour.Send[:])
+		decodeHist(recv, hour.Recv[:])
+		sizes.Add(&hour)
+	}
+
+	return sizes, rows.Err()
+}
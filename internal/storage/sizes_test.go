@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// openTestDB opens a database in a temporary directory.
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// sizes returns size stats with the given send and receive bucket counts.
+func sizes(send, recv map[int]uint64) types.SizeStats {
+	var s types.SizeStats
+	for i, n := range send {
+		s.Send[i] = n
+	}
+	for i, n := range recv {
+		s.Recv[i] = n
+	}
+	return s
+}
+
+func TestSizeStatsAccumulate(t *testing.T) {
+	db := openTestDB(t)
+	port := types.TCPPort(8080)
+	day := time.Date(2025, 1, 15, 0, 0, 0, 0, time.Local)
+	at := func(h, m int) time.Time { return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+
+	upsert := func(ts time.Time, delta types.SizeStats) {
+		t.Helper()
+		if err := db.UpsertSizeStats(port, ts, delta); err != nil {
+			t.Fatalf("UpsertSizeStats: %v", err)
+		}
+	}
+
+	// Two persists in the same hour, with the other hourly columns written
+	// in between, and one in the next hour
+	upsert(at(10, 15), sizes(map[int]uint64{4: 3, 10: 1}, map[int]uint64{12: 2}))
+	if err := db.UpsertHourlyStats(port, at(10, 20), 1000, 500, 4, 3, 1); err != nil {
+		t.Fatalf("UpsertHourlyStats: %v", err)
+	}
+	if err := db.UpsertHealthStats(port, at(10, 20), types.TCPHealth{Retransmits: 1}); err != nil {
+		t.Fatalf("UpsertHealthStats: %v", err)
+	}
+	upsert(at(10, 45), sizes(map[int]uint64{4: 2, 31: 1}, nil))
+	upsert(at(11, 30), sizes(map[int]uint64{0: 5}, map[int]uint64{12: 1, 13: 4}))
+
+	// Another port's histograms stay apart
+	if err := db.UpsertSizeStats(types.TCPPort(9090), at(10, 30), sizes(map[int]uint64{4: 100}, nil)); err != nil {
+		t.Fatalf("UpsertSizeStats: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       types.SizeStats
+	}{
+		{
+			name:  "whole day",
+			start: day, end: day.Add(24*time.Hour - time.Second),
+			want: sizes(map[int]uint64{0: 5, 4: 5, 10: 1, 31: 1}, map[int]uint64{12: 3, 13: 4}),
+		},
+		{
+			name:  "first hour",
+			start: at(10, 0), end: at(10, 59),
+			want: sizes(map[int]uint64{4: 5, 10: 1, 31: 1}, map[int]uint64{12: 2}),
+		},
+		{
+			// The start is rounded down to its hour
+			name:  "second hour",
+			start: at(11, 10), end: at(11, 59),
+			want: sizes(map[int]uint64{0: 5}, map[int]uint64{12: 1, 13: 4}),
+		},
+		{
+			name:  "next day",
+			start: day.Add(24 * time.Hour), end: day.Add(48*time.Hour - time.Second),
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := db.QuerySizeStats(port, tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("%s: QuerySizeStats: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: sizes = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// The other columns of the hour weren't disturbed
+	rows, err := db.QueryHourlyStats(port, at(10, 0), at(10, 59))
+	if err != nil {
+		t.Fatalf("QueryHourlyStats: %v", err)
+	}
+	if len(rows) != 1 || rows[0].RxBytes != 1000 || rows[0].TxBytes != 500 {
+		t.Errorf("hourly rows = %+v, want one with 1000/500 bytes", rows)
+	}
+}
+
+func TestHistEncoding(t *testing.T) {
+	var hist types.SizeHistogram
+	if got := encodeHist(hist[:]); got != "" {
+		t.Errorf("encodeHist(empty) = %q, want \"\"", got)
+	}
+
+	hist[0], hist[3], hist[31] = 7, 1, 2
+	s := encodeHist(hist[:])
+
+	var decoded types.SizeHistogram
+	decodeHist(s, decoded[:])
+	if decoded != hist {
+		t.Errorf("decodeHist(%q) = %v, want %v", s, decoded, hist)
+	}
+
+	// Surplus buckets, e.g. from a build with more of them, are ignored
+	var short [2]uint64
+	decodeHist("1,2,3", short[:])
+	if short != [2]uint64{1, 2} {
+		t.Errorf("decodeHist into 2 buckets = %v, want [1 2]", short)
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/wellsgz/portmon/internal/types"
+)
+
+// UpsertWireStats adds an IP-level traffic delta to both the hourly and
+// daily rows of a port. Payload totals are written by UpsertHourlyStats
+// and UpsertDailyStats, so a row holds both for reconciliation.
+func (d *DB) UpsertWireStats(key types.PortKey, ts time.Time, delta WireStats) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO hourly_stats (port, port_end, protocol, netns, timestamp, wire_rx_bytes, wire_tx_bytes, wire_rx_packets, wire_tx_packets)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, timestamp) DO UPDATE SET
+			wire_rx_bytes = wire_rx_bytes + excluded.wire_rx_bytes,
+			wire_tx_bytes = wire_tx_bytes + excluded.wire_tx_bytes,
+			wire_rx_packets = wire_rx_packets + excluded.wire_rx_packets,
+			wire_tx_packets = wire_tx_packets + excluded.wire_tx_packets
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, ts.Truncate(time.Hour).Unix(),
+		delta.WireRxBytes, delta.WireTxBytes, delta.WireRxPackets, delta.WireTxPackets)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO daily_stats (port, port_end, protocol, netns, date, wire_rx_bytes, wire_tx_bytes, wire_rx_packets, wire_tx_packets)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(port, port_end, protocol, netns, date) DO UPDATE SET
+			wire_rx_bytes = wire_rx_bytes + excluded.wire_rx_bytes,
+			wire_tx_bytes = wire_tx_bytes + excluded.wire_tx_bytes,
+			wire_rx_packets = wire_rx_packets + excluded.wire_rx_packets,
+			wire_tx_packets = wire_tx_packets + excluded.wire_tx_packets
+	`, key.Port, key.PortEnd, key.Protocol, key.Netns, ts.Format("2006-01-02"),
+		delta.WireRxBytes, delta.WireTxBytes, delta.WireRxPackets, delta.WireTxPackets)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
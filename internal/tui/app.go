@@ -10,6 +10,7 @@ import (
 	"github.com/wellsgz/portmon/api"
 	"github.com/wellsgz/portmon/internal/client"
 	"github.com/wellsgz/portmon/internal/storage"
+	"github.com/wellsgz/portmon/internal/types"
 )
 
 // View represents the current view state
@@ -46,6 +47,7 @@ type KeyMap struct {
 	Escape    key.Binding
 	NextPort  key.Binding
 	PrevPort  key.Binding
+	Window    key.Binding
 }
 
 var DefaultKeyMap = KeyMap{
@@ -59,6 +61,7 @@ var DefaultKeyMap = KeyMap{
 	Escape:    key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "back")),
 	NextPort:  key.NewBinding(key.WithKeys("down", "j", "n"), key.WithHelp("↓", "next port")),
 	PrevPort:  key.NewBinding(key.WithKeys("up", "k", "N"), key.WithHelp("↑", "prev port")),
+	Window:    key.NewBinding(key.WithKeys("w"), key.WithHelp("w", "rate window")),
 }
 
 // Messages
@@ -66,6 +69,8 @@ type tickMsg time.Time
 type statsMsg struct {
 	realtime   *api.RealtimeStatsResult
 	historical *api.HistoricalStatsResult
+	processes  *api.ProcessStatsResult
+	rates      *api.RateHistoryResult
 	status     *api.StatusResult
 	err        error
 }
@@ -80,15 +85,16 @@ type Model struct {
 
 	// State
 	currentView   View
-	port          uint16
-	ports         []uint16
-	portInfos     []api.PortInfo
+	port          types.PortKey
+	ports         []api.PortInfo
 	portIndex     int
 	width, height int
 
 	// Data
 	realtimeStats   *api.RealtimeStatsResult
 	historicalStats *api.HistoricalStatsResult
+	processStats    *api.ProcessStatsResult
+	rateHistory     *api.RateHistoryResult
 	daemonStatus    *api.StatusResult
 
 	// Date range
@@ -99,11 +105,12 @@ type Model struct {
 	presetCursor int
 
 	// UI state
-	keys KeyMap
+	keys       KeyMap
+	rateWindow int // Index into types.RateWindows of the rates shown
 }
 
-// New creates a new TUI model
-func New(socketPath string, port uint16) Model {
+// New creates a new TUI model. A zero port selects the first monitored port.
+func New(socketPath string, port types.PortKey) Model {
 	return Model{
 		socketPath:   socketPath,
 		port:         port,
@@ -112,6 +119,7 @@ func New(socketPath string, port uint16) Model {
 		cycleDay:     1,
 		presetCursor: 4, // This Month
 		keys:         DefaultKeyMap,
+		rateWindow:   1, // 10s
 	}
 }
 
@@ -158,8 +166,18 @@ func (m Model) fetchStats() tea.Cmd {
 
 		// Get realtime stats for current port
 		var realtime *api.RealtimeStatsResult
-		if m.port > 0 {
-			realtime, err = m.client.GetRealtimeStats(m.port)
+		var processes *api.ProcessStatsResult
+		var rates *api.RateHistoryResult
+		if m.port.Port > 0 {
+			realtime, err = m.client.GetRealtimeStats(m.port, "")
+			if err != nil {
+				return statsMsg{err: err}
+			}
+			processes, err = m.client.GetProcessStats(m.port, maxProcessRows)
+			if err != nil {
+				return statsMsg{err: err}
+			}
+			rates, err = m.client.GetRateHistory(m.port, liveRateSeconds)
 			if err != nil {
 				return statsMsg{err: err}
 			}
@@ -194,8 +212,8 @@ func (m Model) fetchStats() tea.Cmd {
 
 		// Get historical stats
 		var historical *api.HistoricalStatsResult
-		if m.port > 0 {
-			historical, err = m.client.GetHistoricalStats(m.port, startDate, endDate)
+		if m.port.Port > 0 {
+			historical, err = m.client.GetHistoricalStats(m.port, "", startDate, endDate)
 			if err != nil {
 				return statsMsg{err: err}
 			}
@@ -204,6 +222,8 @@ func (m Model) fetchStats() tea.Cmd {
 		return statsMsg{
 			realtime:   realtime,
 			historical: historical,
+			processes:  processes,
+			rates:      rates,
 			status:     status,
 		}
 	}
@@ -251,13 +271,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.lastError = ""
 			m.realtimeStats = msg.realtime
 			m.historicalStats = msg.historical
+			m.processStats = msg.processes
+			m.rateHistory = msg.rates
 			m.daemonStatus = msg.status
 			if msg.status != nil {
-				m.ports = msg.status.MonitoredPorts
-				m.portInfos = msg.status.PortInfos
+				m.ports = msg.status.PortInfos
 				// Set first port if none selected
-				if m.port == 0 && len(m.ports) > 0 {
-					m.port = m.ports[0]
+				if m.port.Port == 0 && len(m.ports) > 0 {
+					m.selectPort(0)
 				}
 			}
 		}
@@ -298,23 +319,34 @@ func (m Model) handleDashboardKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case key.Matches(msg, m.keys.Refresh):
 		return m, m.flushAndFetch()
 
+	case key.Matches(msg, m.keys.Window):
+		m.rateWindow = (m.rateWindow + 1) % len(types.RateWindows)
+		return m, nil
+
 	case key.Matches(msg, m.keys.NextPort):
 		if len(m.ports) > 0 {
-			m.portIndex = (m.portIndex + 1) % len(m.ports)
-			m.port = m.ports[m.portIndex]
+			m.selectPort((m.portIndex + 1) % len(m.ports))
 			return m, m.fetchStats()
 		}
 
 	case key.Matches(msg, m.keys.PrevPort):
 		if len(m.ports) > 0 {
-			m.portIndex = (m.portIndex - 1 + len(m.ports)) % len(m.ports)
-			m.port = m.ports[m.portIndex]
+			m.selectPort((m.portIndex - 1 + len(m.ports)) % len(m.ports))
 			return m, m.fetchStats()
 		}
 	}
 	return m, nil
 }
 
+// selectPort makes the port at index i of the port list current
+func (m *Model) selectPort(i int) {
+	p := m.ports[i]
+	proto, _ := types.ParseProtocol(p.Protocol)
+	m.portIndex = i
+	m.port = types.NewPortKey(p.Port, p.PortEnd, proto)
+	m.port.Netns = p.Netns
+}
+
 func (m Model) handleDatePickerKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch {
 	case key.Matches(msg, m.keys.Escape):
@@ -384,3 +416,8 @@ func FormatBytes(b uint64) string {
 func FormatRate(r float64) string {
 	return FormatBytes(uint64(r)) + "/s"
 }
+
+// FormatMicros formats a duration given in microseconds
+func FormatMicros(us uint64) string {
+	return (time.Duration(us) * time.Microsecond).String()
+}
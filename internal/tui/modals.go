@@ -90,6 +90,7 @@ func (m Model) viewHelp() string {
 		{"d", "Change date range"},
 		{"n/N", "Next/Previous port"},
 		{"r", "Refresh data"},
+		{"w", "Cycle rate window (1s/10s/60s/300s)"},
 		{"?", "Toggle help"},
 		{"Esc", "Close modal"},
 	}
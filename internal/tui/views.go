@@ -2,9 +2,14 @@ package tui
 
 import (
 	"fmt"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/wellsgz/portmon/api"
+	"github.com/wellsgz/portmon/internal/types"
 )
 
 // viewDashboard renders the main dashboard
@@ -16,7 +21,7 @@ func (m Model) viewDashboard() string {
 	b.WriteString("\n")
 
 	// Main content
-	if m.connected && m.port > 0 {
+	if m.connected && m.port.Port > 0 {
 		// Period summary and realtime side by side
 		summary := m.renderPeriodSummary()
 		realtime := m.renderRealtimeStats()
@@ -36,6 +41,21 @@ func (m Model) viewDashboard() string {
 		chart := m.renderChart()
 		chartPanel := PanelStyle.Width(m.width - 2).Render(chart)
 		b.WriteString(chartPanel)
+		b.WriteString("\n")
+
+		// Per-second rates
+		ratesPanel := PanelStyle.Width(m.width - 2).Render(m.renderLiveRates())
+		b.WriteString(ratesPanel)
+		b.WriteString("\n")
+
+		// TCP health
+		healthPanel := PanelStyle.Width(m.width - 2).Render(m.renderHealth())
+		b.WriteString(healthPanel)
+		b.WriteString("\n")
+
+		// Top processes
+		processPanel := PanelStyle.Width(m.width - 2).Render(m.renderProcesses())
+		b.WriteString(processPanel)
 	} else if !m.connected {
 		errMsg := ErrorStyle.Render("⚠ Not connected to daemon")
 		if m.lastError != "" {
@@ -66,8 +86,8 @@ func (m Model) renderHeader() string {
 	parts = append(parts, TitleStyle.Render("portmon"))
 
 	// Port
-	if m.port > 0 {
-		parts = append(parts, LabelStyle.Render("Port: ")+ValueStyle.Render(fmt.Sprintf("%d", m.port)))
+	if m.port.Port > 0 {
+		parts = append(parts, LabelStyle.Render("Port: ")+ValueStyle.Render(m.port.String()))
 	}
 
 	// Connection status
@@ -82,6 +102,23 @@ func (m Model) renderHeader() string {
 		parts = append(parts, LabelStyle.Render("Uptime: ")+ValueStyle.Render(m.daemonStatus.Uptime))
 	}
 
+	// Fullest in-kernel map, and inserts dropped because a map was full
+	if m.daemonStatus != nil && len(m.daemonStatus.Maps) > 0 {
+		var fill float64
+		var drops uint64
+		for _, mu := range m.daemonStatus.Maps {
+			if mu.MaxEntries > 0 {
+				fill = max(fill, float64(mu.Entries)/float64(mu.MaxEntries))
+			}
+			drops += mu.Drops
+		}
+		mapsPart := LabelStyle.Render("Maps: ") + ValueStyle.Render(fmt.Sprintf("%.0f%%", fill*100))
+		if drops > 0 {
+			mapsPart += " " + ErrorStyle.Render(fmt.Sprintf("%d dropped", drops))
+		}
+		parts = append(parts, mapsPart)
+	}
+
 	return lipgloss.JoinHorizontal(lipgloss.Center, "  "+strings.Join(parts, "  │  "))
 }
 
@@ -130,7 +167,9 @@ func (m Model) renderPeriodSummary() string {
 func (m Model) renderRealtimeStats() string {
 	var b strings.Builder
 
+	window := types.RateWindowName(types.RateWindows[m.rateWindow])
 	b.WriteString(PanelTitleStyle.Render("Realtime"))
+	b.WriteString(LabelStyle.Render(" (" + window + " avg)"))
 	b.WriteString("\n\n")
 
 	if m.realtimeStats == nil {
@@ -140,10 +179,14 @@ func (m Model) renderRealtimeStats() string {
 
 	stats := m.realtimeStats
 
-	// Current rates
+	// Current rates, averaged over the selected window
+	rate, ok := stats.Rates[window]
+	if !ok {
+		rate = api.WindowRate{RxRate: stats.RxRate, TxRate: stats.TxRate}
+	}
 	b.WriteString(fmt.Sprintf("  Rate:  %s %s  %s %s\n",
-		RxStyle.Render(SymbolRx), RxStyle.Render(FormatRate(stats.RxRate)),
-		TxStyle.Render(SymbolTx), TxStyle.Render(FormatRate(stats.TxRate))))
+		RxStyle.Render(SymbolRx), RxStyle.Render(FormatRate(rate.RxRate)),
+		TxStyle.Render(SymbolTx), TxStyle.Render(FormatRate(rate.TxRate))))
 
 	// Totals
 	b.WriteString(fmt.Sprintf("  Total: %s %s  %s %s\n",
@@ -154,6 +197,18 @@ func (m Model) renderRealtimeStats() string {
 	b.WriteString(fmt.Sprintf("  Conns: %s active\n",
 		ValueStyle.Render(fmt.Sprintf("%d", stats.Connections))))
 
+	// Server-side vs client-side rates
+	b.WriteString("\n")
+	for _, r := range []struct {
+		label string
+		stats api.TrafficStats
+	}{{"Server", stats.Server}, {"Client", stats.Client}} {
+		b.WriteString(fmt.Sprintf("  %s: %s %s  %s %s\n",
+			LabelStyle.Render(r.label),
+			RxStyle.Render(SymbolRx), RxStyle.Render(FormatRate(r.stats.RxRate)),
+			TxStyle.Render(SymbolTx), TxStyle.Render(FormatRate(r.stats.TxRate))))
+	}
+
 	return b.String()
 }
 
@@ -252,6 +307,188 @@ func (m Model) renderChart() string {
 	return b.String()
 }
 
+// barLevels are the levels used to draw the RTT distribution and rate
+// sparklines
+var barLevels = []rune("▁▂▃▄▅▆▇█")
+
+// liveRateSeconds is the span of the live rate sparklines
+const liveRateSeconds = 5 * 60
+
+// renderLiveRates renders the per-second RX and TX rates of the last
+// minutes as sparklines
+func (m Model) renderLiveRates() string {
+	var b strings.Builder
+
+	b.WriteString(PanelTitleStyle.Render(fmt.Sprintf("Live Rate (last %s)", time.Duration(liveRateSeconds)*time.Second)))
+	b.WriteString("\n\n")
+
+	if m.rateHistory == nil || len(m.rateHistory.Samples) == 0 {
+		b.WriteString(LabelStyle.Render("  No traffic yet") + "\n\n")
+		return b.String()
+	}
+
+	// Leave room for the labels and the current and peak rates
+	width := m.width - 50
+	if width < 20 {
+		width = 20
+	}
+
+	samples := m.rateHistory.Samples
+	rx := make([]float64, len(samples))
+	tx := make([]float64, len(samples))
+	for i, s := range samples {
+		rx[i], tx[i] = s.RxRate, s.TxRate
+	}
+
+	row := func(label string, style lipgloss.Style, rates []float64) {
+		b.WriteString(fmt.Sprintf("  %s %s  %s %s  %s %s\n",
+			LabelStyle.Render(label),
+			style.Render(Sparkline(rates, width)),
+			LabelStyle.Render("now"),
+			ValueStyle.Render(fmt.Sprintf("%-12s", FormatRate(rates[len(rates)-1]))),
+			LabelStyle.Render("peak"),
+			ValueStyle.Render(FormatRate(slices.Max(rates)))))
+	}
+	row("RX", RxStyle, rx)
+	row("TX", TxStyle, tx)
+
+	return b.String()
+}
+
+// Sparkline draws values as a row of bars at most width wide, scaled to
+// the largest value. Longer series are averaged down to width columns.
+func Sparkline(values []float64, width int) string {
+	if len(values) > width {
+		cols := make([]float64, width)
+		for i := range cols {
+			from, to := i*len(values)/width, (i+1)*len(values)/width
+			var sum float64
+			for _, v := range values[from:to] {
+				sum += v
+			}
+			cols[i] = sum / float64(to-from)
+		}
+		values = cols
+	}
+
+	var peak float64
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		level := 0
+		if peak > 0 {
+			level = int(v * float64(len(barLevels)-1) / peak)
+		}
+		if v > 0 && level == 0 {
+			level = 1
+		}
+		b.WriteRune(barLevels[level])
+	}
+	return b.String()
+}
+
+// renderHealth renders the TCP health panel with fixed height
+func (m Model) renderHealth() string {
+	var b strings.Builder
+
+	b.WriteString(PanelTitleStyle.Render("TCP Health"))
+	b.WriteString("\n\n")
+
+	var h *api.TCPHealth
+	if m.realtimeStats != nil {
+		h = m.realtimeStats.Health
+	}
+	if h == nil {
+		b.WriteString(LabelStyle.Render("  No TCP health data") + "\n\n")
+		return b.String()
+	}
+
+	b.WriteString(fmt.Sprintf("  Retransmits: %s   Resets: %s\n",
+		ValueStyle.Render(fmt.Sprintf("%d", h.Retransmits)),
+		ValueStyle.Render(fmt.Sprintf("%d", h.Resets))))
+
+	if h.RTTSamples == 0 {
+		b.WriteString(fmt.Sprintf("  RTT: %s\n", LabelStyle.Render("no samples")))
+		return b.String()
+	}
+
+	// Distribution over the buckets that have samples
+	first, last := -1, 0
+	var peak uint64
+	for i, c := range h.RTTHistogram {
+		if c == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		if c > peak {
+			peak = c
+		}
+	}
+	var bars strings.Builder
+	for _, c := range h.RTTHistogram[first : last+1] {
+		level := int(c * uint64(len(barLevels)-1) / peak)
+		if c > 0 && level == 0 {
+			level = 1
+		}
+		bars.WriteRune(barLevels[level])
+	}
+
+	b.WriteString(fmt.Sprintf("  RTT: p50 %s  p90 %s  p99 %s   %s %s %s\n",
+		ValueStyle.Render("<"+FormatMicros(h.RTTP50)),
+		ValueStyle.Render("<"+FormatMicros(h.RTTP90)),
+		ValueStyle.Render("<"+FormatMicros(h.RTTP99)),
+		LabelStyle.Render(FormatMicros(1<<first)),
+		TotalStyle.Render(bars.String()),
+		LabelStyle.Render(FormatMicros(1<<(last+1)))))
+
+	return b.String()
+}
+
+// maxProcessRows is the number of processes shown in the processes panel
+const maxProcessRows = 5
+
+// renderProcesses renders the top processes panel with fixed height
+func (m Model) renderProcesses() string {
+	var b strings.Builder
+
+	b.WriteString(PanelTitleStyle.Render("Top Processes"))
+	b.WriteString("\n\n")
+
+	var procs []api.ProcessInfo
+	if m.processStats != nil {
+		procs = m.processStats.Processes
+	}
+	// Always render a header line plus maxProcessRows rows for consistent height
+	lines := make([]string, maxProcessRows+1)
+	if len(procs) == 0 {
+		lines[0] = LabelStyle.Render("  No process traffic yet")
+	} else {
+		lines[0] = LabelStyle.Render(fmt.Sprintf("  %-8s %-16s %12s %12s %10s %10s", "PID", "Command", "RX/s", "TX/s", "RX", "TX"))
+	}
+
+	for i, p := range procs {
+		if i >= maxProcessRows {
+			break
+		}
+		lines[i+1] = fmt.Sprintf("  %-8d %-16s %s %s %10s %10s",
+			p.Tgid,
+			p.Comm,
+			RxStyle.Render(fmt.Sprintf("%12s", FormatRate(p.RxRate))),
+			TxStyle.Render(fmt.Sprintf("%12s", FormatRate(p.TxRate))),
+			FormatBytes(p.RxBytes),
+			FormatBytes(p.TxBytes))
+	}
+
+	b.WriteString(strings.Join(lines, "\n"))
+	return b.String()
+}
+
 // renderHelpBar renders the bottom help bar
 func (m Model) renderHelpBar() string {
 	keys := []string{
@@ -259,6 +496,7 @@ func (m Model) renderHelpBar() string {
 		HelpKeyStyle.Render("d") + HelpStyle.Render(" date"),
 		HelpKeyStyle.Render("↑/↓") + HelpStyle.Render(" port"),
 		HelpKeyStyle.Render("r") + HelpStyle.Render(" refresh"),
+		HelpKeyStyle.Render("w") + HelpStyle.Render(" rate window"),
 		HelpKeyStyle.Render("?") + HelpStyle.Render(" help"),
 	}
 	return "  " + strings.Join(keys, "  ")
@@ -274,9 +512,9 @@ func (m Model) renderPortSelector() string {
 
 	// Table header
 	b.WriteString(fmt.Sprintf("  %s  │  %s\n",
-		PanelTitleStyle.Render(fmt.Sprintf("%-6s", "Port")),
+		PanelTitleStyle.Render(fmt.Sprintf("%-24s", "Port")),
 		PanelTitleStyle.Render(fmt.Sprintf("%-32s", "Description"))))
-	b.WriteString("  " + strings.Repeat("─", 44) + "\n")
+	b.WriteString("  " + strings.Repeat("─", 62) + "\n")
 
 	// Table rows (show max 5 ports around current selection)
 	startIdx := 0
@@ -294,21 +532,25 @@ func (m Model) renderPortSelector() string {
 	}
 
 	for i := startIdx; i < endIdx; i++ {
-		port := m.ports[i]
-		desc := m.getPortDescription(port)
+		info := m.ports[i]
+		desc := info.Description
 
 		// Truncate description to 32 chars
 		if len(desc) > 32 {
 			desc = desc[:29] + "..."
 		}
 
-		portStr := fmt.Sprintf("%-6d", port)
+		label := portLabel(info)
+		if len(label) > 24 {
+			label = label[:21] + "..."
+		}
+		portStr := fmt.Sprintf("%-24s", label)
 		descStr := fmt.Sprintf("%-32s", desc)
 
 		// Highlight selected port
 		if i == m.portIndex {
 			b.WriteString(fmt.Sprintf("  %s  │  %s\n",
-				SelectedStyle.Render("▸"+portStr[:5]),
+				SelectedStyle.Render("▸"+portStr[:23]),
 				SelectedStyle.Render(descStr)))
 		} else {
 			b.WriteString(fmt.Sprintf("   %s  │  %s\n",
@@ -320,12 +562,18 @@ func (m Model) renderPortSelector() string {
 	return b.String()
 }
 
-// getPortDescription returns the description for a port, or empty string if none
-func (m Model) getPortDescription(port uint16) string {
-	for _, info := range m.portInfos {
-		if info.Port == port {
-			return info.Description
-		}
+// portLabel formats a port or port range as "port/proto" or "first-last/proto",
+// followed by "@netns" for ports monitored in a single network namespace
+func portLabel(info api.PortInfo) string {
+	label := fmt.Sprintf("%d/%s", info.Port, info.Protocol)
+	if info.PortEnd > info.Port {
+		label = fmt.Sprintf("%d-%d/%s", info.Port, info.PortEnd, info.Protocol)
+	}
+	switch {
+	case info.NetnsName != "":
+		label += "@" + filepath.Base(info.NetnsName)
+	case info.Netns != 0:
+		label += fmt.Sprintf("@%d", info.Netns)
 	}
-	return ""
+	return label
 }
@@ -0,0 +1,121 @@
+package types
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// PortFilters restrict which remote addresses count towards a port's
+// totals. Traffic is counted if the longest matching prefix is an include
+// rule, or if there are no include rules and no exclude rule matches.
+// Excluded traffic is reported separately as filtered.
+type PortFilters struct {
+	Include []netip.Prefix `json:"include,omitempty"`
+	Exclude []netip.Prefix `json:"exclude,omitempty"`
+}
+
+// IsEmpty reports whether no filter rules are set.
+func (f PortFilters) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// PrefixSets are named groups of prefixes that can be used in place of a
+// CIDR in filter lists.
+var PrefixSets = map[string][]string{
+	"loopback":   {"127.0.0.0/8", "::1/128"},
+	"rfc1918":    {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"},
+	"private":    {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"},
+	"link-local": {"169.254.0.0/16", "fe80::/10"},
+}
+
+// ParsePrefixes parses a filter list of CIDR prefixes, bare addresses
+// (treated as a single host) and names from PrefixSets. IPv4-mapped IPv6
+// prefixes are converted to IPv4, matching how the probes report
+// addresses.
+func ParsePrefixes(specs []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if set, ok := PrefixSets[strings.ToLower(spec)]; ok {
+			for _, s := range set {
+				prefixes = append(prefixes, netip.MustParsePrefix(s))
+			}
+			continue
+		}
+
+		p, err := parsePrefix(spec)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	var p netip.Prefix
+	if strings.Contains(s, "/") {
+		var err error
+		if p, err = netip.ParsePrefix(s); err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+		}
+	} else {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid address %q", s)
+		}
+		p = netip.PrefixFrom(addr, addr.BitLen())
+	}
+
+	if p.Addr().Is4In6() {
+		if p.Bits() < 96 {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: IPv4-mapped prefix shorter than /96", s)
+		}
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p.Masked(), nil
+}
+
+// PrefixStrings formats prefixes for display.
+func PrefixStrings(prefixes []netip.Prefix) []string {
+	if len(prefixes) == 0 {
+		return nil
+	}
+	out := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		out[i] = p.String()
+	}
+	return out
+}
+
+// ParseLocalAddrs parses a list of local addresses a port is pinned to.
+// IPv4-mapped IPv6 addresses are converted to IPv4; unspecified addresses
+// are rejected, as they never match a pin.
+func ParseLocalAddrs(specs []string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, spec := range specs {
+		addr, err := netip.ParseAddr(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, fmt.Errorf("invalid local address %q", spec)
+		}
+		addr = addr.Unmap().WithZone("")
+		if addr.IsUnspecified() {
+			return nil, fmt.Errorf("local address %q is unspecified", spec)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// AddrStrings formats addresses for display.
+func AddrStrings(addrs []netip.Addr) []string {
+	if len(addrs) == 0 {
+		return nil
+	}
+	out := make([]string, len(addrs))
+	for i, a := range addrs {
+		out[i] = a.String()
+	}
+	return out
+}
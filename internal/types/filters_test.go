@@ -0,0 +1,59 @@
+package types
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	tests := []struct {
+		specs   []string
+		want    []string
+		wantErr bool
+	}{
+		{[]string{"10.0.0.0/8"}, []string{"10.0.0.0/8"}, false},
+		{[]string{"10.1.2.3/8"}, []string{"10.0.0.0/8"}, false}, // Masked
+		{[]string{" 192.0.2.1 "}, []string{"192.0.2.1/32"}, false},
+		{[]string{"2001:db8::1"}, []string{"2001:db8::1/128"}, false},
+		{[]string{"2001:db8::/32", "0.0.0.0/0"}, []string{"2001:db8::/32", "0.0.0.0/0"}, false},
+		{[]string{"::ffff:10.0.0.0/104"}, []string{"10.0.0.0/8"}, false},
+		{[]string{"::ffff:192.0.2.1"}, []string{"192.0.2.1/32"}, false},
+		{[]string{"loopback"}, []string{"127.0.0.0/8", "::1/128"}, false},
+		{[]string{"RFC1918"}, []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}, false},
+		{[]string{"private", "198.51.100.0/24"}, []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7", "198.51.100.0/24"}, false},
+		{[]string{"link-local"}, []string{"169.254.0.0/16", "fe80::/10"}, false},
+		{nil, nil, false},
+		{[]string{"10.0.0.0/33"}, nil, true},
+		{[]string{"2001:db8::/129"}, nil, true},
+		{[]string{"10.0.0.0/8", "internal"}, nil, true},
+		{[]string{"10.0.0.256"}, nil, true},
+		{[]string{"::ffff:0.0.0.0/90"}, nil, true}, // Mapped prefix shorter than /96
+		{[]string{""}, nil, true},
+	}
+
+	for _, tt := range tests {
+		prefixes, err := ParsePrefixes(tt.specs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePrefixes(%q) error = %v, want error %v", tt.specs, err, tt.wantErr)
+			continue
+		}
+		if got := PrefixStrings(prefixes); !slices.Equal(got, tt.want) {
+			t.Errorf("ParsePrefixes(%q) = %q, want %q", tt.specs, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixSetsParse(t *testing.T) {
+	for name, set := range PrefixSets {
+		prefixes, err := ParsePrefixes(set)
+		if err != nil {
+			t.Errorf("set %s: %v", name, err)
+			continue
+		}
+		for i, p := range prefixes {
+			if p.String() != set[i] {
+				t.Errorf("set %s: %s is not in canonical form (%s)", name, set[i], p)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package types
+
+import "math"
+
+// RTTBuckets is the number of buckets in an RTTHistogram.
+const RTTBuckets = 24
+
+// RTTHistogram counts smoothed RTT samples in log2 buckets: bucket i holds
+// samples in [2^i, 2^(i+1)) microseconds, and the last bucket everything
+// above.
+type RTTHistogram [RTTBuckets]uint64
+
+// Samples returns the total number of samples.
+func (h *RTTHistogram) Samples() uint64 {
+	var n uint64
+	for _, c := range h {
+		n += c
+	}
+	return n
+}
+
+// Add adds the counts of o to h.
+func (h *RTTHistogram) Add(o *RTTHistogram) {
+	for i := range h {
+		h[i] += o[i]
+	}
+}
+
+// Percentile returns the upper bound, in microseconds, of the bucket
+// containing the q-th quantile (0 < q <= 1), or 0 without samples.
+func (h *RTTHistogram) Percentile(q float64) uint64 {
+	return log2Percentile(h[:], q)
+}
+
+// log2Percentile returns the upper bound of the log2 bucket containing the
+// q-th quantile of counts, by nearest rank, or 0 if they are all zero.
+func log2Percentile(counts []uint64, q float64) uint64 {
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(total)))
+	rank = min(max(rank, 1), total)
+
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if seen >= rank {
+			return 1 << (i + 1)
+		}
+	}
+	return 1 << len(counts)
+}
+
+// TCPHealth holds the TCP health counters of a port: retransmissions,
+// resets sent and received, and the distribution of smoothed RTT sampled
+// on every send and receive.
+type TCPHealth struct {
+	Retransmits uint64       `json:"retransmits"`
+	Resets      uint64       `json:"resets"`
+	RTT         RTTHistogram `json:"rtt"`
+}
+
+// Add adds the counters of o to h.
+func (h *TCPHealth) Add(o *TCPHealth) {
+	h.Retransmits += o.Retransmits
+	h.Resets += o.Resets
+	h.RTT.Add(&o.RTT)
+}
+
+// IsZero reports whether no health data has been recorded.
+func (h *TCPHealth) IsZero() bool {
+	return h.Retransmits == 0 && h.Resets == 0 && h.RTT.Samples() == 0
+}
+
+// HandshakeStats counts the TCP connections established on a port:
+// accepted in the server role, initiated in the client role, and connects
+// or handshakes that failed before the connection was established.
+type HandshakeStats struct {
+	Accepted  uint64 `json:"accepted"`
+	Initiated uint64 `json:"initiated"`
+	Failed    uint64 `json:"failed"`
+}
+
+// Add adds the counters of o to h.
+func (h *HandshakeStats) Add(o *HandshakeStats) {
+	h.Accepted += o.Accepted
+	h.Initiated += o.Initiated
+	h.Failed += o.Failed
+}
+
+// IsZero reports whether no connection attempts have been counted.
+func (h *HandshakeStats) IsZero() bool {
+	return h.Accepted == 0 && h.Initiated == 0 && h.Failed == 0
+}
@@ -0,0 +1,58 @@
+package types
+
+import "testing"
+
+func TestRTTPercentile(t *testing.T) {
+	// hist returns a histogram with the given bucket counts.
+	hist := func(buckets map[int]uint64) RTTHistogram {
+		var h RTTHistogram
+		for i, n := range buckets {
+			h[i] = n
+		}
+		return h
+	}
+
+	tests := []struct {
+		name          string
+		hist          RTTHistogram
+		p50, p90, p99 uint64
+	}{
+		{"empty", RTTHistogram{}, 0, 0, 0},
+		{"one sample", hist(map[int]uint64{0: 1}), 2, 2, 2},
+		{"one bucket", hist(map[int]uint64{6: 40}), 128, 128, 128},
+		{"spread", hist(map[int]uint64{3: 50, 10: 49, 20: 1}), 16, 2048, 2048},
+		// The slowest of ten samples is the 99th percentile by nearest rank
+		{"tail", hist(map[int]uint64{2: 9, 12: 1}), 8, 8, 8192},
+		{"median on a bucket edge", hist(map[int]uint64{4: 2, 5: 2}), 32, 64, 64},
+		{"last bucket", hist(map[int]uint64{RTTBuckets - 1: 3}), 1 << RTTBuckets, 1 << RTTBuckets, 1 << RTTBuckets},
+	}
+
+	for _, tt := range tests {
+		h := tt.hist
+		p50, p90, p99 := h.Percentile(0.50), h.Percentile(0.90), h.Percentile(0.99)
+		if p50 != tt.p50 || p90 != tt.p90 || p99 != tt.p99 {
+			t.Errorf("%s: p50/p90/p99 = %d/%d/%d, want %d/%d/%d", tt.name, p50, p90, p99, tt.p50, tt.p90, tt.p99)
+		}
+	}
+}
+
+func TestTCPHealthAdd(t *testing.T) {
+	var h TCPHealth
+	if !h.IsZero() {
+		t.Error("zero TCPHealth is not IsZero")
+	}
+
+	a := TCPHealth{Retransmits: 3, Resets: 1}
+	a.RTT[5] = 2
+	b := TCPHealth{Resets: 2}
+	b.RTT[5], b.RTT[9] = 1, 4
+
+	h.Add(&a)
+	h.Add(&b)
+	if h.Retransmits != 3 || h.Resets != 3 || h.RTT[5] != 3 || h.RTT[9] != 4 || h.RTT.Samples() != 7 {
+		t.Errorf("sum = %+v, want 3 retransmits, 3 resets, buckets 5: 3, 9: 4", h)
+	}
+	if h.IsZero() {
+		t.Error("TCPHealth with samples is IsZero")
+	}
+}
@@ -0,0 +1,65 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateWindows are the windows smoothed rates are averaged over, shortest
+// first, like the 1, 5 and 15 minute load averages.
+var RateWindows = [...]time.Duration{time.Second, 10 * time.Second, time.Minute, 5 * time.Minute}
+
+// RateSample holds a port's average traffic rates (bytes/sec) over one
+// second of wall-clock time.
+type RateSample struct {
+	Time   time.Time `json:"time"` // Start of the second
+	RxRate float64   `json:"rx_rate"`
+	TxRate float64   `json:"tx_rate"`
+}
+
+// Rate holds receive and transmit rates in bytes/sec.
+type Rate struct {
+	RxRate float64 `json:"rx_rate"`
+	TxRate float64 `json:"tx_rate"`
+}
+
+// SmoothedRates holds a port's rates averaged over each of RateWindows, in
+// the same order.
+type SmoothedRates [len(RateWindows)]Rate
+
+// Window returns the rates averaged over w, zero if w isn't one of
+// RateWindows.
+func (r *SmoothedRates) Window(w time.Duration) Rate {
+	for i, rw := range RateWindows {
+		if rw == w {
+			return r[i]
+		}
+	}
+	return Rate{}
+}
+
+// RateWindowName formats a rate window in seconds, e.g. "60s", as clients
+// select it.
+func RateWindowName(w time.Duration) string {
+	return strconv.Itoa(int(w/time.Second)) + "s"
+}
+
+// ParseRateWindow parses one of RateWindows written as a duration, e.g.
+// "10s", "60s" or "5m".
+func ParseRateWindow(s string) (time.Duration, error) {
+	w, err := time.ParseDuration(strings.TrimSpace(s))
+	if err == nil {
+		for _, rw := range RateWindows {
+			if rw == w {
+				return w, nil
+			}
+		}
+	}
+	names := make([]string, len(RateWindows))
+	for i, rw := range RateWindows {
+		names[i] = RateWindowName(rw)
+	}
+	return 0, fmt.Errorf("unknown rate window %q (want %s)", s, strings.Join(names, ", "))
+}
@@ -0,0 +1,48 @@
+package types
+
+// SizeBuckets is the number of buckets in a SizeHistogram.
+const SizeBuckets = 32
+
+// SizeHistogram counts TCP sends or receives in log2 buckets of their
+// payload size: bucket i holds calls of [2^i, 2^(i+1)) bytes, and the last
+// bucket everything above.
+type SizeHistogram [SizeBuckets]uint64
+
+// Samples returns the total number of sends or receives.
+func (h *SizeHistogram) Samples() uint64 {
+	var n uint64
+	for _, c := range h {
+		n += c
+	}
+	return n
+}
+
+// Add adds the counts of o to h.
+func (h *SizeHistogram) Add(o *SizeHistogram) {
+	for i := range h {
+		h[i] += o[i]
+	}
+}
+
+// Percentile returns the upper bound, in bytes, of the bucket containing
+// the q-th quantile (0 < q <= 1), or 0 without samples.
+func (h *SizeHistogram) Percentile(q float64) uint64 {
+	return log2Percentile(h[:], q)
+}
+
+// SizeStats holds the payload size distributions of a TCP port.
+type SizeStats struct {
+	Send SizeHistogram `json:"send"`
+	Recv SizeHistogram `json:"recv"`
+}
+
+// Add adds the counts of o to s.
+func (s *SizeStats) Add(o *SizeStats) {
+	s.Send.Add(&o.Send)
+	s.Recv.Add(&o.Recv)
+}
+
+// IsZero reports whether no sends or receives have been recorded.
+func (s *SizeStats) IsZero() bool {
+	return s.Send.Samples() == 0 && s.Recv.Samples() == 0
+}
@@ -2,18 +2,281 @@
 package types
 
 import (
+	"fmt"
 	"net"
+	"net/netip"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// PortStats holds real-time statistics for a monitored port.
+// Transport protocols (IPPROTO_* numbers).
+const (
+	ProtocolTCP uint8 = 6
+	ProtocolUDP uint8 = 17
+)
+
+// Connection roles of a monitored port, as a bit mask. On server-side
+// traffic the monitored port is the local port (we accepted the connection);
+// on client-side traffic it is the remote port (we initiated it).
+const (
+	RoleServer uint8 = 1
+	RoleClient uint8 = 2
+	RoleBoth         = RoleServer | RoleClient
+)
+
+// ParseRoles parses "server", "client" or "both". An empty string means
+// both.
+func ParseRoles(s string) (uint8, error) {
+	switch strings.ToLower(s) {
+	case "", "both":
+		return RoleBoth, nil
+	case "server":
+		return RoleServer, nil
+	case "client":
+		return RoleClient, nil
+	default:
+		return 0, fmt.Errorf("unknown role %q (want server, client or both)", s)
+	}
+}
+
+// RolesName returns the name of a role mask.
+func RolesName(roles uint8) string {
+	switch roles {
+	case RoleServer:
+		return "server"
+	case RoleClient:
+		return "client"
+	default:
+		return "both"
+	}
+}
+
+// PortKey identifies a monitored port, or an inclusive range of ports
+// aggregated as one logical service, on a specific transport protocol.
+// PortEnd is zero for a single port. Netns is the inode of the network
+// namespace the port is monitored in, or zero for every namespace.
+type PortKey struct {
+	Port     uint16 `json:"port"`
+	PortEnd  uint16 `json:"port_end,omitempty"`
+	Protocol uint8  `json:"protocol"`
+	Netns    uint32 `json:"netns,omitempty"`
+}
+
+// TCPPort returns the PortKey for a TCP port.
+func TCPPort(port uint16) PortKey {
+	return PortKey{Port: port, Protocol: ProtocolTCP}
+}
+
+// IsRange reports whether the key covers more than one port.
+func (k PortKey) IsRange() bool {
+	return k.PortEnd > k.Port
+}
+
+// Last returns the highest port covered by the key.
+func (k PortKey) Last() uint16 {
+	if k.IsRange() {
+		return k.PortEnd
+	}
+	return k.Port
+}
+
+// Contains reports whether port falls within the key's port range.
+func (k PortKey) Contains(port uint16) bool {
+	return port >= k.Port && port <= k.Last()
+}
+
+// Overlaps reports whether two keys share any port on the same protocol
+// and network namespace. A namespace-scoped key does not overlap an
+// unscoped one; it takes precedence within its namespace.
+func (k PortKey) Overlaps(o PortKey) bool {
+	return k.Protocol == o.Protocol && k.Netns == o.Netns &&
+		k.Port <= o.Last() && o.Port <= k.Last()
+}
+
+// String formats the key as "port/proto" or "first-last/proto",
+// e.g. "5000/udp" or "30000-30999/tcp", with "@netns:<inode>" appended
+// for namespace-scoped keys.
+func (k PortKey) String() string {
+	s := fmt.Sprintf("%s/%s", k.PortString(), ProtocolName(k.Protocol))
+	if k.Netns != 0 {
+		s += fmt.Sprintf("@netns:%d", k.Netns)
+	}
+	return s
+}
+
+// PortString formats just the port part of the key, e.g. "30000-30999".
+func (k PortKey) PortString() string {
+	if k.IsRange() {
+		return fmt.Sprintf("%d-%d", k.Port, k.PortEnd)
+	}
+	return strconv.Itoa(int(k.Port))
+}
+
+// ProtocolName returns the lowercase name of a protocol number.
+func ProtocolName(proto uint8) string {
+	switch proto {
+	case ProtocolTCP:
+		return "tcp"
+	case ProtocolUDP:
+		return "udp"
+	default:
+		return strconv.Itoa(int(proto))
+	}
+}
+
+// ParseProtocol parses a protocol name. An empty string means TCP.
+func ParseProtocol(s string) (uint8, error) {
+	switch strings.ToLower(s) {
+	case "", "tcp":
+		return ProtocolTCP, nil
+	case "udp":
+		return ProtocolUDP, nil
+	default:
+		return 0, fmt.Errorf("unknown protocol %q (want tcp or udp)", s)
+	}
+}
+
+// ParsePortKey parses "5000", "5000/udp", "30000-30999" or
+// "30000-30999/udp".
+func ParsePortKey(s string) (PortKey, error) {
+	portStr, protoStr, _ := strings.Cut(s, "/")
+	first, last, err := ParsePortRange(portStr)
+	if err != nil {
+		return PortKey{}, err
+	}
+	proto, err := ParseProtocol(protoStr)
+	if err != nil {
+		return PortKey{}, err
+	}
+	return NewPortKey(first, last, proto), nil
+}
+
+// ParsePortRange parses "5000" or "30000-30999" into its first and last
+// port. For a single port both values are equal.
+func ParsePortRange(s string) (first, last uint16, err error) {
+	firstStr, lastStr, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	f, err := strconv.ParseUint(strings.TrimSpace(firstStr), 10, 16)
+	if err != nil || f == 0 {
+		return 0, 0, fmt.Errorf("invalid port: %s", s)
+	}
+	l := f
+	if isRange {
+		l, err = strconv.ParseUint(strings.TrimSpace(lastStr), 10, 16)
+		if err != nil || l < f {
+			return 0, 0, fmt.Errorf("invalid port range: %s", s)
+		}
+	}
+	return uint16(f), uint16(l), nil
+}
+
+// NewPortKey builds a PortKey for the ports first..last, normalizing a
+// single-port range so equal keys compare equal.
+func NewPortKey(first, last uint16, proto uint8) PortKey {
+	k := PortKey{Port: first, Protocol: proto}
+	if last > first {
+		k.PortEnd = last
+	}
+	return k
+}
+
+// PortStats holds real-time statistics for a monitored port. Packets are
+// TCP segments or UDP datagrams; operations are the send and receive calls
+// that moved the bytes, several packets each for bulk TCP transfers.
 type PortStats struct {
 	Port        uint16 `json:"port"`
+	PortEnd     uint16 `json:"port_end,omitempty"`
+	Protocol    uint8  `json:"protocol"`
+	Netns       uint32 `json:"netns,omitempty"`
 	RxBytes     uint64 `json:"rx_bytes"`
 	TxBytes     uint64 `json:"tx_bytes"`
 	RxPackets   uint64 `json:"rx_packets"`
 	TxPackets   uint64 `json:"tx_packets"`
+	RxOps       uint64 `json:"rx_ops"`
+	TxOps       uint64 `json:"tx_ops"`
 	Connections uint64 `json:"connections"`
+	// Calculated rates (bytes/sec) over the last poll
+	RxRate float64 `json:"rx_rate"`
+	TxRate float64 `json:"tx_rate"`
+	// Rates averaged over each of RateWindows
+	Smoothed SmoothedRates `json:"smoothed"`
+	// Highest rates over the collector's peak window since the aggregator
+	// last took them
+	Peak Rate `json:"peak"`
+	// Split of the totals by the role the port played
+	Server TrafficStats `json:"server"`
+	Client TrafficStats `json:"client"`
+	// Traffic excluded by the port's CIDR filters, not part of the totals
+	Filtered TrafficStats `json:"filtered"`
+	// IP-level traffic, headers and retransmissions included; only
+	// collected in wire accounting mode
+	Wire TrafficStats `json:"wire"`
+	// TCP retransmits, resets and RTT distribution
+	Health TCPHealth `json:"health"`
+	// TCP connections accepted, initiated and failed
+	Handshakes HandshakeStats `json:"handshakes"`
+	// Distribution of TCP send and receive sizes
+	Sizes SizeStats `json:"sizes"`
+}
+
+// TrafficStats holds a share or view of a port's traffic: the traffic seen
+// in one role, the traffic excluded by the port's filters, or the same
+// traffic measured at the IP level.
+type TrafficStats struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxPackets uint64 `json:"tx_packets"`
+	// Calculated rates (bytes/sec)
+	RxRate float64 `json:"rx_rate"`
+	TxRate float64 `json:"tx_rate"`
+}
+
+// ProcessStats holds real-time statistics for one process's traffic on a
+// monitored port. Packets can't be attributed to processes, only the send
+// and receive operations.
+type ProcessStats struct {
+	Pid     uint32 `json:"pid"`  // Most recent thread seen
+	Tgid    uint32 `json:"tgid"` // Process ID
+	Comm    string `json:"comm"`
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
+	RxOps   uint64 `json:"rx_ops"`
+	TxOps   uint64 `json:"tx_ops"`
+	// Calculated rates (bytes/sec)
+	RxRate float64 `json:"rx_rate"`
+	TxRate float64 `json:"tx_rate"`
+}
+
+// CgroupStats holds real-time statistics for one cgroup's traffic on a
+// monitored port. Path is relative to the cgroup v2 mount; Unit and
+// Container are derived from it when it names a systemd unit or a
+// container scope. Like processes, cgroups only have operation counts.
+type CgroupStats struct {
+	CgroupID    uint64 `json:"cgroup_id"`
+	Path        string `json:"path"`
+	Unit        string `json:"unit,omitempty"`
+	Container   string `json:"container,omitempty"`
+	RxBytes     uint64 `json:"rx_bytes"`
+	TxBytes     uint64 `json:"tx_bytes"`
+	RxOps       uint64 `json:"rx_ops"`
+	TxOps       uint64 `json:"tx_ops"`
+	Connections uint64 `json:"connections"` // Connections opened
+	// Calculated rates (bytes/sec)
+	RxRate float64 `json:"rx_rate"`
+	TxRate float64 `json:"tx_rate"`
+}
+
+// LocalAddrStats holds real-time statistics for the traffic on one local
+// address of a monitored port. UDP sockets bound to the wildcard address
+// are reported under the unspecified address.
+type LocalAddrStats struct {
+	Addr        netip.Addr `json:"addr"`
+	RxBytes     uint64     `json:"rx_bytes"`
+	TxBytes     uint64     `json:"tx_bytes"`
+	RxPackets   uint64     `json:"rx_packets"`
+	TxPackets   uint64     `json:"tx_packets"`
+	Connections uint64     `json:"connections"` // TCP connections established
 	// Calculated rates (bytes/sec)
 	RxRate float64 `json:"rx_rate"`
 	TxRate float64 `json:"tx_rate"`
@@ -49,6 +312,9 @@ type DailyStats struct {
 type ActiveConnection struct {
 	ID         int64     `json:"id,omitempty"`
 	Port       uint16    `json:"port"`
+	Family     uint16    `json:"family"`
+	LocalAddr  net.IP    `json:"local_addr"`
+	LocalPort  uint16    `json:"local_port"`
 	RemoteAddr net.IP    `json:"remote_addr"`
 	RemotePort uint16    `json:"remote_port"`
 	State      string    `json:"state"`
@@ -58,6 +324,20 @@ type ActiveConnection struct {
 	LastSeen   time.Time `json:"last_seen"`
 }
 
+// ConnectionRecord describes a finished TCP connection.
+type ConnectionRecord struct {
+	Port       uint16    `json:"port"`
+	Family     uint16    `json:"family"`
+	LocalAddr  net.IP    `json:"local_addr"`
+	LocalPort  uint16    `json:"local_port"`
+	RemoteAddr net.IP    `json:"remote_addr"`
+	RemotePort uint16    `json:"remote_port"`
+	RxBytes    uint64    `json:"rx_bytes"`
+	TxBytes    uint64    `json:"tx_bytes"`
+	StartedAt  time.Time `json:"started_at"`
+	EndedAt    time.Time `json:"ended_at"`
+}
+
 // PeriodSummary holds aggregated stats for a time period.
 type PeriodSummary struct {
 	Port       uint16    `json:"port"`
@@ -86,14 +366,40 @@ type DaemonStatus struct {
 	Version        string    `json:"version"`
 }
 
+// Address families as reported by the kernel.
+const (
+	FamilyIPv4 uint16 = 2  // AF_INET
+	FamilyIPv6 uint16 = 10 // AF_INET6
+)
+
 // ConnKey identifies a unique TCP connection.
+// IPv4 addresses (including IPv4-mapped IPv6) use FamilyIPv4 and occupy
+// the first 4 bytes of the address arrays.
 type ConnKey struct {
-	SrcAddr uint32
-	DstAddr uint32
+	Family  uint16
+	SrcAddr [16]byte
+	DstAddr [16]byte
 	SrcPort uint16
 	DstPort uint16
 }
 
+// SrcIP returns the local address of the connection.
+func (k ConnKey) SrcIP() netip.Addr {
+	return k.addr(k.SrcAddr)
+}
+
+// DstIP returns the remote address of the connection.
+func (k ConnKey) DstIP() netip.Addr {
+	return k.addr(k.DstAddr)
+}
+
+func (k ConnKey) addr(b [16]byte) netip.Addr {
+	if k.Family == FamilyIPv4 {
+		return netip.AddrFrom4([4]byte{b[0], b[1], b[2], b[3]})
+	}
+	return netip.AddrFrom16(b)
+}
+
 // ConnStats holds per-connection statistics from eBPF.
 type ConnStats struct {
 	RxBytes      uint64
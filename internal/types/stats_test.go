@@ -0,0 +1,166 @@
+package types
+
+import "testing"
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		in          string
+		first, last uint16
+		wantErr     bool
+	}{
+		{"5000", 5000, 5000, false},
+		{" 5000 ", 5000, 5000, false},
+		{"30000-30999", 30000, 30999, false},
+		{"30000 - 30999", 30000, 30999, false},
+		{"8080-8080", 8080, 8080, false},
+		{"65535", 65535, 65535, false},
+		{"0", 0, 0, true},
+		{"65536", 0, 0, true},
+		{"-1", 0, 0, true},
+		{"abc", 0, 0, true},
+		{"", 0, 0, true},
+		{"30000-abc", 0, 0, true},
+		{"30000-", 0, 0, true},
+		{"30999-30000", 0, 0, true},
+		{"30000-70000", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		first, last, err := ParsePortRange(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePortRange(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if first != tt.first || last != tt.last {
+			t.Errorf("ParsePortRange(%q) = %d, %d, want %d, %d", tt.in, first, last, tt.first, tt.last)
+		}
+	}
+}
+
+func TestParsePortKey(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    PortKey
+		wantErr bool
+	}{
+		{"5000", PortKey{Port: 5000, Protocol: ProtocolTCP}, false},
+		{"5353/udp", PortKey{Port: 5353, Protocol: ProtocolUDP}, false},
+		{"5353/UDP", PortKey{Port: 5353, Protocol: ProtocolUDP}, false},
+		{"443/tcp", PortKey{Port: 443, Protocol: ProtocolTCP}, false},
+		{"30000-30999", PortKey{Port: 30000, PortEnd: 30999, Protocol: ProtocolTCP}, false},
+		{"30000-30999/udp", PortKey{Port: 30000, PortEnd: 30999, Protocol: ProtocolUDP}, false},
+		{"8080-8080", PortKey{Port: 8080, Protocol: ProtocolTCP}, false}, // Normalized to a single port
+		{"5000/sctp", PortKey{}, true},
+		{"30000-abc", PortKey{}, true},
+		{"0/udp", PortKey{}, true},
+		{"/udp", PortKey{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePortKey(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePortKey(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePortKey(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if !tt.wantErr && got.String() != tt.want.String() {
+			t.Errorf("ParsePortKey(%q).String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPortKeyContains(t *testing.T) {
+	single := TCPPort(5000)
+	rng := NewPortKey(30000, 30999, ProtocolTCP)
+
+	tests := []struct {
+		key  PortKey
+		port uint16
+		want bool
+	}{
+		{single, 5000, true},
+		{single, 4999, false},
+		{single, 5001, false},
+		{rng, 30000, true},
+		{rng, 30500, true},
+		{rng, 30999, true},
+		{rng, 29999, false},
+		{rng, 31000, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.key.Contains(tt.port); got != tt.want {
+			t.Errorf("%s.Contains(%d) = %v, want %v", tt.key, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestPortKeyOverlaps(t *testing.T) {
+	rng := NewPortKey(30000, 30999, ProtocolTCP)
+	scoped := rng
+	scoped.Netns = 4026531840
+
+	tests := []struct {
+		a, b PortKey
+		want bool
+	}{
+		{rng, rng, true},
+		{rng, TCPPort(30000), true},
+		{rng, TCPPort(30999), true},
+		{rng, TCPPort(31000), false},
+		{rng, NewPortKey(30999, 31999, ProtocolTCP), true},
+		{rng, NewPortKey(29000, 29999, ProtocolTCP), false},
+		{rng, NewPortKey(29000, 32000, ProtocolTCP), true},
+		{rng, NewPortKey(30000, 30999, ProtocolUDP), false}, // Other protocol
+		{rng, scoped, false},                                // Other namespace scope
+		{scoped, scoped, true},
+		{TCPPort(5000), TCPPort(5000), true},
+		{TCPPort(5000), TCPPort(5001), false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.a.Overlaps(tt.b); got != tt.want {
+			t.Errorf("%s.Overlaps(%s) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := tt.b.Overlaps(tt.a); got != tt.want {
+			t.Errorf("%s.Overlaps(%s) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestParseRoles(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint8
+		name    string // RolesName of the result
+		wantErr bool
+	}{
+		{"", RoleBoth, "both", false},
+		{"both", RoleBoth, "both", false},
+		{"server", RoleServer, "server", false},
+		{"Server", RoleServer, "server", false},
+		{"client", RoleClient, "client", false},
+		{"CLIENT", RoleClient, "client", false},
+		{"listener", 0, "", true},
+		{"server,client", 0, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRoles(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRoles(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRoles(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+		if name := RolesName(got); name != tt.name {
+			t.Errorf("RolesName(ParseRoles(%q)) = %q, want %q", tt.in, name, tt.name)
+		}
+	}
+}